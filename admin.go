@@ -0,0 +1,191 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	jwtauth "github.com/greenpau/caddy-auth-jwt/pkg/auth"
+	jwtbackends "github.com/greenpau/caddy-auth-jwt/pkg/backends"
+)
+
+func init() {
+	caddy.RegisterModule(AdminAPI{})
+}
+
+// AdminAPI is the endpoints of Caddy admin API reporting whether the plugin
+// instances have the keys of their trusted tokens, e.g. for the readiness
+// probes of orchestrators, listing the keys, and refreshing them, e.g.
+// during key rotation incidents.
+type AdminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.jwt",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+// Routes returns the routes of the admin API.
+func (AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/jwt/ready",
+			Handler: caddy.AdminHandlerFunc(handleReadiness),
+		},
+		{
+			Pattern: "/jwt/keys",
+			Handler: caddy.AdminHandlerFunc(handleKeys),
+		},
+		{
+			Pattern: "/jwt/keys/refresh",
+			Handler: caddy.AdminHandlerFunc(handleKeysRefresh),
+		},
+		{
+			Pattern: "/jwt/tokens/validate",
+			Handler: caddy.AdminHandlerFunc(handleTokensValidate),
+		},
+	}
+}
+
+// handleReadiness responds with 200 when all instances are ready, and with
+// 503 otherwise. The body has the errors of the instances not ready.
+func handleReadiness(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			Code: http.StatusMethodNotAllowed,
+			Err:  fmt.Errorf("method not allowed"),
+		}
+	}
+	resp := struct {
+		Ready     bool              `json:"ready"`
+		Instances map[string]string `json:"instances"`
+	}{
+		Ready:     true,
+		Instances: make(map[string]string),
+	}
+	for name, err := range jwtauth.AuthManager.Readiness() {
+		if err != nil {
+			resp.Ready = false
+			resp.Instances[name] = err.Error()
+			continue
+		}
+		resp.Instances[name] = "ready"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// handleKeys responds with the keys of the instances, i.e. the key ids,
+// the algorithms, the sources, and the time of the last refresh.
+func handleKeys(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			Code: http.StatusMethodNotAllowed,
+			Err:  fmt.Errorf("method not allowed"),
+		}
+	}
+	resp := struct {
+		Instances map[string][]*jwtbackends.KeyStoreInfo `json:"instances"`
+	}{
+		Instances: jwtauth.AuthManager.InspectKeys(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// handleKeysRefresh fetches the keys of the instances immediately. It
+// responds with 200 when all fetches succeed, and with 502 otherwise.
+func handleKeysRefresh(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			Code: http.StatusMethodNotAllowed,
+			Err:  fmt.Errorf("method not allowed"),
+		}
+	}
+	resp := struct {
+		Refreshed bool              `json:"refreshed"`
+		Instances map[string]string `json:"instances"`
+	}{
+		Refreshed: true,
+		Instances: make(map[string]string),
+	}
+	for name, err := range jwtauth.AuthManager.RefreshKeys() {
+		if err != nil {
+			resp.Refreshed = false
+			resp.Instances[name] = err.Error()
+			continue
+		}
+		resp.Instances[name] = "refreshed"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Refreshed {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// maxTokensValidateBodySize is the size of the body of the token validation
+// requests.
+const maxTokensValidateBodySize = 1 << 20
+
+// handleTokensValidate validates the tokens in the body, optionally as the
+// tokens of the requests with the method and the path, and responds with
+// the decision and the claims of each token by instance.
+func handleTokensValidate(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			Code: http.StatusMethodNotAllowed,
+			Err:  fmt.Errorf("method not allowed"),
+		}
+	}
+	req := struct {
+		Tokens []string `json:"tokens"`
+		Method string   `json:"method"`
+		Path   string   `json:"path"`
+	}{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTokensValidateBodySize)).Decode(&req); err != nil {
+		return caddy.APIError{
+			Code: http.StatusBadRequest,
+			Err:  fmt.Errorf("decoding request: %v", err),
+		}
+	}
+	if len(req.Tokens) == 0 {
+		return caddy.APIError{
+			Code: http.StatusBadRequest,
+			Err:  fmt.Errorf("no tokens"),
+		}
+	}
+	resp := struct {
+		Instances map[string][]*jwtauth.TokenDecision `json:"instances"`
+	}{
+		Instances: jwtauth.AuthManager.ValidateTokens(req.Tokens, strings.ToUpper(req.Method), req.Path),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// Interface guards
+var (
+	_ caddy.AdminRouter = (*AdminAPI)(nil)
+)
@@ -17,17 +17,21 @@ package jwt
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
 
 	jwtacl "github.com/greenpau/caddy-auth-jwt/pkg/acl"
 	jwtauth "github.com/greenpau/caddy-auth-jwt/pkg/auth"
+	jwtclaims "github.com/greenpau/caddy-auth-jwt/pkg/claims"
 	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
+	jwtvalidator "github.com/greenpau/caddy-auth-jwt/pkg/validator"
 )
 
 func init() {
@@ -42,21 +46,253 @@ func init() {
 //       trusted_tokens {
 //         static_secret {
 //           token_name <value>
+//           token_issuer <value>
+//           token_issuers <value...>
+//           token_required_type [value]
+//           token_audience <value...>
+//           token_hosted_domains <value...>
 //           token_secret <value>
+//           token_secret_file <path>
 //         }
 //         rsa_file {
 //           token_name <value>
-//           token_rsa_file <path>
+//           token_rsa_file <kid> <path>
+//           token_rsa_dir <path>
+//           token_key_watch
+//           token_key_watch_poll_interval <duration>
+//           token_rsa_pss_methods <PS256|PS384|PS512...>
+//           allowed_algs <RS256|PS256|ES256|...>
+//         }
+//         ecdsa_file {
+//           token_name <value>
+//           token_ecdsa_file <kid> <path>
+//           token_ecdsa_dir <path>
+//         }
+//         eddsa_file {
+//           token_name <value>
+//           token_eddsa_file <kid> <path>
+//           token_eddsa_dir <path>
+//         }
+//         paseto {
+//           token_name <value>
+//           token_format <jwt|paseto>
+//           token_required_type [v2.public|v4.public]
+//           token_eddsa_file <kid> <path>
+//           token_eddsa_dir <path>
+//         }
+//         jwks {
+//           token_name <value>
+//           token_issuer <value>
+//           token_issuers <value...>
+//           token_required_type [value]
+//           token_audience <value...>
+//           token_hosted_domains <value...>
+//           token_jwks_uri <url>
+//           token_jwks_refresh_interval <duration>
+//           token_jwks_min_refresh_interval <duration>
+//           token_jwks_fetch_timeout <duration>
+//           token_jwks_fetch_retries <number>
+//           token_jwks_retry_backoff <duration>
+//           token_jwks_tolerate_fetch_errors
+//           token_jwks_warmup_timeout <duration>
+//           token_jwks_lazy_load
+//           token_key_outage_policy <deny|unavailable|allow>
+//           token_jwks_ca_file <path>
+//           token_jwks_client_cert <path>
+//           token_jwks_client_key <path>
+//           token_jwks_insecure_skip_verify
+//           token_jwks_http_timeout <duration>
+//           token_jwks_proxy_url <url>
+//           token_jwks_header <name> <value>
+//           token_jwks_user_agent <value>
+//           token_jwks_bearer_token <token>
+//           token_jwks_bearer_token_file <path>
+//           token_jwks_basic_auth <username> <password>
+//           token_jwks_api_key <value>
+//           token_jwks_api_key_header <name>
+//           token_jwks_x5c_ca_file <path>
+//           token_decryption_key_file <path>
+//         }
+//         oidc {
+//           token_name <value>
+//           token_issuer <value>
+//           token_issuers <value...>
+//           token_required_type [value]
+//           token_audience <value...>
+//           token_hosted_domains <value...>
+//           token_oidc_issuer <url>
+//           token_decryption_key_file <path>
+//         }
+//         kubernetes {
+//           token_name <value>
+//           token_audience <value...>
+//           token_kubernetes_mode <jwks|token_review>
+//           token_kubernetes_api_server <url>
+//           token_kubernetes_ca_file <path>
+//           token_kubernetes_token_file <path>
+//           token_kubernetes_review_cache_ttl <duration>
+//         }
+//         introspection {
+//           token_name <value>
+//           token_introspection_endpoint <url>
+//           token_introspection_client_id <id>
+//           token_introspection_client_secret <secret>
+//           token_introspection_cache_ttl <duration>
+//         }
+//         firebase {
+//           token_name <value>
+//           token_firebase_project_id <project id>
+//         }
+//         jwks_file {
+//           token_name <value>
+//           token_jwks_file <path>
+//         }
+//         jwks_inline {
+//           token_name <value>
+//           token_jwks <json|base64>
+//         }
+//         aws {
+//           token_name <value>
+//           token_aws_secret_id <secret id>
+//           token_aws_kms_key_id <key id>
+//           token_aws_region <region>
+//           token_aws_refresh_interval <duration>
+//         }
+//         azure {
+//           token_name <value>
+//           token_azure_vault_url <url>
+//           token_azure_secret_name <name>
+//           token_azure_key_name <name>
+//           token_azure_tenant_id <tenant id>
+//           token_azure_client_id <client id>
+//           token_azure_client_secret <client secret>
+//           token_azure_refresh_interval <duration>
+//         }
+//         gcp {
+//           token_name <value>
+//           token_gcp_secret projects/<project>/secrets/<secret>[/versions/<version>]
+//           token_gcp_credentials_file <path>
+//           token_gcp_refresh_interval <duration>
+//         }
+//         backend {
+//           token_name <value>
+//           token_backend <module> {
+//             ...
+//           }
+//           token_backend_refresh_interval <duration>
 //         }
 //       }
 //       auth_url <path>
+//       redirect_param <name>
+//       redirect_encoding <query|base64url>
+//       redirect_header <name>
+//       redirect_allowed_hosts <host...>
+//       verify_endpoint <path>
+//       mode <required|optional>
+//       guest_roles <role...>
+//       exempt path <path...>
+//       redirect_loop_limit <count>
+//       token_sources <header|cookie|query|websocket|form...>
+//       token_header_names <name...>
+//       token_cookie_names <name...>
+//       token_query_names <name...>
+//       token_form_names <name...>
+//       token_form_max_body_size <bytes>
+//       strip_token
 //       disable auth_url_redirect_query
+//       disable content_negotiation
+//       disable redirect_loop_check
+//       enable <claim headers|forwarded headers|expiry headers|expiry response headers|error routes|request context headers>
 //       allow <field> <value...>
 //       allow <field> <value...> with <get|post|put|patch|delete|all> to <uri|any>
 //       allow <field> <value...> with <get|post|put|patch|delete|all>
 //       allow <field> <value...> to <uri|any>
+//       allow <field> <value...> require <acr|amr> <value...>
 //       default <allow|deny>
+//       realm <name>
+//       deny_status <unauthorized|forbidden> <status> [to <path...>]
+//       deny_template <401|403> <html|json> <file|inline> <path|template>
+//       require claim <name> [value...]
+//       require mtls_binding [to <path...>]
+//       require auth_time <duration> [to <path...>]
+//       require single_use [to <path...>]
+//       revocation_file <path>
+//       token_cache_size <number>
+//       invalid_token_cache_ttl <duration>
+//       decision_cache_size <number>
+//       redis {
+//         address <host:port>
+//         username <name>
+//         password <secret>
+//         db <number>
+//         tls
+//         tls_server_name <name>
+//         tls_ca_file <path>
+//         tls_insecure_skip_verify
+//         key_prefix <prefix>
+//         revocation_channel <name>
+//       }
+//       inject header <name> from <claim>
+//       forward_payload_header [<name>] {
+//         allow <claim...>
+//         exclude <claim...>
+//       }
+//       sign_headers <secret>
+//       reissue {
+//         method <alg>
+//         secret <secret>
+//         key_file <path>
+//         key_id <kid>
+//         issuer <iss>
+//         audience <aud...>
+//         ttl <seconds>
+//         claims <claim...>
+//         exclude_claims <claim...>
+//         header <name>
+//       }
+//       token_exchange {
+//         endpoint <url>
+//         client_id <id>
+//         client_secret <secret>
+//         audience <aud...>
+//         resource <uri...>
+//         scope <scope...>
+//         subject_token_type <type>
+//         requested_token_type <type>
+//         cache_ttl <seconds>
+//         header <name>
+//       }
+//       session_cookie {
+//         name <name>
+//         domain <domain>
+//         path <path>
+//         lifetime <seconds>
+//         same_site <lax|strict|none>
+//         reissue
+//         renew_window <seconds>
+//       }
+//       token_refresh {
+//         endpoint <url>
+//         client_id <id>
+//         client_secret <secret>
+//         scope <scope...>
+//         cookie <name>
+//       }
+//       claim_headers {
+//         prefix <prefix>
+//         exclude <claim...>
+//         <claim> <header>
+//       }
+//       claim_namespace <prefix...>
+//       claim_map {
+//         <source claim> <target claim>
+//       }
+//       transform claim <name> <lowercase|trim|strip_email_domain>
+//       transform claim <name> <strip_prefix|strip_suffix> <value>
 //       validate path_acl
+//       issuer_routing <prefer|strict>
+//       provider <keycloak|azure|github|firebase>
+//       provider cognito [id|access]
 //     }
 //
 //     jwt allow roles admin editor viewer
@@ -106,6 +342,64 @@ func parseCaddyfileTokenValidator(h httpcaddyfile.Helper) (caddyhttp.MiddlewareH
 					return nil, fmt.Errorf("%s argument value of %s is unsupported", rootDirective, args[0])
 				}
 				p.AuthURLPath = args[0]
+			case "redirect_param", "redirect_encoding", "redirect_header":
+				args := h.RemainingArgs()
+				if len(args) == 0 {
+					return nil, fmt.Errorf("%s argument has no value", rootDirective)
+				}
+				if len(args) != 1 {
+					return nil, fmt.Errorf("%s argument value of %s is unsupported", rootDirective, args[1])
+				}
+				switch rootDirective {
+				case "redirect_param":
+					p.AuthRedirectQueryParameter = args[0]
+				case "redirect_encoding":
+					if args[0] != "query" && args[0] != "base64url" {
+						return nil, fmt.Errorf("%s argument value of %s is unsupported, expected query or base64url", rootDirective, args[0])
+					}
+					p.AuthRedirectEncoding = args[0]
+				case "redirect_header":
+					p.AuthRedirectHeader = args[0]
+				}
+			case "mode":
+				args := h.RemainingArgs()
+				if len(args) != 1 || (args[0] != "required" && args[0] != "optional") {
+					return nil, h.Errf("%s directive syntax is: mode <required|optional>", rootDirective)
+				}
+				p.Mode = args[0]
+			case "exempt":
+				args := h.RemainingArgs()
+				if len(args) < 2 || args[0] != "path" {
+					return nil, h.Errf("%s directive syntax is: exempt path <path...>", rootDirective)
+				}
+				p.ExemptPaths = append(p.ExemptPaths, args[1:]...)
+			case "guest_roles":
+				args := h.RemainingArgs()
+				if len(args) == 0 {
+					return nil, h.Errf("%s directive has no value", rootDirective)
+				}
+				p.GuestRoles = append(p.GuestRoles, args...)
+			case "verify_endpoint":
+				args := h.RemainingArgs()
+				if len(args) != 1 {
+					return nil, h.Errf("%s directive syntax is: verify_endpoint <path>", rootDirective)
+				}
+				p.VerifyPath = args[0]
+			case "redirect_allowed_hosts":
+				args := h.RemainingArgs()
+				if len(args) == 0 {
+					return nil, h.Errf("%s argument has no value", rootDirective)
+				}
+				p.AuthRedirectAllowedHosts = append(p.AuthRedirectAllowedHosts, args...)
+			case "redirect_loop_limit":
+				if !h.NextArg() {
+					return nil, h.Errf("%s argument has no value", rootDirective)
+				}
+				limit, err := strconv.Atoi(h.Val())
+				if err != nil || limit < 1 {
+					return nil, h.Errf("%s argument value of %s is unsupported", rootDirective, h.Val())
+				}
+				p.RedirectLoopLimit = limit
 			case "trusted_public_key":
 				args := h.RemainingArgs()
 				if len(args) == 0 {
@@ -134,20 +428,104 @@ func parseCaddyfileTokenValidator(h httpcaddyfile.Helper) (caddyhttp.MiddlewareH
 					for subNesting := h.Nesting(); h.NextBlock(subNesting); {
 						backendArg := h.Val()
 						switch backendArg {
-						case "token_rsa_file":
-							rsaArgs := h.RemainingArgs()
-							if len(rsaArgs) != 2 {
+						case "token_rsa_file", "token_ecdsa_file", "token_eddsa_file":
+							keyArgs := h.RemainingArgs()
+							if len(keyArgs) != 2 {
 								return nil, h.Errf("auth backend %s subdirective %s requires two arguments: key id and file path", subDirective, backendArg)
 							}
-							var tokenRSAFiles map[string]string
-							if _, exists := tokenConfigProps["token_rsa_files"]; exists {
-								tokenRSAFiles = tokenConfigProps["token_rsa_files"].(map[string]string)
+							var tokenKeyFiles map[string]string
+							if _, exists := tokenConfigProps[backendArg+"s"]; exists {
+								tokenKeyFiles = tokenConfigProps[backendArg+"s"].(map[string]string)
+							}
+							if tokenKeyFiles == nil {
+								tokenKeyFiles = make(map[string]string)
+							}
+							tokenKeyFiles[keyArgs[0]] = keyArgs[1]
+							tokenConfigProps[backendArg+"s"] = tokenKeyFiles
+						case "token_jwks_header":
+							headerArgs := h.RemainingArgs()
+							if len(headerArgs) != 2 {
+								return nil, h.Errf("auth backend %s subdirective %s requires two arguments: header name and value", subDirective, backendArg)
+							}
+							headers, _ := tokenConfigProps["token_jwks_headers"].(map[string]string)
+							if headers == nil {
+								headers = make(map[string]string)
 							}
-							if tokenRSAFiles == nil {
-								tokenRSAFiles = make(map[string]string)
+							headers[headerArgs[0]] = headerArgs[1]
+							tokenConfigProps["token_jwks_headers"] = headers
+						case "token_jwks_basic_auth":
+							credentialArgs := h.RemainingArgs()
+							if len(credentialArgs) != 2 {
+								return nil, h.Errf("auth backend %s subdirective %s requires two arguments: username and password", subDirective, backendArg)
 							}
-							tokenRSAFiles[rsaArgs[0]] = rsaArgs[1]
-							tokenConfigProps["token_rsa_files"] = tokenRSAFiles
+							tokenConfigProps["token_jwks_basic_auth_username"] = credentialArgs[0]
+							tokenConfigProps["token_jwks_basic_auth_password"] = credentialArgs[1]
+						case "token_rsa_pss_methods", "allowed_algs", "token_audience", "token_issuers",
+							"token_hosted_domains":
+							methodArgs := h.RemainingArgs()
+							if len(methodArgs) == 0 {
+								return nil, h.Errf("auth backend %s subdirective %s has no value", subDirective, backendArg)
+							}
+							tokenConfigProps[backendArg] = methodArgs
+						case "token_jwks_refresh_interval", "token_jwks_min_refresh_interval",
+							"token_jwks_fetch_timeout", "token_jwks_retry_backoff",
+							"token_key_watch_poll_interval", "token_aws_refresh_interval",
+							"token_azure_refresh_interval", "token_gcp_refresh_interval",
+							"token_backend_refresh_interval", "token_introspection_cache_ttl",
+							"token_kubernetes_review_cache_ttl", "token_jwks_warmup_timeout",
+							"token_jwks_http_timeout":
+							if !h.NextArg() {
+								return nil, h.Errf("auth backend %s subdirective %s has no value", subDirective, backendArg)
+							}
+							interval, err := strconv.Atoi(h.Val())
+							if err != nil {
+								d, err := caddy.ParseDuration(h.Val())
+								if err != nil {
+									return nil, h.Errf("auth backend %s subdirective %s has invalid duration %s: %v", subDirective, backendArg, h.Val(), err)
+								}
+								interval = int(d.Seconds())
+								// The intervals are in seconds, and zero selects
+								// the default or disables the feature.
+								if interval == 0 && d != 0 {
+									return nil, h.Errf("auth backend %s subdirective %s duration %s is shorter than the minimum of 1s", subDirective, backendArg, h.Val())
+								}
+							}
+							tokenConfigProps[backendArg] = interval
+						case "token_backend":
+							if !h.NextArg() {
+								return nil, h.Errf("auth backend %s subdirective %s has no value", subDirective, backendArg)
+							}
+							moduleName := h.Val()
+							mod, err := caddy.GetModule("http.authentication.providers.jwt.backends." + moduleName)
+							if err != nil {
+								return nil, h.Errf("auth backend %s subdirective %s module %s: %v", subDirective, backendArg, moduleName, err)
+							}
+							unm, ok := mod.New().(caddyfile.Unmarshaler)
+							if !ok {
+								return nil, h.Errf("auth backend %s subdirective %s module %s is not a Caddyfile unmarshaler", subDirective, backendArg, moduleName)
+							}
+							if err := unm.UnmarshalCaddyfile(h.NewFromNextSegment()); err != nil {
+								return nil, err
+							}
+							tokenConfigProps[backendArg] = caddyconfig.JSONModuleObject(unm, "backend", moduleName, nil)
+						case "token_required_type":
+							// Without value, the type of RFC 9068 access tokens is required.
+							tokenConfigProps[backendArg] = "at+jwt"
+							if h.NextArg() {
+								tokenConfigProps[backendArg] = h.Val()
+							}
+						case "token_jwks_fetch_retries":
+							if !h.NextArg() {
+								return nil, h.Errf("auth backend %s subdirective %s has no value", subDirective, backendArg)
+							}
+							retries, err := strconv.Atoi(h.Val())
+							if err != nil {
+								return nil, h.Errf("auth backend %s subdirective %s has invalid value %s: %v", subDirective, backendArg, h.Val(), err)
+							}
+							tokenConfigProps[backendArg] = retries
+						case "token_jwks_insecure_skip_verify", "token_jwks_tolerate_fetch_errors",
+							"token_key_watch", "token_jwks_lazy_load":
+							tokenConfigProps[backendArg] = true
 						default:
 							if !h.NextArg() {
 								return nil, h.Errf("auth backend %s subdirective %s has no value", subDirective, backendArg)
@@ -195,9 +573,25 @@ func parseCaddyfileTokenValidator(h httpcaddyfile.Helper) (caddyhttp.MiddlewareH
 					case "to":
 						mode = "path"
 						continue
+					case "require":
+						mode = "require"
+						continue
 					}
 
 					switch mode {
+					case "require":
+						if arg != "acr" && arg != "amr" {
+							return nil, fmt.Errorf("%s argument requirement %s is unsupported, expected acr or amr", rootDirective, arg)
+						}
+						mode = arg
+					case "acr":
+						if err := entry.AddRequiredACR(arg); err != nil {
+							return nil, fmt.Errorf("%s argument required acr %s error: %s", rootDirective, arg, err)
+						}
+					case "amr":
+						if err := entry.AddRequiredAMR(arg); err != nil {
+							return nil, fmt.Errorf("%s argument required amr %s error: %s", rootDirective, arg, err)
+						}
 					case "roles":
 						if err := entry.AddValue(arg); err != nil {
 							return nil, fmt.Errorf("%s argument claim value %s error: %s", rootDirective, arg, err)
@@ -217,6 +611,10 @@ func parseCaddyfileTokenValidator(h httpcaddyfile.Helper) (caddyhttp.MiddlewareH
 						p.ValidateMethodPath = true
 					}
 				}
+				switch {
+				case mode == "require", mode == "acr" && len(entry.RequiredACR) == 0, mode == "amr" && len(entry.RequiredAMR) == 0:
+					return nil, fmt.Errorf("%s argument requirement has no value", rootDirective)
+				}
 				p.AccessList = append(p.AccessList, entry)
 			case "disable":
 				args := h.RemainingArgs()
@@ -230,6 +628,10 @@ func parseCaddyfileTokenValidator(h httpcaddyfile.Helper) (caddyhttp.MiddlewareH
 					p.AuthRedirectDisabled = true
 				case "delete_auth_cookies":
 					p.AuthCookiesDeleteDisabled = true
+				case "content_negotiation":
+					p.ContentNegotiationDisabled = true
+				case "redirect_loop_check":
+					p.RedirectLoopCheckDisabled = true
 				default:
 					return nil, fmt.Errorf("%s argument %s is unsupported", rootDirective, args[0])
 				}
@@ -259,7 +661,61 @@ func parseCaddyfileTokenValidator(h httpcaddyfile.Helper) (caddyhttp.MiddlewareH
 				}
 				switch args[0] {
 				case "validate_bearer_header":
+					// The arguments are the schemes accepted in addition
+					// to Bearer, e.g. JWT.
 					p.TokenValidatorOptions.ValidateBearerHeader = true
+					p.TokenValidatorOptions.BearerSchemes = append(p.TokenValidatorOptions.BearerSchemes, args[1:]...)
+				case "strict":
+					p.TokenValidatorOptions.ValidateStrict = true
+				case "require_exp":
+					p.TokenValidatorOptions.ValidateRequireExpiration = true
+				case "dpop":
+					p.TokenValidatorOptions.ValidateDPoP = true
+				case "mtls_binding":
+					p.TokenValidatorOptions.ValidateCertificateBinding = true
+				case "first_valid_token":
+					p.TokenValidatorOptions.ValidateFirstValidToken = true
+				case "clock_skew", "max_token_lifetime", "dpop_max_age", "expiry_grace_period":
+					if len(args) != 2 {
+						return nil, fmt.Errorf("%s argument %s has no value", rootDirective, args[0])
+					}
+					interval, err := strconv.Atoi(args[1])
+					if err != nil {
+						d, err := caddy.ParseDuration(args[1])
+						if err != nil {
+							return nil, fmt.Errorf("%s argument %s has invalid duration %s: %v", rootDirective, args[0], args[1], err)
+						}
+						interval = int(d.Seconds())
+					}
+					if interval < 0 {
+						return nil, fmt.Errorf("%s argument %s must not be negative: %s", rootDirective, args[0], args[1])
+					}
+					switch args[0] {
+					case "clock_skew":
+						p.TokenValidatorOptions.ClockSkew = interval
+					case "max_token_lifetime":
+						p.TokenValidatorOptions.MaxTokenLifetime = interval
+					case "expiry_grace_period":
+						p.TokenValidatorOptions.ExpiryGracePeriod = interval
+					default:
+						p.TokenValidatorOptions.DPoPMaxAge = interval
+					}
+				case "max_token_size", "max_token_claims", "max_token_depth":
+					if len(args) != 2 {
+						return nil, fmt.Errorf("%s argument %s has no value", rootDirective, args[0])
+					}
+					limit, err := strconv.Atoi(args[1])
+					if err != nil || limit <= 0 {
+						return nil, fmt.Errorf("%s argument %s must be a positive number: %s", rootDirective, args[0], args[1])
+					}
+					switch args[0] {
+					case "max_token_size":
+						p.TokenValidatorOptions.MaxTokenSize = limit
+					case "max_token_claims":
+						p.TokenValidatorOptions.MaxTokenClaims = limit
+					default:
+						p.TokenValidatorOptions.MaxTokenDepth = limit
+					}
 				default:
 					return nil, fmt.Errorf("%s argument %s is unsupported", rootDirective, args[0])
 				}
@@ -268,9 +724,24 @@ func parseCaddyfileTokenValidator(h httpcaddyfile.Helper) (caddyhttp.MiddlewareH
 				switch args {
 				case "claim headers":
 					p.PassClaimsWithHeaders = true
+				case "forwarded headers":
+					p.PassForwardedHeaders = true
+				case "expiry headers":
+					p.PassExpiryHeaders = true
+				case "expiry response headers":
+					p.PassExpiryResponseHeaders = true
+				case "error routes":
+					p.ErrorRoutesEnabled = true
+				case "request context headers":
+					p.PassRequestContextHeaders = true
 				default:
 					return nil, h.Errf("unsupported directive for %s: %s", rootDirective, args)
 				}
+			case "strip_token":
+				if h.NextArg() {
+					return nil, h.Errf("%s directive has unexpected value %s", rootDirective, h.Val())
+				}
+				p.StripToken = true
 			case "default":
 				if !h.NextArg() {
 					return nil, h.Errf("%s argument has no value", rootDirective)
@@ -283,11 +754,530 @@ func parseCaddyfileTokenValidator(h httpcaddyfile.Helper) (caddyhttp.MiddlewareH
 					return nil, h.Errf("%s argument has no value", rootDirective)
 				}
 				p.ForbiddenURL = h.Val()
+			case "deny_status":
+				args := h.RemainingArgs()
+				if len(args) != 2 && (len(args) < 4 || args[2] != "to") {
+					return nil, h.Errf("%s directive syntax is: deny_status <unauthorized|forbidden> <status> [to <path...>]", rootDirective)
+				}
+				status, err := strconv.Atoi(args[1])
+				if err != nil {
+					return nil, h.Errf("%s directive has invalid status %s", rootDirective, args[1])
+				}
+				denyStatus := &jwtconfig.DenyStatus{
+					Denial: args[0],
+					Status: status,
+				}
+				if len(args) > 2 {
+					denyStatus.Paths = args[3:]
+				}
+				p.DenyStatuses = append(p.DenyStatuses, denyStatus)
+			case "realm":
+				if !h.NextArg() {
+					return nil, h.Errf("%s argument has no value", rootDirective)
+				}
+				p.Realm = h.Val()
+			case "deny_template":
+				args := h.RemainingArgs()
+				if len(args) != 4 {
+					return nil, h.Errf("%s directive must have status, format, source, and value: %v", rootDirective, args)
+				}
+				status, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, h.Errf("%s directive has invalid status %s", rootDirective, args[0])
+				}
+				tmpl := &jwtconfig.DenyTemplate{
+					Status: status,
+					Format: args[1],
+				}
+				switch args[2] {
+				case "file":
+					tmpl.File = args[3]
+				case "inline":
+					tmpl.Template = args[3]
+				default:
+					return nil, h.Errf("%s directive source %s is unsupported", rootDirective, args[2])
+				}
+				p.DenyTemplates = append(p.DenyTemplates, tmpl)
+			case "revocation_file":
+				if !h.NextArg() {
+					return nil, h.Errf("%s argument has no value", rootDirective)
+				}
+				p.RevocationFile = h.Val()
+			case "token_cache_size", "decision_cache_size":
+				arg := h.Val()
+				if !h.NextArg() {
+					return nil, h.Errf("%s argument has no value", rootDirective)
+				}
+				size, err := strconv.Atoi(h.Val())
+				if err != nil || size < 0 {
+					return nil, h.Errf("%s argument %s value %s is not a number of tokens", rootDirective, arg, h.Val())
+				}
+				if arg == "token_cache_size" {
+					p.TokenCacheSize = size
+				} else {
+					p.DecisionCacheSize = size
+				}
+			case "invalid_token_cache_ttl":
+				if !h.NextArg() {
+					return nil, h.Errf("%s argument has no value", rootDirective)
+				}
+				ttl, err := strconv.Atoi(h.Val())
+				if err != nil {
+					d, err := caddy.ParseDuration(h.Val())
+					if err != nil {
+						return nil, h.Errf("%s argument %s has invalid duration %s: %v", rootDirective, "invalid_token_cache_ttl", h.Val(), err)
+					}
+					ttl = int(d.Seconds())
+					if ttl == 0 && d > 0 {
+						return nil, h.Errf("%s argument %s duration %s is shorter than the minimum of 1s", rootDirective, "invalid_token_cache_ttl", h.Val())
+					}
+				}
+				if ttl <= 0 {
+					return nil, h.Errf("%s argument %s must be positive: %s", rootDirective, "invalid_token_cache_ttl", h.Val())
+				}
+				p.InvalidTokenCacheTTL = ttl
 			case "user_identity":
 				if !h.NextArg() {
 					return nil, h.Errf("%s argument has no value", rootDirective)
 				}
 				p.UserIdentityField = h.Val()
+			case "require":
+				args := h.RemainingArgs()
+				if len(args) > 0 && (args[0] == "mtls_binding" || args[0] == "single_use") {
+					var paths []string
+					switch {
+					case len(args) == 1:
+						paths = []string{"any"}
+					case len(args) > 2 && args[1] == "to":
+						paths = args[2:]
+					default:
+						return nil, h.Errf("%s directive syntax is: require %s [to <path...>]", rootDirective, args[0])
+					}
+					if args[0] == "mtls_binding" {
+						p.CertificateBoundPaths = append(p.CertificateBoundPaths, paths...)
+					} else {
+						p.SingleUsePaths = append(p.SingleUsePaths, paths...)
+					}
+					continue
+				}
+				if len(args) > 0 && args[0] == "auth_time" {
+					if len(args) < 2 || (len(args) > 2 && (args[2] != "to" || len(args) == 3)) {
+						return nil, h.Errf("%s directive syntax is: require auth_time <duration> [to <path...>]", rootDirective)
+					}
+					maxAge, err := strconv.Atoi(args[1])
+					if err != nil {
+						d, err := caddy.ParseDuration(args[1])
+						if err != nil {
+							return nil, h.Errf("%s directive auth_time has invalid duration %s: %v", rootDirective, args[1], err)
+						}
+						maxAge = int(d.Seconds())
+					}
+					if maxAge <= 0 {
+						return nil, h.Errf("%s directive auth_time must be positive: %s", rootDirective, args[1])
+					}
+					requirement := &jwtconfig.AuthTimeRequirement{
+						MaxAge: maxAge,
+					}
+					if len(args) > 2 {
+						requirement.Paths = args[3:]
+					}
+					p.AuthTimeRequirements = append(p.AuthTimeRequirements, requirement)
+					continue
+				}
+				if len(args) < 2 || args[0] != "claim" {
+					return nil, h.Errf("%s directive must be followed by claim and the claim name", rootDirective)
+				}
+				p.RequiredClaims = append(p.RequiredClaims, &jwtconfig.RequiredClaim{
+					Name:   args[1],
+					Values: args[2:],
+				})
+			case "inject":
+				args := h.RemainingArgs()
+				if len(args) != 4 || args[0] != "header" || args[2] != "from" {
+					return nil, h.Errf("%s directive syntax is: inject header <name> from <claim>", rootDirective)
+				}
+				p.InjectHeaders = append(p.InjectHeaders, &jwtconfig.HeaderInjection{
+					Header: args[1],
+					Claim:  args[3],
+				})
+			case "claim_namespace":
+				args := h.RemainingArgs()
+				if len(args) == 0 {
+					return nil, h.Errf("%s directive has no value", rootDirective)
+				}
+				p.ClaimNamespaces = append(p.ClaimNamespaces, args...)
+			case "transform":
+				args := h.RemainingArgs()
+				if len(args) < 3 || len(args) > 4 || args[0] != "claim" {
+					return nil, h.Errf("%s directive syntax is: transform claim <name> <transform> [value]", rootDirective)
+				}
+				t := &jwtconfig.ClaimTransform{
+					Claim:     args[1],
+					Transform: args[2],
+				}
+				if len(args) == 4 {
+					t.Value = args[3]
+				}
+				if err := t.Validate(); err != nil {
+					return nil, h.Errf("%s directive error: %v", rootDirective, err)
+				}
+				p.ClaimTransforms = append(p.ClaimTransforms, t)
+			case "redis":
+				redisConfig := &jwtconfig.RedisConfig{}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					subDirective := h.Val()
+					switch subDirective {
+					case "tls":
+						redisConfig.TLS = true
+						continue
+					case "tls_insecure_skip_verify":
+						redisConfig.TLS = true
+						redisConfig.TLSInsecureSkipVerify = true
+						continue
+					}
+					if !h.NextArg() {
+						return nil, h.Errf("%s subdirective %s has no value", rootDirective, subDirective)
+					}
+					switch subDirective {
+					case "address":
+						redisConfig.Address = h.Val()
+					case "username":
+						redisConfig.Username = h.Val()
+					case "password":
+						redisConfig.Password = h.Val()
+					case "db":
+						db, err := strconv.Atoi(h.Val())
+						if err != nil || db < 0 {
+							return nil, h.Errf("%s subdirective %s value %s is not a database number", rootDirective, subDirective, h.Val())
+						}
+						redisConfig.DB = db
+					case "tls_server_name":
+						redisConfig.TLS = true
+						redisConfig.TLSServerName = h.Val()
+					case "tls_ca_file":
+						redisConfig.TLS = true
+						redisConfig.TLSCAFile = h.Val()
+					case "key_prefix":
+						redisConfig.KeyPrefix = h.Val()
+					case "revocation_channel":
+						redisConfig.RevocationChannel = h.Val()
+					default:
+						return nil, h.Errf("%s subdirective %s is unsupported", rootDirective, subDirective)
+					}
+				}
+				if redisConfig.Address == "" {
+					return nil, h.Errf("%s directive has no address", rootDirective)
+				}
+				p.Redis = redisConfig
+			case "sign_headers":
+				args := h.RemainingArgs()
+				if len(args) != 1 {
+					return nil, h.Errf("%s directive must have secret", rootDirective)
+				}
+				p.HeaderSigningSecret = args[0]
+			case "reissue":
+				reissueConfig := &jwtconfig.ReissueConfig{}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					subDirective := h.Val()
+					args := h.RemainingArgs()
+					if len(args) == 0 {
+						return nil, h.Errf("%s subdirective %s has no value", rootDirective, subDirective)
+					}
+					switch subDirective {
+					case "audience":
+						reissueConfig.Audience = append(reissueConfig.Audience, args...)
+						continue
+					case "claims":
+						reissueConfig.Claims = append(reissueConfig.Claims, args...)
+						continue
+					case "exclude_claims":
+						reissueConfig.ExcludeClaims = append(reissueConfig.ExcludeClaims, args...)
+						continue
+					}
+					if len(args) != 1 {
+						return nil, h.Errf("%s subdirective %s must have a single value", rootDirective, subDirective)
+					}
+					switch subDirective {
+					case "method":
+						reissueConfig.Method = args[0]
+					case "secret":
+						reissueConfig.Secret = args[0]
+					case "key_file":
+						reissueConfig.KeyFile = args[0]
+					case "key_id":
+						reissueConfig.KeyID = args[0]
+					case "issuer":
+						reissueConfig.Issuer = args[0]
+					case "ttl":
+						ttl, err := strconv.Atoi(args[0])
+						if err != nil || ttl <= 0 {
+							return nil, h.Errf("%s subdirective %s value %s is not a positive number of seconds", rootDirective, subDirective, args[0])
+						}
+						reissueConfig.TTL = ttl
+					case "header":
+						reissueConfig.Header = args[0]
+					default:
+						return nil, h.Errf("%s subdirective %s is unsupported", rootDirective, subDirective)
+					}
+				}
+				if reissueConfig.Secret == "" && reissueConfig.KeyFile == "" {
+					return nil, h.Errf("%s directive has no secret or key file", rootDirective)
+				}
+				p.Reissue = reissueConfig
+			case "token_exchange":
+				exchangeConfig := &jwtconfig.TokenExchangeConfig{}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					subDirective := h.Val()
+					args := h.RemainingArgs()
+					if len(args) == 0 {
+						return nil, h.Errf("%s subdirective %s has no value", rootDirective, subDirective)
+					}
+					switch subDirective {
+					case "audience":
+						exchangeConfig.Audience = append(exchangeConfig.Audience, args...)
+						continue
+					case "resource":
+						exchangeConfig.Resource = append(exchangeConfig.Resource, args...)
+						continue
+					case "scope":
+						exchangeConfig.Scope = append(exchangeConfig.Scope, args...)
+						continue
+					}
+					if len(args) != 1 {
+						return nil, h.Errf("%s subdirective %s must have a single value", rootDirective, subDirective)
+					}
+					switch subDirective {
+					case "endpoint":
+						exchangeConfig.Endpoint = args[0]
+					case "client_id":
+						exchangeConfig.ClientID = args[0]
+					case "client_secret":
+						exchangeConfig.ClientSecret = args[0]
+					case "subject_token_type":
+						exchangeConfig.SubjectTokenType = args[0]
+					case "requested_token_type":
+						exchangeConfig.RequestedTokenType = args[0]
+					case "cache_ttl":
+						ttl, err := strconv.Atoi(args[0])
+						if err != nil {
+							return nil, h.Errf("%s subdirective %s value %s is not a number of seconds", rootDirective, subDirective, args[0])
+						}
+						exchangeConfig.CacheTTL = ttl
+					case "header":
+						exchangeConfig.Header = args[0]
+					default:
+						return nil, h.Errf("%s subdirective %s is unsupported", rootDirective, subDirective)
+					}
+				}
+				if exchangeConfig.Endpoint == "" {
+					return nil, h.Errf("%s directive has no endpoint", rootDirective)
+				}
+				p.TokenExchange = exchangeConfig
+			case "session_cookie":
+				cookieConfig := &jwtconfig.SessionCookieConfig{}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					subDirective := h.Val()
+					if subDirective == "reissue" {
+						cookieConfig.Reissue = true
+						continue
+					}
+					if !h.NextArg() {
+						return nil, h.Errf("%s subdirective %s has no value", rootDirective, subDirective)
+					}
+					switch subDirective {
+					case "name":
+						cookieConfig.Name = h.Val()
+					case "domain":
+						cookieConfig.Domain = h.Val()
+					case "path":
+						cookieConfig.Path = h.Val()
+					case "lifetime":
+						lifetime, err := strconv.Atoi(h.Val())
+						if err != nil || lifetime <= 0 {
+							return nil, h.Errf("%s subdirective %s value %s is not a positive number of seconds", rootDirective, subDirective, h.Val())
+						}
+						cookieConfig.Lifetime = lifetime
+					case "renew_window":
+						window, err := strconv.Atoi(h.Val())
+						if err != nil || window <= 0 {
+							return nil, h.Errf("%s subdirective %s value %s is not a positive number of seconds", rootDirective, subDirective, h.Val())
+						}
+						cookieConfig.RenewWindow = window
+					case "same_site":
+						switch h.Val() {
+						case "lax", "strict", "none":
+							cookieConfig.SameSite = h.Val()
+						default:
+							return nil, h.Errf("%s subdirective %s value %s is unsupported", rootDirective, subDirective, h.Val())
+						}
+					default:
+						return nil, h.Errf("%s subdirective %s is unsupported", rootDirective, subDirective)
+					}
+				}
+				p.SessionCookie = cookieConfig
+			case "token_refresh":
+				refreshConfig := &jwtconfig.TokenRefreshConfig{}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					subDirective := h.Val()
+					args := h.RemainingArgs()
+					if len(args) == 0 {
+						return nil, h.Errf("%s subdirective %s has no value", rootDirective, subDirective)
+					}
+					if subDirective == "scope" {
+						refreshConfig.Scope = append(refreshConfig.Scope, args...)
+						continue
+					}
+					if len(args) != 1 {
+						return nil, h.Errf("%s subdirective %s must have a single value", rootDirective, subDirective)
+					}
+					switch subDirective {
+					case "endpoint":
+						refreshConfig.Endpoint = args[0]
+					case "client_id":
+						refreshConfig.ClientID = args[0]
+					case "client_secret":
+						refreshConfig.ClientSecret = args[0]
+					case "cookie":
+						refreshConfig.Cookie = args[0]
+					default:
+						return nil, h.Errf("%s subdirective %s is unsupported", rootDirective, subDirective)
+					}
+				}
+				if refreshConfig.Endpoint == "" {
+					return nil, h.Errf("%s directive has no endpoint", rootDirective)
+				}
+				p.TokenRefresh = refreshConfig
+			case "forward_payload_header":
+				args := h.RemainingArgs()
+				switch len(args) {
+				case 0:
+					p.PayloadHeader = "X-Jwt-Payload"
+				case 1:
+					p.PayloadHeader = args[0]
+				default:
+					return nil, h.Errf("%s directive has too many values", rootDirective)
+				}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					subDirective := h.Val()
+					claims := h.RemainingArgs()
+					if len(claims) == 0 {
+						return nil, h.Errf("%s subdirective %s has no claims", rootDirective, subDirective)
+					}
+					if p.PayloadClaims == nil {
+						p.PayloadClaims = &jwtconfig.ClaimFilter{}
+					}
+					switch subDirective {
+					case "allow":
+						p.PayloadClaims.Allow = append(p.PayloadClaims.Allow, claims...)
+					case "exclude":
+						p.PayloadClaims.Exclude = append(p.PayloadClaims.Exclude, claims...)
+					default:
+						return nil, h.Errf("%s subdirective %s is unsupported", rootDirective, subDirective)
+					}
+				}
+			case "claim_headers":
+				p.ClaimHeaders = &jwtconfig.ClaimHeaders{}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					args := append([]string{h.Val()}, h.RemainingArgs()...)
+					switch {
+					case args[0] == "prefix" && len(args) == 2:
+						p.ClaimHeaders.Prefix = args[1]
+					case args[0] == "exclude" && len(args) > 1:
+						p.ClaimHeaders.Exclude = append(p.ClaimHeaders.Exclude, args[1:]...)
+					case len(args) == 2:
+						p.ClaimHeaders.Headers = append(p.ClaimHeaders.Headers, &jwtconfig.HeaderInjection{
+							Claim:  args[0],
+							Header: args[1],
+						})
+					default:
+						return nil, h.Errf("%s directive entry must have claim and header: %s", rootDirective, strings.Join(args, " "))
+					}
+				}
+			case "claim_map":
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					args := append([]string{h.Val()}, h.RemainingArgs()...)
+					if len(args) != 2 {
+						return nil, h.Errf("%s directive entry must have source and target claims: %s", rootDirective, strings.Join(args, " "))
+					}
+					p.ClaimMap = append(p.ClaimMap, &jwtconfig.ClaimMapping{
+						From: args[0],
+						To:   args[1],
+					})
+				}
+			case "token_sources":
+				args := h.RemainingArgs()
+				if len(args) == 0 {
+					return nil, h.Errf("%s directive has no value", rootDirective)
+				}
+				for _, arg := range args {
+					if _, exists := jwtvalidator.TokenSources[arg]; !exists {
+						return nil, h.Errf("%s directive value %s is unsupported", rootDirective, arg)
+					}
+					for _, source := range p.AllowedTokenSources {
+						if source == arg {
+							return nil, h.Errf("%s directive value %s is duplicate", rootDirective, arg)
+						}
+					}
+					p.AllowedTokenSources = append(p.AllowedTokenSources, arg)
+				}
+			case "token_header_names", "token_cookie_names", "token_query_names", "token_form_names":
+				args := h.RemainingArgs()
+				if len(args) == 0 {
+					return nil, h.Errf("%s directive has no value", rootDirective)
+				}
+				switch rootDirective {
+				case "token_header_names":
+					p.TokenHeaderNames = append(p.TokenHeaderNames, args...)
+				case "token_cookie_names":
+					p.TokenCookieNames = append(p.TokenCookieNames, args...)
+				case "token_query_names":
+					p.TokenQueryNames = append(p.TokenQueryNames, args...)
+				default:
+					p.TokenFormNames = append(p.TokenFormNames, args...)
+				}
+			case "token_form_max_body_size":
+				if !h.NextArg() {
+					return nil, h.Errf("%s argument has no value", rootDirective)
+				}
+				size, err := strconv.ParseInt(h.Val(), 10, 64)
+				if err != nil || size <= 0 {
+					return nil, h.Errf("%s argument value %s is not a number of bytes", rootDirective, h.Val())
+				}
+				p.TokenFormMaxBodySize = size
+			case "issuer_routing":
+				if !h.NextArg() {
+					return nil, h.Errf("%s argument has no value", rootDirective)
+				}
+				switch h.Val() {
+				case "prefer", "strict":
+					p.IssuerRouting = h.Val()
+				default:
+					return nil, h.Errf("%s argument %s is unsupported", rootDirective, h.Val())
+				}
+			case "provider":
+				if !h.NextArg() {
+					return nil, h.Errf("%s argument has no value", rootDirective)
+				}
+				switch h.Val() {
+				case jwtclaims.ProviderKeycloak, jwtclaims.ProviderAzure, jwtclaims.ProviderGitHub,
+					jwtclaims.ProviderFirebase:
+					p.Provider = h.Val()
+				case jwtclaims.ProviderCognito:
+					p.Provider = h.Val()
+					if h.NextArg() {
+						switch h.Val() {
+						case "id", "access":
+							p.TokenUse = h.Val()
+						default:
+							return nil, h.Errf("%s token use %s is unsupported", rootDirective, h.Val())
+						}
+					}
+				default:
+					return nil, h.Errf("%s argument %s is unsupported", rootDirective, h.Val())
+				}
+				if h.NextArg() {
+					return nil, h.Errf("%s directive has too many arguments", rootDirective)
+				}
 			default:
 				return nil, h.Errf("unsupported root directive: %s", rootDirective)
 			}
@@ -15,11 +15,16 @@
 package jwt
 
 import (
+	"encoding/json"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/caddytest"
-	jwtlib "github.com/dgrijalva/jwt-go"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
+	jwtlib "github.com/golang-jwt/jwt/v5"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -361,3 +366,201 @@ func TestCaddyfile(t *testing.T) {
 
 	time.Sleep(1 * time.Second)
 }
+
+func TestParseCaddyfileTokenSources(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected []string
+		err      string
+	}{
+		{
+			name:     "ordered token sources",
+			config:   "jwt {\n token_sources cookie header query\n}",
+			expected: []string{"cookie", "header", "query"},
+		},
+		{
+			name:   "duplicate token source",
+			config: "jwt {\n token_sources cookie header cookie\n}",
+			err:    "token_sources directive value cookie is duplicate",
+		},
+		{
+			name:   "unsupported token source",
+			config: "jwt {\n token_sources cookie body\n}",
+			err:    "token_sources directive value body is unsupported",
+		},
+		{
+			name:   "token sources without value",
+			config: "jwt {\n token_sources\n}",
+			err:    "token_sources directive has no value",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(test.config)}
+			handler, err := parseCaddyfileTokenValidator(h)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("unexpected error: %v, expected: %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var m AuthMiddleware
+			if err := json.Unmarshal(handler.(caddyauth.Authentication).ProvidersRaw["jwt"], &m); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m.Authorizer.AllowedTokenSources, test.expected) {
+				t.Fatalf("unexpected token sources: %v, expected: %v", m.Authorizer.AllowedTokenSources, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseCaddyfileTokenCacheSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected int
+		err      string
+	}{
+		{
+			name:     "default token cache size",
+			config:   "jwt {\n allow roles admin\n}",
+			expected: 0,
+		},
+		{
+			name:     "token cache size",
+			config:   "jwt {\n token_cache_size 500\n}",
+			expected: 500,
+		},
+		{
+			name:     "disabled token cache",
+			config:   "jwt {\n token_cache_size 0\n}",
+			expected: 0,
+		},
+		{
+			name:   "negative token cache size",
+			config: "jwt {\n token_cache_size -1\n}",
+			err:    "token_cache_size value -1 is not a number of tokens",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(test.config)}
+			handler, err := parseCaddyfileTokenValidator(h)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("unexpected error: %v, expected: %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var m AuthMiddleware
+			if err := json.Unmarshal(handler.(caddyauth.Authentication).ProvidersRaw["jwt"], &m); err != nil {
+				t.Fatal(err)
+			}
+			if m.Authorizer.TokenCacheSize != test.expected {
+				t.Fatalf("unexpected token cache size: %d, expected: %d", m.Authorizer.TokenCacheSize, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseCaddyfileBackendDurations(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected int
+		err      string
+	}{
+		{name: "seconds", value: "30", expected: 30},
+		{name: "duration", value: "2m", expected: 120},
+		{name: "disabled", value: "-1", expected: -1},
+		{
+			name:  "duration shorter than second",
+			value: "500ms",
+			err:   "subdirective token_jwks_http_timeout duration 500ms is shorter than the minimum of 1s",
+		},
+		{
+			name:  "invalid duration",
+			value: "fast",
+			err:   "subdirective token_jwks_http_timeout has invalid duration fast",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := "jwt {\n trusted_tokens {\n  jwks {\n   token_jwks_url https://auth.example.com/jwks\n   token_jwks_http_timeout " + test.value + "\n  }\n }\n}"
+			h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(config)}
+			handler, err := parseCaddyfileTokenValidator(h)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("unexpected error: %v, expected: %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var m AuthMiddleware
+			if err := json.Unmarshal(handler.(caddyauth.Authentication).ProvidersRaw["jwt"], &m); err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Authorizer.TrustedTokens) != 1 {
+				t.Fatalf("unexpected trusted tokens: %v", m.Authorizer.TrustedTokens)
+			}
+			if got := m.Authorizer.TrustedTokens[0].TokenJwksHTTPTimeout; got != test.expected {
+				t.Fatalf("unexpected timeout: %d, expected: %d", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseCaddyfileInvalidTokenCacheTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected int
+		err      string
+	}{
+		{name: "seconds", value: "30", expected: 30},
+		{name: "duration", value: "1m", expected: 60},
+		{
+			name:  "duration shorter than second",
+			value: "500ms",
+			err:   "invalid_token_cache_ttl duration 500ms is shorter than the minimum of 1s",
+		},
+		{
+			name:  "zero",
+			value: "0",
+			err:   "invalid_token_cache_ttl must be positive: 0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := "jwt {\n invalid_token_cache_ttl " + test.value + "\n}"
+			h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(config)}
+			handler, err := parseCaddyfileTokenValidator(h)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("unexpected error: %v, expected: %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var m AuthMiddleware
+			if err := json.Unmarshal(handler.(caddyauth.Authentication).ProvidersRaw["jwt"], &m); err != nil {
+				t.Fatal(err)
+			}
+			if m.Authorizer.InvalidTokenCacheTTL != test.expected {
+				t.Fatalf("unexpected ttl: %d, expected: %d", m.Authorizer.InvalidTokenCacheTTL, test.expected)
+			}
+		})
+	}
+}
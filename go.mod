@@ -3,13 +3,20 @@ module github.com/greenpau/caddy-auth-jwt
 go 1.15
 
 require (
+	github.com/aws/aws-sdk-go v1.30.29
 	github.com/caddyserver/caddy/v2 v2.3.0
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible
-	github.com/google/go-cmp v0.4.0
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/google/go-cmp v0.5.5
 	github.com/imdario/mergo v0.3.9 // indirect
 	github.com/manifoldco/promptui v0.7.0 // indirect
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
+	github.com/prometheus/client_golang v1.9.0
 	github.com/satori/go.uuid v1.2.0
 	go.uber.org/zap v1.16.0
+	golang.org/x/oauth2 v0.0.0-20191202225959-858c2ad4c8b6
+	golang.org/x/sync v0.0.0-20201020160332-67f06af15bc9
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
+	gopkg.in/square/go-jose.v2 v2.5.1
 )
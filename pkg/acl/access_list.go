@@ -20,9 +20,15 @@ import (
 	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
 	"regexp"
 	"strings"
+	"sync"
 )
 
-var pathACLPatterns map[string]*regexp.Regexp
+// pathACLPatterns holds the compiled path patterns, shared by the requests
+// matching them concurrently.
+var (
+	pathACLPatterns   map[string]*regexp.Regexp
+	pathACLPatternsMu sync.RWMutex
+)
 
 func init() {
 	pathACLPatterns = make(map[string]*regexp.Regexp)
@@ -35,6 +41,11 @@ type AccessListEntry struct {
 	Claim   string   `json:"claim,omitempty"`
 	Methods []string `json:"method,omitempty"`
 	Path    string   `json:"path,omitempty"`
+	// The authentication context required by the entry. The acr claim of
+	// the token must be one of the RequiredACR values, and the amr claim
+	// must contain all of the RequiredAMR values.
+	RequiredACR []string `json:"required_acr,omitempty"`
+	RequiredAMR []string `json:"required_amr,omitempty"`
 }
 
 // NewAccessListEntry return an instance of AccessListEntry.
@@ -56,6 +67,9 @@ func (acl *AccessListEntry) Validate() error {
 	if len(acl.Values) == 0 {
 		return errors.ErrNoValues
 	}
+	if acl.Action == "deny" && (len(acl.RequiredACR) > 0 || len(acl.RequiredAMR) > 0) {
+		return errors.ErrACLRequireWithDeny
+	}
 	return nil
 }
 
@@ -94,11 +108,30 @@ func (acl *AccessListEntry) SetClaim(s string) error {
 		"audiences": "audience",
 		"scopes": "scopes",
 		"scope": "scopes",
+		// The permissions of Auth0 users are in permissions claim.
+		"permissions": "permissions",
+		"permission": "permissions",
+		// The claims of GitHub Actions OIDC tokens describing the workflow run.
+		"sub": "sub",
+		"repository": "repository",
+		"repository_owner": "repository_owner",
+		"ref": "ref",
+		"ref_type": "ref_type",
+		"environment": "environment",
+		"workflow": "workflow",
+		"job_workflow_ref": "job_workflow_ref",
+		"actor": "actor",
+		"event_name": "event_name",
 	}
 	if s == "" {
 		return errors.ErrEmptyClaim
 	}
 	if _, exists := supportedClaims[s]; !exists {
+		// The nested claims, e.g. realm_access.roles, are referenced by path.
+		if strings.Contains(s, ".") {
+			acl.Claim = s
+			return nil
+		}
 		return errors.ErrUnsupportedClaim.WithArgs(s)
 	}
 	acl.Claim = supportedClaims[s]
@@ -129,6 +162,26 @@ func (acl *AccessListEntry) SetPath(s string) error {
 	return nil
 }
 
+// AddRequiredACR adds the authentication context class, e.g. urn:mfa,
+// required by an access list entry.
+func (acl *AccessListEntry) AddRequiredACR(s string) error {
+	if s == "" {
+		return errors.ErrEmptyValue
+	}
+	acl.RequiredACR = append(acl.RequiredACR, s)
+	return nil
+}
+
+// AddRequiredAMR adds the authentication method, e.g. otp, required by
+// an access list entry.
+func (acl *AccessListEntry) AddRequiredAMR(s string) error {
+	if s == "" {
+		return errors.ErrEmptyValue
+	}
+	acl.RequiredAMR = append(acl.RequiredAMR, s)
+	return nil
+}
+
 // AddValue adds value to an access list entry.
 func (acl *AccessListEntry) AddValue(s string) error {
 	if s == "" {
@@ -165,8 +218,6 @@ func (acl *AccessListEntry) GetValues() string {
 // IsClaimAllowed checks whether access list entry allows the claims.
 func (acl *AccessListEntry) IsClaimAllowed(userClaims *jwtclaims.UserClaims, opts *jwtconfig.TokenValidatorOptions) (bool, bool) {
 	claimMatches := false
-	methodMatches := false
-	pathMatches := false
 	switch acl.Claim {
 	case "roles":
 		if len(userClaims.Roles) == 0 {
@@ -214,9 +265,37 @@ func (acl *AccessListEntry) IsClaimAllowed(userClaims *jwtclaims.UserClaims, opt
 			}
 		}
 	default:
-		return false, false
+		values := userClaims.GetClaimValues(acl.Claim)
+		if len(values) == 0 {
+			return false, false
+		}
+		for _, v := range values {
+			if claimMatches {
+				break
+			}
+			for _, value := range acl.Values {
+				if value == v || value == "*" || value == "any" {
+					claimMatches = true
+					break
+				}
+			}
+		}
 	}
 
+	if claimMatches && acl.MatchesRequest(opts) {
+		if acl.Action == "allow" {
+			return true, false
+		}
+		return false, true
+	}
+	return false, false
+}
+
+// MatchesRequest checks whether the method and the path of the request, in
+// the metadata of the options, match the entry.
+func (acl *AccessListEntry) MatchesRequest(opts *jwtconfig.TokenValidatorOptions) bool {
+	methodMatches := false
+	pathMatches := false
 	if opts != nil {
 		if opts.ValidateMethodPath && opts.Metadata != nil {
 			// The opts.Metadata shoud contain method and path keys
@@ -256,14 +335,38 @@ func (acl *AccessListEntry) IsClaimAllowed(userClaims *jwtclaims.UserClaims, opt
 		methodMatches = true
 		pathMatches = true
 	}
+	return methodMatches && pathMatches
+}
 
-	if claimMatches && methodMatches && pathMatches {
-		if acl.Action == "allow" {
-			return true, false
+// IsAuthContextSatisfied checks whether the acr and amr claims satisfy the
+// authentication context required by access list entry.
+func (acl *AccessListEntry) IsAuthContextSatisfied(userClaims *jwtclaims.UserClaims) bool {
+	if len(acl.RequiredACR) > 0 {
+		acrMatches := false
+		for _, acr := range userClaims.GetClaimValues("acr") {
+			for _, value := range acl.RequiredACR {
+				if value == acr {
+					acrMatches = true
+					break
+				}
+			}
+		}
+		if !acrMatches {
+			return false
 		}
-		return false, true
 	}
-	return false, false
+	if len(acl.RequiredAMR) > 0 {
+		methods := make(map[string]bool)
+		for _, amr := range userClaims.GetClaimValues("amr") {
+			methods[amr] = true
+		}
+		for _, value := range acl.RequiredAMR {
+			if !methods[value] {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 // MatchPathBasedACL matches pattern in a URI.
@@ -284,18 +387,23 @@ func MatchPathBasedACL(pattern, uri string) bool {
 	var found bool
 
 	// Check cached entries
+	pathACLPatternsMu.RLock()
 	regex, found = pathACLPatterns[pattern]
+	pathACLPatternsMu.RUnlock()
 	if !found {
 		// advPattern = strings.ReplaceAll(pattern, "/", "\\/")
 		advPattern := strings.ReplaceAll(pattern, "**", "[a-zA-Z0-9_/.~-]+")
 		advPattern = strings.ReplaceAll(advPattern, "*", "[a-zA-Z0-9_.~-]+")
 		advPattern = "^" + advPattern + "$"
 		r, err := regexp.Compile(advPattern)
+		pathACLPatternsMu.Lock()
 		if err != nil {
 			pathACLPatterns[pattern] = nil
+			pathACLPatternsMu.Unlock()
 			return false
 		}
 		pathACLPatterns[pattern] = r
+		pathACLPatternsMu.Unlock()
 		regex = r
 	}
 	if regex == nil {
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	jwtclaims "github.com/greenpau/caddy-auth-jwt/pkg/claims"
 	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	"sync"
 	"testing"
 	"time"
 )
@@ -348,6 +349,57 @@ func TestAccessList(t *testing.T) {
 	}
 }
 
+func TestNestedClaimAccessList(t *testing.T) {
+	claims := &jwtclaims.UserClaims{
+		RawClaims: map[string]interface{}{
+			"realm_access": map[string]interface{}{
+				"roles": []interface{}{"offline_access", "user"},
+			},
+			"resource_access": map[string]interface{}{
+				"api": map[string]interface{}{
+					"roles": []interface{}{"reader"},
+				},
+			},
+			"https://example.com/tenant": "acme",
+		},
+	}
+
+	tests := []struct {
+		name   string
+		claim  string
+		values []string
+		allow  bool
+	}{
+		{name: "realm roles", claim: "realm_access.roles", values: []string{"user"}, allow: true},
+		{name: "client roles", claim: "resource_access.api.roles", values: []string{"reader"}, allow: true},
+		{name: "client roles with other value", claim: "resource_access.api.roles", values: []string{"writer"}, allow: false},
+		{name: "missing client", claim: "resource_access.web.roles", values: []string{"reader"}, allow: false},
+		{name: "path through array", claim: "realm_access.roles.user", values: []string{"any"}, allow: false},
+		{name: "claim name with dots", claim: "https://example.com/tenant", values: []string{"acme"}, allow: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := NewAccessListEntry()
+			entry.Allow()
+			if err := entry.SetClaim(test.claim); err != nil {
+				t.Fatalf("failed to set claim %s: %s", test.claim, err)
+			}
+			if err := entry.SetValue(test.values); err != nil {
+				t.Fatalf("failed to set claim values: %s", err)
+			}
+			allow, _ := entry.IsClaimAllowed(claims, nil)
+			if allow != test.allow {
+				t.Fatalf("got: %t expected: %t", allow, test.allow)
+			}
+		})
+	}
+
+	if err := NewAccessListEntry().SetClaim("tenant"); err == nil {
+		t.Fatalf("expected error setting unsupported claim without path")
+	}
+}
+
 func TestMatchPathBasedACL(t *testing.T) {
 	testFailed := 0
 	tests := []struct {
@@ -410,3 +462,22 @@ func TestMatchPathBasedACL(t *testing.T) {
 		t.Fatalf("Failed %d tests", testFailed)
 	}
 }
+
+func TestMatchPathBasedACLConcurrency(t *testing.T) {
+	// The patterns are compiled and cached by the requests matching them
+	// concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				pattern := fmt.Sprintf("/app%d/**", j)
+				if !MatchPathBasedACL(pattern, fmt.Sprintf("/app%d/media/icon.png", j)) {
+					t.Errorf("path does not match pattern %s", pattern)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -15,14 +15,26 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	jwtacl "github.com/greenpau/caddy-auth-jwt/pkg/acl"
+	jwtbackends "github.com/greenpau/caddy-auth-jwt/pkg/backends"
+	jwtclaims "github.com/greenpau/caddy-auth-jwt/pkg/claims"
 	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
 	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwtgrantor "github.com/greenpau/caddy-auth-jwt/pkg/grantor"
 	jwthandlers "github.com/greenpau/caddy-auth-jwt/pkg/handlers"
 	jwtvalidator "github.com/greenpau/caddy-auth-jwt/pkg/validator"
 	"go.uber.org/zap"
 	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -39,26 +51,78 @@ type Authorizer struct {
 	AuthRedirectDisabled       bool                             `json:"disable_auth_redirect,omitempty"`
 	AuthRedirectQueryDisabled  bool                             `json:"disable_auth_redirect_query,omitempty"`
 	AuthRedirectQueryParameter string                           `json:"auth_redirect_query_param,omitempty"`
+	AuthRedirectEncoding       string                           `json:"auth_redirect_encoding,omitempty"`
+	AuthRedirectHeader         string                           `json:"auth_redirect_header,omitempty"`
+	AuthRedirectAllowedHosts   []string                         `json:"auth_redirect_allowed_hosts,omitempty"`
 	AuthCookiesDeleteDisabled  bool                             `json:"disable_delete_auth_cookies,omitempty"`
+	ContentNegotiationDisabled bool                             `json:"disable_content_negotiation,omitempty"`
+	ErrorRoutesEnabled         bool                             `json:"enable_error_routes,omitempty"`
+	VerifyPath                 string                           `json:"verify_path,omitempty"`
+	Mode                       string                           `json:"mode,omitempty"`
+	GuestRoles                 []string                         `json:"guest_roles,omitempty"`
+	ExemptPaths                []string                         `json:"exempt_paths,omitempty"`
+	RedirectLoopLimit          int                              `json:"redirect_loop_limit,omitempty"`
+	RedirectLoopCheckDisabled  bool                             `json:"disable_redirect_loop_check,omitempty"`
+	DenyTemplates              []*jwtconfig.DenyTemplate        `json:"deny_templates,omitempty"`
+	Realm                      string                           `json:"realm,omitempty"`
+	DenyStatuses               []*jwtconfig.DenyStatus          `json:"deny_statuses,omitempty"`
 	AccessList                 []*jwtacl.AccessListEntry        `json:"access_list,omitempty"`
 	TrustedTokens              []*jwtconfig.CommonTokenConfig   `json:"trusted_tokens,omitempty"`
 	TokenValidator             *jwtvalidator.TokenValidator     `json:"-"`
 	TokenValidatorOptions      *jwtconfig.TokenValidatorOptions `json:"token_validate_options,omitempty"`
 	AllowedTokenTypes          []string                         `json:"token_types,omitempty"`
 	AllowedTokenSources        []string                         `json:"token_sources,omitempty"`
+	TokenHeaderNames           []string                         `json:"token_header_names,omitempty"`
+	TokenCookieNames           []string                         `json:"token_cookie_names,omitempty"`
+	TokenQueryNames            []string                         `json:"token_query_names,omitempty"`
+	TokenFormNames             []string                         `json:"token_form_names,omitempty"`
+	TokenFormMaxBodySize       int64                            `json:"token_form_max_body_size,omitempty"`
 	PassClaims                 bool                             `json:"pass_claims,omitempty"`
 	StripToken                 bool                             `json:"strip_token,omitempty"`
 	ForbiddenURL               string                           `json:"forbidden_url,omitempty"`
 	UserIdentityField          string                           `json:"user_identity_field,omitempty"`
+	IssuerRouting              string                           `json:"issuer_routing,omitempty"`
+	Provider                   string                           `json:"provider,omitempty"`
+	TokenUse                   string                           `json:"token_use,omitempty"`
+	RequiredClaims             []*jwtconfig.RequiredClaim       `json:"required_claims,omitempty"`
+	InjectHeaders              []*jwtconfig.HeaderInjection     `json:"inject_headers,omitempty"`
+	ClaimHeaders               *jwtconfig.ClaimHeaders          `json:"claim_headers,omitempty"`
+	PayloadHeader              string                           `json:"payload_header,omitempty"`
+	PayloadClaims              *jwtconfig.ClaimFilter           `json:"payload_claims,omitempty"`
+	HeaderSigningSecret        string                           `json:"header_signing_secret,omitempty"`
+	Reissue                    *jwtconfig.ReissueConfig         `json:"reissue,omitempty"`
+	TokenExchange              *jwtconfig.TokenExchangeConfig   `json:"token_exchange,omitempty"`
+	SessionCookie              *jwtconfig.SessionCookieConfig   `json:"session_cookie,omitempty"`
+	TokenRefresh               *jwtconfig.TokenRefreshConfig    `json:"token_refresh,omitempty"`
+	ClaimNamespaces            []string                         `json:"claim_namespaces,omitempty"`
+	ClaimMap                   []*jwtconfig.ClaimMapping        `json:"claim_map,omitempty"`
+	ClaimTransforms            []*jwtconfig.ClaimTransform      `json:"claim_transforms,omitempty"`
+	CertificateBoundPaths      []string                         `json:"certificate_bound_paths,omitempty"`
+	AuthTimeRequirements       []*jwtconfig.AuthTimeRequirement `json:"auth_time_requirements,omitempty"`
+	SingleUsePaths             []string                         `json:"single_use_paths,omitempty"`
+	RevocationFile             string                           `json:"revocation_file,omitempty"`
+	Redis                      *jwtconfig.RedisConfig           `json:"redis,omitempty"`
+	TokenCacheSize             int                              `json:"token_cache_size,omitempty"`
+	InvalidTokenCacheTTL       int                              `json:"invalid_token_cache_ttl,omitempty"`
+	DecisionCacheSize          int                              `json:"decision_cache_size,omitempty"`
 
 	ValidateMethodPath          bool `json:"validate_method_path,omitempty"`
 	ValidateAccessListPathClaim bool `json:"validate_acl_path_claim,omitempty"`
 	ValidateAllowMatchAll       bool `json:"validate_acl_allow_match_all,omitempty"`
 
-	PassClaimsWithHeaders bool `json:"pass_claims_with_headers,omitempty"`
+	PassClaimsWithHeaders     bool `json:"pass_claims_with_headers,omitempty"`
+	PassForwardedHeaders      bool `json:"pass_forwarded_headers,omitempty"`
+	PassExpiryHeaders         bool `json:"pass_expiry_headers,omitempty"`
+	PassExpiryResponseHeaders bool `json:"pass_expiry_response_headers,omitempty"`
+	PassRequestContextHeaders bool `json:"pass_request_context_headers,omitempty"`
 
 	logger    *zap.Logger
 	startedAt time.Time
+	reissuer  *jwtgrantor.Reissuer
+	exchanger *jwtbackends.TokenExchanger
+	refresher *jwtbackends.TokenRefresher
+
+	denyTemplates *jwthandlers.DenyTemplates
 }
 
 // Provision provisions JWT authorization provider
@@ -86,6 +150,16 @@ func (m *Authorizer) Provision(upstreamOptions map[string]interface{}) error {
 
 // Validate implements caddy.Validator.
 func (m *Authorizer) Validate() error {
+	if m.PrimaryInstance && m.TokenValidator != nil {
+		if err := m.TokenValidator.Ready(); err != nil {
+			// The keys were not fetched, but the trusted tokens tolerate it.
+			m.logger.Warn(
+				"plugin instance is not ready",
+				zap.String("instance_name", m.Name),
+				zap.String("error", err.Error()),
+			)
+		}
+	}
 	m.logger.Info(
 		"validated plugin instance",
 		zap.String("instance_name", m.Name),
@@ -93,6 +167,15 @@ func (m *Authorizer) Validate() error {
 	return nil
 }
 
+// Cleanup stops background activities of the token backends, e.g.
+// periodic key refresh.
+func (m *Authorizer) Cleanup() error {
+	if m.TokenValidator != nil {
+		m.TokenValidator.Stop()
+	}
+	return nil
+}
+
 // Authenticate authorizes access based on the presense and content of JWT token.
 func (m Authorizer) Authenticate(w http.ResponseWriter, r *http.Request, upstreamOptions map[string]interface{}) (map[string]interface{}, bool, error) {
 	/*
@@ -125,6 +208,21 @@ func (m Authorizer) Authenticate(w http.ResponseWriter, r *http.Request, upstrea
 		m = *provisionedInstance
 	}
 
+	if exempt, _ := upstreamOptions["exempt"].(bool); exempt || m.IsExemptRequest(r) {
+		// The exempt requests, e.g. health checks and public assets, pass
+		// without a token, before the tokens are searched.
+		return m.getGuestIdentity(r), true, nil
+	}
+
+	// The request for the verification endpoint, e.g. sent by Traefik
+	// forwardAuth or nginx auth_request, is authorized as the original
+	// request forwarded in its headers. The identity of the user is passed
+	// in the headers of the response, rather than to an upstream.
+	var verifyRequest *http.Request
+	if m.VerifyPath != "" && r.URL.Path == m.VerifyPath {
+		verifyRequest, r = r, getForwardedRequest(r)
+	}
+
 	var opts *jwtconfig.TokenValidatorOptions
 	if m.ValidateMethodPath {
 		opts = m.TokenValidatorOptions.Clone()
@@ -134,37 +232,135 @@ func (m Authorizer) Authenticate(w http.ResponseWriter, r *http.Request, upstrea
 		opts = m.TokenValidatorOptions
 	}
 	opts.Logger = m.logger;
+	reqID, _ := upstreamOptions["request_id"].(string)
 
 	userClaims, validUser, err := m.TokenValidator.Authorize(r, opts)
+	if !validUser && m.refresher != nil && isRefreshableError(err) {
+		if refreshedClaims, refreshed := m.refreshToken(w, r, opts); refreshed {
+			userClaims, validUser, err = refreshedClaims, true, nil
+		}
+	}
+	// The tokens in the query parameters and the WebSocket subprotocols are
+	// not passed to the upstreams and the redirects.
+	m.TokenValidator.RemoveQueryParameters(r)
+	if protocol := m.TokenValidator.RemoveWebSocketProtocol(r); protocol != "" && validUser {
+		// The browsers accept the upgrade only when the response selects
+		// one of the requested subprotocols.
+		w.Header().Set("Sec-WebSocket-Protocol", protocol)
+	}
+	if m.Mode == "optional" && ((err == nil && !validUser) || errors.Is(err, jwterrors.ErrNoTokenFound)) {
+		// The request without a token continues with the guest identity.
+		// The invalid tokens are still refused. When no token source has
+		// anything, there is neither a user nor an error.
+		guest := m.getGuestIdentity(r)
+		if verifyRequest != nil {
+			setVerifyResponseHeaders(w.Header(), r, m.getVerifyResponseHeaders())
+		}
+		return guest, true, nil
+	}
+	if m.PassRequestContextHeaders && (err != nil || !validUser || userClaims == nil) {
+		setRequestContextHeaders(w.Header(), r, verifyRequest != nil, m.AuthRedirectAllowedHosts)
+	}
 	if err != nil {
 		m.logger.Debug(
 			"token validation error",
 			zap.String("error", err.Error()),
 		)
+		if errors.Is(err, jwterrors.ErrStepUpRequired) && m.ErrorRoutesEnabled {
+			return nil, false, m.handOffDenial(w, r, 401, err)
+		}
+		if errors.Is(err, jwterrors.ErrStepUpRequired) && !m.AuthRedirectDisabled {
+			// The token does not satisfy the authentication context required
+			// by the access list. The user is sent back to the authentication
+			// portal to authenticate with the required acr.
+			redirOpts := make(map[string]interface{})
+			redirOpts["auth_url_path"] = m.AuthURLPath
+			redirOpts["auth_redirect_query_disabled"] = m.AuthRedirectQueryDisabled
+			redirOpts["redirect_param"] = m.AuthRedirectQueryParameter
+			redirOpts["redirect_encoding"] = m.AuthRedirectEncoding
+			redirOpts["redirect_header"] = m.AuthRedirectHeader
+			redirOpts["redirect_allowed_hosts"] = m.AuthRedirectAllowedHosts
+			var stepUpErr jwterrors.ExtendedError
+			if errors.As(err, &stepUpErr) {
+				if acr, ok := stepUpErr.Args()[0].([]string); ok && len(acr) > 0 {
+					redirOpts["acr_values"] = strings.Join(acr, " ")
+				}
+			}
+			if m.denyAPIRequest(w, r, reqID, 401, err, redirOpts) {
+				return nil, false, err
+			}
+			if m.denyRedirectLoop(w, r) {
+				return nil, false, err
+			}
+			jwthandlers.AddRedirectLocationHeader(w, r, redirOpts)
+			w.WriteHeader(302)
+			w.Write([]byte(`Step-Up Authentication Required`))
+			return nil, false, err
+		}
+		if errors.Is(err, jwterrors.ErrKeysUnavailable) {
+			// The keys of the trusted tokens are unavailable, e.g. JWKS
+			// endpoint is down, and the token could not be verified.
+			w.WriteHeader(503)
+			w.Write([]byte(`Service Unavailable`))
+			return nil, false, err
+		}
+		if errors.Is(err, jwterrors.ErrKeysUnavailableAllowed) {
+			// The key outage policy allows the request without verifying
+			// the token. The request proceeds without the identity of the user,
+			// and the identity headers sent by the client are removed.
+			m.logger.Warn(
+				"token not verified, key source unavailable",
+				zap.String("error", err.Error()),
+			)
+			w.Header().Set("Warning", `199 - "token not verified, key source unavailable"`)
+			return m.getGuestIdentity(r), true, nil
+		}
 		if strings.Contains(err.Error(), "user role is valid, but not allowed by") {
-			if m.ForbiddenURL != "" {
+			if m.ErrorRoutesEnabled {
+				return nil, false, m.handOffDenial(w, r, 403, err)
+			}
+			if m.denyAPIRequest(w, r, reqID, 403, err, nil) {
+				return nil, false, err
+			}
+			switch {
+			case m.ForbiddenURL != "":
 				w.Header().Set("Location", m.ForbiddenURL)
 				w.WriteHeader(303)
-			} else {
+				w.Write([]byte(`Forbidden`))
+			case !m.denyHTMLRequest(w, r, reqID, 403, err):
 				w.WriteHeader(403)
+				w.Write([]byte(`Forbidden`))
 			}
-			w.Write([]byte(`Forbidden`))
 			return nil, false, err
 		}
 		for _, cookie := range r.Cookies() {
-			if _, exists := m.TokenValidator.Cookies[cookie.Name]; exists {
+			if m.TokenValidator.IsTokenCookie(cookie.Name) {
 				w.Header().Add("Set-Cookie", cookie.Name+"=delete; path=/; expires=Thu, 01 Jan 1970 00:00:00 GMT")
 			}
 		}
+		if m.ErrorRoutesEnabled {
+			return nil, false, m.handOffDenial(w, r, 401, err)
+		}
 		if (!m.AuthRedirectDisabled)  {
+			if m.denyAPIRequest(w, r, reqID, 401, err, nil) {
+				return nil, false, err
+			}
 			redirOpts := make(map[string]interface{})
 			redirOpts["auth_url_path"] = m.AuthURLPath
 			redirOpts["auth_redirect_query_disabled"] = m.AuthRedirectQueryDisabled
 			redirOpts["redirect_param"] = m.AuthRedirectQueryParameter
+			redirOpts["redirect_encoding"] = m.AuthRedirectEncoding
+			redirOpts["redirect_header"] = m.AuthRedirectHeader
+			redirOpts["redirect_allowed_hosts"] = m.AuthRedirectAllowedHosts
 			//redirOpts["logger"] = m.logger
+			if m.denyRedirectLoop(w, r) {
+				return nil, false, err
+			}
 			jwthandlers.AddRedirectLocationHeader(w, r, redirOpts)
 			w.WriteHeader(302)
 			w.Write([]byte(`Unauthorized`))
+		} else {
+			m.denyHTMLRequest(w, r, reqID, 401, err)
 		}
 		return nil, false, err
 	}
@@ -174,19 +370,33 @@ func (m Authorizer) Authenticate(w http.ResponseWriter, r *http.Request, upstrea
 			zap.String("error", "user invalid"),
 		)
 		for _, cookie := range r.Cookies() {
-			if _, exists := m.TokenValidator.Cookies[cookie.Name]; exists {
+			if m.TokenValidator.IsTokenCookie(cookie.Name) {
 				w.Header().Add("Set-Cookie", cookie.Name+"=delete; path=/; expires=Thu, 01 Jan 1970 00:00:00 GMT")
 			}
 		}
+		if m.ErrorRoutesEnabled {
+			return nil, false, m.handOffDenial(w, r, 401, nil)
+		}
 		if (!m.AuthRedirectDisabled)  {
+			if m.denyAPIRequest(w, r, reqID, 401, nil, nil) {
+				return nil, false, nil
+			}
 			redirOpts := make(map[string]interface{})
 			redirOpts["auth_url_path"] = m.AuthURLPath
 			redirOpts["auth_redirect_query_disabled"] = m.AuthRedirectQueryDisabled
 			redirOpts["redirect_param"] = m.AuthRedirectQueryParameter
+			redirOpts["redirect_encoding"] = m.AuthRedirectEncoding
+			redirOpts["redirect_header"] = m.AuthRedirectHeader
+			redirOpts["redirect_allowed_hosts"] = m.AuthRedirectAllowedHosts
 			//redirOpts["logger"] = m.logger
+			if m.denyRedirectLoop(w, r) {
+				return nil, false, nil
+			}
 			jwthandlers.AddRedirectLocationHeader(w, r, redirOpts)
 			w.WriteHeader(302)
 			w.Write([]byte(`Unauthorized User`))
+		} else {
+			m.denyHTMLRequest(w, r, reqID, 401, nil)
 		}
 		return nil, false, nil
 	}
@@ -197,23 +407,75 @@ func (m Authorizer) Authenticate(w http.ResponseWriter, r *http.Request, upstrea
 			zap.String("error", "nil claims"),
 		)
 		for _, cookie := range r.Cookies() {
-			if _, exists := m.TokenValidator.Cookies[cookie.Name]; exists {
+			if m.TokenValidator.IsTokenCookie(cookie.Name) {
 				w.Header().Add("Set-Cookie", cookie.Name+"=delete; path=/; expires=Thu, 01 Jan 1970 00:00:00 GMT")
 			}
 		}
+		if m.ErrorRoutesEnabled {
+			return nil, false, m.handOffDenial(w, r, 401, nil)
+		}
 		if (!m.AuthRedirectDisabled)  {
+			if m.denyAPIRequest(w, r, reqID, 401, nil, nil) {
+				return nil, false, nil
+			}
 			redirOpts := make(map[string]interface{})
 			redirOpts["auth_url_path"] = m.AuthURLPath
 			redirOpts["auth_redirect_query_disabled"] = m.AuthRedirectQueryDisabled
 			redirOpts["redirect_param"] = m.AuthRedirectQueryParameter
+			redirOpts["redirect_encoding"] = m.AuthRedirectEncoding
+			redirOpts["redirect_header"] = m.AuthRedirectHeader
+			redirOpts["redirect_allowed_hosts"] = m.AuthRedirectAllowedHosts
 			//redirOpts["logger"] = m.logger
+			if m.denyRedirectLoop(w, r) {
+				return nil, false, nil
+			}
 			jwthandlers.AddRedirectLocationHeader(w, r, redirOpts)
 			w.WriteHeader(302)
 			w.Write([]byte(`User Unauthorized`))
+		} else {
+			m.denyHTMLRequest(w, r, reqID, 401, nil)
 		}
 		return nil, false, nil
 	}
 
+	r.Header.Del("X-Token-Stale")
+	if now := time.Now(); jwtvalidator.IsStaleToken(userClaims, opts, now) {
+		m.logger.Debug(
+			"accepted expired token during grace period",
+			zap.String("instance_name", m.Name),
+			zap.String("sub", userClaims.Subject),
+			zap.Int64("exp", userClaims.ExpiresAt),
+		)
+		setStaleTokenHeader(r.Header, userClaims, now)
+		setStaleTokenHeader(w.Header(), userClaims, now)
+	}
+
+	if m.SessionCookie != nil {
+		var err error
+		switch {
+		case m.TokenValidator.IsHeaderToken(r, userClaims.Token, opts):
+			err = m.setSessionCookie(w, r, userClaims, m.SessionCookie.Reissue, time.Now())
+		case m.isRenewableCookieToken(r, userClaims, time.Now()):
+			err = m.setSessionCookie(w, r, userClaims, true, time.Now())
+		}
+		if err != nil {
+			m.logger.Warn(
+				"failed setting session cookie",
+				zap.String("instance_name", m.Name),
+				zap.String("error", err.Error()),
+			)
+		}
+	}
+
+	if _, err := r.Cookie(redirectLoopCookieName); err == nil {
+		// The user is authenticated, the redirects are not a loop.
+		w.Header().Add("Set-Cookie", redirectLoopCookieName+"=delete; path=/; expires=Thu, 01 Jan 1970 00:00:00 GMT")
+	}
+
+	if m.StripToken {
+		m.TokenValidator.RemoveTokens(r, opts)
+	}
+
 	userIdentity := make(map[string]interface{})
 
 	userIdentity["roles"] = strings.Join(userClaims.Roles, " ")
@@ -240,28 +502,871 @@ func (m Authorizer) Authenticate(w http.ResponseWriter, r *http.Request, upstrea
 		}
 	}
 
+	if m.PassClaimsWithHeaders && m.ClaimHeaders == nil {
+		// The headers are set only for the claims present in the token,
+		// and the headers sent by the client are removed.
+		for _, header := range []string{"X-Token-Subject", "X-Token-User-Name", "X-Token-User-Email", "X-Token-User-Roles", "X-Token-User-Scopes"} {
+			r.Header.Del(header)
+		}
+	}
+
 	if userClaims.Name != "" {
 		userIdentity["name"] = userClaims.Name
-		if m.PassClaimsWithHeaders {
+		if m.PassClaimsWithHeaders && m.ClaimHeaders == nil {
 			r.Header.Set("X-Token-User-Name", userClaims.Name)
 		}
 	}
 
 	if userClaims.Email != "" {
 		userIdentity["email"] = userClaims.Email
-		if m.PassClaimsWithHeaders {
+		if m.PassClaimsWithHeaders && m.ClaimHeaders == nil {
 			r.Header.Set("X-Token-User-Email", userClaims.Email)
 		}
 	}
 
-	if m.PassClaimsWithHeaders {
+	if m.PassClaimsWithHeaders && m.ClaimHeaders == nil {
 		if len(userClaims.Roles) > 0 {
 			r.Header.Set("X-Token-User-Roles", strings.Join(userClaims.Roles, " "))
 		}
+		if len(userClaims.Scopes) > 0 {
+			r.Header.Set("X-Token-User-Scopes", strings.Join(userClaims.Scopes, " "))
+		}
 		if userClaims.Subject != "" {
 			r.Header.Set("X-Token-Subject", userClaims.Subject)
 		}
 	}
 
+	if m.ClaimHeaders != nil {
+		setClaimHeaders(r, userClaims, m.ClaimHeaders.GetHeaders())
+	}
+
+	if m.PassForwardedHeaders {
+		setForwardedHeaders(r, userClaims)
+	}
+
+	if m.PassExpiryHeaders {
+		setExpiryHeaders(r.Header, userClaims, time.Now())
+	}
+	if m.PassExpiryResponseHeaders {
+		setExpiryHeaders(w.Header(), userClaims, time.Now())
+	}
+
+	if m.PayloadHeader != "" {
+		if err := setPayloadHeader(r, userClaims, m.PayloadHeader, m.PayloadClaims); err != nil {
+			m.logger.Warn(
+				"failed passing token payload",
+				zap.String("header", m.PayloadHeader),
+				zap.String("error", err.Error()),
+			)
+		}
+	}
+
+	for _, injection := range m.InjectHeaders {
+		r.Header.Del(injection.Header)
+		if values := userClaims.GetClaimValues(injection.Claim); len(values) > 0 {
+			r.Header.Set(injection.Header, strings.Join(values, " "))
+		}
+	}
+
+	if m.HeaderSigningSecret != "" {
+		signIdentityHeaders(r, m.getIdentityHeaders(), m.HeaderSigningSecret, time.Now())
+	}
+
+	if m.reissuer != nil {
+		if err := setReissuedToken(r, userClaims, m.reissuer, m.Reissue.Header, time.Now()); err != nil {
+			m.logger.Warn(
+				"failed reissuing token",
+				zap.String("instance_name", m.Name),
+				zap.String("error", err.Error()),
+			)
+		}
+	}
+
+	if m.exchanger != nil {
+		if err := setExchangedToken(r, userClaims, m.exchanger, m.TokenExchange.Header); err != nil {
+			// The upstreams expecting the exchanged tokens reject the
+			// original tokens, and the request is not passed.
+			m.logger.Warn(
+				"failed exchanging token",
+				zap.String("instance_name", m.Name),
+				zap.String("error", err.Error()),
+			)
+			w.WriteHeader(502)
+			w.Write([]byte(`Bad Gateway`))
+			return nil, false, err
+		}
+	}
+
+	if verifyRequest != nil {
+		if !m.PassForwardedHeaders {
+			setForwardedHeaders(r, userClaims)
+		}
+		setVerifyResponseHeaders(w.Header(), r, m.getVerifyResponseHeaders())
+	}
+
 	return userIdentity, true, nil
 }
+
+// IsExemptRequest returns true if the path of the request matches the exempt
+// paths, e.g. /healthz or /assets/**. The requests matching the exempt
+// matchers of the provider are passed with exempt upstream option. The paths
+// with dot segments, e.g. /assets/../admin, are not exempt, because they
+// resolve outside of the exempt paths.
+func (m Authorizer) IsExemptRequest(r *http.Request) bool {
+	if len(m.ExemptPaths) == 0 {
+		return false
+	}
+	cleanPath := path.Clean(r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") && cleanPath != "/" {
+		cleanPath += "/"
+	}
+	if cleanPath != r.URL.Path {
+		return false
+	}
+	for _, pattern := range m.ExemptPaths {
+		if jwtacl.MatchPathBasedACL(pattern, cleanPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// getGuestIdentity returns the identity of the user without a token, with
+// the guest roles, by default anonymous. The identity headers sent by the
+// client are removed, so that the upstreams do not trust them.
+func (m Authorizer) getGuestIdentity(r *http.Request) map[string]interface{} {
+	for _, header := range m.getIdentityHeaders() {
+		r.Header.Del(header)
+	}
+	roles := m.GuestRoles
+	if len(roles) == 0 {
+		roles = []string{"anonymous"}
+	}
+	return map[string]interface{}{
+		"id":    "anonymous",
+		"roles": strings.Join(roles, " "),
+	}
+}
+
+// getForwardedRequest returns the copy of the request for the verification
+// endpoint with the method and the URI of the original request, forwarded in
+// X-Forwarded-Method and X-Forwarded-Uri, or X-Original-URI, headers. The
+// host of the request is not changed, so that the return URL of the redirect
+// is checked against the allowed hosts.
+func getForwardedRequest(r *http.Request) *http.Request {
+	fr := r.Clone(r.Context())
+	if method := r.Header.Get("X-Forwarded-Method"); method != "" {
+		fr.Method = method
+	}
+	uri := r.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = r.Header.Get("X-Original-URI")
+	}
+	if uri == "" {
+		return fr
+	}
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return fr
+	}
+	fr.RequestURI = uri
+	fr.URL.Path = u.Path
+	fr.URL.RawPath = u.RawPath
+	fr.URL.RawQuery = u.RawQuery
+	return fr
+}
+
+// setVerifyResponseHeaders sets the headers added to the forwarded request,
+// e.g. X-Forwarded-User and the claim headers, in the response of the
+// verification endpoint, so that the proxy passes them to the upstream.
+// The headers without value are sent empty, so that the proxy replaces the
+// headers sent by the client.
+func setVerifyResponseHeaders(h http.Header, fr *http.Request, headers []string) {
+	for _, header := range headers {
+		if values := fr.Header.Values(header); len(values) > 0 {
+			h[http.CanonicalHeaderKey(header)] = values
+			continue
+		}
+		h.Set(header, "")
+	}
+}
+
+// getVerifyResponseHeaders returns the names of the headers set in the
+// response of the verification endpoint, i.e. the identity headers, the
+// forwarded headers, and the headers passing the signature and the tokens.
+func (m Authorizer) getVerifyResponseHeaders() []string {
+	headers := m.getIdentityHeaders()
+	if !m.PassForwardedHeaders {
+		headers = append(headers, "X-Forwarded-User", "X-Forwarded-Email", "X-Forwarded-Groups", "X-Forwarded-Preferred-Username")
+	}
+	if m.HeaderSigningSecret != "" {
+		headers = append(headers, "X-Auth-Signature")
+	}
+	if m.reissuer != nil {
+		headers = append(headers, tokenHeaderName(m.Reissue.Header))
+	}
+	if m.exchanger != nil {
+		headers = append(headers, tokenHeaderName(m.TokenExchange.Header))
+	}
+	return headers
+}
+
+// tokenHeaderName returns the name of the header passing the token to the
+// upstreams, by default Authorization.
+func tokenHeaderName(header string) string {
+	if header == "" {
+		return "Authorization"
+	}
+	return header
+}
+
+// isRefreshableError returns true if the request is not authorized because
+// of the absent or invalid token, rather than the access list or the outage
+// of the key sources, so that the new access token may authorize it.
+func isRefreshableError(err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, jwterrors.ErrStepUpRequired),
+		errors.Is(err, jwterrors.ErrKeysUnavailable),
+		errors.Is(err, jwterrors.ErrKeysUnavailableAllowed),
+		strings.Contains(err.Error(), "user role is valid, but not allowed by"):
+		return false
+	}
+	return true
+}
+
+// refreshToken obtains the new access token with the refresh token in the
+// cookie, and authorizes the request with the new token, in place of the
+// token cookies of the request. The new access token, and the new refresh
+// token, if rotated, are set in the cookies of the response.
+func (m Authorizer) refreshToken(w http.ResponseWriter, r *http.Request, opts *jwtconfig.TokenValidatorOptions) (*jwtclaims.UserClaims, bool) {
+	refreshCookie, err := r.Cookie(m.TokenRefresh.GetCookieName())
+	if err != nil || refreshCookie.Value == "" {
+		return nil, false
+	}
+	tokens, err := m.refresher.Refresh(refreshCookie.Value)
+	if err != nil {
+		m.logger.Debug(
+			"token refresh error",
+			zap.String("instance_name", m.Name),
+			zap.String("error", err.Error()),
+		)
+		return nil, false
+	}
+
+	sessionCookie := m.SessionCookie
+	if sessionCookie == nil {
+		sessionCookie = &jwtconfig.SessionCookieConfig{}
+	}
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if !m.TokenValidator.IsTokenCookie(cookie.Name) {
+			r.AddCookie(cookie)
+		}
+	}
+	r.AddCookie(&http.Cookie{Name: sessionCookie.GetName(), Value: tokens.AccessToken})
+
+	userClaims, validUser, err := m.TokenValidator.Authorize(r, opts)
+	if err != nil || !validUser || userClaims == nil {
+		if err != nil {
+			m.logger.Debug(
+				"refreshed token validation error",
+				zap.String("instance_name", m.Name),
+				zap.String("error", err.Error()),
+			)
+		}
+		return nil, false
+	}
+
+	now := time.Now()
+	var expiresAt time.Time
+	if userClaims.ExpiresAt > 0 {
+		expiresAt = time.Unix(userClaims.ExpiresAt, 0)
+	}
+	setSessionCookie(w, sessionCookie, tokens.AccessToken, expiresAt, now, cookies)
+	if tokens.RefreshToken != "" && tokens.RefreshToken != refreshCookie.Value {
+		rotated := *sessionCookie
+		rotated.Name = m.TokenRefresh.GetCookieName()
+		rotated.Lifetime = int(tokens.RefreshExpiresIn)
+		setSessionCookie(w, &rotated, tokens.RefreshToken, time.Time{}, now, cookies)
+	}
+	return userClaims, true
+}
+
+// isRenewableCookieToken returns true if the token of the user was found in
+// the cookies and expires within the renewal window of the session cookie.
+func (m Authorizer) isRenewableCookieToken(r *http.Request, userClaims *jwtclaims.UserClaims, now time.Time) bool {
+	if m.SessionCookie.RenewWindow <= 0 || m.reissuer == nil || userClaims.ExpiresAt <= 0 {
+		return false
+	}
+	if token, found := m.TokenValidator.SearchCookies(r.Cookies()); !found || token != userClaims.Token {
+		return false
+	}
+	return time.Unix(userClaims.ExpiresAt, 0).Sub(now) < time.Duration(m.SessionCookie.RenewWindow)*time.Second
+}
+
+// setSessionCookie sets the token of the user, or the token reissued with the
+// internal key, in the session cookie.
+func (m Authorizer) setSessionCookie(w http.ResponseWriter, r *http.Request, userClaims *jwtclaims.UserClaims, reissue bool, now time.Time) error {
+	token := userClaims.Token
+	var expiresAt time.Time
+	if userClaims.ExpiresAt > 0 {
+		expiresAt = time.Unix(userClaims.ExpiresAt, 0)
+	}
+	if reissue && m.reissuer != nil {
+		var err error
+		if token, err = m.reissuer.Reissue(userClaims, now); err != nil {
+			return err
+		}
+		expiresAt = now.Add(m.reissuer.TTL())
+	}
+	setSessionCookie(w, m.SessionCookie, token, expiresAt, now, r.Cookies())
+	return nil
+}
+
+// redirectLoopCookieName is the name of the cookie counting the redirects of
+// the user to the authentication portal, which did not result in a token.
+const redirectLoopCookieName = "jwt_redirect_count"
+
+// The redirects exceeding the limit within the window, in seconds, are a loop.
+const (
+	defaultRedirectLoopLimit = 5
+	redirectLoopWindow       = 60
+)
+
+// denyRedirectLoop responds to the request with 508 status, rather than
+// redirecting the user to the authentication portal, when the redirects are
+// looping, i.e. the request is for the authentication portal itself, e.g.
+// auth_url is behind the plugin, or the portal redirected the user back
+// without a token too many times in a row. Otherwise, it counts the redirect
+// in the cookie and returns false.
+func (m Authorizer) denyRedirectLoop(w http.ResponseWriter, r *http.Request) bool {
+	if m.RedirectLoopCheckDisabled {
+		return false
+	}
+	limit := m.RedirectLoopLimit
+	if limit <= 0 {
+		limit = defaultRedirectLoopLimit
+	}
+	var count int
+	if cookie, err := r.Cookie(redirectLoopCookieName); err == nil {
+		count, _ = strconv.Atoi(cookie.Value)
+	}
+	var reason string
+	switch {
+	case isAuthURLRequest(m.AuthURLPath, r):
+		reason = "auth_url is behind the plugin"
+	case count >= limit:
+		reason = "authentication portal redirected back without a token"
+	default:
+		http.SetCookie(w, &http.Cookie{
+			Name:     redirectLoopCookieName,
+			Value:    strconv.Itoa(count + 1),
+			Path:     "/",
+			MaxAge:   redirectLoopWindow,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return false
+	}
+	m.logger.Warn(
+		"redirect loop detected",
+		zap.String("instance_name", m.Name),
+		zap.String("auth_url", m.AuthURLPath),
+		zap.String("reason", reason),
+		zap.Int("redirects", count),
+	)
+	// The count starts over, so that the user retries after fixing the
+	// configuration, or signing in again.
+	w.Header().Add("Set-Cookie", redirectLoopCookieName+"=delete; path=/; expires=Thu, 01 Jan 1970 00:00:00 GMT")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusLoopDetected)
+	w.Write([]byte("Redirect Loop Detected: " + reason))
+	return true
+}
+
+// isAuthURLRequest returns true if the request is for the authentication
+// portal, i.e. the redirect would return to the same page.
+func isAuthURLRequest(authURLPath string, r *http.Request) bool {
+	u, err := url.Parse(authURLPath)
+	if err != nil {
+		return false
+	}
+	if u.Host != "" && u.Host != r.Host {
+		return false
+	}
+	return u.Path == r.URL.Path
+}
+
+// maxSessionCookieSize is the size of the token stored in a single cookie.
+// The larger tokens are split into the chunks, e.g. access_token.0 and
+// access_token.1, because the browsers limit the cookies to about 4096 bytes.
+const maxSessionCookieSize = 3800
+
+// setSessionCookie adds Set-Cookie header with the token to the response. The
+// cookie expires with the token, when the expiry is not zero, or after the
+// lifetime of the cookie, whichever comes first.
+func setSessionCookie(w http.ResponseWriter, cfg *jwtconfig.SessionCookieConfig, token string, expiresAt time.Time, now time.Time, cookies []*http.Cookie) {
+	var maxAge int
+	if !expiresAt.IsZero() {
+		maxAge = int(expiresAt.Sub(now) / time.Second)
+		if maxAge <= 0 {
+			return
+		}
+	}
+	if cfg.Lifetime > 0 && (maxAge == 0 || cfg.Lifetime < maxAge) {
+		maxAge = cfg.Lifetime
+	}
+	path := cfg.Path
+	if path == "" {
+		path = "/"
+	}
+	sameSite := http.SameSiteLaxMode
+	switch strings.ToLower(cfg.SameSite) {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+	values := map[string]string{cfg.GetName(): token}
+	if len(token) > maxSessionCookieSize {
+		values = make(map[string]string)
+		for i := 0; len(token) > 0; i++ {
+			n := len(token)
+			if n > maxSessionCookieSize {
+				n = maxSessionCookieSize
+			}
+			values[cfg.GetName()+"."+strconv.Itoa(i)] = token[:n]
+			token = token[n:]
+		}
+	}
+	// The cookies of the request holding the previous token, but not the
+	// new one, are expired, e.g. the trailing chunks of a larger token,
+	// because they would be joined with the new chunks.
+	expired := make(map[string]bool)
+	for _, cookie := range cookies {
+		if cookie == nil {
+			continue
+		}
+		if _, exists := values[cookie.Name]; exists {
+			continue
+		}
+		if cookie.Name == cfg.GetName() || isSessionCookieChunk(cookie.Name, cfg.GetName()) {
+			expired[cookie.Name] = true
+		}
+	}
+	names := make([]string, 0, len(values)+len(expired))
+	for name := range values {
+		names = append(names, name)
+	}
+	for name := range expired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cookie := &http.Cookie{
+			Name:     name,
+			Value:    values[name],
+			Domain:   cfg.Domain,
+			Path:     path,
+			MaxAge:   maxAge,
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: sameSite,
+		}
+		if expired[name] {
+			cookie.MaxAge = -1
+		}
+		http.SetCookie(w, cookie)
+	}
+}
+
+// isSessionCookieChunk returns true if the cookie is a chunk of the session
+// cookie with the name, e.g. access_token.1.
+func isSessionCookieChunk(cookieName, name string) bool {
+	if !strings.HasPrefix(cookieName, name+".") {
+		return false
+	}
+	_, err := strconv.Atoi(strings.TrimPrefix(cookieName, name+"."))
+	return err == nil
+}
+
+// getIdentityHeaders returns the names of the headers passing the claims.
+func (m Authorizer) getIdentityHeaders() []string {
+	var headers []string
+	if m.PassClaimsWithHeaders && m.ClaimHeaders == nil {
+		headers = append(headers, "X-Token-Subject", "X-Token-User-Name", "X-Token-User-Email", "X-Token-User-Roles", "X-Token-User-Scopes")
+	}
+	if m.ClaimHeaders != nil {
+		for _, entry := range m.ClaimHeaders.GetHeaders() {
+			headers = append(headers, entry.Header)
+		}
+	}
+	if m.PassForwardedHeaders {
+		headers = append(headers, "X-Forwarded-User", "X-Forwarded-Email", "X-Forwarded-Groups", "X-Forwarded-Preferred-Username")
+	}
+	if m.PassExpiryHeaders {
+		headers = append(headers, "X-Token-Expires-At", "X-Token-Expires-In")
+	}
+	if m.PayloadHeader != "" {
+		headers = append(headers, m.PayloadHeader)
+	}
+	for _, injection := range m.InjectHeaders {
+		headers = append(headers, injection.Header)
+	}
+	return headers
+}
+
+// signIdentityHeaders adds X-Auth-Signature header with HMAC-SHA256 of the
+// identity headers, so that the upstreams verify that the headers were set by
+// the plugin. The header is t=<unix time>;h=<headers>;s=<signature>, where the
+// headers are the lowercase names of the signed headers separated by commas,
+// and the signature is base64url-encoded, without padding, HMAC of the time
+// and the headers, i.e. <unix time>\n followed by <name>:<value>\n for each
+// header, with empty values of the absent headers.
+func signIdentityHeaders(r *http.Request, headers []string, secret string, now time.Time) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, header := range headers {
+		name := strings.ToLower(header)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "\n"))
+	for _, name := range names {
+		mac.Write([]byte(name + ":" + r.Header.Get(name) + "\n"))
+	}
+	signature := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	r.Header.Set("X-Auth-Signature", "t="+ts+";h="+strings.Join(names, ",")+";s="+signature)
+}
+
+// setReissuedToken passes the claims of the token signed with the internal key
+// to the upstreams. The token is passed in Authorization header with Bearer
+// scheme, or in the other header without the scheme. The header sent by the
+// client is removed, so that the original token is not passed when the token
+// is not reissued.
+func setReissuedToken(r *http.Request, userClaims *jwtclaims.UserClaims, reissuer *jwtgrantor.Reissuer, header string, now time.Time) error {
+	header = tokenHeaderName(header)
+	r.Header.Del(header)
+	token, err := reissuer.Reissue(userClaims, now)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(header, "Authorization") {
+		token = "Bearer " + token
+	}
+	r.Header.Set(header, token)
+	return nil
+}
+
+// setExchangedToken passes the token issued in exchange for the token of the
+// user to the upstreams. The token is passed in Authorization header with
+// Bearer scheme, or in the other header without the scheme.
+func setExchangedToken(r *http.Request, userClaims *jwtclaims.UserClaims, exchanger *jwtbackends.TokenExchanger, header string) error {
+	header = tokenHeaderName(header)
+	r.Header.Del(header)
+	if userClaims.Token == "" {
+		return jwterrors.ErrNoTokenFound
+	}
+	var expiresAt time.Time
+	if userClaims.ExpiresAt > 0 {
+		expiresAt = time.Unix(userClaims.ExpiresAt, 0)
+	}
+	token, err := exchanger.Exchange(userClaims.Token, expiresAt)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(header, "Authorization") {
+		token = "Bearer " + token
+	}
+	r.Header.Set(header, token)
+	return nil
+}
+
+// setExpiryHeaders sets X-Token-Expires-At header with the expiration time of
+// the token, in seconds since the epoch, and X-Token-Expires-In header with
+// the seconds until the token expires. The headers are not set for the tokens
+// without expiration time. The headers sent by the client are removed.
+func setExpiryHeaders(h http.Header, userClaims *jwtclaims.UserClaims, now time.Time) {
+	h.Del("X-Token-Expires-At")
+	h.Del("X-Token-Expires-In")
+	if userClaims.ExpiresAt <= 0 {
+		return
+	}
+	expiresIn := userClaims.ExpiresAt - now.Unix()
+	if expiresIn < 0 {
+		expiresIn = 0
+	}
+	h.Set("X-Token-Expires-At", strconv.FormatInt(userClaims.ExpiresAt, 10))
+	h.Set("X-Token-Expires-In", strconv.FormatInt(expiresIn, 10))
+}
+
+// denyAPIRequest responds to the denied request of a script or an API client,
+// rather than a browser navigation, with the status and the problem details
+// in JSON body, instead of redirecting the client to the authentication
+// portal. It returns false for the navigations. The denial, i.e. 401 or 403,
+// is responded with the status configured for the path, and the denials of
+// the paths hidden with 404 status are never redirected.
+func (m Authorizer) denyAPIRequest(w http.ResponseWriter, r *http.Request, reqID string, denial int, err error, opts map[string]interface{}) bool {
+	status := m.getDenyStatus(r, denial)
+	if status == http.StatusNotFound {
+		// The response does not reveal that the path exists.
+		w.WriteHeader(status)
+		w.Write([]byte(`Not Found`))
+		return true
+	}
+	if m.ContentNegotiationDisabled || jwthandlers.IsNavigationRequest(r) {
+		return false
+	}
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		m.setChallenge(w.Header(), denial, err)
+	}
+	detail := denyReason(denial, err)
+	if m.denyTemplates.Write(w, "json", denial, m.getDenyTemplateData(reqID, status, detail)) {
+		return true
+	}
+	jwthandlers.WriteProblem(w, status, detail, opts)
+	return true
+}
+
+// denyHTMLRequest responds to the denied browser navigation, which is not
+// redirected, with the HTML template of the denial. It returns false if there
+// is no such template, and the status of the denial is not changed.
+func (m Authorizer) denyHTMLRequest(w http.ResponseWriter, r *http.Request, reqID string, denial int, err error) bool {
+	status := m.getDenyStatus(r, denial)
+	if status == http.StatusNotFound || (!m.ContentNegotiationDisabled && !jwthandlers.IsNavigationRequest(r)) {
+		return m.denyAPIRequest(w, r, reqID, denial, err, nil)
+	}
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		m.setChallenge(w.Header(), denial, err)
+	}
+	if m.denyTemplates.Write(w, "html", denial, m.getDenyTemplateData(reqID, status, denyReason(denial, err))) {
+		return true
+	}
+	if status == denial {
+		return false
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(http.StatusText(status)))
+	return true
+}
+
+// handOffDenial returns the denial, i.e. 401 or 403, of the request as
+// DenialError, without responding to the request, so that the error routes
+// of the server respond to the denial. Only the challenge is set.
+func (m Authorizer) handOffDenial(w http.ResponseWriter, r *http.Request, denial int, err error) error {
+	status := m.getDenyStatus(r, denial)
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		m.setChallenge(w.Header(), denial, err)
+	}
+	return jwterrors.DenialError{
+		Status: status,
+		Reason: denyReason(denial, err),
+		Err:    err,
+	}
+}
+
+// getDenyStatus returns the status of the response to the denial, i.e. 401
+// or 403, of the request. The statuses configured for the paths of the
+// request take precedence over the statuses for any path.
+func (m Authorizer) getDenyStatus(r *http.Request, denial int) int {
+	name := "unauthorized"
+	if denial == http.StatusForbidden {
+		name = "forbidden"
+	}
+	status := denial
+	var anyPath bool
+	for _, s := range m.DenyStatuses {
+		if s.Denial != name {
+			continue
+		}
+		if len(s.Paths) == 0 {
+			if !anyPath {
+				status, anyPath = s.Status, true
+			}
+			continue
+		}
+		for _, pattern := range s.Paths {
+			if jwtacl.MatchPathBasedACL(pattern, r.URL.Path) {
+				return s.Status
+			}
+		}
+	}
+	return status
+}
+
+// setChallenge sets WWW-Authenticate header with Bearer challenge per RFC
+// 6750 describing the denial, e.g. insufficient_scope error with the scopes
+// allowed by the access list. The step-up authentication challenge has
+// insufficient_user_authentication error with the required acr_values, per
+// RFC 9470. The challenge to the request without the token has no error.
+func (m Authorizer) setChallenge(h http.Header, status int, err error) {
+	var params []string
+	if m.Realm != "" {
+		params = append(params, "realm="+quoteChallengeParam(m.Realm))
+	}
+	var errorCode string
+	switch {
+	case errors.Is(err, jwterrors.ErrStepUpRequired):
+		errorCode = "insufficient_user_authentication"
+	case status == http.StatusForbidden:
+		errorCode = "insufficient_scope"
+	case err == nil || errors.Is(err, jwterrors.ErrNoTokenFound):
+	default:
+		errorCode = "invalid_token"
+	}
+	if errorCode != "" {
+		params = append(params, `error="`+errorCode+`"`, "error_description="+quoteChallengeParam(denyReason(status, err)))
+	}
+	var scopeErr jwterrors.InsufficientScopeError
+	if errors.As(err, &scopeErr) {
+		params = append(params, "scope="+quoteChallengeParam(strings.Join(scopeErr.Scopes, " ")))
+	}
+	var stepUpErr jwterrors.ExtendedError
+	if errors.Is(err, jwterrors.ErrStepUpRequired) && errors.As(err, &stepUpErr) {
+		if acr, ok := stepUpErr.Args()[0].([]string); ok && len(acr) > 0 {
+			params = append(params, "acr_values="+quoteChallengeParam(strings.Join(acr, " ")))
+		}
+	}
+	challenge := "Bearer"
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+	h.Set("WWW-Authenticate", challenge)
+}
+
+// quoteChallengeParam returns the value of the challenge parameter as quoted
+// string.
+func quoteChallengeParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (m Authorizer) getDenyTemplateData(reqID string, status int, reason string) *jwthandlers.DenyTemplateData {
+	return &jwthandlers.DenyTemplateData{
+		Status:    status,
+		Reason:    reason,
+		RequestID: reqID,
+		AuthURL:   m.AuthURLPath,
+	}
+}
+
+// denyReason returns the reason of the denial, without the details of the
+// error, which are logged only.
+func denyReason(status int, err error) string {
+	switch {
+	case status == http.StatusForbidden:
+		return "access is not allowed"
+	case errors.Is(err, jwterrors.ErrStepUpRequired):
+		return "step-up authentication required"
+	case err == nil || errors.Is(err, jwterrors.ErrNoTokenFound):
+		return "token required"
+	}
+	return "token is invalid or expired"
+}
+
+// setStaleTokenHeader sets X-Token-Stale header with the seconds since the
+// token expired, for the token accepted during the grace period, so that the
+// client refreshes the token.
+func setStaleTokenHeader(h http.Header, userClaims *jwtclaims.UserClaims, now time.Time) {
+	h.Set("X-Token-Stale", strconv.FormatInt(now.Unix()-userClaims.ExpiresAt, 10))
+}
+
+// setRequestContextHeaders passes the original request to the authentication
+// portal, or to the proxy sending the request as the authentication
+// subrequest, in X-Forwarded-Uri, X-Forwarded-Method, and X-Forwarded-Host
+// response headers, so that the portal restores the request after the login.
+// The headers sent by the client are ignored, except for the request of the
+// verification endpoint, forwarded by a proxy. Then, the request has the
+// method and the URI of the forwarded request, and the host in
+// X-Forwarded-Host header is passed if it is the host of the request, or one
+// of the allowed hosts.
+func setRequestContextHeaders(h http.Header, r *http.Request, forwarded bool, allowedHosts []string) {
+	host := r.Host
+	if forwardedHost := r.Header.Get("X-Forwarded-Host"); forwarded && forwardedHost != "" {
+		if jwthandlers.IsAllowedRedirectURL("https://"+forwardedHost, r.Host, allowedHosts) {
+			host = forwardedHost
+		}
+	}
+	h.Set("X-Forwarded-Uri", r.RequestURI)
+	h.Set("X-Forwarded-Method", r.Method)
+	h.Set("X-Forwarded-Host", host)
+}
+
+// setForwardedHeaders passes the identity of the user in X-Forwarded-User,
+// X-Forwarded-Email, X-Forwarded-Groups, and X-Forwarded-Preferred-Username
+// headers, as oauth2-proxy does. The groups are the roles separated by commas.
+// The headers sent by the client are removed.
+func setForwardedHeaders(r *http.Request, userClaims *jwtclaims.UserClaims) {
+	var preferredUsername string
+	if values := userClaims.GetClaimValues("preferred_username"); len(values) > 0 {
+		preferredUsername = values[0]
+	}
+	for header, value := range map[string]string{
+		"X-Forwarded-User":               userClaims.Subject,
+		"X-Forwarded-Email":              userClaims.Email,
+		"X-Forwarded-Groups":             strings.Join(userClaims.Roles, ","),
+		"X-Forwarded-Preferred-Username": preferredUsername,
+	} {
+		r.Header.Del(header)
+		if value != "" {
+			r.Header.Set(header, value)
+		}
+	}
+}
+
+// setPayloadHeader passes the claims of the token in the header, as
+// base64url-encoded JSON without padding, i.e. the format of the payload
+// forwarded by Envoy jwt_authn filter. The claims are selected by the filter,
+// when not nil. The header sent by the client is removed.
+func setPayloadHeader(r *http.Request, userClaims *jwtclaims.UserClaims, header string, filter *jwtconfig.ClaimFilter) error {
+	r.Header.Del(header)
+	claims := userClaims.RawClaims
+	if claims == nil {
+		claims = userClaims.AsMap()
+	}
+	if filter != nil {
+		claims = jwtclaims.FilterClaims(claims, filter)
+	}
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+	r.Header.Set(header, base64.RawURLEncoding.EncodeToString(b))
+	return nil
+}
+
+// setClaimHeaders passes the claims in the headers. The headers sent by the
+// client are removed, so that the upstreams receive only the headers with
+// the claims of the token.
+func setClaimHeaders(r *http.Request, userClaims *jwtclaims.UserClaims, headers []*jwtconfig.HeaderInjection) {
+	for _, entry := range headers {
+		r.Header.Del(entry.Header)
+	}
+	for _, entry := range headers {
+		var values []string
+		switch entry.Claim {
+		case "sub":
+			values = []string{userClaims.Subject}
+		case "name":
+			values = []string{userClaims.Name}
+		case "email":
+			values = []string{userClaims.Email}
+		case "roles":
+			values = userClaims.Roles
+		case "scopes", "scope":
+			values = userClaims.Scopes
+		default:
+			values = userClaims.GetClaimValues(entry.Claim)
+		}
+		if value := strings.Join(values, " "); value != "" {
+			r.Header.Set(entry.Header, value)
+		}
+	}
+}
@@ -17,13 +17,17 @@ package auth
 import (
 	"fmt"
 	jwtacl "github.com/greenpau/caddy-auth-jwt/pkg/acl"
+	jwtbackends "github.com/greenpau/caddy-auth-jwt/pkg/backends"
 	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
 	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwtgrantor "github.com/greenpau/caddy-auth-jwt/pkg/grantor"
+	jwthandlers "github.com/greenpau/caddy-auth-jwt/pkg/handlers"
 	jwtvalidator "github.com/greenpau/caddy-auth-jwt/pkg/validator"
 	"go.uber.org/zap"
 	"os"
 	"strings"
 	"sync"
+	"time"
 )
 
 // AuthManager is the global authorization provider pool.
@@ -99,7 +103,11 @@ func (p *InstanceManager) Register(m *Authorizer) error {
 				entry.TokenLifetime = 900
 			}
 
-			if !entry.HasRSAKeys() && entry.TokenSecret == "" {
+			if err := jwtvalidator.LoadSecret(entry); err != nil {
+				return jwterrors.ErrInvalidSecretFile.WithArgs(m.Name, err)
+			}
+
+			if !entry.HasVerificationKeys() && entry.TokenSecret == "" {
 				entry.TokenSecret = os.Getenv(jwtconfig.EnvTokenSecret)
 				if entry.TokenSecret == "" {
 					return jwterrors.ErrUndefinedSecret.WithArgs(m.Name)
@@ -115,6 +123,14 @@ func (p *InstanceManager) Register(m *Authorizer) error {
 			m.AuthRedirectQueryParameter = "redirect_url"
 		}
 
+		if err := validateRedirectEncoding(m); err != nil {
+			return err
+		}
+
+		if err := validateMode(m); err != nil {
+			return err
+		}
+
 		if len(m.AccessList) == 0 {
 			entry := jwtacl.NewAccessListEntry()
 			entry.Allow()
@@ -190,12 +206,65 @@ func (p *InstanceManager) Register(m *Authorizer) error {
 		}
 		m.TokenValidator.AccessList = m.AccessList
 		m.TokenValidator.TokenSources = m.AllowedTokenSources
+		m.TokenValidator.HeaderNames = m.TokenHeaderNames
+		m.TokenValidator.CookieNames = m.TokenCookieNames
+		m.TokenValidator.QueryNames = m.TokenQueryNames
+		m.TokenValidator.FormNames = m.TokenFormNames
+		m.TokenValidator.FormMaxBodySize = m.TokenFormMaxBodySize
 		m.TokenValidator.TokenConfigs = m.TrustedTokens
+		m.TokenValidator.IssuerRouting = m.IssuerRouting
+		m.TokenValidator.Provider = m.Provider
+		m.TokenValidator.TokenUse = m.TokenUse
+		m.TokenValidator.RequiredClaims = m.RequiredClaims
+		m.TokenValidator.ClaimNamespaces = m.ClaimNamespaces
+		m.TokenValidator.ClaimMap = m.ClaimMap
+		m.TokenValidator.ClaimTransforms = m.ClaimTransforms
+		m.TokenValidator.CertificateBoundPaths = m.CertificateBoundPaths
+		m.TokenValidator.AuthTimeRequirements = m.AuthTimeRequirements
+		m.TokenValidator.SingleUsePaths = m.SingleUsePaths
+		m.TokenValidator.RevocationFile = m.RevocationFile
+		m.TokenValidator.Redis = m.Redis
+		m.TokenValidator.CacheSize = m.TokenCacheSize
+		m.TokenValidator.InvalidTokenCacheTTL = m.InvalidTokenCacheTTL
+		m.TokenValidator.DecisionCacheSize = m.DecisionCacheSize
+		m.TokenValidator.SetLogger(m.logger)
 
 		if err := m.TokenValidator.ConfigureTokenBackends(); err != nil {
 			return jwterrors.ErrInvalidBackendConfiguration.WithArgs(m.Name, err)
 		}
 
+		if m.Reissue != nil {
+			reissuer, err := jwtgrantor.NewReissuer(m.Reissue)
+			if err != nil {
+				return jwterrors.ErrInvalidReissueConfiguration.WithArgs(m.Name, err)
+			}
+			m.reissuer = reissuer
+		}
+
+		if m.TokenExchange != nil {
+			m.exchanger = newTokenExchanger(m.TokenExchange)
+		}
+
+		if m.TokenRefresh != nil {
+			m.refresher = newTokenRefresher(m.TokenRefresh)
+		}
+
+		if err := validateSessionCookie(m); err != nil {
+			return err
+		}
+
+		if len(m.DenyTemplates) > 0 {
+			denyTemplates, err := jwthandlers.NewDenyTemplates(m.DenyTemplates)
+			if err != nil {
+				return jwterrors.ErrInvalidDenyTemplates.WithArgs(m.Name, err)
+			}
+			m.denyTemplates = denyTemplates
+		}
+
+		if err := validateDenyStatuses(m); err != nil {
+			return err
+		}
+
 		m.logger.Debug(
 			"JWT token configuration provisioned",
 			zap.String("instance_name", m.Name),
@@ -213,6 +282,122 @@ func (p *InstanceManager) Register(m *Authorizer) error {
 	return nil
 }
 
+// Readiness returns the readiness of the primary instances by the name of
+// the instance. The instance is ready, i.e. has nil error, when the keys of
+// its trusted tokens are available.
+func (p *InstanceManager) Readiness() map[string]error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	readiness := make(map[string]error)
+	for _, m := range p.PrimaryInstances {
+		switch {
+		case !m.Provisioned || m.TokenValidator == nil:
+			readiness[m.Name] = jwterrors.ErrNotReady.WithArgs(m.Name, jwterrors.ErrProvisonFailed)
+		default:
+			if err := m.TokenValidator.Ready(); err != nil {
+				readiness[m.Name] = jwterrors.ErrNotReady.WithArgs(m.Name, err)
+			} else {
+				readiness[m.Name] = nil
+			}
+		}
+	}
+	return readiness
+}
+
+// InspectKeys returns the description of the keys of the primary instances
+// by the name of the instance.
+func (p *InstanceManager) InspectKeys() map[string][]*jwtbackends.KeyStoreInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make(map[string][]*jwtbackends.KeyStoreInfo)
+	for _, m := range p.PrimaryInstances {
+		if !m.Provisioned || m.TokenValidator == nil {
+			continue
+		}
+		keys[m.Name] = m.TokenValidator.InspectKeys()
+	}
+	return keys
+}
+
+// RefreshKeys fetches the keys of the primary instances immediately. It
+// returns the errors by the name of the instance.
+func (p *InstanceManager) RefreshKeys() map[string]error {
+	// The keys are fetched without holding the lock, so that the fetches
+	// do not delay the provisioning of the instances.
+	p.mu.Lock()
+	validators := make(map[string]*jwtvalidator.TokenValidator)
+	for _, m := range p.PrimaryInstances {
+		if !m.Provisioned || m.TokenValidator == nil {
+			continue
+		}
+		validators[m.Name] = m.TokenValidator
+	}
+	p.mu.Unlock()
+	results := make(map[string]error)
+	for name, validator := range validators {
+		results[name] = validator.RefreshKeys()
+	}
+	return results
+}
+
+// TokenDecision is the decision of an instance on a token.
+type TokenDecision struct {
+	Allowed bool                   `json:"allowed"`
+	Error   string                 `json:"error,omitempty"`
+	Claims  map[string]interface{} `json:"claims,omitempty"`
+}
+
+// ValidateTokens validates the tokens with the primary instances, as the
+// tokens of the requests with the method and the path, when not empty, and
+// returns the decisions by the name of the instance, in the order of the
+// tokens. The checks of the requests, e.g. DPoP proofs and single-use
+// tokens, are not performed.
+func (p *InstanceManager) ValidateTokens(tokens []string, method, path string) map[string][]*TokenDecision {
+	// The tokens are validated without holding the lock, because the
+	// validation may introspect the opaque tokens.
+	p.mu.Lock()
+	instances := make(map[string]*Authorizer)
+	for _, m := range p.PrimaryInstances {
+		if !m.Provisioned || m.TokenValidator == nil {
+			continue
+		}
+		instances[m.Name] = m
+	}
+	p.mu.Unlock()
+	results := make(map[string][]*TokenDecision)
+	for name, m := range instances {
+		opts := m.TokenValidatorOptions
+		if method != "" || path != "" {
+			opts = m.TokenValidatorOptions.Clone()
+			if method != "" {
+				opts.Metadata["method"] = method
+			}
+			if path != "" {
+				opts.Metadata["path"] = path
+			}
+		}
+		decisions := make([]*TokenDecision, 0, len(tokens))
+		for _, token := range tokens {
+			decision := &TokenDecision{}
+			userClaims, valid, err := m.TokenValidator.ValidateToken(token, opts)
+			switch {
+			case err != nil:
+				decision.Error = err.Error()
+			case !valid || userClaims == nil:
+				decision.Error = "user invalid"
+			default:
+				decision.Allowed = true
+			}
+			if userClaims != nil {
+				decision.Claims = userClaims.AsMap()
+			}
+			decisions = append(decisions, decision)
+		}
+		results[name] = decisions
+	}
+	return results
+}
+
 // Provision provisions non-primaryInstance instances in an authorization context.
 func (p *InstanceManager) Provision(name string) (*Authorizer, error) {
 	if name == "" {
@@ -263,7 +448,11 @@ func (p *InstanceManager) Provision(name string) (*Authorizer, error) {
 			entry.TokenLifetime = 900
 		}
 
-		if !entry.HasRSAKeys() && entry.TokenSecret == "" {
+		if err := jwtvalidator.LoadSecret(entry); err != nil {
+			return nil, jwterrors.ErrInvalidSecretFile.WithArgs(m.Name, err)
+		}
+
+		if !entry.HasVerificationKeys() && entry.TokenSecret == "" {
 			entry.TokenSecret = os.Getenv(jwtconfig.EnvTokenSecret)
 			if entry.TokenSecret == "" {
 				return nil, jwterrors.ErrUndefinedSecret.WithArgs(m.Name)
@@ -279,6 +468,42 @@ func (p *InstanceManager) Provision(name string) (*Authorizer, error) {
 		m.AuthRedirectQueryParameter = primaryInstance.AuthRedirectQueryParameter
 	}
 
+	if m.AuthRedirectEncoding == "" {
+		m.AuthRedirectEncoding = primaryInstance.AuthRedirectEncoding
+	}
+	if err := validateRedirectEncoding(m); err != nil {
+		m.ProvisionFailed = true
+		return nil, err
+	}
+	if m.Mode == "" {
+		m.Mode = primaryInstance.Mode
+	}
+	if err := validateMode(m); err != nil {
+		m.ProvisionFailed = true
+		return nil, err
+	}
+	if len(m.GuestRoles) == 0 {
+		m.GuestRoles = primaryInstance.GuestRoles
+	}
+	if len(m.ExemptPaths) == 0 {
+		m.ExemptPaths = primaryInstance.ExemptPaths
+	}
+	if m.VerifyPath == "" {
+		m.VerifyPath = primaryInstance.VerifyPath
+	}
+
+	if m.AuthRedirectHeader == "" {
+		m.AuthRedirectHeader = primaryInstance.AuthRedirectHeader
+	}
+
+	if len(m.AuthRedirectAllowedHosts) == 0 {
+		m.AuthRedirectAllowedHosts = primaryInstance.AuthRedirectAllowedHosts
+	}
+
+	if m.RedirectLoopLimit == 0 {
+		m.RedirectLoopLimit = primaryInstance.RedirectLoopLimit
+	}
+
 	if len(m.AccessList) == 0 {
 		for _, primaryInstanceEntry := range primaryInstance.AccessList {
 			entry := jwtacl.NewAccessListEntry()
@@ -339,19 +564,194 @@ func (p *InstanceManager) Provision(name string) (*Authorizer, error) {
 		m.TokenValidator.SetTokenName(tokenName)
 	}
 
+	if m.IssuerRouting == "" {
+		m.IssuerRouting = primaryInstance.IssuerRouting
+	}
+	if m.Provider == "" {
+		m.Provider = primaryInstance.Provider
+		m.TokenUse = primaryInstance.TokenUse
+	}
+	if len(m.RequiredClaims) == 0 {
+		m.RequiredClaims = primaryInstance.RequiredClaims
+	}
+	if len(m.InjectHeaders) == 0 {
+		m.InjectHeaders = primaryInstance.InjectHeaders
+	}
+	if m.ClaimHeaders == nil {
+		m.ClaimHeaders = primaryInstance.ClaimHeaders
+	}
+	if m.PayloadHeader == "" {
+		m.PayloadHeader = primaryInstance.PayloadHeader
+	}
+	if m.PayloadClaims == nil {
+		m.PayloadClaims = primaryInstance.PayloadClaims
+	}
+	if m.HeaderSigningSecret == "" {
+		m.HeaderSigningSecret = primaryInstance.HeaderSigningSecret
+	}
+	if m.Reissue == nil {
+		m.Reissue = primaryInstance.Reissue
+		m.reissuer = primaryInstance.reissuer
+	}
+	if m.TokenExchange == nil {
+		m.TokenExchange = primaryInstance.TokenExchange
+		m.exchanger = primaryInstance.exchanger
+	}
+	if m.SessionCookie == nil {
+		m.SessionCookie = primaryInstance.SessionCookie
+	}
+	if m.TokenRefresh == nil {
+		m.TokenRefresh = primaryInstance.TokenRefresh
+		m.refresher = primaryInstance.refresher
+	}
+	if len(m.ClaimNamespaces) == 0 {
+		m.ClaimNamespaces = primaryInstance.ClaimNamespaces
+	}
+	if len(m.ClaimMap) == 0 {
+		m.ClaimMap = primaryInstance.ClaimMap
+	}
+	if len(m.ClaimTransforms) == 0 {
+		m.ClaimTransforms = primaryInstance.ClaimTransforms
+	}
+	if len(m.CertificateBoundPaths) == 0 {
+		m.CertificateBoundPaths = primaryInstance.CertificateBoundPaths
+	}
+	if len(m.AuthTimeRequirements) == 0 {
+		m.AuthTimeRequirements = primaryInstance.AuthTimeRequirements
+	}
+	if len(m.SingleUsePaths) == 0 {
+		m.SingleUsePaths = primaryInstance.SingleUsePaths
+	}
+	if m.RevocationFile == "" {
+		m.RevocationFile = primaryInstance.RevocationFile
+	}
+	if m.Redis == nil {
+		m.Redis = primaryInstance.Redis
+	}
+	if m.TokenCacheSize == 0 {
+		m.TokenCacheSize = primaryInstance.TokenCacheSize
+	}
+	if m.InvalidTokenCacheTTL == 0 {
+		m.InvalidTokenCacheTTL = primaryInstance.InvalidTokenCacheTTL
+	}
+	if m.DecisionCacheSize == 0 {
+		m.DecisionCacheSize = primaryInstance.DecisionCacheSize
+	}
+	if len(m.TokenHeaderNames) == 0 {
+		m.TokenHeaderNames = primaryInstance.TokenHeaderNames
+	}
+	if len(m.TokenCookieNames) == 0 {
+		m.TokenCookieNames = primaryInstance.TokenCookieNames
+	}
+	if len(m.TokenQueryNames) == 0 {
+		m.TokenQueryNames = primaryInstance.TokenQueryNames
+	}
+	if len(m.TokenFormNames) == 0 {
+		m.TokenFormNames = primaryInstance.TokenFormNames
+	}
+	if m.TokenFormMaxBodySize == 0 {
+		m.TokenFormMaxBodySize = primaryInstance.TokenFormMaxBodySize
+	}
+
 	m.TokenValidator.AccessList = m.AccessList
 	m.TokenValidator.TokenSources = m.AllowedTokenSources
+	m.TokenValidator.HeaderNames = m.TokenHeaderNames
+	m.TokenValidator.CookieNames = m.TokenCookieNames
+	m.TokenValidator.QueryNames = m.TokenQueryNames
+	m.TokenValidator.FormNames = m.TokenFormNames
+	m.TokenValidator.FormMaxBodySize = m.TokenFormMaxBodySize
 	m.TokenValidator.TokenConfigs = m.TrustedTokens
+	m.TokenValidator.IssuerRouting = m.IssuerRouting
+	m.TokenValidator.Provider = m.Provider
+	m.TokenValidator.TokenUse = m.TokenUse
+	m.TokenValidator.RequiredClaims = m.RequiredClaims
+	m.TokenValidator.ClaimNamespaces = m.ClaimNamespaces
+	m.TokenValidator.ClaimMap = m.ClaimMap
+	m.TokenValidator.ClaimTransforms = m.ClaimTransforms
+	m.TokenValidator.CertificateBoundPaths = m.CertificateBoundPaths
+	m.TokenValidator.AuthTimeRequirements = m.AuthTimeRequirements
+	m.TokenValidator.SingleUsePaths = m.SingleUsePaths
+	m.TokenValidator.RevocationFile = m.RevocationFile
+	m.TokenValidator.Redis = m.Redis
+	m.TokenValidator.CacheSize = m.TokenCacheSize
+	m.TokenValidator.InvalidTokenCacheTTL = m.InvalidTokenCacheTTL
+	m.TokenValidator.DecisionCacheSize = m.DecisionCacheSize
+	m.TokenValidator.SetLogger(m.logger)
 	if err := m.TokenValidator.ConfigureTokenBackends(); err != nil {
 		m.ProvisionFailed = true
 		return nil, jwterrors.ErrInvalidBackendConfiguration.WithArgs(m.Name, err)
 	}
 
+	if m.Reissue != nil && m.reissuer == nil {
+		reissuer, err := jwtgrantor.NewReissuer(m.Reissue)
+		if err != nil {
+			m.ProvisionFailed = true
+			return nil, jwterrors.ErrInvalidReissueConfiguration.WithArgs(m.Name, err)
+		}
+		m.reissuer = reissuer
+	}
+	if m.TokenExchange != nil && m.exchanger == nil {
+		m.exchanger = newTokenExchanger(m.TokenExchange)
+	}
+	if m.TokenRefresh != nil && m.refresher == nil {
+		m.refresher = newTokenRefresher(m.TokenRefresh)
+	}
+	if err := validateSessionCookie(m); err != nil {
+		m.ProvisionFailed = true
+		return nil, err
+	}
+
 	if m.ForbiddenURL == "" {
 		m.ForbiddenURL = primaryInstance.ForbiddenURL
 	}
+	if m.Realm == "" {
+		m.Realm = primaryInstance.Realm
+	}
+	if len(m.DenyStatuses) == 0 {
+		m.DenyStatuses = primaryInstance.DenyStatuses
+	}
+	if err := validateDenyStatuses(m); err != nil {
+		m.ProvisionFailed = true
+		return nil, err
+	}
+	if len(m.DenyTemplates) == 0 {
+		m.DenyTemplates = primaryInstance.DenyTemplates
+		m.denyTemplates = primaryInstance.denyTemplates
+	}
+	if len(m.DenyTemplates) > 0 && m.denyTemplates == nil {
+		denyTemplates, err := jwthandlers.NewDenyTemplates(m.DenyTemplates)
+		if err != nil {
+			m.ProvisionFailed = true
+			return nil, jwterrors.ErrInvalidDenyTemplates.WithArgs(m.Name, err)
+		}
+		m.denyTemplates = denyTemplates
+	}
 
 	m.PassClaimsWithHeaders = primaryInstance.PassClaimsWithHeaders
+	if !m.PassForwardedHeaders {
+		m.PassForwardedHeaders = primaryInstance.PassForwardedHeaders
+	}
+	if !m.PassExpiryHeaders {
+		m.PassExpiryHeaders = primaryInstance.PassExpiryHeaders
+	}
+	if !m.PassExpiryResponseHeaders {
+		m.PassExpiryResponseHeaders = primaryInstance.PassExpiryResponseHeaders
+	}
+	if !m.PassRequestContextHeaders {
+		m.PassRequestContextHeaders = primaryInstance.PassRequestContextHeaders
+	}
+	if !m.StripToken {
+		m.StripToken = primaryInstance.StripToken
+	}
+	if !m.ContentNegotiationDisabled {
+		m.ContentNegotiationDisabled = primaryInstance.ContentNegotiationDisabled
+	}
+	if !m.ErrorRoutesEnabled {
+		m.ErrorRoutesEnabled = primaryInstance.ErrorRoutesEnabled
+	}
+	if !m.RedirectLoopCheckDisabled {
+		m.RedirectLoopCheckDisabled = primaryInstance.RedirectLoopCheckDisabled
+	}
 
 	m.logger.Debug(
 		"JWT token configuration provisioned for non-primary instance",
@@ -370,3 +770,99 @@ func (p *InstanceManager) Provision(name string) (*Authorizer, error) {
 
 	return m, nil
 }
+
+// newTokenExchanger returns the exchanger of the validated tokens.
+func newTokenExchanger(cfg *jwtconfig.TokenExchangeConfig) *jwtbackends.TokenExchanger {
+	return jwtbackends.NewTokenExchanger(&jwtbackends.TokenExchangeOptions{
+		Endpoint:           cfg.Endpoint,
+		ClientID:           cfg.ClientID,
+		ClientSecret:       cfg.ClientSecret,
+		Audience:           cfg.Audience,
+		Resource:           cfg.Resource,
+		Scope:              cfg.Scope,
+		SubjectTokenType:   cfg.SubjectTokenType,
+		RequestedTokenType: cfg.RequestedTokenType,
+		CacheTTL:           time.Duration(cfg.CacheTTL) * time.Second,
+	})
+}
+
+// newTokenRefresher returns the refresher of the expired access tokens.
+func newTokenRefresher(cfg *jwtconfig.TokenRefreshConfig) *jwtbackends.TokenRefresher {
+	return jwtbackends.NewTokenRefresher(&jwtbackends.TokenRefreshOptions{
+		Endpoint:     cfg.Endpoint,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		Scope:        cfg.Scope,
+	})
+}
+
+// validateMode checks the authentication mode.
+func validateMode(m *Authorizer) error {
+	switch m.Mode {
+	case "", "required", "optional":
+		return nil
+	}
+	return jwterrors.ErrAuthMode.WithArgs(m.Name, m.Mode)
+}
+
+// validateRedirectEncoding checks the encoding of the return URL passed to
+// the authentication portal.
+func validateRedirectEncoding(m *Authorizer) error {
+	switch m.AuthRedirectEncoding {
+	case "", "query", "base64url":
+		return nil
+	}
+	return jwterrors.ErrRedirectEncoding.WithArgs(m.Name, m.AuthRedirectEncoding)
+}
+
+// validateDenyStatuses checks the statuses of the responses to the denials.
+func validateDenyStatuses(m *Authorizer) error {
+	for _, s := range m.DenyStatuses {
+		if s.Denial != "unauthorized" && s.Denial != "forbidden" {
+			return jwterrors.ErrDenyStatusDenial.WithArgs(m.Name, s.Denial)
+		}
+		if s.Status < 400 || s.Status > 499 {
+			return jwterrors.ErrDenyStatusCode.WithArgs(m.Name, s.Status)
+		}
+	}
+	return nil
+}
+
+// validateSessionCookie checks that the session cookie, also holding the
+// refreshed access tokens, is read back as a token cookie, so that the
+// requests with the cookie are authorized.
+func validateSessionCookie(m *Authorizer) error {
+	if m.SessionCookie == nil && m.TokenRefresh == nil {
+		return nil
+	}
+	cfg := m.SessionCookie
+	if cfg == nil {
+		cfg = &jwtconfig.SessionCookieConfig{}
+	}
+	name := cfg.GetName()
+	if !hasCookieTokenSource(m) || !m.TokenValidator.IsTokenCookie(name) {
+		return jwterrors.ErrSessionCookieName.WithArgs(m.Name, name)
+	}
+	if cfg.Reissue && m.reissuer == nil {
+		return jwterrors.ErrSessionCookieReissue.WithArgs(m.Name)
+	}
+	if cfg.RenewWindow > 0 && m.reissuer == nil {
+		return jwterrors.ErrSessionCookieRenewal.WithArgs(m.Name)
+	}
+	switch strings.ToLower(cfg.SameSite) {
+	case "", "lax", "strict", "none":
+	default:
+		return jwterrors.ErrSessionCookieSameSite.WithArgs(m.Name, cfg.SameSite)
+	}
+	return nil
+}
+
+// hasCookieTokenSource returns true if the tokens are searched in the cookies.
+func hasCookieTokenSource(m *Authorizer) bool {
+	for _, source := range m.TokenValidator.TokenSources {
+		if source == "cookie" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"context"
+	"crypto/x509"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+// AWSSecretsManagerClient is the part of AWS Secrets Manager API used by
+// AWSKeySource.
+type AWSSecretsManagerClient interface {
+	GetSecretValueWithContext(aws.Context, *secretsmanager.GetSecretValueInput, ...request.Option) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// AWSKMSClient is the part of AWS KMS API used by AWSKeySource.
+type AWSKMSClient interface {
+	GetPublicKeyWithContext(aws.Context, *kms.GetPublicKeyInput, ...request.Option) (*kms.GetPublicKeyOutput, error)
+}
+
+// AWSOptions are the options of AWSKeySource.
+type AWSOptions struct {
+	// The id or ARN of the secret in AWS Secrets Manager. The secret holds
+	// either a shared secret or a PEM-encoded public key.
+	SecretID string
+	// The id, ARN, or alias of the asymmetric key in AWS KMS.
+	KMSKeyID string
+	// The region of the services. When empty, the region is determined by
+	// the default AWS configuration, e.g. AWS_REGION environment variable.
+	Region string
+	// The clients of the services. When nil, the clients are created with
+	// the default AWS credential chain.
+	SecretsManagerClient AWSSecretsManagerClient
+	KMSClient            AWSKMSClient
+}
+
+// AWSKeySource fetches key material from AWS Secrets Manager and AWS KMS.
+type AWSKeySource struct {
+	secretID       string
+	kmsKeyID       string
+	secretsManager AWSSecretsManagerClient
+	kms            AWSKMSClient
+}
+
+// NewAWSKeySource returns AWSKeySource instance.
+func NewAWSKeySource(opts *AWSOptions) (*AWSKeySource, error) {
+	s := &AWSKeySource{
+		secretID:       opts.SecretID,
+		kmsKeyID:       opts.KMSKeyID,
+		secretsManager: opts.SecretsManagerClient,
+		kms:            opts.KMSClient,
+	}
+	if (s.secretID != "" && s.secretsManager == nil) || (s.kmsKeyID != "" && s.kms == nil) {
+		sessionOpts := session.Options{
+			SharedConfigState: session.SharedConfigEnable,
+		}
+		if opts.Region != "" {
+			sessionOpts.Config.Region = aws.String(opts.Region)
+		}
+		sess, err := session.NewSessionWithOptions(sessionOpts)
+		if err != nil {
+			return nil, errors.ErrAWSSession.WithArgs(err)
+		}
+		if s.secretsManager == nil {
+			s.secretsManager = secretsmanager.New(sess)
+		}
+		if s.kms == nil {
+			s.kms = kms.New(sess)
+		}
+	}
+	return s, nil
+}
+
+// FetchKeys fetches the secret from AWS Secrets Manager and the public key
+// from AWS KMS.
+func (s *AWSKeySource) FetchKeys(ctx context.Context) (*KeyMaterial, error) {
+	km := &KeyMaterial{}
+	if s.secretID != "" {
+		resp, err := s.secretsManager.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(s.secretID),
+		})
+		if err != nil {
+			return nil, errors.ErrAWSSecretFetch.WithArgs(s.secretID, err)
+		}
+		value := resp.SecretBinary
+		if resp.SecretString != nil {
+			value = []byte(*resp.SecretString)
+		}
+		km, err = ParseKeyMaterial(s.secretID, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.kmsKeyID != "" {
+		resp, err := s.kms.GetPublicKeyWithContext(ctx, &kms.GetPublicKeyInput{
+			KeyId: aws.String(s.kmsKeyID),
+		})
+		if err != nil {
+			return nil, errors.ErrAWSKMSFetch.WithArgs(s.kmsKeyID, err)
+		}
+		if aws.StringValue(resp.KeyUsage) != kms.KeyUsageTypeSignVerify {
+			return nil, errors.ErrAWSKMSKeyUsage.WithArgs(s.kmsKeyID, aws.StringValue(resp.KeyUsage))
+		}
+		pk, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+		if err != nil {
+			return nil, errors.ErrKeySourceKeyMalformed.WithArgs(s.kmsKeyID, err)
+		}
+		km.AddKey(s.kmsKeyID, pk)
+		if arn := aws.StringValue(resp.KeyId); arn != "" {
+			km.AddKey(arn, pk)
+		}
+	}
+	return km, nil
+}
@@ -0,0 +1,237 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+const (
+	azureKeyVaultAPIVersion       = "7.1"
+	azureKeyVaultResource         = "https://vault.azure.net"
+	azureDefaultAuthorityHost     = "https://login.microsoftonline.com"
+	azureDefaultIdentityEndpoint  = "http://169.254.169.254/metadata/identity/oauth2/token"
+	azureIdentityAPIVersion       = "2018-02-01"
+	azureAccessTokenRefreshMargin = 5 * time.Minute
+)
+
+// AzureOptions are the options of AzureKeySource.
+type AzureOptions struct {
+	// The URL of the key vault, e.g. https://myvault.vault.azure.net.
+	VaultURL string
+	// The name of the secret holding either a shared secret or a PEM-encoded
+	// public key.
+	SecretName string
+	// The name of the key.
+	KeyName string
+	// The client credentials of the application. When the client secret is
+	// empty, the managed identity is used, with the client id selecting
+	// the user-assigned identity. The empty values are taken from
+	// AZURE_TENANT_ID, AZURE_CLIENT_ID, and AZURE_CLIENT_SECRET environment
+	// variables.
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	// The endpoints for obtaining access tokens. When empty, the endpoints
+	// of Azure public cloud and Azure Instance Metadata Service are used.
+	AuthorityHost    string
+	IdentityEndpoint string
+	HTTPClient       *http.Client
+}
+
+// AzureKeySource fetches key material from Azure Key Vault.
+type AzureKeySource struct {
+	opts   AzureOptions
+	client *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+type azureAccessTokenResponse struct {
+	AccessToken string      `json:"access_token"`
+	ExpiresIn   json.Number `json:"expires_in"`
+}
+
+type azureSecretResponse struct {
+	Value string `json:"value"`
+}
+
+type azureKeyResponse struct {
+	Key *JwksKey `json:"key"`
+}
+
+// NewAzureKeySource returns AzureKeySource instance.
+func NewAzureKeySource(opts *AzureOptions) (*AzureKeySource, error) {
+	s := &AzureKeySource{
+		opts:   *opts,
+		client: opts.HTTPClient,
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+	if s.opts.VaultURL == "" {
+		return nil, errors.ErrAzureNoVaultURL
+	}
+	s.opts.VaultURL = strings.TrimSuffix(s.opts.VaultURL, "/")
+	for v, k := range map[*string]string{
+		&s.opts.TenantID:     "AZURE_TENANT_ID",
+		&s.opts.ClientID:     "AZURE_CLIENT_ID",
+		&s.opts.ClientSecret: "AZURE_CLIENT_SECRET",
+	} {
+		if *v == "" {
+			*v = os.Getenv(k)
+		}
+	}
+	if s.opts.ClientSecret != "" && s.opts.TenantID == "" {
+		return nil, errors.ErrAzureNoTenantID
+	}
+	if s.opts.AuthorityHost == "" {
+		s.opts.AuthorityHost = azureDefaultAuthorityHost
+	}
+	if s.opts.IdentityEndpoint == "" {
+		s.opts.IdentityEndpoint = azureDefaultIdentityEndpoint
+	}
+	return s, nil
+}
+
+// FetchKeys fetches the secret and the public key from Azure Key Vault.
+func (s *AzureKeySource) FetchKeys(ctx context.Context) (*KeyMaterial, error) {
+	km := &KeyMaterial{}
+	if s.opts.SecretName != "" {
+		resp := &azureSecretResponse{}
+		if err := s.get(ctx, "/secrets/"+url.PathEscape(s.opts.SecretName), resp); err != nil {
+			return nil, errors.ErrAzureSecretFetch.WithArgs(s.opts.SecretName, err)
+		}
+		var err error
+		km, err = ParseKeyMaterial(s.opts.SecretName, []byte(resp.Value))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.opts.KeyName != "" {
+		resp := &azureKeyResponse{}
+		if err := s.get(ctx, "/keys/"+url.PathEscape(s.opts.KeyName), resp); err != nil {
+			return nil, errors.ErrAzureKeyFetch.WithArgs(s.opts.KeyName, err)
+		}
+		if resp.Key == nil {
+			return nil, errors.ErrKeySourceKeyMalformed.WithArgs(s.opts.KeyName, "no key in response")
+		}
+		// The keys protected by HSM have RSA-HSM and EC-HSM key types.
+		resp.Key.KeyType = strings.TrimSuffix(resp.Key.KeyType, "-HSM")
+		pk, err := resp.Key.PublicKey()
+		if err != nil {
+			return nil, errors.ErrKeySourceKeyMalformed.WithArgs(s.opts.KeyName, err)
+		}
+		km.AddKey(s.opts.KeyName, pk)
+		if resp.Key.KeyID != "" {
+			km.AddKey(resp.Key.KeyID, pk)
+		}
+	}
+	return km, nil
+}
+
+// get fetches the latest version of the object from the key vault.
+func (s *AzureKeySource) get(ctx context.Context, path string, v interface{}) error {
+	accessToken, err := s.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.opts.VaultURL+path+"?api-version="+azureKeyVaultAPIVersion, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	return s.do(req, v)
+}
+
+// getAccessToken returns the access token for the key vault. The token is
+// reused until shortly before it expires.
+func (s *AzureKeySource) getAccessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.accessToken != "" && time.Now().Before(s.expiresAt) {
+		return s.accessToken, nil
+	}
+
+	var req *http.Request
+	var err error
+	if s.opts.ClientSecret != "" {
+		form := url.Values{}
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_id", s.opts.ClientID)
+		form.Set("client_secret", s.opts.ClientSecret)
+		form.Set("scope", azureKeyVaultResource+"/.default")
+		uri := strings.TrimSuffix(s.opts.AuthorityHost, "/") + "/" + url.PathEscape(s.opts.TenantID) + "/oauth2/v2.0/token"
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, uri, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", errors.ErrAzureAccessToken.WithArgs(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := url.Values{}
+		query.Set("api-version", azureIdentityAPIVersion)
+		query.Set("resource", azureKeyVaultResource)
+		if s.opts.ClientID != "" {
+			query.Set("client_id", s.opts.ClientID)
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, s.opts.IdentityEndpoint+"?"+query.Encode(), nil)
+		if err != nil {
+			return "", errors.ErrAzureAccessToken.WithArgs(err)
+		}
+		req.Header.Set("Metadata", "true")
+	}
+
+	resp := &azureAccessTokenResponse{}
+	if err := s.do(req, resp); err != nil {
+		return "", errors.ErrAzureAccessToken.WithArgs(err)
+	}
+	if resp.AccessToken == "" {
+		return "", errors.ErrAzureAccessToken.WithArgs("no access token in response")
+	}
+	expiresIn, _ := strconv.Atoi(resp.ExpiresIn.String())
+	s.accessToken = resp.AccessToken
+	s.expiresAt = time.Now().Add(time.Duration(expiresIn)*time.Second - azureAccessTokenRefreshMargin)
+	return s.accessToken, nil
+}
+
+// do sends the request and decodes JSON response.
+func (s *AzureKeySource) do(req *http.Request, v interface{}) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.ErrAzureResponse.WithArgs(resp.Status, strings.TrimSpace(string(b)))
+	}
+	return json.Unmarshal(b, v)
+}
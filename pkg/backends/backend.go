@@ -15,17 +15,84 @@
 package backends
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 
-	jwtlib "github.com/dgrijalva/jwt-go"
 	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwttoken "github.com/greenpau/caddy-auth-jwt/pkg/token"
 )
 
 var defaultKeyID = "0"
 
 // TokenBackend is the interface to provide key material.
 type TokenBackend interface {
-	ProvideKey(token *jwtlib.Token) (interface{}, error)
+	ProvideKey(token *jwttoken.Token) (interface{}, error)
+}
+
+// KeyAvailabilityChecker is the token backend fetching the keys from
+// a remote key source, e.g. JWKS endpoint.
+type KeyAvailabilityChecker interface {
+	TokenBackend
+	// CheckKeysAvailable returns an error when the backend has no keys,
+	// because the key source is unavailable.
+	CheckKeysAvailable() error
+}
+
+// KeyCount returns the number of distinct keys held by the backend. The key
+// ids referring to the same key, e.g. the default key id, count as one key.
+// It returns zero when the keys of the backend are not known, e.g. for the
+// backends provided by modules.
+func KeyCount(backend TokenBackend) int {
+	switch b := backend.(type) {
+	case *SecretKeyTokenBackend:
+		return 1
+	case *RSAKeyTokenBackend:
+		return countKeys(b.secrets)
+	case *ECDSAKeyTokenBackend:
+		return countKeys(b.secrets)
+	case *EdDSAKeyTokenBackend:
+		return countKeys(b.secrets)
+	case *JwksURIBackend:
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		return countKeys(b.secrets)
+	case *ReloadableTokenBackend:
+		n := 0
+		for _, backend := range b.GetBackends() {
+			n += KeyCount(backend)
+		}
+		return n
+	}
+	return 0
+}
+
+func countKeys(keys map[string]interface{}) int {
+	distinct := []interface{}{}
+	for _, k := range keys {
+		found := false
+		for _, key := range distinct {
+			if sameKey(key, k) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			distinct = append(distinct, k)
+		}
+	}
+	return len(distinct)
+}
+
+func sameKey(a, b interface{}) bool {
+	switch k := a.(type) {
+	case interface{ Equal(crypto.PublicKey) bool }:
+		return k.Equal(b)
+	case interface{ Equal(crypto.PrivateKey) bool }:
+		return k.Equal(b)
+	}
+	return false
 }
 
 // SecretKeyTokenBackend hold symentric keys from HS family.
@@ -45,16 +112,17 @@ func NewSecretKeyTokenBackend(s string) (*SecretKeyTokenBackend, error) {
 }
 
 // ProvideKey provides key material from SecretKeyTokenBackend.
-func (b *SecretKeyTokenBackend) ProvideKey(token *jwtlib.Token) (interface{}, error) {
-	if _, validMethod := token.Method.(*jwtlib.SigningMethodHMAC); !validMethod {
+func (b *SecretKeyTokenBackend) ProvideKey(token *jwttoken.Token) (interface{}, error) {
+	if token.Method != jwttoken.HMAC {
 		return nil, errors.ErrUnexpectedSigningMethod.WithArgs("HS", token.Header["alg"])
 	}
 	return b.secret, nil
 }
 
-// RSAKeyTokenBackend hold asymentric keys from RS family.
+// RSAKeyTokenBackend hold asymentric keys from RS and PS families.
 type RSAKeyTokenBackend struct {
-	secrets map[string]interface{}
+	secrets    map[string]interface{}
+	pssMethods map[string]struct{}
 }
 
 // NewRSAKeyTokenBackend returns RSKeyTokenBackend instance.
@@ -65,14 +133,35 @@ func NewRSAKeyTokenBackend(k map[string]interface{}) *RSAKeyTokenBackend {
 	return b
 }
 
+// SetPSSMethods restricts the RSA-PSS signing methods, e.g. PS256, accepted
+// by the backend. By default, all RSA-PSS methods are accepted.
+func (b *RSAKeyTokenBackend) SetPSSMethods(methods []string) error {
+	b.pssMethods = make(map[string]struct{})
+	for _, method := range methods {
+		if jwttoken.GetSigningMethod(method) != jwttoken.RSAPSS {
+			return errors.ErrUnsupportedRSAPSSMethod.WithArgs(method)
+		}
+		b.pssMethods[method] = struct{}{}
+	}
+	return nil
+}
+
 // ProvideKey provides key material from RSKeyTokenBackend.
-func (b *RSAKeyTokenBackend) ProvideKey(token *jwtlib.Token) (interface{}, error) {
-	if _, validMethod := token.Method.(*jwtlib.SigningMethodRSA); !validMethod {
+func (b *RSAKeyTokenBackend) ProvideKey(token *jwttoken.Token) (interface{}, error) {
+	switch token.Method {
+	case jwttoken.RSA:
+	case jwttoken.RSAPSS:
+		if b.pssMethods != nil {
+			if _, allowed := b.pssMethods[token.Alg]; !allowed {
+				return nil, errors.ErrUnexpectedSigningMethod.WithArgs("RS", token.Header["alg"])
+			}
+		}
+	default:
 		return nil, errors.ErrUnexpectedSigningMethod.WithArgs("RS", token.Header["alg"])
 	}
 
 	// check if we have a "kid" in the header we can use...
-	if kid, ok := token.Header["kid"].(string); ok {
+	if kid, ok := GetKeyID(token); ok {
 		if val, ok := b.secrets[kid]; ok {
 			switch key := val.(type) {
 			case *rsa.PrivateKey:
@@ -99,3 +188,89 @@ func (b *RSAKeyTokenBackend) ProvideKey(token *jwtlib.Token) (interface{}, error
 
 	return nil, errors.ErrNoRSAKeyFound
 }
+
+// ECDSAKeyTokenBackend hold asymentric keys from ES family.
+type ECDSAKeyTokenBackend struct {
+	secrets map[string]interface{}
+}
+
+// NewECDSAKeyTokenBackend returns ECDSAKeyTokenBackend instance.
+func NewECDSAKeyTokenBackend(k map[string]interface{}) *ECDSAKeyTokenBackend {
+	b := &ECDSAKeyTokenBackend{
+		secrets: k,
+	}
+	return b
+}
+
+// ProvideKey provides key material from ECDSAKeyTokenBackend.
+func (b *ECDSAKeyTokenBackend) ProvideKey(token *jwttoken.Token) (interface{}, error) {
+	if token.Method != jwttoken.ECDSA {
+		return nil, errors.ErrUnexpectedSigningMethod.WithArgs("ES", token.Header["alg"])
+	}
+
+	if kid, ok := GetKeyID(token); ok {
+		if val, ok := b.secrets[kid]; ok {
+			switch key := val.(type) {
+			case *ecdsa.PrivateKey:
+				return &key.PublicKey, nil
+			case *ecdsa.PublicKey:
+				return key, nil
+			}
+		}
+		return nil, errors.ErrUnexpectedKID
+	}
+
+	if val, ok := b.secrets[defaultKeyID]; ok {
+		switch key := val.(type) {
+		case *ecdsa.PrivateKey:
+			return &key.PublicKey, nil
+		case *ecdsa.PublicKey:
+			return key, nil
+		}
+	}
+
+	return nil, errors.ErrNoECDSAKeyFound
+}
+
+// EdDSAKeyTokenBackend hold asymentric keys for Ed25519 curve.
+type EdDSAKeyTokenBackend struct {
+	secrets map[string]interface{}
+}
+
+// NewEdDSAKeyTokenBackend returns EdDSAKeyTokenBackend instance.
+func NewEdDSAKeyTokenBackend(k map[string]interface{}) *EdDSAKeyTokenBackend {
+	b := &EdDSAKeyTokenBackend{
+		secrets: k,
+	}
+	return b
+}
+
+// ProvideKey provides key material from EdDSAKeyTokenBackend.
+func (b *EdDSAKeyTokenBackend) ProvideKey(token *jwttoken.Token) (interface{}, error) {
+	if token.Method != jwttoken.EdDSA {
+		return nil, errors.ErrUnexpectedSigningMethod.WithArgs("EdDSA", token.Header["alg"])
+	}
+
+	if kid, ok := GetKeyID(token); ok {
+		if val, ok := b.secrets[kid]; ok {
+			switch key := val.(type) {
+			case ed25519.PrivateKey:
+				return key.Public(), nil
+			case ed25519.PublicKey:
+				return key, nil
+			}
+		}
+		return nil, errors.ErrUnexpectedKID
+	}
+
+	if val, ok := b.secrets[defaultKeyID]; ok {
+		switch key := val.(type) {
+		case ed25519.PrivateKey:
+			return key.Public(), nil
+		case ed25519.PublicKey:
+			return key, nil
+		}
+	}
+
+	return nil, errors.ErrNoEdDSAKeyFound
+}
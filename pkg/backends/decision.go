@@ -0,0 +1,130 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const defaultDecisionCacheMaxEntries = 10000
+
+var decisionCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "caddy",
+	Subsystem: "jwt_decision_cache",
+	Name:      "lookups_total",
+	Help:      "Counter of the lookups of the decisions of remote token validation, by result, i.e. hit or miss.",
+}, []string{"result"})
+
+// Decision is the result of validating the token remotely, e.g. with token
+// introspection. The decisions rejecting the token have an error.
+type Decision struct {
+	Claims map[string]interface{}
+	// The token issued in exchange for the token, e.g. with token exchange.
+	Token string
+	Err   error
+}
+
+// DecisionCache holds the decisions of remote token validation, so that a
+// request to the remote service serves the requests with the same token
+// until the decision expires. The cache is shared by the token backends,
+// each keeping the decisions in its own scope, e.g. the URL of the service.
+type DecisionCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type decisionCacheEntry struct {
+	key       string
+	decision  *Decision
+	expiresAt time.Time
+}
+
+// NewDecisionCache returns DecisionCache instance holding up to maxEntries
+// decisions. Zero means 10000.
+func NewDecisionCache(maxEntries int) *DecisionCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultDecisionCacheMaxEntries
+	}
+	return &DecisionCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Get returns the decision about the token in the scope, unless it is not
+// cached or expired.
+func (c *DecisionCache) Get(scope, token string) (*Decision, bool) {
+	key := decisionCacheKey(scope, token)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, exists := c.entries[key]; exists {
+		entry := elem.Value.(*decisionCacheEntry)
+		if time.Now().Before(entry.expiresAt) {
+			c.order.MoveToFront(elem)
+			decisionCacheLookups.WithLabelValues("hit").Inc()
+			return entry.decision, true
+		}
+		c.remove(elem)
+	}
+	decisionCacheLookups.WithLabelValues("miss").Inc()
+	return nil, false
+}
+
+// Add adds the decision about the token in the scope for the duration. The
+// decision is not kept past the expiration time of the token, when
+// the expiry is not zero.
+func (c *DecisionCache) Add(scope, token string, decision *Decision, ttl time.Duration, tokenExpiresAt time.Time) {
+	expiresAt := time.Now().Add(ttl)
+	if !tokenExpiresAt.IsZero() && tokenExpiresAt.Before(expiresAt) {
+		expiresAt = tokenExpiresAt
+	}
+	if !time.Now().Before(expiresAt) {
+		return
+	}
+	key := decisionCacheKey(scope, token)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, exists := c.entries[key]; exists {
+		c.remove(elem)
+	}
+	// The least recently used decisions are evicted.
+	for c.order.Len() >= c.maxEntries {
+		c.remove(c.order.Back())
+	}
+	c.entries[key] = c.order.PushFront(&decisionCacheEntry{key: key, decision: decision, expiresAt: expiresAt})
+}
+
+func (c *DecisionCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*decisionCacheEntry).key)
+}
+
+// decisionCacheKey returns the key of the token in the scope, so that
+// the cache does not hold the tokens themselves.
+func decisionCacheKey(scope, token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return scope + " " + hex.EncodeToString(sum[:])
+}
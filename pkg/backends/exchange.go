@@ -0,0 +1,185 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+const (
+	tokenExchangeGrantType   = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenExchangeTimeout     = 10 * time.Second
+	defaultTokenExchangeTTL  = 5 * time.Minute
+	defaultSubjectTokenType  = "urn:ietf:params:oauth:token-type:access_token"
+	tokenExchangeExpiryGuard = 5 * time.Second
+)
+
+// TokenExchangeOptions are the options of TokenExchanger.
+type TokenExchangeOptions struct {
+	// The token endpoint of the authorization server.
+	Endpoint string
+	// The client credentials authenticating the requests with HTTP Basic
+	// authentication.
+	ClientID     string
+	ClientSecret string
+	// The audiences, the resources, and the scopes of the exchanged tokens.
+	Audience []string
+	Resource []string
+	Scope    []string
+	// The types of the tokens, by default the access tokens, i.e.
+	// urn:ietf:params:oauth:token-type:access_token. When the requested
+	// type is empty, the authorization server selects the type.
+	SubjectTokenType   string
+	RequestedTokenType string
+	// The duration the exchanged tokens are cached for, by default 5 minutes.
+	// The tokens are not cached past their expiration time, nor past the
+	// expiration time of the subject tokens. Negative value disables the
+	// cache.
+	CacheTTL   time.Duration
+	Cache      *DecisionCache
+	HTTPClient *http.Client
+}
+
+// TokenExchanger exchanges the tokens for the tokens issued by the
+// authorization server, e.g. with a different audience, per OAuth 2.0 Token
+// Exchange, RFC 8693. The exchanged tokens are cached by the digest of the
+// subject tokens.
+type TokenExchanger struct {
+	opts   TokenExchangeOptions
+	scope  string
+	client *http.Client
+	cache  *DecisionCache
+}
+
+type tokenExchangeResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// NewTokenExchanger returns TokenExchanger instance.
+func NewTokenExchanger(opts *TokenExchangeOptions) *TokenExchanger {
+	e := &TokenExchanger{
+		opts:   *opts,
+		client: opts.HTTPClient,
+		cache:  opts.Cache,
+	}
+	if e.client == nil {
+		e.client = http.DefaultClient
+	}
+	if e.cache == nil {
+		e.cache = NewDecisionCache(0)
+	}
+	if e.opts.SubjectTokenType == "" {
+		e.opts.SubjectTokenType = defaultSubjectTokenType
+	}
+	if e.opts.CacheTTL == 0 {
+		e.opts.CacheTTL = defaultTokenExchangeTTL
+	}
+	// The tokens exchanged at the same endpoint for other audiences are
+	// kept apart.
+	e.scope = strings.Join([]string{
+		e.opts.Endpoint,
+		strings.Join(e.opts.Audience, ","),
+		strings.Join(e.opts.Resource, ","),
+		strings.Join(e.opts.Scope, ","),
+		e.opts.RequestedTokenType,
+	}, " ")
+	return e
+}
+
+// Exchange returns the token issued in exchange for the subject token. The
+// expiry, when not zero, is the expiration time of the subject token.
+func (e *TokenExchanger) Exchange(subjectToken string, expiresAt time.Time) (string, error) {
+	if e.opts.CacheTTL > 0 {
+		if decision, exists := e.cache.Get(e.scope, subjectToken); exists {
+			return decision.Token, nil
+		}
+	}
+	resp, err := e.exchange(subjectToken)
+	if err != nil {
+		// The failed requests are not cached.
+		return "", err
+	}
+	if e.opts.CacheTTL > 0 {
+		ttl := e.opts.CacheTTL
+		if resp.ExpiresIn > 0 {
+			// The token is not passed to the upstreams shortly before it
+			// expires.
+			if d := time.Duration(resp.ExpiresIn)*time.Second - tokenExchangeExpiryGuard; d < ttl {
+				ttl = d
+			}
+		}
+		e.cache.Add(e.scope, subjectToken, &Decision{Token: resp.AccessToken}, ttl, expiresAt)
+	}
+	return resp.AccessToken, nil
+}
+
+func (e *TokenExchanger) exchange(subjectToken string) (*tokenExchangeResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", tokenExchangeGrantType)
+	form.Set("subject_token", subjectToken)
+	form.Set("subject_token_type", e.opts.SubjectTokenType)
+	for _, audience := range e.opts.Audience {
+		form.Add("audience", audience)
+	}
+	for _, resource := range e.opts.Resource {
+		form.Add("resource", resource)
+	}
+	if len(e.opts.Scope) > 0 {
+		form.Set("scope", strings.Join(e.opts.Scope, " "))
+	}
+	if e.opts.RequestedTokenType != "" {
+		form.Set("requested_token_type", e.opts.RequestedTokenType)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tokenExchangeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.opts.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.ErrTokenExchange.WithArgs(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if e.opts.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(e.opts.ClientID), url.QueryEscape(e.opts.ClientSecret))
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, errors.ErrTokenExchange.WithArgs(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.ErrTokenExchange.WithArgs(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.ErrTokenExchange.WithArgs(resp.Status)
+	}
+	exchanged := &tokenExchangeResponse{}
+	if err := json.Unmarshal(body, exchanged); err != nil {
+		return nil, errors.ErrTokenExchange.WithArgs(err)
+	}
+	if exchanged.AccessToken == "" {
+		return nil, errors.ErrTokenExchangeNoToken
+	}
+	return exchanged, nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	gcpSecretManagerEndpoint = "https://secretmanager.googleapis.com/v1/"
+	gcpCloudPlatformScope    = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// GCPOptions are the options of GCPKeySource.
+type GCPOptions struct {
+	// The resource name of the secret version in GCP Secret Manager, e.g.
+	// projects/my-project/secrets/my-secret/versions/latest. Without the
+	// version, the latest version is used. The secret holds either a shared
+	// secret or a PEM-encoded public key.
+	Secret string
+	// The path to the service account key file. When empty, the application
+	// default credentials are used, e.g. GOOGLE_APPLICATION_CREDENTIALS
+	// environment variable or the service account of the instance.
+	CredentialsFile string
+	// The endpoint of Secret Manager API.
+	Endpoint string
+	// The client of Secret Manager API. When nil, the client is authorized
+	// with the credentials.
+	HTTPClient *http.Client
+}
+
+// GCPKeySource fetches key material from GCP Secret Manager.
+type GCPKeySource struct {
+	secret   string
+	name     string
+	endpoint string
+	client   *http.Client
+}
+
+type gcpAccessSecretVersionResponse struct {
+	Name    string `json:"name"`
+	Payload struct {
+		Data string `json:"data"`
+	} `json:"payload"`
+}
+
+// NewGCPKeySource returns GCPKeySource instance.
+func NewGCPKeySource(opts *GCPOptions) (*GCPKeySource, error) {
+	parts := strings.Split(strings.Trim(opts.Secret, "/"), "/")
+	switch {
+	case len(parts) == 4 && parts[0] == "projects" && parts[2] == "secrets":
+		parts = append(parts, "versions", "latest")
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "secrets" && parts[4] == "versions":
+	default:
+		return nil, errors.ErrGCPSecretName.WithArgs(opts.Secret)
+	}
+	s := &GCPKeySource{
+		secret:   strings.Join(parts, "/"),
+		name:     parts[3],
+		endpoint: opts.Endpoint,
+		client:   opts.HTTPClient,
+	}
+	if s.endpoint == "" {
+		s.endpoint = gcpSecretManagerEndpoint
+	}
+	if !strings.HasSuffix(s.endpoint, "/") {
+		s.endpoint += "/"
+	}
+	if s.client == nil {
+		ctx := context.Background()
+		var creds *google.Credentials
+		var err error
+		if opts.CredentialsFile != "" {
+			var b []byte
+			b, err = ioutil.ReadFile(opts.CredentialsFile)
+			if err != nil {
+				return nil, errors.ErrGCPCredentials.WithArgs(err)
+			}
+			creds, err = google.CredentialsFromJSON(ctx, b, gcpCloudPlatformScope)
+		} else {
+			creds, err = google.FindDefaultCredentials(ctx, gcpCloudPlatformScope)
+		}
+		if err != nil {
+			return nil, errors.ErrGCPCredentials.WithArgs(err)
+		}
+		s.client = oauth2.NewClient(ctx, creds.TokenSource)
+	}
+	return s, nil
+}
+
+// FetchKeys fetches the secret version from GCP Secret Manager.
+func (s *GCPKeySource) FetchKeys(ctx context.Context) (*KeyMaterial, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint+s.secret+":access", nil)
+	if err != nil {
+		return nil, errors.ErrGCPSecretFetch.WithArgs(s.secret, err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.ErrGCPSecretFetch.WithArgs(s.secret, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.ErrGCPSecretFetch.WithArgs(s.secret, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.ErrGCPSecretFetch.WithArgs(s.secret, resp.Status)
+	}
+	secretVersion := &gcpAccessSecretVersionResponse{}
+	if err := json.Unmarshal(b, secretVersion); err != nil {
+		return nil, errors.ErrGCPSecretFetch.WithArgs(s.secret, err)
+	}
+	value, err := base64.StdEncoding.DecodeString(secretVersion.Payload.Data)
+	if err != nil {
+		return nil, errors.ErrGCPSecretFetch.WithArgs(s.secret, err)
+	}
+	return ParseKeyMaterial(s.name, value)
+}
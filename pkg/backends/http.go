@@ -0,0 +1,139 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+// defaultHTTPTimeout is the timeout of the requests of the clients fetching
+// keys, unless configured otherwise.
+const defaultHTTPTimeout = 30 * time.Second
+
+// defaultHTTPClient is the client fetching keys, unless configured otherwise.
+// Unlike http.DefaultClient, the requests time out.
+var defaultHTTPClient = &http.Client{Timeout: defaultHTTPTimeout}
+
+// HTTPClientOptions holds the settings of the client fetching keys.
+type HTTPClientOptions struct {
+	TLSOptions
+	// The timeout of a request, including reading the response. Zero value
+	// selects defaultHTTPTimeout, and negative value disables the timeout.
+	Timeout time.Duration
+	// The URL of the proxy, e.g. http://proxy.example.com:3128. When empty,
+	// the proxy is taken from HTTPS_PROXY, HTTP_PROXY, and NO_PROXY
+	// environment variables.
+	ProxyURL string
+	// The headers added to the requests, e.g. API keys.
+	Headers map[string]string
+	// The value of User-Agent header. When empty, the default of Go HTTP
+	// client is sent.
+	UserAgent string
+	// The credentials sent in Authorization header, i.e. the bearer token,
+	// the path to the file with the bearer token, or the username and the
+	// password of basic authentication. The file is read for each request,
+	// so that the rotated tokens are picked up.
+	BearerToken     string
+	BearerTokenFile string
+	Username        string
+	Password        string
+}
+
+// NewHTTPClientWithOptions returns HTTP client configured with the options.
+func NewHTTPClientWithOptions(opts *HTTPClientOptions) (*http.Client, error) {
+	if opts == nil {
+		return defaultHTTPClient, nil
+	}
+	transport, err := newTLSTransport(&opts.TLSOptions)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ProxyURL != "" {
+		proxyURL, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return nil, errors.ErrHTTPProxyURL.WithArgs(opts.ProxyURL, err)
+		}
+		if proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, errors.ErrHTTPProxyURL.WithArgs(opts.ProxyURL, "no scheme or host")
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+	}
+	switch {
+	case opts.Timeout == 0:
+		client.Timeout = defaultHTTPTimeout
+	case opts.Timeout < 0:
+		client.Timeout = 0
+	}
+	headers := make(http.Header)
+	for k, v := range opts.Headers {
+		headers.Set(k, v)
+	}
+	if opts.UserAgent != "" {
+		headers.Set("User-Agent", opts.UserAgent)
+	}
+	var credentials int
+	for _, v := range []string{opts.BearerToken, opts.BearerTokenFile, opts.Username + opts.Password} {
+		if v != "" {
+			credentials++
+		}
+	}
+	if credentials > 1 {
+		return nil, errors.ErrHTTPCredentials
+	}
+	switch {
+	case opts.BearerToken != "":
+		headers.Set("Authorization", "Bearer "+opts.BearerToken)
+	case opts.Username != "" || opts.Password != "":
+		headers.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(opts.Username+":"+opts.Password)))
+	}
+	if len(headers) > 0 {
+		client.Transport = &headerTransport{
+			base:    client.Transport,
+			headers: headers,
+		}
+	}
+	if opts.BearerTokenFile != "" {
+		client.Transport = &bearerTokenTransport{
+			tokenFile: opts.BearerTokenFile,
+			transport: client.Transport,
+		}
+	}
+	return client, nil
+}
+
+// headerTransport adds the headers to the requests.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+// RoundTrip sends the request with the headers. The request is cloned,
+// because the round trippers must not modify the requests.
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header[k] = v
+	}
+	return t.base.RoundTrip(req)
+}
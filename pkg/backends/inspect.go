@@ -0,0 +1,142 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// KeyInfo describes a key of a token backend, without the key material.
+type KeyInfo struct {
+	KeyID string `json:"kid"`
+	// The key type and size, e.g. RSA-2048 or EC-P256.
+	Type string `json:"type"`
+	// The signing algorithms the key verifies, e.g. RS256.
+	Algorithms []string `json:"algs,omitempty"`
+}
+
+// KeyStoreInfo describes the keys of a token backend.
+type KeyStoreInfo struct {
+	// The source of the keys, e.g. the URL of JWKS endpoint, or static for
+	// the keys in the configuration or the files.
+	Source string `json:"source"`
+	// The issuer whose jwks_uri is discovered.
+	Issuer string     `json:"issuer,omitempty"`
+	Keys   []*KeyInfo `json:"keys"`
+	// The time of the last fetch of the keys and its error, if any.
+	LastRefresh *time.Time `json:"last_refresh,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+const staticKeySource = "static"
+
+// InspectKeys returns the description of the keys held by the backend. The
+// shared secrets are not described. It returns nil when the keys of the
+// backend are not known, e.g. for the backends provided by modules.
+func InspectKeys(backend TokenBackend) []*KeyStoreInfo {
+	switch b := backend.(type) {
+	case *RSAKeyTokenBackend:
+		return []*KeyStoreInfo{{Source: staticKeySource, Keys: describeKeys(b.secrets)}}
+	case *ECDSAKeyTokenBackend:
+		return []*KeyStoreInfo{{Source: staticKeySource, Keys: describeKeys(b.secrets)}}
+	case *EdDSAKeyTokenBackend:
+		return []*KeyStoreInfo{{Source: staticKeySource, Keys: describeKeys(b.secrets)}}
+	case *JwksURIBackend:
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		info := &KeyStoreInfo{
+			Source: b.uri,
+			Issuer: b.issuer,
+			Keys:   describeKeys(b.secrets),
+		}
+		if !b.lastRefresh.IsZero() {
+			lastRefresh := b.lastRefresh.UTC()
+			info.LastRefresh = &lastRefresh
+		}
+		if b.lastErr != nil {
+			info.LastError = b.lastErr.Error()
+		}
+		return []*KeyStoreInfo{info}
+	case *ReloadableTokenBackend:
+		var infos []*KeyStoreInfo
+		for _, backend := range b.GetBackends() {
+			infos = append(infos, InspectKeys(backend)...)
+		}
+		return infos
+	}
+	return nil
+}
+
+// RefreshKeys fetches the keys of the backend immediately, regardless of
+// the refresh intervals. It returns false when the backend does not fetch
+// its keys, e.g. the keys are in the configuration.
+func RefreshKeys(backend TokenBackend) (bool, error) {
+	switch b := backend.(type) {
+	case *JwksURIBackend:
+		return true, b.Refresh()
+	}
+	return false, nil
+}
+
+// describeKeys returns the description of the keys sorted by key id.
+func describeKeys(keys map[string]interface{}) []*KeyInfo {
+	infos := []*KeyInfo{}
+	for kid, k := range keys {
+		keyType, algs := describeKey(k)
+		infos = append(infos, &KeyInfo{
+			KeyID:      kid,
+			Type:       keyType,
+			Algorithms: algs,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].KeyID < infos[j].KeyID
+	})
+	return infos
+}
+
+// describeKey returns the type of the key and the signing algorithms
+// verified with it.
+func describeKey(k interface{}) (string, []string) {
+	switch key := k.(type) {
+	case *rsa.PrivateKey:
+		return describeKey(&key.PublicKey)
+	case *ecdsa.PrivateKey:
+		return describeKey(&key.PublicKey)
+	case ed25519.PrivateKey:
+		return describeKey(key.Public())
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", key.N.BitLen()), []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	case *ecdsa.PublicKey:
+		name := key.Curve.Params().Name
+		switch name {
+		case "P-256":
+			return "EC-" + name, []string{"ES256"}
+		case "P-384":
+			return "EC-" + name, []string{"ES384"}
+		case "P-521":
+			return "EC-" + name, []string{"ES512"}
+		}
+		return "EC-" + name, nil
+	case ed25519.PublicKey:
+		return "Ed25519", []string{"EdDSA"}
+	}
+	return fmt.Sprintf("%T", k), nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwttoken "github.com/greenpau/caddy-auth-jwt/pkg/token"
+)
+
+const introspectionTimeout = 10 * time.Second
+
+// TokenIntrospector is the token backend returning the claims of opaque
+// tokens, i.e. the reference tokens that are not JWTs, e.g. with OAuth 2.0
+// Token Introspection per RFC 7662, instead of providing the keys.
+type TokenIntrospector interface {
+	TokenBackend
+	IntrospectToken(s string) (map[string]interface{}, error)
+}
+
+// IntrospectionOptions are the options of IntrospectionBackend.
+type IntrospectionOptions struct {
+	// The introspection endpoint of the authorization server.
+	Endpoint string
+	// The client credentials authenticating the requests with HTTP Basic
+	// authentication.
+	ClientID     string
+	ClientSecret string
+	// The duration the results are cached for. The active tokens are not
+	// cached past their expiration time. Zero or negative value disables
+	// the cache.
+	CacheTTL time.Duration
+	// The cache of the results, shared with other backends. When nil, the
+	// backend has its own cache.
+	Cache      *DecisionCache
+	HTTPClient *http.Client
+}
+
+// IntrospectionBackend returns the claims of opaque tokens from the
+// introspection endpoint of the authorization server. The results are cached
+// by the digest of the tokens.
+type IntrospectionBackend struct {
+	opts   IntrospectionOptions
+	client *http.Client
+	cache  *DecisionCache
+}
+
+// NewIntrospectionBackend returns IntrospectionBackend instance.
+func NewIntrospectionBackend(opts *IntrospectionOptions) *IntrospectionBackend {
+	b := &IntrospectionBackend{
+		opts:   *opts,
+		client: opts.HTTPClient,
+		cache:  opts.Cache,
+	}
+	if b.client == nil {
+		b.client = http.DefaultClient
+	}
+	if b.cache == nil {
+		b.cache = NewDecisionCache(0)
+	}
+	return b
+}
+
+// ProvideKey returns an error, because the backend returns the claims of
+// opaque tokens with IntrospectToken.
+func (b *IntrospectionBackend) ProvideKey(token *jwttoken.Token) (interface{}, error) {
+	return nil, errors.ErrIntrospectionOnly
+}
+
+// IntrospectToken returns the claims of the active token, or an error when
+// the token is not active.
+func (b *IntrospectionBackend) IntrospectToken(s string) (map[string]interface{}, error) {
+	if b.opts.CacheTTL > 0 {
+		if decision, exists := b.cache.Get(b.opts.Endpoint, s); exists {
+			return decision.Claims, decision.Err
+		}
+	}
+
+	claims, err := b.introspect(s)
+	if err != nil && err != errors.ErrIntrospectionInactive {
+		// The failed requests are not cached.
+		return nil, err
+	}
+	if b.opts.CacheTTL > 0 {
+		var expiresAt time.Time
+		if exp, ok := claims["exp"].(float64); ok {
+			expiresAt = time.Unix(int64(exp), 0)
+		}
+		b.cache.Add(b.opts.Endpoint, s, &Decision{Claims: claims, Err: err}, b.opts.CacheTTL, expiresAt)
+	}
+	return claims, err
+}
+
+func (b *IntrospectionBackend) introspect(s string) (map[string]interface{}, error) {
+	form := url.Values{}
+	form.Set("token", s)
+	form.Set("token_type_hint", "access_token")
+	ctx, cancel := context.WithTimeout(context.Background(), introspectionTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.opts.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.ErrIntrospection.WithArgs(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if b.opts.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(b.opts.ClientID), url.QueryEscape(b.opts.ClientSecret))
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, errors.ErrIntrospection.WithArgs(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.ErrIntrospection.WithArgs(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.ErrIntrospection.WithArgs(resp.Status)
+	}
+	claims := make(map[string]interface{})
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, errors.ErrIntrospection.WithArgs(err)
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, errors.ErrIntrospectionInactive
+	}
+	delete(claims, "active")
+	return claims, nil
+}
@@ -0,0 +1,402 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwttoken "github.com/greenpau/caddy-auth-jwt/pkg/token"
+	"go.uber.org/zap"
+)
+
+// JwksKeySet is a JSON Web Key Set.
+type JwksKeySet struct {
+	Keys []*JwksKey `json:"keys"`
+}
+
+// JwksKey is a JSON Web Key.
+type JwksKey struct {
+	KeyType   string   `json:"kty"`
+	KeyID     string   `json:"kid,omitempty"`
+	Use       string   `json:"use,omitempty"`
+	KeyOps    []string `json:"key_ops,omitempty"`
+	Algorithm string   `json:"alg,omitempty"`
+	// The RSA key parameters.
+	Modulus  string `json:"n,omitempty"`
+	Exponent string `json:"e,omitempty"`
+	// The EC and OKP key parameters.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+	// The X.509 certificate chain of the key, and the thumbprints of the
+	// leaf certificate.
+	X5c     []string `json:"x5c,omitempty"`
+	X5t     string   `json:"x5t,omitempty"`
+	X5tS256 string   `json:"x5t#S256,omitempty"`
+}
+
+// KeySetOptions holds the options of JSON Web Key Set parsing.
+type KeySetOptions struct {
+	// The CA certificates the x5c certificate chains of the keys are
+	// verified against. When set, the keys without a valid chain are
+	// rejected.
+	X5cRoots *x509.CertPool
+	// The logger of the skipped keys.
+	Logger *zap.Logger
+}
+
+var jwksCurves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// PublicKey returns the public key of the JSON Web Key. When the key has
+// x5c certificate chain only, the public key of the leaf certificate is
+// returned.
+func (k *JwksKey) PublicKey() (interface{}, error) {
+	if len(k.X5c) == 0 {
+		return k.parametersPublicKey()
+	}
+	cert, err := k.Certificate()
+	if err != nil {
+		return nil, err
+	}
+	switch cert.PublicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, errors.ErrJwksKeyTypeUnsupported.WithArgs(k.KeyType, k.KeyID)
+	}
+	if !k.hasParameters() {
+		return cert.PublicKey, nil
+	}
+	pk, err := k.parametersPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if !pk.(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey) {
+		return nil, errors.ErrJwksKeyMalformed.WithArgs(k.KeyID, "x5c certificate does not match key parameters")
+	}
+	return pk, nil
+}
+
+// Certificate returns the leaf certificate of x5c certificate chain.
+func (k *JwksKey) Certificate() (*x509.Certificate, error) {
+	if len(k.X5c) == 0 {
+		return nil, errors.ErrJwksKeyMalformed.WithArgs(k.KeyID, "x5c is empty")
+	}
+	return parseX5cCertificate(k.KeyID, k.X5c[0])
+}
+
+// VerifyCertificateChain verifies x5c certificate chain of the key against
+// the provided CA certificates.
+func (k *JwksKey) VerifyCertificateChain(roots *x509.CertPool) error {
+	cert, err := k.Certificate()
+	if err != nil {
+		return err
+	}
+	intermediates := x509.NewCertPool()
+	for _, v := range k.X5c[1:] {
+		c, err := parseX5cCertificate(k.KeyID, v)
+		if err != nil {
+			return err
+		}
+		intermediates.AddCert(c)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if _, err := cert.Verify(opts); err != nil {
+		return errors.ErrJwksKeyChainVerification.WithArgs(k.KeyID, err)
+	}
+	return nil
+}
+
+// thumbprints returns the SHA-1 and SHA-256 thumbprints of the leaf
+// certificate, unless provided with the key.
+func (k *JwksKey) thumbprints() []string {
+	var thumbprints []string
+	if len(k.X5c) == 0 {
+		return thumbprints
+	}
+	x5t, x5tS256 := k.X5t, k.X5tS256
+	if x5t == "" || x5tS256 == "" {
+		cert, err := k.Certificate()
+		if err != nil {
+			return thumbprints
+		}
+		if x5t == "" {
+			sum := sha1.Sum(cert.Raw)
+			x5t = base64.RawURLEncoding.EncodeToString(sum[:])
+		}
+		if x5tS256 == "" {
+			sum := sha256.Sum256(cert.Raw)
+			x5tS256 = base64.RawURLEncoding.EncodeToString(sum[:])
+		}
+	}
+	return append(thumbprints, x5t, x5tS256)
+}
+
+// Thumbprint returns JWK thumbprint of the key per RFC 7638, i.e. the
+// base64url-encoded SHA-256 digest of the required members of the key.
+func (k *JwksKey) Thumbprint() (string, error) {
+	var members map[string]string
+	switch k.KeyType {
+	case "RSA":
+		members = map[string]string{"e": k.Exponent, "kty": k.KeyType, "n": k.Modulus}
+	case "EC":
+		members = map[string]string{"crv": k.Curve, "kty": k.KeyType, "x": k.X, "y": k.Y}
+	case "OKP":
+		members = map[string]string{"crv": k.Curve, "kty": k.KeyType, "x": k.X}
+	default:
+		return "", errors.ErrJwksKeyTypeUnsupported.WithArgs(k.KeyType, k.KeyID)
+	}
+	// The members are encoded in lexicographic order, without whitespace.
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func parseX5cCertificate(kid, v string) (*x509.Certificate, error) {
+	// Unlike other members, x5c is base64-encoded, not base64url-encoded.
+	der, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, errors.ErrJwksKeyMalformed.WithArgs(kid, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.ErrJwksKeyMalformed.WithArgs(kid, err)
+	}
+	return cert, nil
+}
+
+// hasParameters returns true when the key has its public key parameters,
+// e.g. n and e of RSA key.
+func (k *JwksKey) hasParameters() bool {
+	switch k.KeyType {
+	case "RSA":
+		return k.Modulus != "" || k.Exponent != ""
+	case "EC", "OKP":
+		return k.X != "" || k.Y != ""
+	}
+	return false
+}
+
+func (k *JwksKey) parametersPublicKey() (interface{}, error) {
+	switch k.KeyType {
+	case "RSA":
+		if k.Modulus == "" || k.Exponent == "" {
+			return nil, errors.ErrJwksKeyMalformed.WithArgs(k.KeyID, "n and e are required")
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.Modulus)
+		if err != nil {
+			return nil, errors.ErrJwksKeyMalformed.WithArgs(k.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.Exponent)
+		if err != nil {
+			return nil, errors.ErrJwksKeyMalformed.WithArgs(k.KeyID, err)
+		}
+		pk := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		return pk, nil
+	case "EC":
+		curve, exists := jwksCurves[k.Curve]
+		if !exists {
+			return nil, errors.ErrJwksKeyCurveUnsupported.WithArgs(k.Curve, k.KeyID)
+		}
+		if k.X == "" || k.Y == "" {
+			return nil, errors.ErrJwksKeyMalformed.WithArgs(k.KeyID, "x and y are required")
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, errors.ErrJwksKeyMalformed.WithArgs(k.KeyID, err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, errors.ErrJwksKeyMalformed.WithArgs(k.KeyID, err)
+		}
+		pk := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(pk.X, pk.Y) {
+			return nil, errors.ErrJwksKeyMalformed.WithArgs(k.KeyID, "point is not on curve")
+		}
+		return pk, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, errors.ErrJwksKeyCurveUnsupported.WithArgs(k.Curve, k.KeyID)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, errors.ErrJwksKeyMalformed.WithArgs(k.KeyID, err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.ErrJwksKeyMalformed.WithArgs(k.KeyID, "invalid ed25519 public key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.ErrJwksKeyTypeUnsupported.WithArgs(k.KeyType, k.KeyID)
+}
+
+// skipReason returns the reason the key is not used for token
+// verification, or an empty string when the key is used.
+func (k *JwksKey) skipReason() string {
+	if !k.isSupported() {
+		return "unsupported key type or curve"
+	}
+	if k.Use == "enc" {
+		return "key is for encryption"
+	}
+	if len(k.KeyOps) > 0 {
+		for _, op := range k.KeyOps {
+			if op == "verify" {
+				return ""
+			}
+		}
+		return "key operations do not include verify"
+	}
+	return ""
+}
+
+// isSupported returns true when the type and the curve of the key are
+// supported for token verification.
+func (k *JwksKey) isSupported() bool {
+	switch k.KeyType {
+	case "RSA":
+		return true
+	case "EC":
+		_, exists := jwksCurves[k.Curve]
+		return exists || (k.Curve == "" && len(k.X5c) > 0)
+	case "OKP":
+		return k.Curve == "Ed25519" || (k.Curve == "" && len(k.X5c) > 0)
+	}
+	return false
+}
+
+// ParseKeySet parses JSON Web Key Set and returns the map of public keys
+// keyed by kid. The keys without kid are added with the default key id.
+// The keys with x5c certificate chain are also added under the thumbprints
+// of their certificate. The keys of unsupported types are skipped.
+func ParseKeySet(b []byte, opts *KeySetOptions) (map[string]interface{}, error) {
+	if opts == nil {
+		opts = &KeySetOptions{}
+	}
+	keySet := &JwksKeySet{}
+	if err := json.Unmarshal(b, keySet); err != nil {
+		return nil, errors.ErrJwksKeySetMalformed.WithArgs(err)
+	}
+	keys := make(map[string]interface{})
+	for _, k := range keySet.Keys {
+		if k == nil {
+			continue
+		}
+		if reason := k.skipReason(); reason != "" {
+			if opts.Logger != nil {
+				opts.Logger.Info(
+					"skipped jwks key",
+					zap.String("kid", k.KeyID),
+					zap.String("kty", k.KeyType),
+					zap.String("use", k.Use),
+					zap.Strings("key_ops", k.KeyOps),
+					zap.String("reason", reason),
+				)
+			}
+			continue
+		}
+		if opts.X5cRoots != nil {
+			if len(k.X5c) == 0 {
+				return nil, errors.ErrJwksKeyChainVerification.WithArgs(k.KeyID, "x5c is required")
+			}
+			if err := k.VerifyCertificateChain(opts.X5cRoots); err != nil {
+				return nil, err
+			}
+		}
+		pk, err := k.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		kid := k.KeyID
+		if kid == "" {
+			kid = defaultKeyID
+		}
+		keys[kid] = pk
+		for _, thumbprint := range k.thumbprints() {
+			if _, exists := keys[thumbprint]; !exists {
+				keys[thumbprint] = pk
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.ErrJwksKeySetEmpty
+	}
+	return keys, nil
+}
+
+// ParseKeySetFile parses JSON Web Key Set stored in the provided file.
+func ParseKeySetFile(fp string, opts *KeySetOptions) (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, errors.ErrJwksFileRead.WithArgs(fp, err)
+	}
+	return ParseKeySet(b, opts)
+}
+
+// ParseInlineKeySet parses JSON Web Key Set embedded in configuration. The
+// key set is either JSON document or base64-encoded JSON document.
+func ParseInlineKeySet(v string, opts *KeySetOptions) (map[string]interface{}, error) {
+	v = strings.TrimSpace(v)
+	if strings.HasPrefix(v, "{") {
+		return ParseKeySet([]byte(v), opts)
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(v); err == nil {
+			return ParseKeySet(b, opts)
+		}
+	}
+	return nil, errors.ErrJwksInlineMalformed
+}
+
+// GetKeyID returns the id of the key the token was signed with. The id is
+// either kid, or the thumbprint of the certificate of the key.
+func GetKeyID(token *jwttoken.Token) (string, bool) {
+	for _, k := range []string{"kid", "x5t#S256", "x5t"} {
+		if v, ok := token.Header[k].(string); ok && v != "" {
+			return v, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,522 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwttoken "github.com/greenpau/caddy-auth-jwt/pkg/token"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// FetchKeysURL fetches JSON Web Key Set from the provided URL.
+func FetchKeysURL(uri string) (map[string]interface{}, error) {
+	resp, err := fetchKeysURL(context.Background(), defaultHTTPClient, uri, "")
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeySet(resp.body, nil)
+}
+
+// jwksResponse is the response to JSON Web Key Set request.
+type jwksResponse struct {
+	body        []byte
+	etag        string
+	maxAge      time.Duration
+	notModified bool
+}
+
+// fetchKeysURL fetches JSON Web Key Set from the provided URL. When etag is
+// not empty, the request is conditional and the response may indicate that
+// the key set has not been modified.
+func fetchKeysURL(ctx context.Context, client *http.Client, uri, etag string) (*jwksResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, errors.ErrJwksFetch.WithArgs(uri, err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.ErrJwksFetch.WithArgs(uri, err)
+	}
+	defer resp.Body.Close()
+	r := &jwksResponse{
+		etag:   resp.Header.Get("ETag"),
+		maxAge: parseMaxAge(resp.Header.Get("Cache-Control")),
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if etag != "" {
+			r.notModified = true
+			if r.etag == "" {
+				r.etag = etag
+			}
+			return r, nil
+		}
+		return nil, errors.ErrJwksFetch.WithArgs(uri, resp.Status)
+	default:
+		return nil, errors.ErrJwksFetch.WithArgs(uri, resp.Status)
+	}
+	r.body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.ErrJwksFetch.WithArgs(uri, err)
+	}
+	return r, nil
+}
+
+// parseMaxAge returns the max-age directive of Cache-Control header. It
+// returns zero when the response must not be cached.
+func parseMaxAge(header string) time.Duration {
+	var maxAge time.Duration
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache", directive == "no-store":
+			return 0
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || seconds < 0 {
+				continue
+			}
+			maxAge = time.Duration(seconds) * time.Second
+		}
+	}
+	return maxAge
+}
+
+// JwksOptions holds the options of JwksURIBackend.
+type JwksOptions struct {
+	// The interval between periodic key refreshes. Zero or negative
+	// value disables the refreshes.
+	RefreshInterval time.Duration
+	// The minimum interval between key refreshes triggered by a token
+	// with unknown kid.
+	MinRefreshInterval time.Duration
+	// The client used for fetching the keys. Defaults to the client with
+	// the timeout of 30 seconds.
+	HTTPClient *http.Client
+	// The timeout of a single fetch attempt. Zero value disables the timeout.
+	FetchTimeout time.Duration
+	// The number of retries of failed initial and periodic fetches. The
+	// delay between the retries starts at RetryBackoff and doubles after
+	// each retry, up to maxRetryBackoff.
+	FetchRetries int
+	RetryBackoff time.Duration
+	// When enabled, the backend is created even when the initial fetch
+	// fails. The backend keeps serving with the last successfully fetched
+	// keys, if any, and logs the fetch failures.
+	TolerateFetchErrors bool
+	// The timeout of the initial fetch, including the retries. Zero value
+	// disables the timeout.
+	WarmUpTimeout time.Duration
+	// When enabled, the keys are not fetched when the backend is created,
+	// but by the first token or the first periodic refresh.
+	LazyLoad bool
+	// The options of the key set parsing.
+	KeySetOptions *KeySetOptions
+	Logger        *zap.Logger
+}
+
+const maxRetryBackoff = 5 * time.Minute
+
+// JwksURIBackend holds public keys fetched from JSON Web Key Set URL.
+// The keys are refreshed periodically and when a token arrives with
+// a kid not found in the key set.
+type JwksURIBackend struct {
+	uri                string
+	issuer             string
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	client             *http.Client
+	fetchTimeout       time.Duration
+	fetchRetries       int
+	retryBackoff       time.Duration
+	tolerateErrors     bool
+	warmUpTimeout      time.Duration
+	lazyLoad           bool
+	keySetOptions      *KeySetOptions
+	logger             *zap.Logger
+
+	mu          sync.RWMutex
+	secrets     map[string]interface{}
+	lastRefresh time.Time
+	// The error of the last refresh, or nil when it succeeded.
+	lastErr error
+	// The key set is considered fresh until expires, per Cache-Control
+	// max-age of the last response.
+	expires time.Time
+
+	// The validators of the last fetched key set, used by refresh only.
+	etag     string
+	etagURI  string
+	bodyHash [sha256.Size]byte
+
+	// refreshGroup ensures there is only one key fetch in flight. The
+	// concurrent refreshes wait for the result of the fetch in flight.
+	refreshGroup singleflight.Group
+	done         chan struct{}
+	stopOnce     sync.Once
+}
+
+const refreshGroupKey = "refresh"
+
+// NewJwksURIBackend returns JwksURIBackend instance. It fetches the keys
+// and, when the refresh interval is set, starts periodic key refresh.
+func NewJwksURIBackend(uri string, opts *JwksOptions) (*JwksURIBackend, error) {
+	b := newJwksURIBackend(opts)
+	b.uri = uri
+	return b, b.start()
+}
+
+// NewOIDCIssuerBackend returns JwksURIBackend instance for the keys of
+// OpenID Connect issuer. The jwks_uri is discovered via the discovery
+// document of the issuer and rediscovered on every key refresh.
+func NewOIDCIssuerBackend(issuer string, opts *JwksOptions) (*JwksURIBackend, error) {
+	b := newJwksURIBackend(opts)
+	b.issuer = issuer
+	return b, b.start()
+}
+
+func newJwksURIBackend(opts *JwksOptions) *JwksURIBackend {
+	if opts == nil {
+		opts = &JwksOptions{}
+	}
+	b := &JwksURIBackend{
+		refreshInterval:    opts.RefreshInterval,
+		minRefreshInterval: opts.MinRefreshInterval,
+		client:             opts.HTTPClient,
+		fetchTimeout:       opts.FetchTimeout,
+		fetchRetries:       opts.FetchRetries,
+		retryBackoff:       opts.RetryBackoff,
+		tolerateErrors:     opts.TolerateFetchErrors,
+		warmUpTimeout:      opts.WarmUpTimeout,
+		lazyLoad:           opts.LazyLoad,
+		keySetOptions:      opts.KeySetOptions,
+		logger:             opts.Logger,
+		done:               make(chan struct{}),
+	}
+	if b.client == nil {
+		b.client = defaultHTTPClient
+	}
+	if b.logger == nil {
+		b.logger = zap.NewNop()
+	}
+	return b
+}
+
+func (b *JwksURIBackend) start() error {
+	if b.lazyLoad {
+		if b.refreshInterval > 0 {
+			go b.manageRefresh()
+		}
+		return nil
+	}
+	ctx := context.Background()
+	if b.warmUpTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.warmUpTimeout)
+		defer cancel()
+	}
+	if err := b.refreshWithRetry(ctx); err != nil {
+		if !b.tolerateErrors {
+			return err
+		}
+		b.logger.Warn(
+			"failed fetching jwks keys, starting without keys",
+			zap.String("jwks_uri", b.getURI()),
+			zap.String("issuer", b.issuer),
+			zap.String("error", err.Error()),
+		)
+	}
+	if b.refreshInterval > 0 {
+		go b.manageRefresh()
+	}
+	return nil
+}
+
+// refreshWithRetry refreshes the keys and retries failed refreshes with
+// exponential backoff until the context is done. It returns the error of
+// the last attempt.
+func (b *JwksURIBackend) refreshWithRetry(ctx context.Context) error {
+	backoff := b.retryBackoff
+	err := b.refreshContext(ctx)
+	for i := 0; err != nil && i < b.fetchRetries; i++ {
+		b.logger.Debug(
+			"retrying jwks keys fetch",
+			zap.String("jwks_uri", b.getURI()),
+			zap.String("issuer", b.issuer),
+			zap.Int("attempt", i+1),
+			zap.Duration("backoff", backoff),
+			zap.String("error", err.Error()),
+		)
+		select {
+		case <-b.done:
+			return err
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+		err = b.refreshContext(ctx)
+	}
+	return err
+}
+
+// Refresh fetches the keys and replaces the key set. When the backend
+// is configured with the issuer, the jwks_uri is discovered first.
+func (b *JwksURIBackend) Refresh() error {
+	return b.refreshContext(context.Background())
+}
+
+func (b *JwksURIBackend) refreshContext(ctx context.Context) error {
+	_, err, _ := b.refreshGroup.Do(refreshGroupKey, func() (interface{}, error) {
+		return nil, b.refresh(ctx)
+	})
+	return err
+}
+
+func (b *JwksURIBackend) refresh(ctx context.Context) error {
+	uri, keys, expires, err := b.fetchKeys(ctx)
+	b.mu.Lock()
+	b.lastRefresh = time.Now()
+	b.lastErr = err
+	if err == nil {
+		b.uri = uri
+		b.expires = expires
+		if keys != nil {
+			b.secrets = keys
+		}
+	}
+	b.mu.Unlock()
+	return err
+}
+
+// fetchKeys fetches the keys. It returns nil keys when the key set has not
+// changed since the last fetch.
+func (b *JwksURIBackend) fetchKeys(ctx context.Context) (string, map[string]interface{}, time.Time, error) {
+	var expires time.Time
+	if b.fetchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.fetchTimeout)
+		defer cancel()
+	}
+	uri := b.getURI()
+	if b.issuer != "" {
+		var err error
+		uri, err = discoverJwksURI(ctx, b.client, b.issuer)
+		if err != nil {
+			return "", nil, expires, err
+		}
+	}
+	etag := ""
+	if uri == b.etagURI {
+		etag = b.etag
+	}
+	resp, err := fetchKeysURL(ctx, b.client, uri, etag)
+	if err != nil {
+		return "", nil, expires, err
+	}
+	if resp.maxAge > 0 {
+		expires = time.Now().Add(resp.maxAge)
+	}
+	if resp.notModified {
+		b.etag = resp.etag
+		return uri, nil, expires, nil
+	}
+	bodyHash := sha256.Sum256(resp.body)
+	if bodyHash == b.bodyHash && uri == b.getURI() {
+		b.etag = resp.etag
+		return uri, nil, expires, nil
+	}
+	keys, err := ParseKeySet(resp.body, b.keySetOptions)
+	if err != nil {
+		return "", nil, expires, err
+	}
+	b.etag = resp.etag
+	b.etagURI = uri
+	b.bodyHash = bodyHash
+	return uri, keys, expires, nil
+}
+
+// isFresh returns true when the key set has not expired per Cache-Control
+// max-age of the last response.
+func (b *JwksURIBackend) isFresh() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return time.Now().Before(b.expires)
+}
+
+func (b *JwksURIBackend) getURI() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.uri
+}
+
+func (b *JwksURIBackend) manageRefresh() {
+	intervals := time.NewTicker(b.refreshInterval)
+	defer intervals.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-intervals.C:
+			if b.isFresh() {
+				continue
+			}
+			if err := b.refreshWithRetry(context.Background()); err != nil {
+				b.logger.Warn(
+					"failed refreshing jwks keys, using previously fetched keys",
+					zap.String("jwks_uri", b.getURI()),
+					zap.String("issuer", b.issuer),
+					zap.String("error", err.Error()),
+				)
+			}
+		}
+	}
+}
+
+// refreshOnMiss refreshes the keys when a token has unknown kid, unless
+// the keys were refreshed recently. The requests arriving with the unknown
+// kid at the same time share a single fetch.
+func (b *JwksURIBackend) refreshOnMiss(kid string) {
+	if b.refreshedRecently() {
+		return
+	}
+	_, err, _ := b.refreshGroup.Do(refreshGroupKey, func() (interface{}, error) {
+		// The fetch in flight may have completed after the checks above.
+		if _, found := b.getKey(kid); found || b.refreshedRecently() {
+			return nil, nil
+		}
+		return nil, b.refresh(context.Background())
+	})
+	if err != nil {
+		b.logger.Warn(
+			"failed refreshing jwks keys for unknown kid",
+			zap.String("jwks_uri", b.getURI()),
+			zap.String("issuer", b.issuer),
+			zap.String("kid", kid),
+			zap.String("error", err.Error()),
+		)
+	}
+}
+
+func (b *JwksURIBackend) refreshedRecently() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return time.Since(b.lastRefresh) < b.minRefreshInterval
+}
+
+func (b *JwksURIBackend) getKey(kid string) (interface{}, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	k, ok := b.secrets[kid]
+	return k, ok
+}
+
+// CheckKeysAvailable returns an error when the backend has no keys, because
+// the last fetch of the keys failed.
+func (b *JwksURIBackend) CheckKeysAvailable() error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.secrets) == 0 && b.lastErr != nil {
+		// The jwks_uri of the issuer is unknown until discovered.
+		source := b.uri
+		if source == "" {
+			source = b.issuer
+		}
+		return errors.ErrJwksUnavailable.WithArgs(source, b.lastErr)
+	}
+	return nil
+}
+
+// Stop stops periodic key refresh.
+func (b *JwksURIBackend) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.done)
+	})
+}
+
+// ProvideKey provides key material from JwksURIBackend. The key type
+// must match the signing method of the token.
+func (b *JwksURIBackend) ProvideKey(token *jwttoken.Token) (interface{}, error) {
+	var errNoKey error
+	switch token.Method {
+	case jwttoken.RSA, jwttoken.RSAPSS:
+		errNoKey = errors.ErrNoRSAKeyFound
+	case jwttoken.ECDSA:
+		errNoKey = errors.ErrNoECDSAKeyFound
+	case jwttoken.EdDSA:
+		errNoKey = errors.ErrNoEdDSAKeyFound
+	default:
+		return nil, errors.ErrUnexpectedSigningMethod.WithArgs("RS, PS, ES, or EdDSA", token.Header["alg"])
+	}
+
+	kid, ok := GetKeyID(token)
+	if !ok {
+		kid = defaultKeyID
+	}
+
+	k, found := b.getKey(kid)
+	if !found {
+		b.refreshOnMiss(kid)
+		k, found = b.getKey(kid)
+	}
+	if !found {
+		if kid == defaultKeyID {
+			return nil, errNoKey
+		}
+		return nil, errors.ErrUnexpectedKID
+	}
+
+	if !keyMatchesMethod(k, token.Method) {
+		return nil, errNoKey
+	}
+	return k, nil
+}
+
+// keyMatchesMethod returns true when the public key is of the type used
+// by the signing method.
+func keyMatchesMethod(k interface{}, method jwttoken.SigningMethod) bool {
+	switch method {
+	case jwttoken.RSA, jwttoken.RSAPSS:
+		_, ok := k.(*rsa.PublicKey)
+		return ok
+	case jwttoken.ECDSA:
+		_, ok := k.(*ecdsa.PublicKey)
+		return ok
+	case jwttoken.EdDSA:
+		_, ok := k.(ed25519.PublicKey)
+		return ok
+	}
+	return false
+}
@@ -0,0 +1,249 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwttoken "github.com/greenpau/caddy-auth-jwt/pkg/token"
+)
+
+// The defaults of the in-cluster service account configuration.
+const (
+	KubernetesDefaultAPIServer = "https://kubernetes.default.svc"
+	KubernetesDefaultCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	KubernetesDefaultTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	kubernetesJwksPath        = "/openid/v1/jwks"
+	kubernetesTokenReviewPath = "/apis/authentication.k8s.io/v1/tokenreviews"
+	kubernetesReviewTimeout   = 10 * time.Second
+)
+
+// KubernetesOptions are the options of the clients of Kubernetes API server.
+type KubernetesOptions struct {
+	// The URL of the API server. Defaults to KubernetesDefaultAPIServer.
+	APIServer string
+	// The CA certificates of the API server. Defaults to KubernetesDefaultCAFile,
+	// when the file exists, and to the system CA certificates otherwise.
+	CAFile string
+	// The token authenticating the requests. The token is read on every
+	// request, because the projected tokens are rotated. Defaults to
+	// KubernetesDefaultTokenFile.
+	TokenFile string
+	// The audiences of the reviewed tokens. When empty, the API server
+	// accepts the tokens with its own audience.
+	Audiences []string
+	// The duration the results of the token reviews are cached for. The
+	// tokens are not cached past their expiration time. Zero or negative
+	// value disables the cache.
+	ReviewCacheTTL time.Duration
+	// The cache of the results, shared with other backends. When nil, the
+	// backend has its own cache.
+	Cache *DecisionCache
+}
+
+// NewKubernetesHTTPClient returns HTTP client authenticated to Kubernetes API
+// server with the service account token.
+func NewKubernetesHTTPClient(opts *KubernetesOptions) (*http.Client, error) {
+	caFile := opts.CAFile
+	if caFile == "" {
+		if _, err := os.Stat(KubernetesDefaultCAFile); err == nil {
+			caFile = KubernetesDefaultCAFile
+		}
+	}
+	client, err := NewHTTPClient(&TLSOptions{CAFile: caFile})
+	if err != nil {
+		return nil, err
+	}
+	tokenFile := opts.TokenFile
+	if tokenFile == "" {
+		tokenFile = KubernetesDefaultTokenFile
+	}
+	client.Transport = &bearerTokenTransport{
+		tokenFile: tokenFile,
+		transport: client.Transport,
+	}
+	return client, nil
+}
+
+// GetKubernetesAPIServer returns the URL of the API server without trailing
+// slash, or the default one.
+func GetKubernetesAPIServer(opts *KubernetesOptions) string {
+	if opts.APIServer == "" {
+		return KubernetesDefaultAPIServer
+	}
+	return strings.TrimSuffix(opts.APIServer, "/")
+}
+
+// GetKubernetesJwksURI returns the location of the keys of the service
+// account issuer of the API server.
+func GetKubernetesJwksURI(opts *KubernetesOptions) string {
+	return GetKubernetesAPIServer(opts) + kubernetesJwksPath
+}
+
+// bearerTokenTransport adds the token in the file to the requests. The
+// requests are sent without the token when the file cannot be read, e.g.
+// outside of the cluster with the anonymous access to the keys.
+type bearerTokenTransport struct {
+	tokenFile string
+	transport http.RoundTripper
+}
+
+func (t *bearerTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b, err := ioutil.ReadFile(t.tokenFile)
+	if err == nil && len(bytes.TrimSpace(b)) > 0 {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+string(bytes.TrimSpace(b)))
+	}
+	return t.transport.RoundTrip(req)
+}
+
+// TokenVerifier is the token backend verifying the tokens remotely instead
+// of providing the keys, e.g. with Kubernetes TokenReview API. The verified
+// tokens are parsed without verifying their signature.
+type TokenVerifier interface {
+	TokenBackend
+	VerifyToken(s string) error
+}
+
+// KubernetesTokenReviewBackend verifies service account tokens with
+// Kubernetes TokenReview API.
+type KubernetesTokenReviewBackend struct {
+	uri       string
+	audiences []string
+	client    *http.Client
+	cacheTTL  time.Duration
+	cache     *DecisionCache
+	scope     string
+}
+
+type kubernetesTokenReview struct {
+	APIVersion string                       `json:"apiVersion"`
+	Kind       string                       `json:"kind"`
+	Spec       kubernetesTokenReviewSpec    `json:"spec"`
+	Status     *kubernetesTokenReviewStatus `json:"status,omitempty"`
+}
+
+type kubernetesTokenReviewSpec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+type kubernetesTokenReviewStatus struct {
+	Authenticated bool   `json:"authenticated"`
+	Error         string `json:"error,omitempty"`
+}
+
+// NewKubernetesTokenReviewBackend returns KubernetesTokenReviewBackend
+// instance using the client of the API server.
+func NewKubernetesTokenReviewBackend(opts *KubernetesOptions, client *http.Client) *KubernetesTokenReviewBackend {
+	b := &KubernetesTokenReviewBackend{
+		uri:       GetKubernetesAPIServer(opts) + kubernetesTokenReviewPath,
+		audiences: opts.Audiences,
+		client:    client,
+		cacheTTL:  opts.ReviewCacheTTL,
+		cache:     opts.Cache,
+	}
+	// The results depend on the audiences of the review.
+	b.scope = b.uri + " " + strings.Join(b.audiences, ",")
+	if b.cache == nil && b.cacheTTL > 0 {
+		b.cache = NewDecisionCache(0)
+	}
+	return b
+}
+
+// ProvideKey returns an error, because the backend verifies the tokens with
+// VerifyToken.
+func (b *KubernetesTokenReviewBackend) ProvideKey(token *jwttoken.Token) (interface{}, error) {
+	return nil, errors.ErrKubernetesTokenReviewOnly
+}
+
+// VerifyToken sends the token to TokenReview API and returns an error, unless
+// the API server authenticated the token. The results of the reviews are
+// cached, except the failed requests.
+func (b *KubernetesTokenReviewBackend) VerifyToken(s string) error {
+	if b.cacheTTL <= 0 {
+		return b.review(s)
+	}
+	if decision, exists := b.cache.Get(b.scope, s); exists {
+		return decision.Err
+	}
+	err := b.review(s)
+	if err != nil && !stderrors.Is(err, errors.ErrKubernetesTokenRejected) {
+		return err
+	}
+	var expiresAt time.Time
+	if token, parseErr := jwttoken.NewParser(nil).ParseUnverified(s); parseErr == nil {
+		if exp, ok := token.Claims["exp"].(float64); ok {
+			expiresAt = time.Unix(int64(exp), 0)
+		}
+	}
+	b.cache.Add(b.scope, s, &Decision{Err: err}, b.cacheTTL, expiresAt)
+	return err
+}
+
+func (b *KubernetesTokenReviewBackend) review(s string) error {
+	body, err := json.Marshal(&kubernetesTokenReview{
+		APIVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenReview",
+		Spec: kubernetesTokenReviewSpec{
+			Token:     s,
+			Audiences: b.audiences,
+		},
+	})
+	if err != nil {
+		return errors.ErrKubernetesTokenReview.WithArgs(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kubernetesReviewTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.uri, bytes.NewReader(body))
+	if err != nil {
+		return errors.ErrKubernetesTokenReview.WithArgs(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return errors.ErrKubernetesTokenReview.WithArgs(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.ErrKubernetesTokenReview.WithArgs(err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return errors.ErrKubernetesTokenReview.WithArgs(resp.Status)
+	}
+	review := &kubernetesTokenReview{}
+	if err := json.Unmarshal(respBody, review); err != nil {
+		return errors.ErrKubernetesTokenReview.WithArgs(err)
+	}
+	if review.Status == nil || !review.Status.Authenticated {
+		reason := "token is not authenticated"
+		if review.Status != nil && review.Status.Error != "" {
+			reason = review.Status.Error
+		}
+		return errors.ErrKubernetesTokenRejected.WithArgs(reason)
+	}
+	return nil
+}
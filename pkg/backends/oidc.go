@@ -0,0 +1,72 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+const oidcDiscoveryPath = "/.well-known/openid-configuration"
+
+// OIDCDiscoveryDocument is OpenID Connect discovery document. Only the fields
+// necessary for token verification are included.
+type OIDCDiscoveryDocument struct {
+	Issuer  string `json:"issuer"`
+	JwksURI string `json:"jwks_uri"`
+}
+
+// DiscoverJwksURI fetches OpenID Connect discovery document of the issuer
+// and returns the jwks_uri of the issuer.
+func DiscoverJwksURI(issuer string) (string, error) {
+	return discoverJwksURI(context.Background(), defaultHTTPClient, issuer)
+}
+
+func discoverJwksURI(ctx context.Context, client *http.Client, issuer string) (string, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	uri := issuer + oidcDiscoveryPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return "", errors.ErrOIDCDiscoveryFetch.WithArgs(uri, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.ErrOIDCDiscoveryFetch.WithArgs(uri, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.ErrOIDCDiscoveryFetch.WithArgs(uri, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.ErrOIDCDiscoveryFetch.WithArgs(uri, err)
+	}
+	doc := &OIDCDiscoveryDocument{}
+	if err := json.Unmarshal(b, doc); err != nil {
+		return "", errors.ErrOIDCDiscoveryMalformed.WithArgs(uri, err)
+	}
+	if doc.Issuer != "" && strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return "", errors.ErrOIDCIssuerMismatch.WithArgs(doc.Issuer, issuer)
+	}
+	if doc.JwksURI == "" {
+		return "", errors.ErrOIDCDiscoveryNoJwksURI.WithArgs(uri)
+	}
+	return doc.JwksURI, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+const tokenRefreshTimeout = 10 * time.Second
+
+// TokenRefreshOptions are the options of TokenRefresher.
+type TokenRefreshOptions struct {
+	// The token endpoint of the authorization server.
+	Endpoint string
+	// The client credentials authenticating the requests with HTTP Basic
+	// authentication.
+	ClientID     string
+	ClientSecret string
+	// The scopes of the new access tokens.
+	Scope      []string
+	HTTPClient *http.Client
+}
+
+// RefreshedTokens are the tokens issued in exchange for the refresh token.
+// The refresh token is empty, unless the authorization server rotates the
+// refresh tokens.
+type RefreshedTokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	// The lifetime of the refresh token, in seconds, e.g. returned by
+	// Keycloak.
+	RefreshExpiresIn int64 `json:"refresh_expires_in"`
+}
+
+// TokenRefresher obtains the new access tokens with the refresh tokens, per
+// OAuth 2.0 refresh token grant, RFC 6749.
+type TokenRefresher struct {
+	opts   TokenRefreshOptions
+	client *http.Client
+}
+
+// NewTokenRefresher returns TokenRefresher instance.
+func NewTokenRefresher(opts *TokenRefreshOptions) *TokenRefresher {
+	r := &TokenRefresher{
+		opts:   *opts,
+		client: opts.HTTPClient,
+	}
+	if r.client == nil {
+		r.client = http.DefaultClient
+	}
+	return r
+}
+
+// Refresh returns the tokens issued in exchange for the refresh token.
+func (r *TokenRefresher) Refresh(refreshToken string) (*RefreshedTokens, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+	if len(r.opts.Scope) > 0 {
+		form.Set("scope", strings.Join(r.opts.Scope, " "))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tokenRefreshTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.opts.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.ErrTokenRefresh.WithArgs(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if r.opts.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(r.opts.ClientID), url.QueryEscape(r.opts.ClientSecret))
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, errors.ErrTokenRefresh.WithArgs(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.ErrTokenRefresh.WithArgs(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.ErrTokenRefresh.WithArgs(resp.Status)
+	}
+	tokens := &RefreshedTokens{}
+	if err := json.Unmarshal(body, tokens); err != nil {
+		return nil, errors.ErrTokenRefresh.WithArgs(err)
+	}
+	if tokens.AccessToken == "" {
+		return nil, errors.ErrTokenRefreshNoToken
+	}
+	return tokens, nil
+}
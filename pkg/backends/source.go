@@ -0,0 +1,120 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"unicode"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+// KeyMaterial is the key material fetched from a key source.
+type KeyMaterial struct {
+	// The shared secret for the tokens signed with HS family of methods.
+	Secret string
+	// The public keys by key id.
+	Keys map[string]interface{}
+}
+
+// KeySource is the source of key material, e.g. a cloud secret store.
+type KeySource interface {
+	FetchKeys(ctx context.Context) (*KeyMaterial, error)
+}
+
+// ParseKeyMaterial parses the value of a secret. A PEM-encoded key or
+// certificate is added to the public keys, with the default key id and
+// the name of the secret as key ids. Any other value is a shared secret,
+// with the trailing whitespace trimmed.
+func ParseKeyMaterial(name string, value []byte) (*KeyMaterial, error) {
+	km := &KeyMaterial{}
+	s := strings.TrimRightFunc(string(value), unicode.IsSpace)
+	if !strings.Contains(s, "-----BEGIN ") {
+		if len(s) == 0 {
+			return nil, errors.ErrKeySourceEmptySecret.WithArgs(name)
+		}
+		km.Secret = s
+		return km, nil
+	}
+	pk, err := parsePEMPublicKey([]byte(s))
+	if err != nil {
+		return nil, errors.ErrKeySourceKeyMalformed.WithArgs(name, err)
+	}
+	km.AddKey(name, pk)
+	return km, nil
+}
+
+// AddKey adds the public key with the default key id and the key id.
+func (km *KeyMaterial) AddKey(kid string, pk interface{}) {
+	if km.Keys == nil {
+		km.Keys = make(map[string]interface{})
+	}
+	if _, exists := km.Keys[defaultKeyID]; !exists {
+		km.Keys[defaultKeyID] = pk
+	}
+	if kid != "" {
+		km.Keys[kid] = pk
+	}
+}
+
+// parsePEMPublicKey returns the public key of PEM-encoded public key,
+// private key, or certificate. The supported key types are RSA, ECDSA,
+// and Ed25519.
+func parsePEMPublicKey(b []byte) (interface{}, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.ErrKeySourceNotPEM
+	}
+	var pk interface{}
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		pk, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		pk, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			pk = cert.PublicKey
+		}
+	case "RSA PRIVATE KEY":
+		pk, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		pk, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		pk, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, errors.ErrKeySourcePEMTypeUnsupported.WithArgs(block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if signer, ok := pk.(crypto.Signer); ok {
+		pk = signer.Public()
+	}
+	switch pk.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return pk, nil
+	}
+	return nil, errors.ErrKeySourceKeyTypeUnsupported.WithArgs(pk)
+}
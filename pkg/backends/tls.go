@@ -0,0 +1,101 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+// TLSOptions holds TLS settings of the client fetching keys.
+type TLSOptions struct {
+	// The path to PEM-encoded CA certificates trusted in addition
+	// to the system ones.
+	CAFile string
+	// The paths to PEM-encoded client certificate and key for mutual TLS.
+	ClientCertFile string
+	ClientKeyFile  string
+	// Disables server certificate verification.
+	InsecureSkipVerify bool
+}
+
+// NewHTTPClient returns HTTP client configured with the TLS options.
+func NewHTTPClient(opts *TLSOptions) (*http.Client, error) {
+	if opts == nil {
+		return http.DefaultClient, nil
+	}
+	transport, err := newTLSTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// newTLSTransport returns the copy of the default transport configured with
+// the TLS options.
+func newTLSTransport(opts *TLSOptions) (*http.Transport, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+	if opts.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if err := appendCertsFromFile(pool, opts.CAFile); err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opts.ClientCertFile != "" || opts.ClientKeyFile != "" {
+		if opts.ClientCertFile == "" || opts.ClientKeyFile == "" {
+			return nil, errors.ErrTLSClientCertKeyPair
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, errors.ErrTLSClientCert.WithArgs(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// LoadCertPool returns the pool of PEM-encoded CA certificates stored in
+// the provided file. Unlike NewHTTPClient, the system CA certificates are
+// not included.
+func LoadCertPool(fp string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if err := appendCertsFromFile(pool, fp); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+func appendCertsFromFile(pool *x509.CertPool, fp string) error {
+	b, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return errors.ErrTLSCAFile.WithArgs(fp, err)
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return errors.ErrTLSCAFile.WithArgs(fp, "no certificates found")
+	}
+	return nil
+}
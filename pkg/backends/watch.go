@@ -0,0 +1,407 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backends
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwttoken "github.com/greenpau/caddy-auth-jwt/pkg/token"
+	"go.uber.org/zap"
+)
+
+// ReloadableTokenBackend holds the token backends of a trusted token
+// configuration. The backends are replaced when the keys are reloaded,
+// e.g. after the key files change on disk.
+type ReloadableTokenBackend struct {
+	mu       sync.RWMutex
+	backends []TokenBackend
+	lastErr  error
+	watcher  *KeyFileWatcher
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// unavailableRetryInterval is the longest interval between the refreshes
+// while the backend has no keys.
+const unavailableRetryInterval = time.Minute
+
+// NewReloadableTokenBackend returns ReloadableTokenBackend instance.
+func NewReloadableTokenBackend(backends []TokenBackend) *ReloadableTokenBackend {
+	return &ReloadableTokenBackend{
+		backends: backends,
+	}
+}
+
+// SetBackends replaces the token backends.
+func (b *ReloadableTokenBackend) SetBackends(backends []TokenBackend) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.backends = backends
+	b.lastErr = nil
+}
+
+// SetLastError records the error of the last failed reload of the backends.
+func (b *ReloadableTokenBackend) SetLastError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastErr = err
+}
+
+// CheckKeysAvailable returns an error when the backend has no keys, because
+// the last reload of the keys failed.
+func (b *ReloadableTokenBackend) CheckKeysAvailable() error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.backends) == 0 && b.lastErr != nil {
+		return errors.ErrKeySourceUnavailable.WithArgs(b.lastErr)
+	}
+	return nil
+}
+
+// GetBackends returns the current token backends.
+func (b *ReloadableTokenBackend) GetBackends() []TokenBackend {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.backends
+}
+
+// Watch starts watching the key files and directories. When their content
+// changes, the backends returned by the reload function replace the current
+// ones. If the reload fails, the current backends are kept.
+func (b *ReloadableTokenBackend) Watch(paths []string, reload func() ([]TokenBackend, error), opts *KeyWatchOptions) {
+	b.Stop()
+	b.watcher = NewKeyFileWatcher(paths, func() error {
+		backends, err := reload()
+		if err != nil {
+			return err
+		}
+		if len(backends) == 0 {
+			return errors.ErrKeyReloadEmpty
+		}
+		b.SetBackends(backends)
+		return nil
+	}, opts)
+}
+
+// Refresh starts replacing the current backends with the backends returned
+// by the reload function every interval. If the reload fails, the current
+// backends are kept. While there are no backends, the reload is retried
+// at least every minute.
+func (b *ReloadableTokenBackend) Refresh(interval time.Duration, reload func() ([]TokenBackend, error), logger *zap.Logger) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	b.stopRefresh()
+	b.stop = make(chan struct{})
+	b.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		timer := time.NewTimer(b.nextRefresh(interval))
+		defer timer.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-timer.C:
+				backends, err := reload()
+				if err == nil && len(backends) == 0 {
+					err = errors.ErrKeyReloadEmpty
+				}
+				if err != nil {
+					logger.Warn("failed refreshing keys", zap.Error(err))
+					b.SetLastError(err)
+				} else {
+					b.SetBackends(backends)
+				}
+				timer.Reset(b.nextRefresh(interval))
+			}
+		}
+	}(b.stop, b.done)
+}
+
+// nextRefresh returns the delay before the next refresh of the backends.
+func (b *ReloadableTokenBackend) nextRefresh(interval time.Duration) time.Duration {
+	if len(b.GetBackends()) == 0 && interval > unavailableRetryInterval {
+		return unavailableRetryInterval
+	}
+	return interval
+}
+
+// Stop stops watching the key files and refreshing the keys.
+func (b *ReloadableTokenBackend) Stop() {
+	if b.watcher != nil {
+		b.watcher.Stop()
+	}
+	b.stopRefresh()
+}
+
+func (b *ReloadableTokenBackend) stopRefresh() {
+	if b.stop != nil {
+		close(b.stop)
+		<-b.done
+		b.stop = nil
+	}
+}
+
+// ProvideKey provides key material from the backend supporting the signing
+// method of the token.
+func (b *ReloadableTokenBackend) ProvideKey(token *jwttoken.Token) (interface{}, error) {
+	var err error
+	for _, backend := range b.GetBackends() {
+		key, backendErr := backend.ProvideKey(token)
+		if backendErr == nil {
+			return key, nil
+		}
+		if err == nil || supportsMethod(backend, token.Method) {
+			err = backendErr
+		}
+	}
+	if err == nil {
+		err = errors.ErrUnexpectedSigningMethod.WithArgs("HS, RS, ES, or EdDSA", token.Header["alg"])
+	}
+	return nil, err
+}
+
+// supportsMethod returns true if the backend provides keys for the signing method.
+func supportsMethod(backend TokenBackend, method jwttoken.SigningMethod) bool {
+	switch backend.(type) {
+	case *SecretKeyTokenBackend:
+		return method == jwttoken.HMAC
+	case *RSAKeyTokenBackend:
+		return method == jwttoken.RSA || method == jwttoken.RSAPSS
+	case *ECDSAKeyTokenBackend:
+		return method == jwttoken.ECDSA
+	case *EdDSAKeyTokenBackend:
+		return method == jwttoken.EdDSA
+	}
+	return false
+}
+
+// KeyWatchOptions are the options of KeyFileWatcher.
+type KeyWatchOptions struct {
+	// The delay between a change and the reload. The changes made within
+	// the delay, e.g. by a rotation replacing several files, result in
+	// a single reload.
+	Debounce time.Duration
+	// The interval between the checks for changes. The checks complement
+	// filesystem notifications, which are not delivered on some filesystems.
+	// Zero disables the checks.
+	PollInterval time.Duration
+	Logger       *zap.Logger
+}
+
+// KeyFileWatcher watches key files and directories and calls the reload
+// function when their content changes.
+type KeyFileWatcher struct {
+	paths       []string
+	reload      func() error
+	opts        KeyWatchOptions
+	logger      *zap.Logger
+	fingerprint string
+	stop        chan struct{}
+	done        chan struct{}
+	stopOnce    sync.Once
+}
+
+// NewKeyFileWatcher returns KeyFileWatcher instance watching the paths in
+// background. The watcher uses filesystem notifications when available and
+// checks the paths every poll interval.
+func NewKeyFileWatcher(paths []string, reload func() error, opts *KeyWatchOptions) *KeyFileWatcher {
+	w := &KeyFileWatcher{
+		paths:  paths,
+		reload: reload,
+		logger: zap.NewNop(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if opts != nil {
+		w.opts = *opts
+		if opts.Logger != nil {
+			w.logger = opts.Logger
+		}
+	}
+	w.fingerprint = fingerprintPaths(paths)
+	notifier := w.newNotifier()
+	if notifier == nil && w.opts.PollInterval <= 0 {
+		w.logger.Warn("key file changes will not be detected", zap.Strings("paths", paths))
+	}
+	go w.run(notifier)
+	return w
+}
+
+// Stop stops watching the paths.
+func (w *KeyFileWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
+
+// newNotifier returns filesystem notifier for the paths. It watches the
+// directories, including subdirectories, and the parent directories of the
+// files, so that the files replaced by renaming are noticed. It returns nil
+// when the notifications are not available.
+func (w *KeyFileWatcher) newNotifier() *fsnotify.Watcher {
+	notifier, err := fsnotify.NewWatcher()
+	if err != nil {
+		w.logger.Warn("key file notifications are not available", zap.Error(err))
+		return nil
+	}
+	for _, path := range w.paths {
+		if err := w.addPath(notifier, path); err != nil {
+			w.logger.Warn("key file notifications are not available", zap.String("path", path), zap.Error(err))
+			notifier.Close()
+			return nil
+		}
+	}
+	return notifier
+}
+
+func (w *KeyFileWatcher) addPath(notifier *fsnotify.Watcher, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return notifier.Add(filepath.Dir(path))
+	}
+	if !info.IsDir() {
+		return notifier.Add(filepath.Dir(path))
+	}
+	return filepath.Walk(path, func(fp string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		return notifier.Add(fp)
+	})
+}
+
+// isWatchedDir returns true if the path is a directory, or a subdirectory
+// of a directory, being watched.
+func (w *KeyFileWatcher) isWatchedDir(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+	for _, p := range w.paths {
+		if strings.HasPrefix(path, filepath.Clean(p)+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *KeyFileWatcher) run(notifier *fsnotify.Watcher) {
+	defer close(w.done)
+	var events <-chan fsnotify.Event
+	var notifierErrors <-chan error
+	if notifier != nil {
+		defer notifier.Close()
+		events = notifier.Events
+		notifierErrors = notifier.Errors
+	}
+	var poll <-chan time.Time
+	if w.opts.PollInterval > 0 {
+		ticker := time.NewTicker(w.opts.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-w.stop:
+			return
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if event.Op&fsnotify.Create != 0 && w.isWatchedDir(event.Name) {
+				if err := w.addPath(notifier, event.Name); err != nil {
+					w.logger.Warn("failed watching key directory", zap.String("path", event.Name), zap.Error(err))
+				}
+			}
+			debounce = time.After(w.opts.Debounce)
+		case err, ok := <-notifierErrors:
+			if !ok {
+				notifierErrors = nil
+				continue
+			}
+			w.logger.Warn("key file notification error", zap.Error(err))
+		case <-debounce:
+			debounce = nil
+			w.check()
+		case <-poll:
+			w.check()
+		}
+	}
+}
+
+// check reloads the keys when the content of the paths changed since the
+// last successful reload.
+func (w *KeyFileWatcher) check() {
+	fingerprint := fingerprintPaths(w.paths)
+	if fingerprint == w.fingerprint {
+		return
+	}
+	if err := w.reload(); err != nil {
+		w.logger.Warn("failed reloading keys", zap.Strings("paths", w.paths), zap.Error(err))
+		return
+	}
+	w.fingerprint = fingerprint
+	w.logger.Info("reloaded keys", zap.Strings("paths", w.paths))
+}
+
+// fingerprintPaths returns the digest of the names and the content of the
+// files in the paths.
+func fingerprintPaths(paths []string) string {
+	files := make(map[string]string)
+	for _, path := range paths {
+		filepath.Walk(path, func(fp string, info os.FileInfo, err error) error {
+			if err != nil {
+				files[fp] = "error: " + err.Error()
+				return nil
+			}
+			if info.IsDir() {
+				return nil
+			}
+			b, err := ioutil.ReadFile(fp)
+			if err != nil {
+				files[fp] = "error: " + err.Error()
+				return nil
+			}
+			sum := sha256.Sum256(b)
+			files[fp] = hex.EncodeToString(sum[:])
+			return nil
+		})
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name + "\x00" + files[name] + "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
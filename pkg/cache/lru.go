@@ -0,0 +1,93 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// lru holds the values until they expire or, when the cache is full, the
+// least recently used value is evicted. The callers synchronize the access.
+type lru struct {
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+type lruEntry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+func newLRU(maxEntries int) *lru {
+	return &lru{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// get returns the value, unless it is not cached or expired.
+func (c *lru) get(key string) (interface{}, bool) {
+	elem, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+// add adds the value until the expiry, and returns the number of the values
+// evicted to make room for it.
+func (c *lru) add(key string, value interface{}, expiresAt time.Time) int {
+	if c.maxEntries <= 0 || !time.Now().Before(expiresAt) {
+		return 0
+	}
+	c.delete(key)
+	evicted := 0
+	for c.order.Len() >= c.maxEntries {
+		c.remove(c.order.Back())
+		evicted++
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	return evicted
+}
+
+func (c *lru) delete(key string) {
+	if elem, exists := c.entries[key]; exists {
+		c.remove(elem)
+	}
+}
+
+func (c *lru) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*lruEntry).key)
+}
+
+// tokenDigest returns the key of the token, so that the caches do not hold
+// the tokens themselves.
+func tokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+const defaultReplayStoreMaxEntries = 100000
+
+// ReplayStore records the ids of the presented tokens, e.g. jti claims,
+// until the tokens expire, so that the tokens are used only once. The store
+// shared by several instances makes the tokens single-use across them.
+type ReplayStore interface {
+	// Add records the id until the expiry. It returns false if the id is
+	// already recorded and did not expire.
+	Add(id string, expiresAt time.Time) (bool, error)
+}
+
+// MemoryReplayStore is ReplayStore keeping the ids in memory. The zero value
+// is ready to use.
+type MemoryReplayStore struct {
+	// The maximum number of the ids recorded at the same time. When the
+	// store is full of the ids not expired yet, no id is accepted. Zero
+	// means 100000.
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+// NewMemoryReplayStore returns MemoryReplayStore instance.
+func NewMemoryReplayStore(maxEntries int) *MemoryReplayStore {
+	return &MemoryReplayStore{
+		MaxEntries: maxEntries,
+	}
+}
+
+// Add records the id until the expiry.
+func (s *MemoryReplayStore) Add(id string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.entries == nil {
+		s.entries = make(map[string]time.Time)
+	}
+	if t, exists := s.entries[id]; exists && now.Before(t) {
+		return false, nil
+	}
+	maxEntries := s.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultReplayStoreMaxEntries
+	}
+	if len(s.entries) >= maxEntries {
+		for k, t := range s.entries {
+			if !now.Before(t) {
+				delete(s.entries, k)
+			}
+		}
+		if len(s.entries) >= maxEntries {
+			return false, errors.ErrReplayStoreFull.WithArgs(maxEntries)
+		}
+	}
+	s.entries[id] = expiresAt
+	return true, nil
+}
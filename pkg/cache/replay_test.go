@@ -0,0 +1,61 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+func TestMemoryReplayStore(t *testing.T) {
+	s := NewMemoryReplayStore(2)
+	for _, test := range []struct {
+		id        string
+		expiresAt time.Time
+		added     bool
+		err       error
+	}{
+		{id: "a", expiresAt: time.Now().Add(time.Minute), added: true},
+		{id: "a", expiresAt: time.Now().Add(time.Minute), added: false},
+		{id: "b", expiresAt: time.Now().Add(-time.Second), added: true},
+		// The expired ids are accepted again.
+		{id: "b", expiresAt: time.Now().Add(time.Minute), added: true},
+		// The store is full of unexpired ids.
+		{id: "c", expiresAt: time.Now().Add(time.Minute), err: jwterrors.ErrReplayStoreFull},
+	} {
+		added, err := s.Add(test.id, test.expiresAt)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Fatalf("id %s: unexpected error: %v, expected: %v", test.id, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("id %s: unexpected error: %v", test.id, err)
+		}
+		if added != test.added {
+			t.Fatalf("id %s: got: %t expected: %t", test.id, added, test.added)
+		}
+	}
+
+	// The zero value is ready to use.
+	var zero MemoryReplayStore
+	if added, err := zero.Add("a", time.Now().Add(time.Minute)); !added || err != nil {
+		t.Fatalf("unexpected result: %t, %v", added, err)
+	}
+}
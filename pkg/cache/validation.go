@@ -0,0 +1,159 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/claims"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var validationCacheMetrics = struct {
+	lookups   *prometheus.CounterVec
+	evictions prometheus.Counter
+}{
+	lookups: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "jwt_validation_cache",
+		Name:      "lookups_total",
+		Help:      "Counter of the lookups of validated tokens, by result, i.e. hit, invalid_hit, or miss.",
+	}, []string{"result"}),
+	evictions: promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "jwt_validation_cache",
+		Name:      "evictions_total",
+		Help:      "Counter of the validated tokens evicted to make room for other tokens.",
+	}),
+}
+
+// ValidationCache holds the claims of the validated tokens, and the errors of
+// the tokens failing the validation, so that the tokens presented repeatedly
+// are not parsed and verified every time. The tokens are keyed by their
+// SHA-256 digest, and kept until they expire or, when the cache is full, the
+// least recently used token is evicted.
+type ValidationCache struct {
+	mu    sync.Mutex
+	cache *lru
+	stats ValidationCacheStats
+}
+
+// ValidationCacheStats are the counters of ValidationCache.
+type ValidationCacheStats struct {
+	Hits        uint64
+	InvalidHits uint64
+	Misses      uint64
+	Evictions   uint64
+}
+
+type validationCacheEntry struct {
+	claims      *claims.UserClaims
+	tokenClaims map[string]interface{}
+	err         error
+}
+
+// NewValidationCache returns ValidationCache instance holding up to
+// maxEntries tokens. Zero or negative value disables the cache.
+func NewValidationCache(maxEntries int) *ValidationCache {
+	return &ValidationCache{
+		cache: newLRU(maxEntries),
+	}
+}
+
+// Get returns the copy of the claims of the validated token, and the claims
+// of the token before they were parsed into the user claims, or the error of
+// the token failing the validation. It returns nil when the token is not
+// cached or expired.
+func (c *ValidationCache) Get(token string) (*claims.UserClaims, map[string]interface{}, error) {
+	if c.cache.maxEntries <= 0 {
+		return nil, nil, nil
+	}
+	key := tokenDigest(token)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, exists := c.cache.get(key)
+	if !exists {
+		c.stats.Misses++
+		validationCacheMetrics.lookups.WithLabelValues("miss").Inc()
+		return nil, nil, nil
+	}
+	entry := value.(*validationCacheEntry)
+	if entry.err != nil {
+		c.stats.InvalidHits++
+		validationCacheMetrics.lookups.WithLabelValues("invalid_hit").Inc()
+		return nil, nil, entry.err
+	}
+	c.stats.Hits++
+	validationCacheMetrics.lookups.WithLabelValues("hit").Inc()
+	// The claims are copied, so that the requests presenting the same token
+	// do not share them, e.g. the token field.
+	userClaims := *entry.claims
+	tokenClaims := make(map[string]interface{}, len(entry.tokenClaims))
+	for k, v := range entry.tokenClaims {
+		tokenClaims[k] = v
+	}
+	return &userClaims, tokenClaims, nil
+}
+
+// Add adds the claims of the validated token. The token is kept until the
+// expiry, which is usually the expiration time of the token.
+func (c *ValidationCache) Add(token string, userClaims *claims.UserClaims, tokenClaims map[string]interface{}, expiresAt time.Time) {
+	c.add(token, &validationCacheEntry{
+		claims:      userClaims,
+		tokenClaims: tokenClaims,
+	}, expiresAt)
+}
+
+// AddFailure adds the error of the token failing the validation. The token
+// is kept until the expiry, which is usually shortly after the failure, so
+// that the token becomes valid soon after, e.g. the keys being rotated.
+func (c *ValidationCache) AddFailure(token string, err error, expiresAt time.Time) {
+	c.add(token, &validationCacheEntry{err: err}, expiresAt)
+}
+
+func (c *ValidationCache) add(token string, entry *validationCacheEntry, expiresAt time.Time) {
+	key := tokenDigest(token)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if evicted := c.cache.add(key, entry, expiresAt); evicted > 0 {
+		c.stats.Evictions += uint64(evicted)
+		validationCacheMetrics.evictions.Add(float64(evicted))
+	}
+}
+
+// Delete removes the token.
+func (c *ValidationCache) Delete(token string) {
+	key := tokenDigest(token)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache.delete(key)
+}
+
+// Len returns the number of the cached tokens, including the expired tokens
+// not removed yet.
+func (c *ValidationCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cache.order.Len()
+}
+
+// Stats returns the counters of the cache.
+func (c *ValidationCache) Stats() ValidationCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/claims"
+)
+
+func TestValidationCache(t *testing.T) {
+	c := NewValidationCache(2)
+	expiresAt := time.Now().Add(time.Minute)
+	for _, token := range []string{"a", "b"} {
+		c.Add(token, &claims.UserClaims{Subject: token}, map[string]interface{}{"sub": token}, expiresAt)
+	}
+	// The expired tokens are not added.
+	c.Add("expired", &claims.UserClaims{Subject: "expired"}, nil, time.Now().Add(-time.Second))
+	if c.Len() != 2 {
+		t.Fatalf("cache contains %d tokens, expected: 2", c.Len())
+	}
+
+	userClaims, tokenClaims, _ := c.Get("a")
+	if userClaims == nil || userClaims.Subject != "a" || tokenClaims["sub"] != "a" {
+		t.Fatalf("unexpected claims of token a: %v, %v", userClaims, tokenClaims)
+	}
+	// The cached claims are not changed by the requests.
+	userClaims.Token = "changed"
+	tokenClaims["sub"] = "changed"
+	if userClaims, tokenClaims, _ := c.Get("a"); userClaims.Token != "" || tokenClaims["sub"] != "a" {
+		t.Fatalf("cached claims of token a changed: %v, %v", userClaims, tokenClaims)
+	}
+	// The least recently used token, i.e. b, is evicted.
+	c.Add("c", &claims.UserClaims{Subject: "c"}, nil, expiresAt)
+	for _, test := range []struct {
+		token  string
+		cached bool
+	}{
+		{token: "a", cached: true},
+		{token: "b", cached: false},
+		{token: "c", cached: true},
+		{token: "expired", cached: false},
+	} {
+		if userClaims, _, _ := c.Get(test.token); (userClaims != nil) != test.cached {
+			t.Fatalf("token %s: got: %t expected: %t", test.token, userClaims != nil, test.cached)
+		}
+	}
+
+	c.Delete("a")
+	if userClaims, _, _ := c.Get("a"); userClaims != nil {
+		t.Fatalf("deleted token a is cached")
+	}
+	// The tokens are removed when they expire.
+	c.Add("d", &claims.UserClaims{Subject: "d"}, nil, time.Now().Add(50*time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	if userClaims, _, _ := c.Get("d"); userClaims != nil {
+		t.Fatalf("expired token d is cached")
+	}
+	if c.Len() != 1 {
+		t.Fatalf("cache contains %d tokens, expected: 1", c.Len())
+	}
+
+	// The errors of the invalid tokens are cached.
+	c.AddFailure("invalid", errors.New("invalid token"), expiresAt)
+	if userClaims, _, err := c.Get("invalid"); userClaims != nil || err == nil || err.Error() != "invalid token" {
+		t.Fatalf("unexpected result of invalid token: %v, %v", userClaims, err)
+	}
+
+	stats := c.Stats()
+	expected := ValidationCacheStats{Hits: 4, InvalidHits: 1, Misses: 4, Evictions: 1}
+	if stats != expected {
+		t.Fatalf("got stats: %+v expected: %+v", stats, expected)
+	}
+
+	// The disabled cache does not hold tokens.
+	c = NewValidationCache(0)
+	c.Add("a", &claims.UserClaims{Subject: "a"}, nil, expiresAt)
+	if userClaims, _, _ := c.Get("a"); userClaims != nil || c.Len() != 0 {
+		t.Fatalf("disabled cache holds tokens")
+	}
+}
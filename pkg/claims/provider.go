@@ -0,0 +1,203 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package claims
+
+import (
+	"sort"
+	"strings"
+)
+
+// The identity providers with the presets normalizing the claims of their
+// tokens.
+const (
+	// ProviderKeycloak collects the realm roles and the client roles of
+	// Keycloak tokens, i.e. realm_access.roles and resource_access.*.roles,
+	// in roles claim.
+	ProviderKeycloak = "keycloak"
+	// ProviderAzure normalizes the claims of Azure AD, also known as Entra
+	// ID, tokens. The object id, i.e. oid, replaces the pairwise subject,
+	// tid is copied to tenant claim, and upn or preferred_username is the
+	// email address of the user without email claim. When the groups of
+	// the user do not fit in the token, groups_overage claim is set.
+	ProviderAzure = "azure"
+	// ProviderCognito collects the groups of AWS Cognito tokens, i.e.
+	// cognito:groups, in roles claim. The validator also checks token_use
+	// claim of the tokens.
+	ProviderCognito = "cognito"
+	// ProviderGitHub accepts the OIDC tokens of GitHub Actions workflows.
+	// The validator checks that the tokens are issued by GitHub Actions,
+	// and the access lists refer to the repository, ref, environment, and
+	// other claims describing the workflow run.
+	ProviderGitHub = "github"
+	// ProviderFirebase collects the custom claims of Firebase ID tokens in
+	// custom_claims claim, so that the access lists refer to them, e.g.
+	// custom_claims.admin. The sign-in provider is in firebase.sign_in_provider
+	// claim.
+	ProviderFirebase = "firebase"
+)
+
+// firebaseClaims are the claims of Firebase ID tokens other than the custom
+// claims.
+var firebaseClaims = map[string]bool{
+	"iss": true, "aud": true, "sub": true, "iat": true, "exp": true, "nbf": true,
+	"auth_time": true, "user_id": true, "email": true, "email_verified": true,
+	"phone_number": true, "name": true, "picture": true, "firebase": true,
+}
+
+// GitHubActionsIssuer is the issuer of the OIDC tokens of GitHub Actions.
+// The issuer of GitHub Enterprise Cloud with a custom issuer has the slug of
+// the enterprise appended, e.g. https://token.actions.githubusercontent.com/octo-inc.
+const GitHubActionsIssuer = "https://token.actions.githubusercontent.com"
+
+// GroupsOverageClaim is the claim set by the Azure AD preset when the token
+// has the group overage marker instead of the groups of the user.
+const GroupsOverageClaim = "groups_overage"
+
+// NormalizeClaims returns the copy of the claims normalized by the preset of
+// the identity provider, e.g. with the roles in nested claims collected in
+// roles claim. The claims are returned as is, when the provider is empty.
+func NormalizeClaims(provider string, m map[string]interface{}) map[string]interface{} {
+	if provider == "" {
+		return m
+	}
+	normalized := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		normalized[k] = v
+	}
+	switch provider {
+	case ProviderKeycloak:
+		normalizeKeycloakClaims(normalized)
+	case ProviderAzure:
+		normalizeAzureClaims(normalized)
+	case ProviderFirebase:
+		custom := make(map[string]interface{})
+		for k, v := range m {
+			if !firebaseClaims[k] {
+				custom[k] = v
+			}
+		}
+		if len(custom) > 0 {
+			normalized["custom_claims"] = custom
+		}
+	case ProviderCognito:
+		roles := newClaimValueSet(normalized["roles"])
+		roles.add(normalized["cognito:groups"])
+		if len(roles.values) > 0 {
+			normalized["roles"] = roles.values
+		}
+	}
+	return normalized
+}
+
+func normalizeKeycloakClaims(m map[string]interface{}) {
+	roles := newClaimValueSet(m["roles"])
+	if realmAccess, ok := m["realm_access"].(map[string]interface{}); ok {
+		roles.add(realmAccess["roles"])
+	}
+	if resourceAccess, ok := m["resource_access"].(map[string]interface{}); ok {
+		clients := make([]string, 0, len(resourceAccess))
+		for client := range resourceAccess {
+			clients = append(clients, client)
+		}
+		sort.Strings(clients)
+		for _, client := range clients {
+			if clientAccess, ok := resourceAccess[client].(map[string]interface{}); ok {
+				roles.add(clientAccess["roles"])
+			}
+		}
+	}
+	if len(roles.values) > 0 {
+		m["roles"] = roles.values
+	}
+}
+
+func normalizeAzureClaims(m map[string]interface{}) {
+	if oid, ok := m["oid"].(string); ok && oid != "" {
+		m["sub"] = oid
+	}
+	if tid, ok := m["tid"].(string); ok && tid != "" {
+		m["tenant"] = tid
+	}
+	_, emailExists := m["email"]
+	_, mailExists := m["mail"]
+	if !emailExists && !mailExists {
+		if upn, ok := m["upn"].(string); ok && upn != "" {
+			m["email"] = upn
+		} else if username, ok := m["preferred_username"].(string); ok && strings.Contains(username, "@") {
+			m["email"] = username
+		}
+	}
+	// The app roles are in roles claim and the group ids are in groups
+	// claim, both of them are roles of the user. When the user is a member
+	// of too many groups, the token has hasgroups claim, or the reference
+	// to groups claim in _claim_names claim, instead of groups claim.
+	overage := false
+	if hasGroups, ok := m["hasgroups"].(bool); ok && hasGroups {
+		overage = true
+	}
+	if claimNames, ok := m["_claim_names"].(map[string]interface{}); ok {
+		if _, exists := claimNames["groups"]; exists {
+			overage = true
+		}
+	}
+	if overage {
+		m[GroupsOverageClaim] = true
+	}
+}
+
+// claimValueSet is the list of the unique values of the claims holding
+// strings or arrays of strings.
+type claimValueSet struct {
+	values []interface{}
+	seen   map[string]bool
+}
+
+func newClaimValueSet(v interface{}) *claimValueSet {
+	s := &claimValueSet{seen: make(map[string]bool)}
+	s.add(v)
+	return s
+}
+
+func (s *claimValueSet) add(v interface{}) {
+	switch v := v.(type) {
+	case string:
+		for _, value := range strings.Fields(v) {
+			s.addValue(value)
+		}
+	case []interface{}:
+		for _, entry := range v {
+			if value, ok := entry.(string); ok {
+				s.addValue(value)
+			}
+		}
+	}
+}
+
+func (s *claimValueSet) addValue(value string) {
+	if value == "" || s.seen[value] {
+		return
+	}
+	s.seen[value] = true
+	s.values = append(s.values, value)
+}
+
+func hasString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -17,12 +17,13 @@ package claims
 import (
 	"encoding/json"
 	stdliberr "errors"
+	"fmt"
 	"github.com/greenpau/caddy-auth-jwt/pkg/config"
 	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
 	"strings"
 	"time"
 
-	jwtlib "github.com/dgrijalva/jwt-go"
+	jwttoken "github.com/greenpau/caddy-auth-jwt/pkg/token"
 )
 
 // UserClaims represents custom and standard JWT claims.
@@ -44,6 +45,12 @@ type UserClaims struct {
 	Address       string                 `json:"addr,omitempty" xml:"addr" yaml:"addr,omitempty"`
 	PictureURL    string                 `json:"picture,omitempty" xml:"picture" yaml:"picture,omitempty"`
 	Metadata      map[string]interface{} `json:"metadata,omitempty" xml:"metadata" yaml:"metadata,omitempty"`
+	// The claims of the token, including the claims without fields, e.g.
+	// nested role claims. They are set for the claims parsed from tokens.
+	RawClaims map[string]interface{} `json:"-" xml:"-" yaml:"-"`
+	// The token the claims are parsed from, as presented by the client, e.g.
+	// for exchanging the token.
+	Token string `json:"-" xml:"-" yaml:"-"`
 }
 
 // AccessListClaim represents custom acl/paths claim
@@ -314,6 +321,11 @@ func NewUserClaimsFromMap(m map[string]interface{}) (*UserClaims, error) {
 					for _, role := range roles {
 						switch role.(type) {
 						case string:
+							// The roles collected in roles claim, e.g. by
+							// the Keycloak preset, are not added again.
+							if hasString(u.Roles, role.(string)) {
+								continue
+							}
 							u.Roles = append(u.Roles, role.(string))
 						default:
 							return nil, errors.ErrInvalidRole.WithArgs(role)
@@ -324,7 +336,9 @@ func NewUserClaimsFromMap(m map[string]interface{}) (*UserClaims, error) {
 		}
 	}
 
-	for _, ra := range []string{"scopes", "scope"} {
+	// The scopes of OAuth 2.0 access tokens are in a space-delimited string,
+	// in scope claim per RFC 8693, or in scp claim.
+	for _, ra := range []string{"scopes", "scope", "scp"} {
 		if _, exists := m[ra]; exists {
 			switch m[ra].(type) {
 			case []interface{}:
@@ -339,7 +353,7 @@ func NewUserClaimsFromMap(m map[string]interface{}) (*UserClaims, error) {
 				}
 			case string:
 				scopes := m[ra].(string)
-				for _, scope := range strings.Split(scopes, " ") {
+				for _, scope := range strings.Fields(scopes) {
 					u.Scopes = append(u.Scopes, scope)
 				}
 			default:
@@ -488,9 +502,7 @@ func GetToken(method string, secret interface{}, claims UserClaims) (string, err
 		return "", errors.ErrUnsupportedSecret
 	}
 
-	sm := jwtlib.GetSigningMethod(method)
-	token := jwtlib.NewWithClaims(sm, claims)
-	signedToken, err := token.SignedString(secret)
+	signedToken, err := jwttoken.Sign(method, claims, nil, secret)
 	if err != nil {
 		return "", err
 	}
@@ -521,7 +533,7 @@ func (u *UserClaims) GetSignedToken(opts map[string]interface{}) (string, error)
 		secret = opts["shared_key"]
 	}
 
-	if strings.HasPrefix(method, "RS") {
+	if strings.HasPrefix(method, "RS") || strings.HasPrefix(method, "PS") || strings.HasPrefix(method, "ES") || method == "EdDSA" {
 		if _, exists := opts["private_key"]; !exists {
 			return "", errors.ErrPrivateSigningKeyNotFound
 		}
@@ -533,14 +545,12 @@ func (u *UserClaims) GetSignedToken(opts map[string]interface{}) (string, error)
 
 // GetSignedToken returns a signed JWT token based on the provided options.
 func GetSignedToken(opts map[string]interface{}, secret interface{}, claims UserClaims) (string, error) {
-	sm := jwtlib.GetSigningMethod(opts["method"].(string))
-	token := jwtlib.NewWithClaims(sm, claims)
-
+	headers := make(map[string]interface{})
 	if _, exists := opts["kid"]; exists {
-		token.Header["kid"] = opts["kid"].(string)
+		headers["kid"] = opts["kid"].(string)
 	}
 
-	signedToken, err := token.SignedString(secret)
+	signedToken, err := jwttoken.Sign(opts["method"].(string), claims, headers, secret)
 	if err != nil {
 		return "", err
 	}
@@ -548,14 +558,204 @@ func GetSignedToken(opts map[string]interface{}, secret interface{}, claims User
 }
 
 // ParseClaims extracts claims from a token.
-func ParseClaims(token *jwtlib.Token) (*UserClaims, error) {
-	claimMap := token.Claims.(jwtlib.MapClaims)
-	claims, err := NewUserClaimsFromMap(claimMap)
+func ParseClaims(token *jwttoken.Token) (*UserClaims, error) {
+	claims, err := NewUserClaimsFromMap(token.Claims)
 	if err != nil {
 		return nil, errors.ErrInvalidParsedClaims.WithArgs(err)
 	}
 	if claims == nil {
 		return nil, errors.ErrNoParsedClaims
 	}
+	claims.RawClaims = token.Claims
 	return claims, nil
 }
+
+// GetClaim returns the value of the claim. The name of a nested claim is the
+// path to the claim with dot separators, e.g. resource_access.api.roles.
+func (u *UserClaims) GetClaim(name string) (interface{}, bool) {
+	m := u.RawClaims
+	if m == nil {
+		m = u.AsMap()
+	}
+	return LookupClaim(m, name)
+}
+
+// LookupClaim returns the value of the claim in the claims. The name of
+// a nested claim is the path to the claim with dot separators.
+func LookupClaim(m map[string]interface{}, name string) (interface{}, bool) {
+	if v, exists := m[name]; exists {
+		return v, true
+	}
+	var v interface{} = m
+	for _, k := range strings.Split(name, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// StripClaimNamespaces returns the copy of the claims with the namespaced
+// claims, e.g. https://example.com/roles, copied to the claims without the
+// namespace, e.g. roles. The value of the claim without the namespace, if
+// any, is replaced.
+func StripClaimNamespaces(m map[string]interface{}, namespaces []string) map[string]interface{} {
+	stripped := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		stripped[k] = v
+	}
+	for _, namespace := range namespaces {
+		for k, v := range m {
+			if !strings.HasPrefix(k, namespace) {
+				continue
+			}
+			name := strings.TrimPrefix(strings.TrimPrefix(k, namespace), "/")
+			if name == "" {
+				continue
+			}
+			stripped[name] = v
+		}
+	}
+	return stripped
+}
+
+// MapClaims returns the copy of the claims with the values of the claims
+// copied to the claims with other names, e.g. groups to roles. The value
+// of the target claim, if any, is replaced.
+func MapClaims(m map[string]interface{}, mappings []*config.ClaimMapping) map[string]interface{} {
+	mapped := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		mapped[k] = v
+	}
+	for _, mapping := range mappings {
+		if v, exists := LookupClaim(m, mapping.From); exists {
+			mapped[mapping.To] = v
+		}
+	}
+	return mapped
+}
+
+// TransformClaims returns the copy of the claims with the values of the claims
+// transformed, e.g. lowercased. The transforms apply to the claims holding
+// strings or arrays of strings.
+func TransformClaims(m map[string]interface{}, transforms []*config.ClaimTransform) map[string]interface{} {
+	transformed := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		transformed[k] = v
+	}
+	for _, t := range transforms {
+		switch v := transformed[t.Claim].(type) {
+		case string:
+			transformed[t.Claim] = transformClaimValue(t, v)
+		case []interface{}:
+			values := make([]interface{}, len(v))
+			for i, entry := range v {
+				if s, ok := entry.(string); ok {
+					values[i] = transformClaimValue(t, s)
+				} else {
+					values[i] = entry
+				}
+			}
+			transformed[t.Claim] = values
+		}
+	}
+	return transformed
+}
+
+// FilterClaims returns the copy of the claims with the claims selected by the
+// filter. The nested objects holding the excluded claims are copied, so that
+// the claims are not modified.
+func FilterClaims(m map[string]interface{}, filter *config.ClaimFilter) map[string]interface{} {
+	filtered := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if filter != nil && len(filter.Allow) > 0 && !hasString(filter.Allow, k) {
+			continue
+		}
+		filtered[k] = v
+	}
+	if filter == nil {
+		return filtered
+	}
+	for _, name := range filter.Exclude {
+		if _, exists := filtered[name]; exists {
+			delete(filtered, name)
+			continue
+		}
+		excludeClaim(filtered, strings.Split(name, "."))
+	}
+	return filtered
+}
+
+// excludeClaim removes the claim at the path from the claims. The claims are
+// copied by FilterClaims, and the nested objects are copied here.
+func excludeClaim(m map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(m, path[0])
+		return
+	}
+	obj, ok := m[path[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	copied := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		copied[k] = v
+	}
+	excludeClaim(copied, path[1:])
+	m[path[0]] = copied
+}
+
+func transformClaimValue(t *config.ClaimTransform, s string) string {
+	switch t.Transform {
+	case config.ClaimTransformLowercase:
+		return strings.ToLower(s)
+	case config.ClaimTransformTrim:
+		return strings.TrimSpace(s)
+	case config.ClaimTransformStripEmailDomain:
+		if i := strings.LastIndex(s, "@"); i >= 0 {
+			return s[:i]
+		}
+	case config.ClaimTransformStripPrefix:
+		return strings.TrimPrefix(s, t.Value)
+	case config.ClaimTransformStripSuffix:
+		return strings.TrimSuffix(s, t.Value)
+	}
+	return s
+}
+
+// GetClaimValues returns the values of the claim as strings. The claim holding
+// an array has a value for each of the entries, other than objects.
+func (u *UserClaims) GetClaimValues(name string) []string {
+	v, exists := u.GetClaim(name)
+	if !exists {
+		return nil
+	}
+	var values []string
+	switch v := v.(type) {
+	case []interface{}:
+		for _, entry := range v {
+			if s, ok := claimValueString(entry); ok {
+				values = append(values, s)
+			}
+		}
+	default:
+		if s, ok := claimValueString(v); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+func claimValueString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool, float64, json.Number, int, int64:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
@@ -18,10 +18,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	jwtlib "github.com/dgrijalva/jwt-go"
+	jwtlib "github.com/golang-jwt/jwt/v5"
 	"github.com/google/go-cmp/cmp"
 	"github.com/greenpau/caddy-auth-jwt/pkg/backends"
+	"github.com/greenpau/caddy-auth-jwt/pkg/config"
 	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwttoken "github.com/greenpau/caddy-auth-jwt/pkg/token"
 	"reflect"
 	"testing"
 	"time"
@@ -34,7 +36,7 @@ type TestUserClaims struct {
 	Group         string   `json:"group,omitempty" xml:"group" yaml:"group,omitempty"`
 	Organizations []string `json:"org,omitempty" xml:"org" yaml:"org,omitempty"`
 	Address       string   `json:"addr,omitempty" xml:"addr" yaml:"addr,omitempty"`
-	jwtlib.StandardClaims
+	jwtlib.RegisteredClaims
 }
 
 func TestGetToken(t *testing.T) {
@@ -474,6 +476,22 @@ func TestNewUserClaimsFromMap(t *testing.T) {
 				Scopes: []string{"repo", "public_repo"},
 			},
 		},
+		{
+			name: "valid scope claim with space-delimited value",
+			data: []byte(`{"scope": " read:documents  write:documents\tprofile "}`),
+			claims: &UserClaims{
+				Roles:  []string{"anonymous", "guest"},
+				Scopes: []string{"read:documents", "write:documents", "profile"},
+			},
+		},
+		{
+			name: "valid scp claim string value",
+			data: []byte(`{"scp": "User.Read Mail.Send"}`),
+			claims: &UserClaims{
+				Roles:  []string{"anonymous", "guest"},
+				Scopes: []string{"User.Read", "Mail.Send"},
+			},
+		},
 		{
 			name:      "invalid scopes claim with numeric slice",
 			data:      []byte(`{"scopes": [123456]}`),
@@ -583,10 +601,10 @@ func TestReadUserClaims(t *testing.T) {
 			name: "user with roles claims and ip address",
 			claims: &TestUserClaims{
 				Roles: []string{"admin", "editor", "viewer"},
-				StandardClaims: jwtlib.StandardClaims{
-					ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
-					IssuedAt:  time.Now().Add(10 * time.Minute * -1).Unix(),
-					NotBefore: time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC).Unix(),
+				RegisteredClaims: jwtlib.RegisteredClaims{
+					ExpiresAt: jwtlib.NewNumericDate(time.Now().Add(10 * time.Minute)),
+					IssuedAt:  jwtlib.NewNumericDate(time.Now().Add(10 * time.Minute * -1)),
+					NotBefore: jwtlib.NewNumericDate(time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC)),
 					Subject:   "smithj@outlook.com",
 				},
 			},
@@ -597,10 +615,10 @@ func TestReadUserClaims(t *testing.T) {
 			name: "user with groups claims and ip address",
 			claims: &TestUserClaims{
 				Groups: []string{"admin", "editor", "viewer"},
-				StandardClaims: jwtlib.StandardClaims{
-					ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
-					IssuedAt:  time.Now().Add(10 * time.Minute * -1).Unix(),
-					NotBefore: time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC).Unix(),
+				RegisteredClaims: jwtlib.RegisteredClaims{
+					ExpiresAt: jwtlib.NewNumericDate(time.Now().Add(10 * time.Minute)),
+					IssuedAt:  jwtlib.NewNumericDate(time.Now().Add(10 * time.Minute * -1)),
+					NotBefore: jwtlib.NewNumericDate(time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC)),
 					Subject:   "smithj@outlook.com",
 				},
 			},
@@ -612,10 +630,10 @@ func TestReadUserClaims(t *testing.T) {
 			claims: &TestUserClaims{
 				Role:    "admin",
 				Address: "192.168.1.1",
-				StandardClaims: jwtlib.StandardClaims{
-					ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
-					IssuedAt:  time.Now().Add(10 * time.Minute * -1).Unix(),
-					NotBefore: time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC).Unix(),
+				RegisteredClaims: jwtlib.RegisteredClaims{
+					ExpiresAt: jwtlib.NewNumericDate(time.Now().Add(10 * time.Minute)),
+					IssuedAt:  jwtlib.NewNumericDate(time.Now().Add(10 * time.Minute * -1)),
+					NotBefore: jwtlib.NewNumericDate(time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC)),
 					Subject:   "smithj@outlook.com",
 				},
 			},
@@ -627,10 +645,10 @@ func TestReadUserClaims(t *testing.T) {
 			claims: &TestUserClaims{
 				Group:   "admin",
 				Address: "192.168.1.1",
-				StandardClaims: jwtlib.StandardClaims{
-					ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
-					IssuedAt:  time.Now().Add(10 * time.Minute * -1).Unix(),
-					NotBefore: time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC).Unix(),
+				RegisteredClaims: jwtlib.RegisteredClaims{
+					ExpiresAt: jwtlib.NewNumericDate(time.Now().Add(10 * time.Minute)),
+					IssuedAt:  jwtlib.NewNumericDate(time.Now().Add(10 * time.Minute * -1)),
+					NotBefore: jwtlib.NewNumericDate(time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC)),
 					Subject:   "smithj@outlook.com",
 				},
 			},
@@ -650,13 +668,10 @@ func TestReadUserClaims(t *testing.T) {
 			}
 			t.Logf("Encoded input token: %s", inputTokenString)
 
-			token, err := jwtlib.Parse(inputTokenString, backend.ProvideKey)
+			token, err := jwttoken.NewParser(nil).Parse(inputTokenString, backend.ProvideKey)
 			if err != nil {
 				t.Fatalf("failed parsing token: %s", err)
 			}
-			if !token.Valid {
-				t.Fatalf("token is invalid")
-			}
 
 			testClaims, err := ParseClaims(token)
 			if err != nil {
@@ -786,6 +801,98 @@ func TestRealmAccessRoles(t *testing.T) {
 	return
 }
 
+func TestGetClaimValues(t *testing.T) {
+	claims := &UserClaims{
+		Name: "Smith, John",
+		RawClaims: map[string]interface{}{
+			"email_verified": true,
+			"level":          float64(2),
+			"groups": map[string]interface{}{
+				"names": []interface{}{"admins", map[string]interface{}{"id": 1}, "users"},
+			},
+		},
+	}
+	tests := []struct {
+		name   string
+		claims *UserClaims
+		claim  string
+		values []string
+	}{
+		{name: "boolean claim", claims: claims, claim: "email_verified", values: []string{"true"}},
+		{name: "numeric claim", claims: claims, claim: "level", values: []string{"2"}},
+		{name: "nested array claim", claims: claims, claim: "groups.names", values: []string{"admins", "users"}},
+		{name: "object claim", claims: claims, claim: "groups"},
+		{name: "missing claim", claims: claims, claim: "groups.ids"},
+		{name: "claim without raw claims", claims: &UserClaims{Name: "Smith, John"}, claim: "name", values: []string{"Smith, John"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values := test.claims.GetClaimValues(test.claim)
+			if !reflect.DeepEqual(values, test.values) {
+				t.Fatalf("values mismatch: %v (received) vs. %v (expected)", values, test.values)
+			}
+		})
+	}
+}
+
+func TestFilterClaims(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub":     "jsmith",
+		"email":   "jsmith@example.com",
+		"address": "1 Main St",
+		"realm_access": map[string]interface{}{
+			"roles":  []interface{}{"admin"},
+			"groups": []interface{}{"/staff", "/payroll"},
+		},
+		"https://example.com/groups": []interface{}{"staff"},
+	}
+	tests := []struct {
+		name     string
+		filter   *config.ClaimFilter
+		expected map[string]interface{}
+	}{
+		{name: "no filter", expected: claims},
+		{
+			name:   "allowed claims",
+			filter: &config.ClaimFilter{Allow: []string{"sub", "email"}},
+			expected: map[string]interface{}{
+				"sub":   "jsmith",
+				"email": "jsmith@example.com",
+			},
+		},
+		{
+			name:   "excluded claims",
+			filter: &config.ClaimFilter{Exclude: []string{"address", "realm_access.groups", "https://example.com/groups"}},
+			expected: map[string]interface{}{
+				"sub":   "jsmith",
+				"email": "jsmith@example.com",
+				"realm_access": map[string]interface{}{
+					"roles": []interface{}{"admin"},
+				},
+			},
+		},
+		{
+			name:   "allowed and excluded claims",
+			filter: &config.ClaimFilter{Allow: []string{"sub", "address"}, Exclude: []string{"address"}},
+			expected: map[string]interface{}{
+				"sub": "jsmith",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filtered := FilterClaims(claims, test.filter)
+			if !reflect.DeepEqual(filtered, test.expected) {
+				t.Fatalf("claims mismatch: %v (received) vs. %v (expected)", filtered, test.expected)
+			}
+		})
+	}
+	// The claims, including the nested objects, are not modified.
+	if groups := claims["realm_access"].(map[string]interface{})["groups"]; groups == nil {
+		t.Fatal("nested claim removed from the original claims")
+	}
+}
+
 func TestAnonymousGuestRoles(t *testing.T) {
 	secret := "75f03764147c4d87b2f04fda89e331c808ab50a932914e758ae17c7847ef27fa"
 	encodedToken := "eyJhbGciOiJIUzUxMiIsInR5cCI6IkpXVCJ9." +
@@ -0,0 +1,145 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+// The transforms of claim values.
+const (
+	ClaimTransformLowercase        = "lowercase"
+	ClaimTransformTrim             = "trim"
+	ClaimTransformStripEmailDomain = "strip_email_domain"
+	ClaimTransformStripPrefix      = "strip_prefix"
+	ClaimTransformStripSuffix      = "strip_suffix"
+)
+
+// RequiredClaim is a claim the tokens must have, e.g. email_verified. When
+// the values are set, the value of the claim, or one of the values of the
+// claims holding an array, must be one of them.
+type RequiredClaim struct {
+	Name   string   `json:"name,omitempty" xml:"name" yaml:"name"`
+	Values []string `json:"values,omitempty" xml:"values" yaml:"values"`
+}
+
+// AuthTimeRequirement requires the user of the token to have authenticated,
+// per auth_time claim, within the maximum age, in seconds, to access the
+// paths, e.g. /admin*. When the paths are empty, the requirement applies to
+// any path.
+type AuthTimeRequirement struct {
+	Paths  []string `json:"paths,omitempty" xml:"paths" yaml:"paths"`
+	MaxAge int      `json:"max_age,omitempty" xml:"max_age" yaml:"max_age"`
+}
+
+// ClaimMapping copies the value of the claim to the claim with other name,
+// e.g. groups to roles, before the claims are evaluated. The name of a nested
+// claim is the path to the claim, e.g. realm_access.groups.
+type ClaimMapping struct {
+	From string `json:"from,omitempty" xml:"from" yaml:"from"`
+	To   string `json:"to,omitempty" xml:"to" yaml:"to"`
+}
+
+// HeaderInjection passes the value of the claim in HTTP request header. The
+// name of a nested claim is the path to the claim, e.g. realm_access.roles.
+type HeaderInjection struct {
+	Header string `json:"header,omitempty" xml:"header" yaml:"header"`
+	Claim  string `json:"claim,omitempty" xml:"claim" yaml:"claim"`
+}
+
+// ClaimHeaders passes the claims in HTTP request headers. When the headers are
+// empty, the subject, the name, the email, the roles, and the scopes are
+// passed in the default headers, e.g. X-Token-User-Email. The prefix, by
+// default X-Token- for the default headers, is prepended to the names of the
+// headers. The excluded claims are not passed.
+type ClaimHeaders struct {
+	Prefix  string             `json:"prefix,omitempty" xml:"prefix" yaml:"prefix"`
+	Headers []*HeaderInjection `json:"headers,omitempty" xml:"headers" yaml:"headers"`
+	Exclude []string           `json:"exclude,omitempty" xml:"exclude" yaml:"exclude"`
+}
+
+// DefaultClaimHeaders are the headers passing the claims when the claim
+// headers have no headers.
+var DefaultClaimHeaders = []*HeaderInjection{
+	{Claim: "sub", Header: "Subject"},
+	{Claim: "name", Header: "User-Name"},
+	{Claim: "email", Header: "User-Email"},
+	{Claim: "roles", Header: "User-Roles"},
+	{Claim: "scopes", Header: "User-Scopes"},
+}
+
+// GetHeaders returns the headers passing the claims, with the prefix, and
+// without the excluded claims.
+func (c *ClaimHeaders) GetHeaders() []*HeaderInjection {
+	headers := c.Headers
+	prefix := c.Prefix
+	if len(headers) == 0 {
+		headers = DefaultClaimHeaders
+		if prefix == "" {
+			prefix = "X-Token-"
+		}
+	}
+	var entries []*HeaderInjection
+	for _, entry := range headers {
+		excluded := false
+		for _, claim := range c.Exclude {
+			if claim == entry.Claim {
+				excluded = true
+			}
+		}
+		if !excluded {
+			entries = append(entries, &HeaderInjection{
+				Header: prefix + entry.Header,
+				Claim:  entry.Claim,
+			})
+		}
+	}
+	return entries
+}
+
+// ClaimFilter selects the claims passed to the upstreams, e.g. in the payload
+// header or the reissued tokens. When the allowed claims are set, only the
+// allowed claims are passed. The excluded claims are never passed. The name of
+// an excluded nested claim is the path to the claim, e.g. realm_access.groups.
+type ClaimFilter struct {
+	Allow   []string `json:"allow,omitempty" xml:"allow" yaml:"allow"`
+	Exclude []string `json:"exclude,omitempty" xml:"exclude" yaml:"exclude"`
+}
+
+// ClaimTransform transforms the values of the claim, e.g. lowercases group
+// names, before the claims are evaluated. The value is the argument of the
+// transform, e.g. the prefix removed by strip_prefix.
+type ClaimTransform struct {
+	Claim     string `json:"claim,omitempty" xml:"claim" yaml:"claim"`
+	Transform string `json:"transform,omitempty" xml:"transform" yaml:"transform"`
+	Value     string `json:"value,omitempty" xml:"value" yaml:"value"`
+}
+
+// Validate checks the claim and the transform.
+func (t *ClaimTransform) Validate() error {
+	if t.Claim == "" {
+		return errors.ErrEmptyClaim
+	}
+	switch t.Transform {
+	case ClaimTransformLowercase, ClaimTransformTrim, ClaimTransformStripEmailDomain:
+	case ClaimTransformStripPrefix, ClaimTransformStripSuffix:
+		if t.Value == "" {
+			return errors.ErrClaimTransformNoValue.WithArgs(t.Transform)
+		}
+	default:
+		return errors.ErrUnsupportedClaimTransform.WithArgs(t.Transform)
+	}
+	return nil
+}
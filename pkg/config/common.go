@@ -16,6 +16,7 @@ package config
 
 import (
 	"crypto/rsa"
+	"encoding/json"
 	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
 )
 
@@ -27,19 +28,67 @@ type CommonTokenConfig struct {
 	TokenSignMethod string `json:"token_sign_method,omitempty" xml:"token_sign_method,omitempty" yaml:"token_sign_method,omitempty"`
 	TokenName       string `json:"token_name,omitempty" xml:"token_name" yaml:"token_name"`
 	TokenOrigin     string `json:"token_origin,omitempty" xml:"token_origin" yaml:"token_origin"`
+	// The issuer, i.e. iss claim, of the tokens verified with the keys of this
+	// configuration. With issuer routing, the tokens are verified with the keys
+	// of the configurations with the issuer of the token first.
+	TokenIssuer string `json:"token_issuer,omitempty" xml:"token_issuer" yaml:"token_issuer"`
+	// The issuers of the tokens verified with the keys of this configuration, e.g.
+	// the regional URLs of an identity provider. Unlike token_issuer, the iss claim
+	// of the tokens must be one of them, or token_issuer.
+	TokenIssuers []string `json:"token_issuers,omitempty" xml:"token_issuers" yaml:"token_issuers"`
+	// The type, i.e. typ header, required of the tokens verified with the keys of
+	// this configuration, e.g. at+jwt for the access tokens per RFC 9068. The
+	// application/ prefix of the type is optional and the type is case-insensitive.
+	TokenRequiredType string `json:"token_required_type,omitempty" xml:"token_required_type" yaml:"token_required_type"`
+	// The format of the tokens verified with the keys of this configuration, either
+	// jwt (default) or paseto, i.e. PASETO v2.public and v4.public tokens verified
+	// with the Ed25519 public keys.
+	TokenFormat string `json:"token_format,omitempty" xml:"token_format" yaml:"token_format"`
+	// The audiences, i.e. aud claim values, accepted of the tokens verified with the
+	// keys of this configuration. The token must have at least one of them. If empty,
+	// any audience is accepted.
+	TokenAudience []string `json:"token_audience,omitempty" xml:"token_audience" yaml:"token_audience"`
+	// The Google Workspace domains, i.e. hd claim values, accepted of the tokens
+	// verified with the keys of this configuration. If empty, any domain, including
+	// none, is accepted.
+	TokenHostedDomains []string `json:"token_hosted_domains,omitempty" xml:"token_hosted_domains" yaml:"token_hosted_domains"`
 	// The expiration time of a token in seconds
 	TokenLifetime      int    `json:"token_lifetime,omitempty" xml:"token_lifetime" yaml:"token_lifetime"`
 	TokenSigningMethod string `json:"token_signing_method,omitempty" xml:"token_signing_method" yaml:"token_signing_method"`
+	// The signing algorithms, e.g. RS256, accepted when validating tokens. If empty,
+	// any algorithm supported by the key material is accepted.
+	AllowedAlgorithms []string `json:"allowed_algs,omitempty" xml:"allowed_algs" yaml:"allowed_algs"`
+	// When enabled, the key files and directories, including token_jwks_file, are
+	// watched for changes and the keys are reloaded without a configuration reload.
+	TokenKeyWatch bool `json:"token_key_watch,omitempty" xml:"token_key_watch" yaml:"token_key_watch"`
+	// The interval between the checks of the watched key files for changes in seconds
+	// (default: 60). The checks complement filesystem notifications, which are not
+	// delivered on some filesystems. A negative value disables the checks.
+	TokenKeyWatchPollInterval int `json:"token_key_watch_poll_interval,omitempty" xml:"token_key_watch_poll_interval" yaml:"token_key_watch_poll_interval"`
 
 	HMACSignMethodConfig
 	RSASignMethodConfig
+	JwksConfig
+	AWSConfig
+	AzureConfig
+	GCPConfig
+	KubernetesConfig
+	IntrospectionConfig
+	DecryptionConfig
+	BackendModuleConfig
+	ECDSASignMethodConfig
+	EdDSASignMethodConfig
 
-	tokenKeys map[string]interface{} // the value must be a *rsa.PrivateKey or *rsa.PublicKey
+	tokenKeys map[string]interface{} // the value must be a RSA, ECDSA, or Ed25519 private or public key
 }
 
 // HMACSignMethodConfig holds configuration for signing messages by means of a shared key.
 type HMACSignMethodConfig struct {
 	TokenSecret string `json:"token_secret,omitempty" xml:"token_secret" yaml:"token_secret"`
+	// The path to the file holding the shared secret, e.g. mounted from a Kubernetes
+	// Secret volume or Docker secret. The trailing whitespace is trimmed. When both
+	// are set, token_secret takes precedence.
+	TokenSecretFile string `json:"token_secret_file,omitempty" xml:"token_secret_file" yaml:"token_secret_file"`
 }
 
 // RSASignMethodConfig holds data for RSA keys that can be used to sign and verify JWT tokens
@@ -55,6 +104,7 @@ type HMACSignMethodConfig struct {
 //    +-- kid_3.key
 //    +-- kid_4.key
 //    +-- kid.5.key
+//    +-- kid_6.pem
 // The above directory will result in a TokenRSKeys that looks like:
 //
 // TokenRSKeys{
@@ -63,8 +113,11 @@ type HMACSignMethodConfig struct {
 //     "kid_3": "---- RSA PRIVATE KEY ---- ...",
 //     "kid_4": "---- RSA PUBLIC KEY ---- ...",
 //     // there is no "kid.5" becuase the "." is invalid.
+//     "kid_6": "---- RSA PUBLIC KEY ---- ...",
 // }
 //
+// The file extension, e.g. .key or .pem, is not a part of the kid.
+//
 // There only needs to be public keys loaded for verification. If you're using the Grantor method then
 // you need to load a PrivateKey so that keys can be signed.
 //
@@ -112,6 +165,286 @@ type RSASignMethodConfig struct {
 
 	TokenRSAFile string `json:"token_rsa_file,omitempty" xml:"token_rsa_file" yaml:"token_rsa_file"`
 	TokenRSAKey  string `json:"token_rsa_key,omitempty" xml:"token_rsa_key" yaml:"token_rsa_key"`
+
+	// TokenRSAPSSMethods restricts the RSA-PSS methods, i.e. PS256, PS384, PS512, validated
+	// with the RSA keys. If empty, all RSA-PSS methods are accepted.
+	TokenRSAPSSMethods []string `json:"token_rsa_pss_methods,omitempty" xml:"token_rsa_pss_methods" yaml:"token_rsa_pss_methods"`
+}
+
+// ECDSASignMethodConfig holds data for ECDSA keys that can be used to verify JWT tokens
+// signed with ES256, ES384, and ES512 methods.
+//
+// The TokenECDSA fields translate to the following config values:
+//
+// "token_ecdsa_files": {"<kid>": "<path to file>", ...}
+// "token_ecdsa_keys": {"<kid>": "<key PEM value>", ...}
+// "token_ecdsa_file": "<path to file>"
+// "token_ecdsa_key": "<key PEM value>"
+// "token_ecdsa_dir": "<path to directory>"
+//
+// Similar to RSA, the last two variables map to a <kid> of "0". If both RSA and
+// ECDSA keys are configured with the <kid> of "0", the RSA key takes precedence.
+type ECDSASignMethodConfig struct {
+	// TokenECDSADir holds the path to a directory of key files. Like TokenRSADir, the path of
+	// the file relative to the directory, without the file extension, is used as the kid.
+	TokenECDSADir string `json:"token_ecdsa_dir,omitempty" xml:"token_ecdsa_dir" yaml:"token_ecdsa_dir"`
+
+	// TokenECDSAFiles holds a map of <kid> to filename. These files should hold the public or private key.
+	TokenECDSAFiles map[string]string `json:"token_ecdsa_files,omitempty" xml:"token_ecdsa_files" yaml:"token_ecdsa_files"`
+
+	// TokenECDSAKeys holds a map of <kid> to the key PEM value
+	TokenECDSAKeys map[string]string `json:"token_ecdsa_keys,omitempty" xml:"token_ecdsa_keys" yaml:"token_ecdsa_keys"`
+
+	TokenECDSAFile string `json:"token_ecdsa_file,omitempty" xml:"token_ecdsa_file" yaml:"token_ecdsa_file"`
+	TokenECDSAKey  string `json:"token_ecdsa_key,omitempty" xml:"token_ecdsa_key" yaml:"token_ecdsa_key"`
+}
+
+// EdDSASignMethodConfig holds data for Ed25519 keys that can be used to verify
+// JWT tokens signed with EdDSA method. The fields follow the same convention
+// as ECDSASignMethodConfig.
+//
+// "token_eddsa_files": {"<kid>": "<path to file>", ...}
+// "token_eddsa_keys": {"<kid>": "<key PEM value>", ...}
+// "token_eddsa_file": "<path to file>"
+// "token_eddsa_key": "<key PEM value>"
+// "token_eddsa_dir": "<path to directory>"
+type EdDSASignMethodConfig struct {
+	// TokenEdDSADir holds the path to a directory of key files.
+	TokenEdDSADir string `json:"token_eddsa_dir,omitempty" xml:"token_eddsa_dir" yaml:"token_eddsa_dir"`
+
+	// TokenEdDSAFiles holds a map of <kid> to filename. These files should hold the public or private key.
+	TokenEdDSAFiles map[string]string `json:"token_eddsa_files,omitempty" xml:"token_eddsa_files" yaml:"token_eddsa_files"`
+
+	// TokenEdDSAKeys holds a map of <kid> to the key PEM value
+	TokenEdDSAKeys map[string]string `json:"token_eddsa_keys,omitempty" xml:"token_eddsa_keys" yaml:"token_eddsa_keys"`
+
+	TokenEdDSAFile string `json:"token_eddsa_file,omitempty" xml:"token_eddsa_file" yaml:"token_eddsa_file"`
+	TokenEdDSAKey  string `json:"token_eddsa_key,omitempty" xml:"token_eddsa_key" yaml:"token_eddsa_key"`
+}
+
+// JwksConfig holds the settings for fetching public keys from JSON Web Key Set
+// (JWKS) URL, e.g. https://www.googleapis.com/oauth2/v3/certs.
+//
+// "token_jwks_uri": "<url>"
+// "token_oidc_issuer": "<url>"
+// "token_jwks_file": "<path>"
+// "token_jwks": "<json|base64>"
+// "token_jwks_refresh_interval": <seconds>
+// "token_jwks_min_refresh_interval": <seconds>
+// "token_firebase_project_id": "<project id>"
+//
+// The keys are refreshed every token_jwks_refresh_interval seconds (default: 3600).
+// Additionally, a token with a kid not found in the key set triggers a refresh,
+// but not more often than every token_jwks_min_refresh_interval seconds (default: 60).
+//
+// When token_oidc_issuer is set instead of token_jwks_uri, the jwks_uri is
+// discovered via <issuer>/.well-known/openid-configuration.
+//
+// When token_firebase_project_id is set, the keys of Firebase Authentication
+// are fetched, and the issuer and the audience of the tokens must match the
+// Firebase project.
+type JwksConfig struct {
+	TokenJwksURI    string `json:"token_jwks_uri,omitempty" xml:"token_jwks_uri" yaml:"token_jwks_uri"`
+	TokenOIDCIssuer string `json:"token_oidc_issuer,omitempty" xml:"token_oidc_issuer" yaml:"token_oidc_issuer"`
+	// The id of the Firebase project issuing Firebase ID tokens.
+	TokenFirebaseProjectID string `json:"token_firebase_project_id,omitempty" xml:"token_firebase_project_id" yaml:"token_firebase_project_id"`
+	// The path to JSON Web Key Set file, e.g. exported from an identity provider.
+	// Unlike token_jwks_uri, the keys are loaded once, unless token_key_watch is enabled.
+	TokenJwksFile string `json:"token_jwks_file,omitempty" xml:"token_jwks_file" yaml:"token_jwks_file"`
+	// The JSON Web Key Set embedded in the configuration, either as JSON document
+	// or base64-encoded JSON document. The keys are loaded once.
+	TokenJwks string `json:"token_jwks,omitempty" xml:"token_jwks" yaml:"token_jwks"`
+	// The path to PEM-encoded CA certificates the x5c certificate chains of the keys
+	// are verified against. When set, the keys without a valid chain are rejected.
+	TokenJwksX5cCAFile string `json:"token_jwks_x5c_ca_file,omitempty" xml:"token_jwks_x5c_ca_file" yaml:"token_jwks_x5c_ca_file"`
+	// The interval between periodic key refreshes in seconds. A negative value disables the refreshes.
+	TokenJwksRefreshInterval int `json:"token_jwks_refresh_interval,omitempty" xml:"token_jwks_refresh_interval" yaml:"token_jwks_refresh_interval"`
+	// The minimum interval between key refreshes triggered by an unknown key id in seconds.
+	TokenJwksMinRefreshInterval int `json:"token_jwks_min_refresh_interval,omitempty" xml:"token_jwks_min_refresh_interval" yaml:"token_jwks_min_refresh_interval"`
+	// The timeout of a single key fetch in seconds (default: 10).
+	TokenJwksFetchTimeout int `json:"token_jwks_fetch_timeout,omitempty" xml:"token_jwks_fetch_timeout" yaml:"token_jwks_fetch_timeout"`
+	// The number of retries of a failed key fetch (default: 2). A negative value disables the retries.
+	TokenJwksFetchRetries int `json:"token_jwks_fetch_retries,omitempty" xml:"token_jwks_fetch_retries" yaml:"token_jwks_fetch_retries"`
+	// The initial delay between the retries in seconds (default: 1). The delay doubles after each retry.
+	TokenJwksRetryBackoff int `json:"token_jwks_retry_backoff,omitempty" xml:"token_jwks_retry_backoff" yaml:"token_jwks_retry_backoff"`
+	// When enabled, the failure to fetch the keys at startup does not fail the configuration.
+	TokenJwksTolerateFetchErrors bool `json:"token_jwks_tolerate_fetch_errors,omitempty" xml:"token_jwks_tolerate_fetch_errors" yaml:"token_jwks_tolerate_fetch_errors"`
+	// The timeout of fetching the keys at startup in seconds, including the retries.
+	TokenJwksWarmUpTimeout int `json:"token_jwks_warmup_timeout,omitempty" xml:"token_jwks_warmup_timeout" yaml:"token_jwks_warmup_timeout"`
+	// When enabled, the keys are not fetched at startup, but by the first token.
+	TokenJwksLazyLoad bool `json:"token_jwks_lazy_load,omitempty" xml:"token_jwks_lazy_load" yaml:"token_jwks_lazy_load"`
+	// The response to the tokens while no keys could be fetched, i.e. deny (default)
+	// for 401, unavailable for 503, or allow for passing the request without identity.
+	// Any policy implies token_jwks_tolerate_fetch_errors.
+	TokenKeyOutagePolicy string `json:"token_key_outage_policy,omitempty" xml:"token_key_outage_policy" yaml:"token_key_outage_policy"`
+	// The TLS settings for fetching the keys from internal identity providers.
+	TokenJwksCAFile             string `json:"token_jwks_ca_file,omitempty" xml:"token_jwks_ca_file" yaml:"token_jwks_ca_file"`
+	TokenJwksClientCert         string `json:"token_jwks_client_cert,omitempty" xml:"token_jwks_client_cert" yaml:"token_jwks_client_cert"`
+	TokenJwksClientKey          string `json:"token_jwks_client_key,omitempty" xml:"token_jwks_client_key" yaml:"token_jwks_client_key"`
+	TokenJwksInsecureSkipVerify bool   `json:"token_jwks_insecure_skip_verify,omitempty" xml:"token_jwks_insecure_skip_verify" yaml:"token_jwks_insecure_skip_verify"`
+	// The timeout of a request for the keys in seconds (default: 30), including reading the response.
+	TokenJwksHTTPTimeout int `json:"token_jwks_http_timeout,omitempty" xml:"token_jwks_http_timeout" yaml:"token_jwks_http_timeout"`
+	// The URL of the proxy for fetching the keys. When empty, the proxy is taken from
+	// HTTPS_PROXY, HTTP_PROXY, and NO_PROXY environment variables.
+	TokenJwksProxyURL string `json:"token_jwks_proxy_url,omitempty" xml:"token_jwks_proxy_url" yaml:"token_jwks_proxy_url"`
+	// The headers added to the requests for the keys, and the value of User-Agent header.
+	TokenJwksHeaders   map[string]string `json:"token_jwks_headers,omitempty" xml:"token_jwks_headers" yaml:"token_jwks_headers"`
+	TokenJwksUserAgent string            `json:"token_jwks_user_agent,omitempty" xml:"token_jwks_user_agent" yaml:"token_jwks_user_agent"`
+	// The credentials for fetching the keys from the endpoints requiring authentication,
+	// i.e. the bearer token, the file with the bearer token read for each request,
+	// the username and the password of basic authentication, or the API key sent
+	// in the header (default: X-Api-Key).
+	TokenJwksBearerToken       string `json:"token_jwks_bearer_token,omitempty" xml:"token_jwks_bearer_token" yaml:"token_jwks_bearer_token"`
+	TokenJwksBearerTokenFile   string `json:"token_jwks_bearer_token_file,omitempty" xml:"token_jwks_bearer_token_file" yaml:"token_jwks_bearer_token_file"`
+	TokenJwksBasicAuthUsername string `json:"token_jwks_basic_auth_username,omitempty" xml:"token_jwks_basic_auth_username" yaml:"token_jwks_basic_auth_username"`
+	TokenJwksBasicAuthPassword string `json:"token_jwks_basic_auth_password,omitempty" xml:"token_jwks_basic_auth_password" yaml:"token_jwks_basic_auth_password"`
+	TokenJwksAPIKey            string `json:"token_jwks_api_key,omitempty" xml:"token_jwks_api_key" yaml:"token_jwks_api_key"`
+	TokenJwksAPIKeyHeader      string `json:"token_jwks_api_key_header,omitempty" xml:"token_jwks_api_key_header" yaml:"token_jwks_api_key_header"`
+}
+
+// AWSConfig holds the settings for fetching the shared secret or the public
+// key from AWS Secrets Manager or AWS KMS.
+//
+// "token_aws_secret_id": "<secret id or arn>"
+// "token_aws_kms_key_id": "<key id, arn, or alias>"
+// "token_aws_region": "<region>"
+// "token_aws_refresh_interval": <seconds>
+//
+// The secret holds either a shared secret or a PEM-encoded public key. The
+// KMS key must be an asymmetric signing key. The credentials are provided by
+// the default AWS credential chain, e.g. environment variables or instance role.
+type AWSConfig struct {
+	TokenAWSSecretID string `json:"token_aws_secret_id,omitempty" xml:"token_aws_secret_id" yaml:"token_aws_secret_id"`
+	TokenAWSKMSKeyID string `json:"token_aws_kms_key_id,omitempty" xml:"token_aws_kms_key_id" yaml:"token_aws_kms_key_id"`
+	// The region of the services. When empty, the region of the default AWS configuration is used.
+	TokenAWSRegion string `json:"token_aws_region,omitempty" xml:"token_aws_region" yaml:"token_aws_region"`
+	// The interval between the refreshes of the secret and the key in seconds (default: 3600).
+	// A negative value disables the refreshes.
+	TokenAWSRefreshInterval int `json:"token_aws_refresh_interval,omitempty" xml:"token_aws_refresh_interval" yaml:"token_aws_refresh_interval"`
+}
+
+// AzureConfig holds the settings for fetching the shared secret or the public
+// key from Azure Key Vault.
+//
+// "token_azure_vault_url": "<url>"
+// "token_azure_secret_name": "<name>"
+// "token_azure_key_name": "<name>"
+// "token_azure_tenant_id": "<tenant id>"
+// "token_azure_client_id": "<client id>"
+// "token_azure_client_secret": "<client secret>"
+// "token_azure_refresh_interval": <seconds>
+//
+// With the client secret, the access token is obtained with the client credentials
+// of the application. Otherwise, the managed identity is used. The empty tenant id,
+// client id, and client secret are taken from AZURE_TENANT_ID, AZURE_CLIENT_ID,
+// and AZURE_CLIENT_SECRET environment variables.
+type AzureConfig struct {
+	TokenAzureVaultURL     string `json:"token_azure_vault_url,omitempty" xml:"token_azure_vault_url" yaml:"token_azure_vault_url"`
+	TokenAzureSecretName   string `json:"token_azure_secret_name,omitempty" xml:"token_azure_secret_name" yaml:"token_azure_secret_name"`
+	TokenAzureKeyName      string `json:"token_azure_key_name,omitempty" xml:"token_azure_key_name" yaml:"token_azure_key_name"`
+	TokenAzureTenantID     string `json:"token_azure_tenant_id,omitempty" xml:"token_azure_tenant_id" yaml:"token_azure_tenant_id"`
+	TokenAzureClientID     string `json:"token_azure_client_id,omitempty" xml:"token_azure_client_id" yaml:"token_azure_client_id"`
+	TokenAzureClientSecret string `json:"token_azure_client_secret,omitempty" xml:"token_azure_client_secret" yaml:"token_azure_client_secret"`
+	// The interval between the refreshes of the secret and the key in seconds (default: 3600).
+	// A negative value disables the refreshes.
+	TokenAzureRefreshInterval int `json:"token_azure_refresh_interval,omitempty" xml:"token_azure_refresh_interval" yaml:"token_azure_refresh_interval"`
+}
+
+// GCPConfig holds the settings for fetching the shared secret or the public
+// key from GCP Secret Manager.
+//
+// "token_gcp_secret": "projects/<project>/secrets/<secret>[/versions/<version>]"
+// "token_gcp_credentials_file": "<path>"
+// "token_gcp_refresh_interval": <seconds>
+//
+// Without the version, the latest version of the secret is used. When the credentials
+// file is not set, the application default credentials are used.
+type GCPConfig struct {
+	TokenGCPSecret          string `json:"token_gcp_secret,omitempty" xml:"token_gcp_secret" yaml:"token_gcp_secret"`
+	TokenGCPCredentialsFile string `json:"token_gcp_credentials_file,omitempty" xml:"token_gcp_credentials_file" yaml:"token_gcp_credentials_file"`
+	// The interval between the refreshes of the secret in seconds (default: 3600).
+	// A negative value disables the refreshes.
+	TokenGCPRefreshInterval int `json:"token_gcp_refresh_interval,omitempty" xml:"token_gcp_refresh_interval" yaml:"token_gcp_refresh_interval"`
+}
+
+// KubernetesConfig holds the settings for validating Kubernetes service account
+// tokens, e.g. projected into the pods of the cluster.
+//
+// "token_kubernetes_mode": "<jwks|token_review>"
+// "token_kubernetes_api_server": "<url>"
+// "token_kubernetes_ca_file": "<path>"
+// "token_kubernetes_token_file": "<path>"
+// "token_kubernetes_review_cache_ttl": <seconds>
+//
+// In jwks mode, the tokens are verified with the keys of the service account
+// issuer, i.e. <api server>/openid/v1/jwks. In token_review mode, the tokens are
+// verified by the API server with TokenReview API. The API server, the CA file,
+// and the token file default to the in-cluster service account configuration.
+type KubernetesConfig struct {
+	TokenKubernetesMode      string `json:"token_kubernetes_mode,omitempty" xml:"token_kubernetes_mode" yaml:"token_kubernetes_mode"`
+	TokenKubernetesAPIServer string `json:"token_kubernetes_api_server,omitempty" xml:"token_kubernetes_api_server" yaml:"token_kubernetes_api_server"`
+	// The CA certificates of the API server.
+	TokenKubernetesCAFile string `json:"token_kubernetes_ca_file,omitempty" xml:"token_kubernetes_ca_file" yaml:"token_kubernetes_ca_file"`
+	// The token authenticating the requests to the API server.
+	TokenKubernetesTokenFile string `json:"token_kubernetes_token_file,omitempty" xml:"token_kubernetes_token_file" yaml:"token_kubernetes_token_file"`
+	// The duration the results of the token reviews are cached for in
+	// seconds (default: 60). The tokens are not cached past their
+	// expiration. A negative value disables the cache.
+	TokenKubernetesReviewCacheTTL int `json:"token_kubernetes_review_cache_ttl,omitempty" xml:"token_kubernetes_review_cache_ttl" yaml:"token_kubernetes_review_cache_ttl"`
+}
+
+// IntrospectionConfig holds the settings for validating opaque tokens, i.e. the
+// tokens that are not JWTs, with OAuth 2.0 Token Introspection per RFC 7662.
+//
+// "token_introspection_endpoint": "<url>"
+// "token_introspection_client_id": "<id>"
+// "token_introspection_client_secret": "<secret>"
+// "token_introspection_cache_ttl": <seconds>
+//
+// The claims of the active tokens returned by the endpoint are used instead of
+// the claims of JWTs. The results are cached by the digest of the tokens.
+type IntrospectionConfig struct {
+	TokenIntrospectionEndpoint     string `json:"token_introspection_endpoint,omitempty" xml:"token_introspection_endpoint" yaml:"token_introspection_endpoint"`
+	TokenIntrospectionClientID     string `json:"token_introspection_client_id,omitempty" xml:"token_introspection_client_id" yaml:"token_introspection_client_id"`
+	TokenIntrospectionClientSecret string `json:"token_introspection_client_secret,omitempty" xml:"token_introspection_client_secret" yaml:"token_introspection_client_secret"`
+	// The duration the results are cached for in seconds (default: 60). The
+	// active tokens are not cached past their expiration. A negative value
+	// disables the cache.
+	TokenIntrospectionCacheTTL int `json:"token_introspection_cache_ttl,omitempty" xml:"token_introspection_cache_ttl" yaml:"token_introspection_cache_ttl"`
+}
+
+// DecryptionConfig holds the private key decrypting the encrypted tokens, i.e.
+// JWE wrapping a signed token. The key is either RSA key for RSA-OAEP and
+// RSA-OAEP-256, or ECDSA key for ECDH-ES and ECDH-ES+A256KW key management,
+// with A256GCM content encryption.
+//
+// "token_decryption_key": "<PEM>"
+// "token_decryption_key_file": "<path>"
+//
+// The nested token is verified with the keys of the trusted tokens.
+type DecryptionConfig struct {
+	TokenDecryptionKey     string `json:"token_decryption_key,omitempty" xml:"token_decryption_key" yaml:"token_decryption_key"`
+	TokenDecryptionKeyFile string `json:"token_decryption_key_file,omitempty" xml:"token_decryption_key_file" yaml:"token_decryption_key_file"`
+}
+
+// BackendModuleConfig holds the settings of the token backend provided by a
+// Caddy module, e.g. registered by a third-party plugin for a hardware security
+// module or a custom key store. The modules are in the
+// http.authentication.providers.jwt.backends namespace.
+//
+// "token_backend": {"backend": "<module name>", ...}
+// "token_backend_refresh_interval": <seconds>
+//
+// The module either provides the keys for the tokens directly, i.e. implements
+// TokenBackend, or provides key material, i.e. implements KeySource. The key
+// material is refreshed like the key material of the cloud secret stores.
+type BackendModuleConfig struct {
+	TokenBackendRaw json.RawMessage `json:"token_backend,omitempty" xml:"token_backend" yaml:"token_backend" caddy:"namespace=http.authentication.providers.jwt.backends inline_key=backend"`
+	// The interval between the refreshes of the key material in seconds (default: 3600).
+	// A negative value disables the refreshes.
+	TokenBackendRefreshInterval int `json:"token_backend_refresh_interval,omitempty" xml:"token_backend_refresh_interval" yaml:"token_backend_refresh_interval"`
+	// TokenBackend is the module loaded from TokenBackendRaw.
+	TokenBackend interface{} `json:"-" xml:"-" yaml:"-"`
 }
 
 // EnvTokenRSADir the env variable used to indicate a directory
@@ -146,6 +479,183 @@ func (c *CommonTokenConfig) HasRSAKeys() bool {
 	return false
 }
 
+// HasECDSAKeys returns true if the configuration has ECDSA keys and files
+func (c *CommonTokenConfig) HasECDSAKeys() bool {
+	if c.TokenECDSADir != "" {
+		return true
+	}
+	if c.TokenECDSAFile != "" {
+		return true
+	}
+	if c.TokenECDSAKey != "" {
+		return true
+	}
+	if c.TokenECDSAFiles != nil {
+		return true
+	}
+	if c.TokenECDSAKeys != nil {
+		return true
+	}
+	return false
+}
+
+// HasEdDSAKeys returns true if the configuration has Ed25519 keys and files
+func (c *CommonTokenConfig) HasEdDSAKeys() bool {
+	if c.TokenEdDSADir != "" {
+		return true
+	}
+	if c.TokenEdDSAFile != "" {
+		return true
+	}
+	if c.TokenEdDSAKey != "" {
+		return true
+	}
+	if c.TokenEdDSAFiles != nil {
+		return true
+	}
+	if c.TokenEdDSAKeys != nil {
+		return true
+	}
+	return false
+}
+
+// FirebaseJwksURI is the JSON Web Key Set of the keys signing Firebase ID tokens.
+const FirebaseJwksURI = "https://www.googleapis.com/service_accounts/v1/jwk/securetoken@system.gserviceaccount.com"
+
+// FirebaseIssuerPrefix is the prefix of the issuer of Firebase ID tokens. The
+// issuer is the prefix followed by the project id.
+const FirebaseIssuerPrefix = "https://securetoken.google.com/"
+
+// HasJwksKeys returns true if the configuration has JWKS key source.
+func (c *CommonTokenConfig) HasJwksKeys() bool {
+	return c.TokenJwksURI != "" || c.TokenOIDCIssuer != "" || c.TokenFirebaseProjectID != ""
+}
+
+// ApplyFirebaseProject sets the key set, the issuer, and the audience of the
+// ID tokens of the Firebase project, unless they are set already.
+func (c *CommonTokenConfig) ApplyFirebaseProject() {
+	if c.TokenFirebaseProjectID == "" {
+		return
+	}
+	if c.TokenJwksURI == "" && c.TokenOIDCIssuer == "" {
+		c.TokenJwksURI = FirebaseJwksURI
+	}
+	issuer := FirebaseIssuerPrefix + c.TokenFirebaseProjectID
+	if !containsString(c.TokenIssuers, issuer) {
+		c.TokenIssuers = append(c.TokenIssuers, issuer)
+	}
+	if !containsString(c.TokenAudience, c.TokenFirebaseProjectID) {
+		c.TokenAudience = append(c.TokenAudience, c.TokenFirebaseProjectID)
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAWSKeys returns true if the configuration has AWS key source.
+func (c *CommonTokenConfig) HasAWSKeys() bool {
+	return c.TokenAWSSecretID != "" || c.TokenAWSKMSKeyID != ""
+}
+
+// HasAzureKeys returns true if the configuration has Azure Key Vault key source.
+func (c *CommonTokenConfig) HasAzureKeys() bool {
+	return c.TokenAzureSecretName != "" || c.TokenAzureKeyName != ""
+}
+
+// HasGCPKeys returns true if the configuration has GCP Secret Manager key source.
+func (c *CommonTokenConfig) HasGCPKeys() bool {
+	return c.TokenGCPSecret != ""
+}
+
+// HasKubernetesKeys returns true if the configuration has Kubernetes service
+// account token source.
+func (c *CommonTokenConfig) HasKubernetesKeys() bool {
+	return c.TokenKubernetesMode != "" || c.TokenKubernetesAPIServer != ""
+}
+
+// HasIntrospection returns true if the configuration has token introspection
+// endpoint.
+func (c *CommonTokenConfig) HasIntrospection() bool {
+	return c.TokenIntrospectionEndpoint != ""
+}
+
+// HasDecryptionKey returns true if the configuration has the key decrypting
+// encrypted tokens.
+func (c *CommonTokenConfig) HasDecryptionKey() bool {
+	return c.TokenDecryptionKey != "" || c.TokenDecryptionKeyFile != ""
+}
+
+// HasBackendModule returns true if the configuration has token backend module.
+func (c *CommonTokenConfig) HasBackendModule() bool {
+	return c.TokenBackend != nil || len(c.TokenBackendRaw) > 0
+}
+
+// GetIssuers returns the issuers of the tokens, i.e. token_issuer and
+// token_issuers.
+func (c *CommonTokenConfig) GetIssuers() []string {
+	issuers := []string{}
+	if c.TokenIssuer != "" {
+		issuers = append(issuers, c.TokenIssuer)
+	}
+	return append(issuers, c.TokenIssuers...)
+}
+
+// HasVerificationKeys returns true if the configuration has any source of
+// public keys for token verification.
+func (c *CommonTokenConfig) HasVerificationKeys() bool {
+	return c.HasRSAKeys() || c.HasECDSAKeys() || c.HasEdDSAKeys() || c.HasJwksKeys() ||
+		c.TokenJwksFile != "" || c.TokenJwks != "" || c.HasAWSKeys() || c.HasAzureKeys() ||
+		c.HasGCPKeys() || c.HasKubernetesKeys() || c.HasIntrospection() || c.HasBackendModule()
+}
+
+// ReplacePlaceholders runs the secrets, the keys, the paths of the key files,
+// the locations of the key sets, and the issuers through the replace function,
+// e.g. to expand {env.JWT_SHARED_KEY} placeholders. The inline JSON Web Key Set is
+// left unchanged, because JSON documents contain braces.
+func (c *CommonTokenConfig) ReplacePlaceholders(replace func(string) string) {
+	for _, v := range []*string{
+		&c.TokenSecret, &c.TokenSecretFile,
+		&c.TokenRSADir, &c.TokenRSAFile, &c.TokenRSAKey,
+		&c.TokenECDSADir, &c.TokenECDSAFile, &c.TokenECDSAKey,
+		&c.TokenEdDSADir, &c.TokenEdDSAFile, &c.TokenEdDSAKey,
+		&c.TokenJwksURI, &c.TokenOIDCIssuer, &c.TokenFirebaseProjectID, &c.TokenJwksFile, &c.TokenJwksX5cCAFile,
+		&c.TokenJwksCAFile, &c.TokenJwksClientCert, &c.TokenJwksClientKey, &c.TokenJwksProxyURL,
+		&c.TokenJwksBearerToken, &c.TokenJwksBearerTokenFile, &c.TokenJwksBasicAuthUsername,
+		&c.TokenJwksBasicAuthPassword, &c.TokenJwksAPIKey,
+		&c.TokenAWSSecretID, &c.TokenAWSKMSKeyID, &c.TokenAWSRegion,
+		&c.TokenAzureVaultURL, &c.TokenAzureSecretName, &c.TokenAzureKeyName,
+		&c.TokenAzureTenantID, &c.TokenAzureClientID, &c.TokenAzureClientSecret,
+		&c.TokenGCPSecret, &c.TokenGCPCredentialsFile,
+		&c.TokenKubernetesAPIServer, &c.TokenKubernetesCAFile, &c.TokenKubernetesTokenFile,
+		&c.TokenIntrospectionEndpoint, &c.TokenIntrospectionClientID, &c.TokenIntrospectionClientSecret,
+		&c.TokenDecryptionKey, &c.TokenDecryptionKeyFile,
+		&c.TokenIssuer,
+	} {
+		if *v != "" {
+			*v = replace(*v)
+		}
+	}
+	for i, v := range c.TokenIssuers {
+		c.TokenIssuers[i] = replace(v)
+	}
+	for _, m := range []map[string]string{
+		c.TokenRSAFiles, c.TokenRSAKeys,
+		c.TokenECDSAFiles, c.TokenECDSAKeys,
+		c.TokenEdDSAFiles, c.TokenEdDSAKeys,
+		c.TokenJwksHeaders,
+	} {
+		for k, v := range m {
+			m[k] = replace(v)
+		}
+	}
+}
+
 // NewCommonTokenConfig returns an instance of CommonTokenConfig.
 func NewCommonTokenConfig() *CommonTokenConfig {
 	return &CommonTokenConfig{
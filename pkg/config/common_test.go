@@ -0,0 +1,44 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReplacePlaceholders(t *testing.T) {
+	replace := func(s string) string {
+		return strings.ReplaceAll(s, "{env.REALM}", "acme")
+	}
+	c := NewCommonTokenConfig()
+	c.TokenSecret = "{env.REALM}-secret"
+	c.TokenRSAFiles = map[string]string{"k1": "/etc/keys/{env.REALM}.pem"}
+	c.TokenIssuer = "https://{env.REALM}.example.com"
+	c.TokenIssuers = []string{"https://auth.{env.REALM}.example.com"}
+	c.TokenJwks = `{"keys":[]}`
+	c.ReplacePlaceholders(replace)
+
+	expected := NewCommonTokenConfig()
+	expected.TokenSecret = "acme-secret"
+	expected.TokenRSAFiles = map[string]string{"k1": "/etc/keys/acme.pem"}
+	expected.TokenIssuer = "https://acme.example.com"
+	expected.TokenIssuers = []string{"https://auth.acme.example.com"}
+	expected.TokenJwks = `{"keys":[]}`
+	if !reflect.DeepEqual(c, expected) {
+		t.Fatalf("unexpected config: %+v, expected: %+v", c, expected)
+	}
+}
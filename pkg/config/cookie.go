@@ -0,0 +1,50 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// SessionCookieConfig holds the settings of the cookie bridging the tokens in
+// Authorization header to the browser sessions. After the token in the header
+// is validated, the token is set in the Secure and HttpOnly cookie, so that
+// the navigation requests of the browser are authorized without the scripts
+// adding the header.
+type SessionCookieConfig struct {
+	// The name of the cookie, by default access_token. The name must be one
+	// of the cookies holding the tokens.
+	Name   string `json:"name,omitempty" xml:"name" yaml:"name"`
+	Domain string `json:"domain,omitempty" xml:"domain" yaml:"domain"`
+	// The path of the cookie, by default /.
+	Path string `json:"path,omitempty" xml:"path" yaml:"path"`
+	// The lifetime of the cookie, in seconds. The cookie does not outlive
+	// the token. When zero, the cookie expires with the token.
+	Lifetime int `json:"lifetime,omitempty" xml:"lifetime" yaml:"lifetime"`
+	// The SameSite attribute of the cookie, i.e. lax, strict, or none, by
+	// default lax.
+	SameSite string `json:"same_site,omitempty" xml:"same_site" yaml:"same_site"`
+	// When enabled, the cookie holds the token reissued with the internal
+	// key, rather than the token in the header.
+	Reissue bool `json:"reissue,omitempty" xml:"reissue" yaml:"reissue"`
+	// When the token in the cookie expires within the window, in seconds,
+	// the cookie is renewed with the token reissued with the internal key,
+	// so that the active users are not sent to the portal mid-session.
+	RenewWindow int `json:"renew_window,omitempty" xml:"renew_window" yaml:"renew_window"`
+}
+
+// GetName returns the name of the cookie.
+func (c *SessionCookieConfig) GetName() string {
+	if c.Name == "" {
+		return "access_token"
+	}
+	return c.Name
+}
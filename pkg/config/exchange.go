@@ -0,0 +1,42 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// TokenExchangeConfig holds the settings of exchanging the validated tokens,
+// per OAuth 2.0 Token Exchange, RFC 8693, for the tokens passed to the
+// upstreams, e.g. with the audience of the upstream.
+type TokenExchangeConfig struct {
+	// The token endpoint of the authorization server.
+	Endpoint string `json:"endpoint,omitempty" xml:"endpoint" yaml:"endpoint"`
+	// The client credentials authenticating the requests.
+	ClientID     string `json:"client_id,omitempty" xml:"client_id" yaml:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty" xml:"client_secret" yaml:"client_secret"`
+	// The audiences, the resources, and the scopes of the exchanged tokens.
+	Audience []string `json:"audience,omitempty" xml:"audience" yaml:"audience"`
+	Resource []string `json:"resource,omitempty" xml:"resource" yaml:"resource"`
+	Scope    []string `json:"scope,omitempty" xml:"scope" yaml:"scope"`
+	// The types of the validated and the exchanged tokens, e.g.
+	// urn:ietf:params:oauth:token-type:jwt. By default, the validated tokens
+	// are access tokens, and the authorization server selects the type of
+	// the exchanged tokens.
+	SubjectTokenType   string `json:"subject_token_type,omitempty" xml:"subject_token_type" yaml:"subject_token_type"`
+	RequestedTokenType string `json:"requested_token_type,omitempty" xml:"requested_token_type" yaml:"requested_token_type"`
+	// The duration, in seconds, the exchanged tokens are cached for, by
+	// default 300 seconds. Negative value disables the cache.
+	CacheTTL int `json:"cache_ttl,omitempty" xml:"cache_ttl" yaml:"cache_ttl"`
+	// The header passing the exchanged tokens, by default Authorization with
+	// Bearer scheme. The other headers pass the tokens without the scheme.
+	Header string `json:"header,omitempty" xml:"header" yaml:"header"`
+}
@@ -22,7 +22,11 @@ var SigningMethods = map[string]struct{}{
 	"RS256": {},
 	"RS384": {},
 	"RS512": {},
-	//"ES256": true,
-	//"ES384": true,
-	//"ES512": true,
+	"PS256": {},
+	"PS384": {},
+	"PS512": {},
+	"ES256": {},
+	"ES384": {},
+	"ES512": {},
+	"EdDSA": {},
 }
@@ -0,0 +1,41 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// RedisConfig holds the settings of Redis server shared by Caddy instances,
+// e.g. for the revoked tokens and the single-use tokens.
+type RedisConfig struct {
+	// The host and port of the server, e.g. redis.example.com:6379.
+	Address string `json:"address,omitempty" xml:"address" yaml:"address"`
+	// The credentials of the server. Without the username, the password is
+	// sent with legacy AUTH command.
+	Username string `json:"username,omitempty" xml:"username" yaml:"username"`
+	Password string `json:"password,omitempty" xml:"password" yaml:"password"`
+	DB       int    `json:"db,omitempty" xml:"db" yaml:"db"`
+	// When enabled, the connections to the server use TLS. The certificate of
+	// the server is verified with the CA certificates in the PEM file, or
+	// with the system roots.
+	TLS                   bool   `json:"tls,omitempty" xml:"tls" yaml:"tls"`
+	TLSServerName         string `json:"tls_server_name,omitempty" xml:"tls_server_name" yaml:"tls_server_name"`
+	TLSCAFile             string `json:"tls_ca_file,omitempty" xml:"tls_ca_file" yaml:"tls_ca_file"`
+	TLSInsecureSkipVerify bool   `json:"tls_insecure_skip_verify,omitempty" xml:"tls_insecure_skip_verify" yaml:"tls_insecure_skip_verify"`
+	// The prefix of the keys, e.g. caddy:jwt:, separating the keys of
+	// several deployments sharing the server.
+	KeyPrefix string `json:"key_prefix,omitempty" xml:"key_prefix" yaml:"key_prefix"`
+	// The channel of the revocation events. When set, the revoked tokens and
+	// subjects of the events published on the channel are kept in memory,
+	// instead of being looked up in Redis for each token.
+	RevocationChannel string `json:"revocation_channel,omitempty" xml:"revocation_channel" yaml:"revocation_channel"`
+}
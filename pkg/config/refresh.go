@@ -0,0 +1,42 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// TokenRefreshConfig holds the settings of renewing the expired access tokens
+// with the refresh tokens. When the request has no valid access token, but
+// has the refresh token cookie, the new access token is obtained from the
+// token endpoint of the authorization server, and the request continues with
+// the new token, instead of being redirected to the portal.
+type TokenRefreshConfig struct {
+	// The token endpoint of the authorization server.
+	Endpoint string `json:"endpoint,omitempty" xml:"endpoint" yaml:"endpoint"`
+	// The client credentials authenticating the requests.
+	ClientID     string `json:"client_id,omitempty" xml:"client_id" yaml:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty" xml:"client_secret" yaml:"client_secret"`
+	// The scopes of the new access tokens. When empty, the scopes of the
+	// refresh tokens are kept.
+	Scope []string `json:"scope,omitempty" xml:"scope" yaml:"scope"`
+	// The name of the cookie holding the refresh token, by default
+	// refresh_token.
+	Cookie string `json:"cookie,omitempty" xml:"cookie" yaml:"cookie"`
+}
+
+// GetCookieName returns the name of the cookie holding the refresh token.
+func (c *TokenRefreshConfig) GetCookieName() string {
+	if c.Cookie == "" {
+		return "refresh_token"
+	}
+	return c.Cookie
+}
@@ -0,0 +1,45 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// ReissueConfig holds the settings of the internal tokens reissued for the
+// upstreams. After the token is validated, its claims are signed with the
+// internal key, so that the upstreams trust only the key, instead of the keys
+// of every identity provider.
+type ReissueConfig struct {
+	// The signing method, e.g. RS256. When empty, the method is derived from
+	// the key, i.e. HS256 for the secret, RS256 for RSA key, ES256, ES384, or
+	// ES512 for ECDSA key, and EdDSA for Ed25519 key.
+	Method string `json:"method,omitempty" xml:"method" yaml:"method"`
+	// The shared secret or the path to the PEM encoded private key.
+	Secret  string `json:"secret,omitempty" xml:"secret" yaml:"secret"`
+	KeyFile string `json:"key_file,omitempty" xml:"key_file" yaml:"key_file"`
+	// The kid header of the tokens.
+	KeyID string `json:"key_id,omitempty" xml:"key_id" yaml:"key_id"`
+	// The iss and aud claims of the tokens. When empty, the claims of the
+	// original token are kept.
+	Issuer   string   `json:"issuer,omitempty" xml:"issuer" yaml:"issuer"`
+	Audience []string `json:"audience,omitempty" xml:"audience" yaml:"audience"`
+	// The lifetime of the tokens, in seconds, by default 60 seconds.
+	TTL int `json:"ttl,omitempty" xml:"ttl" yaml:"ttl"`
+	// The claims of the original token copied to the tokens. When empty, all
+	// claims are copied. The excluded claims, e.g. address, are not copied.
+	// The sub claim is always copied.
+	Claims        []string `json:"claims,omitempty" xml:"claims" yaml:"claims"`
+	ExcludeClaims []string `json:"exclude_claims,omitempty" xml:"exclude_claims" yaml:"exclude_claims"`
+	// The header passing the tokens, by default Authorization with Bearer
+	// scheme. The other headers pass the tokens without the scheme.
+	Header string `json:"header,omitempty" xml:"header" yaml:"header"`
+}
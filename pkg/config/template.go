@@ -0,0 +1,41 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// DenyTemplate is the template of the response to the requests denied
+// access, in place of the built-in response. The template is either in the
+// file or inline.
+type DenyTemplate struct {
+	// The status of the response, i.e. 401 or 403.
+	Status int `json:"status"`
+	// The format of the response, i.e. html for the browser navigations, or
+	// json for the scripts and the API clients.
+	Format   string `json:"format"`
+	File     string `json:"file,omitempty"`
+	Template string `json:"template,omitempty"`
+}
+
+// DenyStatus is the status of the response to the requests denied access, in
+// place of 401 or 403.
+type DenyStatus struct {
+	// The denial, i.e. unauthorized for the requests without a valid token,
+	// or forbidden for the valid tokens not allowed by the access list.
+	Denial string `json:"denial"`
+	// The status, e.g. 404 hiding the existence of the paths.
+	Status int `json:"status"`
+	// The paths, e.g. /admin/**, of the requests. When empty, the status
+	// applies to any path.
+	Paths []string `json:"paths,omitempty"`
+}
@@ -23,9 +23,44 @@ type TokenValidatorOptions struct {
     ValidateSourceAddress       bool
     SourceAddress               string
     ValidateBearerHeader        bool
+    // The authentication schemes of Authorization header accepted, in
+    // addition to Bearer, e.g. JWT, when validating bearer header. The
+    // schemes are case-insensitive.
+    BearerSchemes               []string
     ValidateMethodPath          bool
     ValidateAccessListPathClaim bool
     ValidateAllowMatchAll       bool
+    ValidateStrict              bool
+    ValidateRequireExpiration   bool
+    // The leeway in seconds applied to exp, nbf, and iat claims, for the clocks
+    // of the token issuer and of the server being slightly off.
+    ClockSkew                   int
+    // The grace period in seconds during which the expired tokens are still
+    // accepted, e.g. while the clients refresh them. The responses to the
+    // requests with the tokens have X-Token-Stale header.
+    ExpiryGracePeriod           int
+    // The maximum age in seconds of the tokens, per iat claim, regardless of
+    // their exp claim.
+    MaxTokenLifetime            int
+    // When enabled, the tokens in Authorization header with DPoP scheme are
+    // bound to the key of the DPoP proof per RFC 9449.
+    ValidateDPoP                bool
+    // The maximum age in seconds of DPoP proofs, per iat claim.
+    DPoPMaxAge                  int
+    // When enabled, the tokens bound to client certificates, i.e. with
+    // cnf.x5t#S256 claim, are accepted only over mutual TLS connections
+    // authenticated with the certificates, per RFC 8705.
+    ValidateCertificateBinding  bool
+    // When enabled, the tokens found in the token sources, and in the values
+    // of Authorization header, are tried in order, and the first valid token
+    // is authorized.
+    ValidateFirstValidToken     bool
+    // The limits of the size of the tokens in bytes, of the number of the
+    // claims, and of the nesting depth of the header and the claims, checked
+    // before the tokens are parsed. Zero disables the limit.
+    MaxTokenSize                int
+    MaxTokenClaims              int
+    MaxTokenDepth               int
 
     Metadata                    map[string]interface{}
     Logger                      *zap.Logger
@@ -45,9 +80,22 @@ func (opts *TokenValidatorOptions) Clone() *TokenValidatorOptions {
     clonedOpts := &TokenValidatorOptions{
         ValidateSourceAddress:       opts.ValidateSourceAddress,
         ValidateBearerHeader:        opts.ValidateBearerHeader,
+        BearerSchemes:               opts.BearerSchemes,
         ValidateMethodPath:          opts.ValidateMethodPath,
         ValidateAccessListPathClaim: opts.ValidateAccessListPathClaim,
         ValidateAllowMatchAll:       opts.ValidateAllowMatchAll,
+        ValidateStrict:              opts.ValidateStrict,
+        ValidateRequireExpiration:   opts.ValidateRequireExpiration,
+        ClockSkew:                   opts.ClockSkew,
+        ExpiryGracePeriod:           opts.ExpiryGracePeriod,
+        MaxTokenLifetime:            opts.MaxTokenLifetime,
+        ValidateDPoP:                opts.ValidateDPoP,
+        DPoPMaxAge:                  opts.DPoPMaxAge,
+        ValidateCertificateBinding:  opts.ValidateCertificateBinding,
+        ValidateFirstValidToken:     opts.ValidateFirstValidToken,
+        MaxTokenSize:                opts.MaxTokenSize,
+        MaxTokenClaims:              opts.MaxTokenClaims,
+        MaxTokenDepth:               opts.MaxTokenDepth,
         Metadata:                    make(map[string]interface{}),
         Logger:                      opts.Logger,
     }
@@ -19,6 +19,75 @@ const (
 	ErrInvalidSecretLength StandardError = "secrets less than 16 characters in length are not allowed"
 	ErrUnexpectedKID       StandardError = "the kid specified in the header was not found"
 	ErrNoRSAKeyFound       StandardError = "no RSA key found"
+	ErrNoECDSAKeyFound     StandardError = "no ECDSA key found"
+	ErrNoEdDSAKeyFound     StandardError = "no EdDSA key found"
 
-	ErrUnexpectedSigningMethod StandardError = "signing method mismatch: %v (expected) vs. %v (received)"
+	ErrJwksFetch                StandardError = "failed fetching jwks keys from %s: %v"
+	ErrJwksFileRead             StandardError = "failed reading jwks file %s: %v"
+	ErrJwksInlineMalformed      StandardError = "inline jwks is neither json nor base64-encoded json"
+	ErrJwksKeySetMalformed      StandardError = "malformed jwks key set: %v"
+	ErrJwksKeySetEmpty          StandardError = "jwks key set has no keys"
+	ErrJwksKeyMalformed         StandardError = "malformed jwks key %q: %v"
+	ErrJwksKeyTypeUnsupported   StandardError = "unsupported jwks key type %q for key %q"
+	ErrJwksKeyCurveUnsupported  StandardError = "unsupported jwks key curve %q for key %q"
+	ErrJwksKeyChainVerification StandardError = "failed verifying x5c certificate chain of jwks key %q: %v"
+	ErrJwksUnavailable          StandardError = "no jwks keys from %s, the last fetch failed: %v"
+
+	ErrOIDCDiscoveryFetch     StandardError = "failed fetching openid configuration from %s: %v"
+	ErrOIDCDiscoveryMalformed StandardError = "malformed openid configuration from %s: %v"
+	ErrOIDCDiscoveryNoJwksURI StandardError = "openid configuration from %s has no jwks_uri"
+	ErrOIDCIssuerMismatch     StandardError = "openid configuration issuer %q does not match %q"
+
+	ErrKeyReloadEmpty StandardError = "reloaded key files have no keys"
+
+	ErrKeySourceUnavailable        StandardError = "no keys from key source, the last fetch failed: %v"
+	ErrKeySourceEmptySecret        StandardError = "secret %s is empty"
+	ErrKeySourceKeyMalformed       StandardError = "malformed key in secret %s: %v"
+	ErrKeySourceNotPEM             StandardError = "key is not PEM-encoded"
+	ErrKeySourcePEMTypeUnsupported StandardError = "unsupported PEM block type %q"
+	ErrKeySourceKeyTypeUnsupported StandardError = "unsupported key type %T"
+
+	ErrAWSSession     StandardError = "failed creating aws session: %v"
+	ErrAWSSecretFetch StandardError = "failed fetching aws secret %s: %v"
+	ErrAWSKMSFetch    StandardError = "failed fetching public key of aws kms key %s: %v"
+	ErrAWSKMSKeyUsage StandardError = "aws kms key %s is not a signing key, key usage: %s"
+
+	ErrAzureNoVaultURL  StandardError = "azure key vault url is not configured"
+	ErrAzureNoTenantID  StandardError = "azure client secret requires tenant id"
+	ErrAzureAccessToken StandardError = "failed obtaining azure access token: %v"
+	ErrAzureSecretFetch StandardError = "failed fetching azure key vault secret %s: %v"
+	ErrAzureKeyFetch    StandardError = "failed fetching azure key vault key %s: %v"
+	ErrAzureResponse    StandardError = "unexpected response status %s: %s"
+
+	ErrGCPSecretName  StandardError = "malformed gcp secret name %q, expected projects/<project>/secrets/<secret>[/versions/<version>]"
+	ErrGCPCredentials StandardError = "failed loading gcp credentials: %v"
+	ErrGCPSecretFetch StandardError = "failed fetching gcp secret %s: %v"
+
+	ErrKubernetesMode            StandardError = "unsupported kubernetes token validation mode: %s"
+	ErrKubernetesTokenReview     StandardError = "failed reviewing kubernetes token: %v"
+	ErrKubernetesTokenRejected   StandardError = "kubernetes token review rejected token: %s"
+	ErrKubernetesTokenReviewOnly StandardError = "kubernetes token review backend does not provide keys"
+
+	ErrIntrospection         StandardError = "failed introspecting token: %v"
+	ErrIntrospectionInactive StandardError = "introspected token is not active"
+	ErrIntrospectionOnly     StandardError = "token introspection backend does not provide keys"
+
+	ErrTokenExchange        StandardError = "failed exchanging token: %v"
+	ErrTokenExchangeNoToken StandardError = "token exchange response has no access token"
+
+	ErrTokenRefresh        StandardError = "failed refreshing token: %v"
+	ErrTokenRefreshNoToken StandardError = "token refresh response has no access token"
+
+	ErrBackendModuleLoad StandardError = "failed loading token backend module: %v"
+	ErrBackendModuleType StandardError = "token backend module %T provides neither keys nor key material"
+
+	ErrTLSCAFile            StandardError = "failed loading ca file %s: %v"
+	ErrTLSClientCert        StandardError = "failed loading client certificate: %v"
+	ErrTLSClientCertKeyPair StandardError = "client certificate requires both certificate and key files"
+	ErrHTTPProxyURL         StandardError = "invalid proxy url %s: %v"
+	ErrHTTPCredentials      StandardError = "bearer token, bearer token file, and basic auth are mutually exclusive"
+
+	ErrUnexpectedSigningMethod     StandardError = "signing method mismatch: %v (expected) vs. %v (received)"
+	ErrUnsupportedRSAPSSMethod     StandardError = "unsupported RSA-PSS signing method: %s"
+	ErrUnsupportedAllowedAlgorithm StandardError = "unsupported signing algorithm in allowed_algs: %s"
 )
@@ -24,26 +24,48 @@ const (
 	ErrEmptyValue                  StandardError = "empty value"
 	ErrNoValues                    StandardError = "no acl.Values"
 	ErrUnsupportedACLAction        StandardError = "unsupported access list action: %s"
-	ErrUnsupportedClaim            StandardError = "access list does not support %s claim, only audiences, roles, scopes"
+	ErrUnsupportedClaim            StandardError = "access list does not support %s claim, only audiences, permissions, roles, scopes, GitHub Actions, and nested claims"
 	ErrUnsupportedMethod           StandardError = "unsupported http method: %s"
+	ErrACLRequireWithDeny          StandardError = "access list deny action does not support authentication context requirements"
 	ErrKeyIDNotFound               StandardError = "key ID not found"
 	ErrUnsupportedKeyType          StandardError = "unsupported key type %T for key ID %s"
 	ErrRSAKeysNotFound             StandardError = "no RSA keys found"
 	ErrEmptySecret                 StandardError = "grantor token secret not configured"
 	ErrNoClaims                    StandardError = "provided claims are nil"
 	ErrUnsupportedSigningMethod    StandardError = "grantor does not support %s token signing method"
+	ErrReissueNoKey                StandardError = "reissue: secret or key file must be configured"
+	ErrReissueKeyFile              StandardError = "reissue: failed loading key file %s: %v"
+	ErrReissueMethodMismatch       StandardError = "reissue: %s token signing method does not match the key"
+	ErrDenyTemplateStatus          StandardError = "deny template: unsupported status %d, expected 401 or 403"
+	ErrDenyTemplateFormat          StandardError = "deny template: unsupported format %s, expected html or json"
+	ErrDenyTemplateNoSource        StandardError = "deny template: %d %s template requires either file or inline template"
+	ErrDenyTemplate                StandardError = "deny template: failed loading %d %s template: %v"
+	ErrDenyStatusDenial            StandardError = "%s: unsupported deny status denial %s, expected unauthorized or forbidden"
+	ErrDenyStatusCode              StandardError = "%s: unsupported deny status %d, expected 4xx status"
+	ErrRedirectEncoding            StandardError = "%s: unsupported redirect encoding %s, expected query or base64url"
+	ErrAuthMode                    StandardError = "%s: unsupported mode %s, expected required or optional"
+	ErrExemptMatcherLoad           StandardError = "failed loading exempt matchers: %v"
 	ErrUnknownConfigSource         StandardError = "sig key config source is not found"
 	ErrReadPEMFile                 StandardError = "(source: %s): read PEM file: %v"
 	ErrWalkDir                     StandardError = "walking directory: %v"
+	ErrReadSecretFile              StandardError = "failed reading secret file %s: %v"
+	ErrEmptySecretFile             StandardError = "secret file %s is empty"
 	ErrProvisonFailed              StandardError = "authorization provider provisioning error"
 	ErrEmptyProviderName           StandardError = "authorization provider name is empty"
 	ErrNoMemberReference           StandardError = "no member reference found"
 	ErrTooManyPrimaryInstances     StandardError = "found more than one primaryInstance instance of the plugin for %s context"
 	ErrUndefinedSecret             StandardError = "%s: token keys and secrets must be defined either via environment variables or via token_ configuration element"
+	ErrInvalidSecretFile           StandardError = "%s: token secret file error: %s"
 	ErrInvalidConfiguration        StandardError = "%s: default access list configuration error: %s"
 	ErrUnsupportedSignatureMethod  StandardError = "%s: unsupported token sign/verify method: %s"
 	ErrUnsupportedTokenSource      StandardError = "%s: unsupported token source: %s"
 	ErrInvalidBackendConfiguration StandardError = "%s: token validator configuration error: %s"
+	ErrInvalidReissueConfiguration StandardError = "%s: token reissue configuration error: %s"
+	ErrInvalidDenyTemplates        StandardError = "%s: deny template configuration error: %s"
+	ErrSessionCookieName           StandardError = "%s: session cookie %s is not a token cookie"
+	ErrSessionCookieReissue        StandardError = "%s: session cookie with reissued token requires reissue configuration"
+	ErrSessionCookieRenewal        StandardError = "%s: session cookie renewal requires reissue configuration"
+	ErrSessionCookieSameSite       StandardError = "%s: unsupported session cookie same_site value: %s"
 	ErrUnknownProvider             StandardError = "authorization provider %s not found"
 	ErrInvalidProvider             StandardError = "authorization provider %s is nil"
 	ErrNoPrimaryInstanceProvider   StandardError = "no primaryInstance authorization provider found in %s context when configuring %s"
@@ -78,14 +100,95 @@ const (
 	ErrSharedSigningKeyNotFound    StandardError = "shared secret for signing not found"
 	ErrPrivateSigningKeyNotFound   StandardError = "private key for signing not found"
 	ErrNoBackends                  StandardError = "no token backends available"
+	ErrNoIssuerBackends            StandardError = "no token backends for issuer %q"
+	ErrUnsupportedIssuerRouting    StandardError = "unsupported issuer routing mode: %s"
+	ErrUnsupportedProvider         StandardError = "unsupported identity provider preset: %s"
+	ErrUnsupportedTokenUse         StandardError = "unsupported token use: %s"
+	ErrTokenUseNotFound            StandardError = "token_use claim not found"
+	ErrTokenUseMismatch            StandardError = "token use mismatch: %v (expected) vs. %v (received)"
 	ErrExpiredToken                StandardError = "expired token"
+	ErrUnsupportedClaimTransform   StandardError = "unsupported claim transform: %s"
+	ErrClaimTransformNoValue       StandardError = "claim transform %s has no value"
+	ErrRequiredClaimNotFound       StandardError = "required claim %s not found"
+	ErrRequiredClaimMismatch       StandardError = "required claim %s value %v is not one of %v"
+	ErrExpirationNotFound          StandardError = "expiration is required, but no exp claim found"
+	ErrIssuedAtNotFound            StandardError = "token lifetime is limited, but no iat claim found"
+	ErrTokenLifetimeExceeded       StandardError = "token issued %s ago exceeds maximum lifetime of %s"
+	ErrIssuerNotAllowed            StandardError = "issuer %q is not allowed"
+	ErrAudienceNotFound            StandardError = "aud claim not found, expected %v"
+	ErrHostedDomainNotFound        StandardError = "hd claim not found, expected %v"
+	ErrHostedDomainNotAllowed      StandardError = "hosted domain %v is not allowed, expected %v"
+	ErrAudienceNotAllowed          StandardError = "audience %v is not allowed, expected %v"
+	ErrCriticalHeaderMalformed     StandardError = "malformed crit header"
+	ErrCriticalHeaderUnsupported   StandardError = "unsupported critical header parameter: %s"
+	ErrNoDecryptionKeys            StandardError = "encrypted token found, but no decryption keys configured"
+	ErrInvalidDecryptionKey        StandardError = "invalid token decryption key %s: %v"
+	ErrTokenDecryption             StandardError = "failed decrypting token: %v"
+	ErrUnsupportedEncryptionAlg    StandardError = "unsupported token encryption algorithm: %v"
+	ErrUnsupportedEncryptionEnc    StandardError = "unsupported token content encryption algorithm: %v"
+	ErrEncryptedTokenNotNested     StandardError = "encrypted token does not contain signed token"
+	ErrInvalidPasetoToken          StandardError = "malformed paseto token"
+	ErrPasetoSignature             StandardError = "paseto token signature is invalid"
+	ErrPasetoClaim                 StandardError = "invalid paseto %s claim value %v"
+	ErrTokenNotValidYet            StandardError = "token is not valid yet"
+	ErrUnsupportedTokenFormat      StandardError = "unsupported token format: %s"
+	ErrDPoPProofNotFound           StandardError = "dpop proof not found"
+	ErrDPoPProofNotUnique          StandardError = "multiple dpop proofs found"
+	ErrDPoPProof                   StandardError = "invalid dpop proof: %v"
+	ErrDPoPProofClaimMismatch      StandardError = "dpop proof %s claim mismatch: %v (expected) vs. %v (received)"
+	ErrDPoPProofClaimNotFound      StandardError = "dpop proof %s claim not found"
+	ErrDPoPProofExpired            StandardError = "dpop proof is outside of accepted time window"
+	ErrDPoPProofReplayed           StandardError = "dpop proof was already used"
+	ErrDPoPKeyMismatch             StandardError = "dpop proof key does not match cnf.jkt claim of token"
+	ErrDPoPBoundToken              StandardError = "token is bound to dpop key, but presented without dpop proof"
+	ErrReplayStoreFull             StandardError = "replay store is full with %d unexpired entries"
+	ErrReplayStore                 StandardError = "failed recording token for replay detection: %v"
+	ErrSingleUseTokenNoJTI         StandardError = "single-use token has no jti claim"
+	ErrSingleUseTokenNoExp         StandardError = "single-use token has no exp claim"
+	ErrTokenReplayed               StandardError = "single-use token was already used"
+	ErrRevocationFile              StandardError = "failed loading revocation file %s: %v"
+	ErrRevocationFileFormat        StandardError = "revocation file %s is not json or csv file"
+	ErrRevocationEntryType         StandardError = "unsupported revocation entry type %s, expected jti or sub"
+	ErrRevocationCheck             StandardError = "failed checking token revocation: %v"
+	ErrTokenRevoked                StandardError = "token is revoked"
+	ErrSubjectRevoked              StandardError = "tokens of subject %s are revoked"
+	ErrRedisNoAddress              StandardError = "redis address is empty"
+	ErrRedisCAFile                 StandardError = "failed loading redis ca file %s: %v"
+	ErrRedis                       StandardError = "redis command failed: %v"
+	ErrUnsupportedKeyOutagePolicy  StandardError = "unsupported key outage policy: %s"
+	ErrKeysUnavailable             StandardError = "keys are unavailable: %v"
+	ErrKeysUnavailableAllowed      StandardError = "keys are unavailable, request allowed without verification: %v"
+	ErrNotReady                    StandardError = "authorization provider %s is not ready: %v"
+	ErrKeyRefresh                  StandardError = "failed refreshing keys: %v"
+	ErrClientCertificateNotFound   StandardError = "token is bound to client certificate, but no client certificate found"
+	ErrCertificateBindingMismatch  StandardError = "token is bound to other client certificate"
+	ErrCertificateBindingRequired  StandardError = "token is not bound to client certificate, but the binding is required"
+	ErrAuthTimeNotFound            StandardError = "authentication age is limited, but no auth_time claim found"
+	ErrAuthTimeExceeded            StandardError = "authentication %s ago exceeds maximum age of %s, re-authentication required"
+	ErrTokenTypeNotFound           StandardError = "token type not found, expected %s"
+	ErrTokenTypeMismatch           StandardError = "token type mismatch: %v (expected) vs. %v (received)"
+	ErrStrictAlgNone               StandardError = "strict mode: alg none is not allowed"
+	ErrStrictHeaderNotAllowed      StandardError = "strict mode: %s header is not allowed"
+	ErrStrictHeaderTooLarge        StandardError = "strict mode: token header exceeds %d bytes"
+	ErrStrictClaimsTooLarge        StandardError = "strict mode: token claims exceed %d bytes"
+	ErrTokenTooLarge               StandardError = "token exceeds %d bytes"
+	ErrTokenTooManyClaims          StandardError = "token has more than %d claims"
+	ErrTokenTooDeep                StandardError = "token nesting depth exceeds %d"
+	ErrStrictNoExpiration          StandardError = "strict mode: exp claim not found"
+	ErrStrictKeyIDRequired         StandardError = "strict mode: kid header is required when multiple keys are loaded"
+	ErrInvalidToken                StandardError = "invalid token"
+	ErrInvalidKey                  StandardError = "key is invalid"
+	ErrInvalidKeyType              StandardError = "key is of invalid type"
+	ErrKeyMustBePEMEncoded         StandardError = "invalid key: key must be a PEM encoded PKCS1 or PKCS8 key"
 	ErrNoAccessList                StandardError = "user role is valid, but denied by default deny on empty access list"
 	ErrAccessNotAllowed            StandardError = "user role is valid, but not allowed by access list"
 	ErrAccessNotAllowedByPathACL   StandardError = "user role is valid, but not allowed by path access list"
+	ErrStepUpRequired              StandardError = "access requires step-up authentication, acr: %v, amr: %v"
 	ErrSourceAddressNotFound       StandardError = "source ip validation is enabled, but no ip address claim found"
 	ErrSourceAddressMismatch       StandardError = "source ip address mismatch between the claim %s and request %s"
 	ErrNoParsedClaims              StandardError = "failed to extract claims"
 	ErrNoTokenFound                StandardError = "no token found"
+	ErrFormBodyRead                StandardError = "failed reading form body: %v"
 	ErrInvalidParsedClaims         StandardError = "failed to extract claims: %s"
 	ErrInvalidSecret               StandardError = "secret key backend error: %s"
 	ErrInvalid                     StandardError = "%v"
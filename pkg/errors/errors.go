@@ -64,7 +64,53 @@ func (e ExtendedError) Error() string {
 	return fmt.Sprintf(e.err.Error(), e.v...)
 }
 
+// Args returns the parameters of the error.
+func (e ExtendedError) Args() []interface{} {
+	return e.v
+}
+
 // Unwrap returns unwrapped error.
 func (e ExtendedError) Unwrap() error {
 	return errors.Unwrap(e.err)
 }
+
+// InsufficientScopeError is ErrAccessNotAllowed error of the token lacking
+// the scopes allowed by the access list.
+type InsufficientScopeError struct {
+	// The scopes allowed by the access list, any of them is sufficient.
+	Scopes []string
+}
+
+// Error returns error string.
+func (e InsufficientScopeError) Error() string {
+	return ErrAccessNotAllowed.Error()
+}
+
+// Unwrap returns ErrAccessNotAllowed error.
+func (e InsufficientScopeError) Unwrap() error {
+	return ErrAccessNotAllowed
+}
+
+// DenialError is the denial handed off to the error routes of the server,
+// rather than responded to by the authorizer.
+type DenialError struct {
+	// The status of the response to the denial, e.g. 401 or 403.
+	Status int
+	// The reason of the denial, without the details of the error.
+	Reason string
+	// The error causing the denial, if any.
+	Err error
+}
+
+// Error returns error string.
+func (e DenialError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Reason
+}
+
+// Unwrap returns the error causing the denial.
+func (e DenialError) Unwrap() error {
+	return e.Err
+}
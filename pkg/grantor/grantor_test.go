@@ -16,8 +16,13 @@ package grantor
 
 import (
 	"errors"
+	jwtclaims "github.com/greenpau/caddy-auth-jwt/pkg/claims"
+	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
 	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwttoken "github.com/greenpau/caddy-auth-jwt/pkg/token"
+	"reflect"
 	"testing"
+	"time"
 )
 
 // TestGrantorError tests using errors as values
@@ -47,3 +52,127 @@ func TestGrantorError(t *testing.T) {
 		}
 	}
 }
+
+func TestReissue(t *testing.T) {
+	userClaims := &jwtclaims.UserClaims{
+		RawClaims: map[string]interface{}{
+			"sub":   "jsmith",
+			"email": "jsmith@example.com",
+			"roles": []interface{}{"admin"},
+			"iss":   "https://idp.example.com",
+			"exp":   float64(4102444800),
+			"jti":   "a3f1",
+		},
+	}
+
+	testcases := []struct {
+		name      string
+		config    *jwtconfig.ReissueConfig
+		claims    map[string]interface{}
+		kid       string
+		shouldErr bool
+		err       error
+	}{
+		{
+			name: "reissue all claims",
+			config: &jwtconfig.ReissueConfig{
+				Secret: "internal",
+			},
+			claims: map[string]interface{}{
+				"sub":   "jsmith",
+				"email": "jsmith@example.com",
+				"roles": []interface{}{"admin"},
+				"iss":   "https://idp.example.com",
+			},
+		},
+		{
+			name: "reissue subset of claims with issuer and audience",
+			config: &jwtconfig.ReissueConfig{
+				Secret:   "internal",
+				KeyID:    "internal-1",
+				Issuer:   "https://gateway.example.com",
+				Audience: []string{"api"},
+				Claims:   []string{"roles"},
+			},
+			claims: map[string]interface{}{
+				"sub":   "jsmith",
+				"roles": []interface{}{"admin"},
+				"iss":   "https://gateway.example.com",
+				"aud":   "api",
+			},
+			kid: "internal-1",
+		},
+		{
+			name: "reissue without excluded claims",
+			config: &jwtconfig.ReissueConfig{
+				Secret:        "internal",
+				ExcludeClaims: []string{"email", "sub"},
+			},
+			claims: map[string]interface{}{
+				"sub":   "jsmith",
+				"roles": []interface{}{"admin"},
+				"iss":   "https://idp.example.com",
+			},
+		},
+		{
+			name:      "reissue without key",
+			config:    &jwtconfig.ReissueConfig{},
+			shouldErr: true,
+			err:       jwterrors.ErrReissueNoKey,
+		},
+		{
+			name: "reissue with signing method not matching key",
+			config: &jwtconfig.ReissueConfig{
+				Method: "RS256",
+				Secret: "internal",
+			},
+			shouldErr: true,
+			err:       jwterrors.ErrReissueMethodMismatch.WithArgs("RS256"),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			g, err := NewReissuer(tc.config)
+			if tc.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error: %v", tc.err)
+				}
+				if err.Error() != tc.err.Error() {
+					t.Fatalf("unexpected error: %v, expected: %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			now := time.Now()
+			s, err := g.Reissue(userClaims, now)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			parser := jwttoken.NewParser(&jwttoken.ParserOptions{ValidMethods: []string{"HS256"}})
+			token, err := parser.Parse(s, func(*jwttoken.Token) (interface{}, error) {
+				return []byte("internal"), nil
+			})
+			if err != nil {
+				t.Fatalf("failed parsing reissued token: %v", err)
+			}
+			if kid, _ := token.Header["kid"].(string); kid != tc.kid {
+				t.Fatalf("unexpected kid: %q, expected: %q", kid, tc.kid)
+			}
+			if exp := token.Claims["exp"]; exp != float64(now.Unix()+defaultReissueTTL) {
+				t.Fatalf("unexpected exp: %v, expected: %d", exp, now.Unix()+defaultReissueTTL)
+			}
+			delete(token.Claims, "exp")
+			delete(token.Claims, "iat")
+			if len(token.Claims) != len(tc.claims) {
+				t.Fatalf("unexpected claims: %v, expected: %v", token.Claims, tc.claims)
+			}
+			for k, v := range tc.claims {
+				if got, exists := token.Claims[k]; !exists || !reflect.DeepEqual(got, v) {
+					t.Fatalf("unexpected %s claim: %v, expected: %v", k, got, v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grantor
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"time"
+
+	jwtclaims "github.com/greenpau/caddy-auth-jwt/pkg/claims"
+	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
+	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwttoken "github.com/greenpau/caddy-auth-jwt/pkg/token"
+)
+
+const defaultReissueTTL = 60
+
+// Reissuer signs the claims of the validated tokens with the internal key.
+type Reissuer struct {
+	method   string
+	key      interface{}
+	keyID    string
+	issuer   string
+	audience []string
+	ttl      time.Duration
+	filter   *jwtconfig.ClaimFilter
+}
+
+// NewReissuer returns Reissuer instance with the key of the configuration.
+func NewReissuer(cfg *jwtconfig.ReissueConfig) (*Reissuer, error) {
+	g := &Reissuer{
+		method:   cfg.Method,
+		keyID:    cfg.KeyID,
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		ttl:      time.Duration(cfg.TTL) * time.Second,
+	}
+	if cfg.TTL <= 0 {
+		g.ttl = defaultReissueTTL * time.Second
+	}
+	if len(cfg.Claims) > 0 || len(cfg.ExcludeClaims) > 0 {
+		g.filter = &jwtconfig.ClaimFilter{Exclude: cfg.ExcludeClaims}
+		if len(cfg.Claims) > 0 {
+			g.filter.Allow = append([]string{"sub"}, cfg.Claims...)
+		}
+	}
+
+	switch {
+	case cfg.KeyFile != "":
+		b, err := ioutil.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, jwterrors.ErrReissueKeyFile.WithArgs(cfg.KeyFile, err)
+		}
+		key, err := parsePrivateKeyFromPEM(b)
+		if err != nil {
+			return nil, jwterrors.ErrReissueKeyFile.WithArgs(cfg.KeyFile, err)
+		}
+		g.key = key
+	case cfg.Secret != "":
+		g.key = []byte(cfg.Secret)
+	default:
+		return nil, jwterrors.ErrReissueNoKey
+	}
+
+	method := getKeySigningMethod(g.key)
+	if g.method == "" {
+		g.method = method
+	}
+	if _, exists := jwtconfig.SigningMethods[g.method]; !exists {
+		return nil, jwterrors.ErrUnsupportedSigningMethod.WithArgs(g.method)
+	}
+	if jwttoken.GetSigningMethod(g.method) != jwttoken.GetSigningMethod(method) {
+		// RSA keys sign both RS and PS tokens.
+		if jwttoken.GetSigningMethod(g.method) != jwttoken.RSAPSS || jwttoken.GetSigningMethod(method) != jwttoken.RSA {
+			return nil, jwterrors.ErrReissueMethodMismatch.WithArgs(g.method)
+		}
+	}
+	return g, nil
+}
+
+// TTL returns the lifetime of the reissued tokens.
+func (g *Reissuer) TTL() time.Duration {
+	return g.ttl
+}
+
+// Reissue returns the token with the claims of the user signed with the
+// internal key. The token expires after the lifetime of the reissued tokens,
+// regardless of the expiration of the original token.
+func (g *Reissuer) Reissue(userClaims *jwtclaims.UserClaims, now time.Time) (string, error) {
+	if userClaims == nil {
+		return "", jwterrors.ErrNoClaims
+	}
+	original := userClaims.RawClaims
+	if original == nil {
+		original = userClaims.AsMap()
+	}
+	claims := jwtclaims.FilterClaims(original, g.filter)
+	for _, k := range []string{"exp", "iat", "nbf", "jti"} {
+		delete(claims, k)
+	}
+	if sub, exists := original["sub"]; exists {
+		claims["sub"] = sub
+	}
+	if g.issuer != "" {
+		claims["iss"] = g.issuer
+	}
+	if len(g.audience) == 1 {
+		claims["aud"] = g.audience[0]
+	} else if len(g.audience) > 1 {
+		claims["aud"] = g.audience
+	}
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(g.ttl).Unix()
+
+	var headers map[string]interface{}
+	if g.keyID != "" {
+		headers = map[string]interface{}{"kid": g.keyID}
+	}
+	return jwttoken.Sign(g.method, claims, headers, g.key)
+}
+
+// getKeySigningMethod returns the default signing method of the key.
+func getKeySigningMethod(key interface{}) string {
+	switch k := key.(type) {
+	case []byte:
+		return "HS256"
+	case *rsa.PrivateKey:
+		return "RS256"
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 384:
+			return "ES384"
+		case 521:
+			return "ES512"
+		}
+		return "ES256"
+	case ed25519.PrivateKey:
+		return "EdDSA"
+	}
+	return ""
+}
+
+// parsePrivateKeyFromPEM parses PEM encoded PKCS #1, SEC 1, or PKCS #8
+// private key. The supported key types are RSA, ECDSA, and Ed25519.
+func parsePrivateKeyFromPEM(b []byte) (interface{}, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, jwterrors.ErrKeyMustBePEMEncoded
+	}
+	if pk, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return pk, nil
+	}
+	if pk, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return pk, nil
+	}
+	pk, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch pk.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return pk, nil
+	}
+	return nil, jwterrors.ErrUnsupportedKeyType.WithArgs(pk, "reissue")
+}
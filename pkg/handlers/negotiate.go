@@ -0,0 +1,101 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// IsNavigationRequest returns true if the request is an interactive browser
+// navigation, rather than a request of a script, e.g. XMLHttpRequest or
+// fetch, or a request of an API client. The navigations are redirected to the
+// authentication portal. The requests without any hints, e.g. Accept */*,
+// are navigations.
+func IsNavigationRequest(r *http.Request) bool {
+	// The browsers send Sec-Fetch-Mode header with every request, and the
+	// mode of the navigations is navigate.
+	if mode := r.Header.Get("Sec-Fetch-Mode"); mode != "" {
+		return strings.EqualFold(mode, "navigate")
+	}
+	if strings.EqualFold(r.Header.Get("X-Requested-With"), "XMLHttpRequest") {
+		return false
+	}
+	return acceptsHTML(r.Header.Get("Accept"))
+}
+
+// acceptsHTML returns true if the media ranges in Accept header prefer HTML,
+// or do not name any specific media type.
+func acceptsHTML(accept string) bool {
+	var htmlQuality, otherQuality float64
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		quality := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "q") {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		switch mediaType {
+		case "", "*/*":
+		case "text/html", "application/xhtml+xml", "text/*":
+			if quality > htmlQuality {
+				htmlQuality = quality
+			}
+		default:
+			if quality > otherQuality {
+				otherQuality = quality
+			}
+		}
+	}
+	if otherQuality == 0 {
+		return true
+	}
+	return htmlQuality > 0 && htmlQuality >= otherQuality
+}
+
+// Problem is the problem details of the error response per RFC 7807.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	ACRValues string `json:"acr_values,omitempty"`
+}
+
+// WriteProblem writes the error response with the problem details in JSON
+// body.
+func WriteProblem(w http.ResponseWriter, status int, detail string, opts map[string]interface{}) {
+	problem := &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+	if acrValues, ok := opts["acr_values"].(string); ok {
+		problem.ACRValues = acrValues
+	}
+	b, _ := json.Marshal(problem)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(b)
+}
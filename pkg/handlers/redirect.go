@@ -16,11 +16,42 @@ package handlers
 
 import (
 	//"go.uber.org/zap"
+	"encoding/base64"
 	"net/http"
 	"net/url"
 	"strings"
 )
 
+// IsAllowedRedirectURL returns true if the URL is an http or https URL of the
+// host, or of the allowed hosts. The allowed host starting with *. matches
+// the subdomains of the domain. The ports are not compared.
+func IsAllowedRedirectURL(s, host string, allowedHosts []string) bool {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	name := strings.ToLower(u.Hostname())
+	if name == "" {
+		return false
+	}
+	if name == strings.ToLower((&url.URL{Host: host}).Hostname()) {
+		return true
+	}
+	for _, allowedHost := range allowedHosts {
+		allowedHost = strings.ToLower(allowedHost)
+		if strings.HasPrefix(allowedHost, "*.") {
+			if strings.HasSuffix(name, allowedHost[1:]) {
+				return true
+			}
+			continue
+		}
+		if name == allowedHost {
+			return true
+		}
+	}
+	return false
+}
+
 // AddRedirectLocationHeader Adds redirect header.
 func AddRedirectLocationHeader(w http.ResponseWriter, r *http.Request, opts map[string]interface{}) {
 	authURLPath := opts["auth_url_path"].(string)
@@ -28,6 +59,18 @@ func AddRedirectLocationHeader(w http.ResponseWriter, r *http.Request, opts map[
 	redirectParameter := opts["redirect_param"].(string)
 	//log := opts["logger"].(*zap.Logger)
 
+	// The authentication context class required by step-up authentication,
+	// e.g. urn:mfa, is passed to the authentication portal in acr_values
+	// query parameter.
+	if acrValues, ok := opts["acr_values"].(string); ok && acrValues != "" {
+		if strings.Contains(authURLPath, "?") {
+			authURLPath += "&"
+		} else {
+			authURLPath += "?"
+		}
+		authURLPath += "acr_values=" + url.QueryEscape(acrValues)
+	}
+
 	if strings.Contains(r.RequestURI, redirectParameter) {
 		return
 	}
@@ -70,10 +113,32 @@ func AddRedirectLocationHeader(w http.ResponseWriter, r *http.Request, opts map[
 		redirectURL = redirectBaseURL + r.RequestURI
 	}
 
+	// The return URL is passed to the portal only if it points to the host
+	// of the request, or to the allowed hosts, so that the portal does not
+	// redirect the users to the host forged, e.g., in X-Forwarded-Host header.
+	allowedHosts, _ := opts["redirect_allowed_hosts"].([]string)
+	if !IsAllowedRedirectURL(redirectURL, r.Host, allowedHosts) {
+		w.Header().Set("Location", authURLPath)
+		return
+	}
+
+	// The return URL is passed in the header, rather than in the query,
+	// e.g. to the portals posting the login form to the URL in the header.
+	if redirectHeader, ok := opts["redirect_header"].(string); ok && redirectHeader != "" {
+		w.Header().Set(redirectHeader, redirectURL)
+		w.Header().Set("Location", authURLPath)
+		return
+	}
+
 	if strings.Contains(authURLPath, "?") {
 		sep = "&"
 	}
 
-	redirectURL = url.QueryEscape(redirectURL)
+	// Some portals require base64url-encoded return URL, without padding.
+	if encoding, ok := opts["redirect_encoding"].(string); ok && encoding == "base64url" {
+		redirectURL = base64.RawURLEncoding.EncodeToString([]byte(redirectURL))
+	} else {
+		redirectURL = url.QueryEscape(redirectURL)
+	}
 	w.Header().Set("Location", authURLPath+sep+redirectParameter+"="+redirectURL)
 }
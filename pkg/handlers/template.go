@@ -0,0 +1,126 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	texttemplate "text/template"
+
+	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
+	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+// DenyTemplateData are the placeholders of the deny templates, e.g.
+// {{.Reason}}.
+type DenyTemplateData struct {
+	Status    int
+	Reason    string
+	RequestID string
+	AuthURL   string
+}
+
+// DenyTemplates are the templates of the responses to the requests denied
+// access, by status and format.
+type DenyTemplates struct {
+	templates map[string]denyTemplate
+}
+
+type denyTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// NewDenyTemplates returns DenyTemplates instance. The HTML templates escape
+// the placeholders. The JSON templates quote the placeholders with json
+// function, e.g. {{json .Reason}}.
+func NewDenyTemplates(cfgs []*jwtconfig.DenyTemplate) (*DenyTemplates, error) {
+	t := &DenyTemplates{
+		templates: make(map[string]denyTemplate),
+	}
+	for _, cfg := range cfgs {
+		if cfg.Status != http.StatusUnauthorized && cfg.Status != http.StatusForbidden {
+			return nil, jwterrors.ErrDenyTemplateStatus.WithArgs(cfg.Status)
+		}
+		if cfg.Format != "html" && cfg.Format != "json" {
+			return nil, jwterrors.ErrDenyTemplateFormat.WithArgs(cfg.Format)
+		}
+		if (cfg.File == "") == (cfg.Template == "") {
+			return nil, jwterrors.ErrDenyTemplateNoSource.WithArgs(cfg.Status, cfg.Format)
+		}
+		text := cfg.Template
+		if cfg.File != "" {
+			b, err := ioutil.ReadFile(cfg.File)
+			if err != nil {
+				return nil, jwterrors.ErrDenyTemplate.WithArgs(cfg.Status, cfg.Format, err)
+			}
+			text = string(b)
+		}
+		var tmpl denyTemplate
+		var err error
+		name := denyTemplateKey(cfg.Status, cfg.Format)
+		if cfg.Format == "html" {
+			tmpl, err = htmltemplate.New(name).Parse(text)
+		} else {
+			tmpl, err = texttemplate.New(name).Funcs(texttemplate.FuncMap{"json": quoteJSON}).Parse(text)
+		}
+		if err != nil {
+			return nil, jwterrors.ErrDenyTemplate.WithArgs(cfg.Status, cfg.Format, err)
+		}
+		t.templates[name] = tmpl
+	}
+	return t, nil
+}
+
+// Write writes the response with the template of the denial, i.e. 401 or
+// 403, and the format. The status of the response is the one in the data. It
+// returns false if there is no such template, or the template fails, so that
+// the built-in response is written instead.
+func (t *DenyTemplates) Write(w http.ResponseWriter, format string, denial int, data *DenyTemplateData) bool {
+	if t == nil {
+		return false
+	}
+	tmpl, exists := t.templates[denyTemplateKey(denial, format)]
+	if !exists {
+		return false
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return false
+	}
+	if format == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(data.Status)
+	w.Write(b.Bytes())
+	return true
+}
+
+func denyTemplateKey(status int, format string) string {
+	return strconv.Itoa(status) + "/" + format
+}
+
+// quoteJSON returns the value quoted as JSON string.
+func quoteJSON(s string) (string, error) {
+	b, err := json.Marshal(s)
+	return string(b), err
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package revocation holds the revoked tokens, identified by jti claim, and
+// the revoked subjects, whose tokens are rejected before they expire.
+package revocation
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+// Store holds the revoked token ids and subjects.
+type Store interface {
+	// IsTokenRevoked returns true if the token with the jti claim is revoked.
+	IsTokenRevoked(jti string) (bool, error)
+	// IsSubjectRevoked returns true if the tokens of the subject are revoked.
+	IsSubjectRevoked(sub string) (bool, error)
+}
+
+// Entries are the revoked token ids and subjects.
+type Entries struct {
+	TokenIDs []string `json:"jti,omitempty"`
+	Subjects []string `json:"sub,omitempty"`
+	// The time, in seconds since epoch, the entries are no longer needed
+	// after, e.g. the expiry of the revoked tokens. Zero means never.
+	ExpiresAt int64 `json:"exp,omitempty"`
+}
+
+// List is Store keeping the revoked token ids and subjects in memory.
+type List struct {
+	mu       sync.RWMutex
+	tokenIDs map[string]time.Time
+	subjects map[string]time.Time
+}
+
+// NewList returns List instance.
+func NewList() *List {
+	return &List{
+		tokenIDs: make(map[string]time.Time),
+		subjects: make(map[string]time.Time),
+	}
+}
+
+// IsTokenRevoked returns true if the token with the jti claim is revoked.
+func (l *List) IsTokenRevoked(jti string) (bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return isRevoked(l.tokenIDs, jti), nil
+}
+
+// IsSubjectRevoked returns true if the tokens of the subject are revoked.
+func (l *List) IsSubjectRevoked(sub string) (bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return isRevoked(l.subjects, sub), nil
+}
+
+// Replace replaces the revoked token ids and subjects with the entries.
+func (l *List) Replace(entries *Entries) {
+	tokenIDs := make(map[string]time.Time)
+	subjects := make(map[string]time.Time)
+	addEntries(tokenIDs, entries.TokenIDs, entries.ExpiresAt)
+	addEntries(subjects, entries.Subjects, entries.ExpiresAt)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokenIDs = tokenIDs
+	l.subjects = subjects
+}
+
+// Add adds the entries to the revoked token ids and subjects. The expired
+// entries are removed.
+func (l *List) Add(entries *Entries) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for _, m := range []map[string]time.Time{l.tokenIDs, l.subjects} {
+		for k, t := range m {
+			if !t.IsZero() && now.After(t) {
+				delete(m, k)
+			}
+		}
+	}
+	addEntries(l.tokenIDs, entries.TokenIDs, entries.ExpiresAt)
+	addEntries(l.subjects, entries.Subjects, entries.ExpiresAt)
+}
+
+func addEntries(m map[string]time.Time, values []string, expiresAt int64) {
+	var t time.Time
+	if expiresAt > 0 {
+		t = time.Unix(expiresAt, 0)
+	}
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		// The entry without the expiry is kept.
+		if prev, exists := m[v]; exists && (prev.IsZero() || (!t.IsZero() && prev.After(t))) {
+			continue
+		}
+		m[v] = t
+	}
+}
+
+func isRevoked(m map[string]time.Time, k string) bool {
+	t, exists := m[k]
+	return exists && (t.IsZero() || time.Now().Before(t))
+}
+
+// ParseEntries parses the entries from JSON object with jti and sub arrays,
+// and optional exp.
+func ParseEntries(b []byte) (*Entries, error) {
+	entries := &Entries{}
+	if err := json.Unmarshal(b, entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// LoadFile loads the entries from JSON or CSV file. The JSON file holds an
+// object with jti and sub arrays, and optional exp. The records of the CSV file are the type
+// of the entry, i.e. jti or sub, and the value, e.g. "jti,3f2a". The lines
+// starting with # are comments.
+func LoadFile(fp string) (*Entries, error) {
+	b, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, errors.ErrRevocationFile.WithArgs(fp, err)
+	}
+	var entries *Entries
+	switch strings.ToLower(filepath.Ext(fp)) {
+	case ".json":
+		entries, err = ParseEntries(b)
+	case ".csv":
+		entries, err = parseCSV(b)
+	default:
+		return nil, errors.ErrRevocationFileFormat.WithArgs(fp)
+	}
+	if err != nil {
+		return nil, errors.ErrRevocationFile.WithArgs(fp, err)
+	}
+	return entries, nil
+}
+
+func parseCSV(b []byte) (*Entries, error) {
+	entries := &Entries{}
+	r := csv.NewReader(bytes.NewReader(b))
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := strings.TrimSpace(record[1])
+		if value == "" {
+			continue
+		}
+		switch strings.TrimSpace(record[0]) {
+		case "jti":
+			entries.TokenIDs = append(entries.TokenIDs, value)
+		case "sub":
+			entries.Subjects = append(entries.Subjects, value)
+		default:
+			return nil, errors.ErrRevocationEntryType.WithArgs(record[0])
+		}
+	}
+	return entries, nil
+}
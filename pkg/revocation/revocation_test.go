@@ -0,0 +1,91 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package revocation
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revocation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		tokenIDs []string
+		subjects []string
+		err      error
+	}{
+		{name: "json file", file: "revoked.json", content: `{"jti": ["a1", "a2"], "sub": ["jsmith"]}`, tokenIDs: []string{"a1", "a2"}, subjects: []string{"jsmith"}},
+		{name: "csv file", file: "revoked.csv", content: "# revoked on 2020-10-01\njti,a1\nsub, jsmith\n\njti,a2\n", tokenIDs: []string{"a1", "a2"}, subjects: []string{"jsmith"}},
+		{name: "csv file with unsupported type", file: "bad.csv", content: "iss,a1\n", err: jwterrors.ErrRevocationFile},
+		{name: "csv file with missing value", file: "bad2.csv", content: "jti\n", err: jwterrors.ErrRevocationFile},
+		{name: "malformed json file", file: "bad.json", content: `{"jti": "a1"}`, err: jwterrors.ErrRevocationFile},
+		{name: "unsupported format", file: "revoked.txt", content: "a1", err: jwterrors.ErrRevocationFileFormat},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fp := filepath.Join(dir, test.file)
+			if err := ioutil.WriteFile(fp, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := LoadFile(fp)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("unexpected error: %v, expected: %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(entries.TokenIDs, " ") != strings.Join(test.tokenIDs, " ") {
+				t.Fatalf("unexpected jti entries: %v, expected: %v", entries.TokenIDs, test.tokenIDs)
+			}
+			if strings.Join(entries.Subjects, " ") != strings.Join(test.subjects, " ") {
+				t.Fatalf("unexpected sub entries: %v, expected: %v", entries.Subjects, test.subjects)
+			}
+
+			l := NewList()
+			l.Replace(entries)
+			for _, jti := range test.tokenIDs {
+				if revoked, _ := l.IsTokenRevoked(jti); !revoked {
+					t.Fatalf("token %s is not revoked", jti)
+				}
+			}
+			for _, sub := range test.subjects {
+				if revoked, _ := l.IsSubjectRevoked(sub); !revoked {
+					t.Fatalf("subject %s is not revoked", sub)
+				}
+			}
+			if revoked, _ := l.IsTokenRevoked("b1"); revoked {
+				t.Fatalf("token b1 is revoked")
+			}
+		})
+	}
+}
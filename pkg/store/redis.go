@@ -0,0 +1,170 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package store holds the state shared by Caddy instances, e.g. the revoked
+// tokens, in external stores.
+package store
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	"github.com/greenpau/caddy-auth-jwt/pkg/revocation"
+	"go.uber.org/zap"
+)
+
+// The default prefix of the keys in Redis.
+const defaultRedisKeyPrefix = "caddy:jwt:"
+
+// The timeout of the Redis commands issued while validating tokens.
+const redisCommandTimeout = 2 * time.Second
+
+// RedisStore keeps the revoked tokens and subjects, and the ids of the used
+// single-use tokens, in Redis. The keys are:
+//
+//	<prefix>revoked:jti:<jti>  the revoked token
+//	<prefix>revoked:sub:<sub>  the revoked subject
+//	<prefix>replay:<id>        the used single-use token
+//
+// The revoked tokens and subjects are added by the operators, e.g. with SET
+// command, preferably with the expiry of the tokens.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+	pubsub *redis.PubSub
+	done   chan struct{}
+}
+
+// NewRedisStore returns RedisStore instance.
+func NewRedisStore(c *jwtconfig.RedisConfig) (*RedisStore, error) {
+	if c.Address == "" {
+		return nil, errors.ErrRedisNoAddress
+	}
+	opts := &redis.Options{
+		Addr:     c.Address,
+		Username: c.Username,
+		Password: c.Password,
+		DB:       c.DB,
+	}
+	if c.TLS {
+		opts.TLSConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			ServerName:         c.TLSServerName,
+			InsecureSkipVerify: c.TLSInsecureSkipVerify,
+		}
+		if c.TLSCAFile != "" {
+			b, err := ioutil.ReadFile(c.TLSCAFile)
+			if err != nil {
+				return nil, errors.ErrRedisCAFile.WithArgs(c.TLSCAFile, err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(b) {
+				return nil, errors.ErrRedisCAFile.WithArgs(c.TLSCAFile, "no certificates found")
+			}
+			opts.TLSConfig.RootCAs = pool
+		}
+	}
+	s := &RedisStore{
+		client: redis.NewClient(opts),
+		prefix: c.KeyPrefix,
+	}
+	if s.prefix == "" {
+		s.prefix = defaultRedisKeyPrefix
+	}
+	return s, nil
+}
+
+// IsTokenRevoked returns true if the token with the jti claim is revoked.
+func (s *RedisStore) IsTokenRevoked(jti string) (bool, error) {
+	return s.exists(s.prefix + "revoked:jti:" + jti)
+}
+
+// IsSubjectRevoked returns true if the tokens of the subject are revoked.
+func (s *RedisStore) IsSubjectRevoked(sub string) (bool, error) {
+	return s.exists(s.prefix + "revoked:sub:" + sub)
+}
+
+// Add records the id of the single-use token until the expiry. It returns
+// false if the id is already recorded.
+func (s *RedisStore) Add(id string, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl < time.Millisecond {
+		// The token expired, and will not be accepted again.
+		return true, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisCommandTimeout)
+	defer cancel()
+	added, err := s.client.SetNX(ctx, s.prefix+"replay:"+id, 1, ttl).Result()
+	if err != nil {
+		return false, errors.ErrRedis.WithArgs(err)
+	}
+	return added, nil
+}
+
+// SubscribeRevocations subscribes to the revocation events published on the
+// channel, and adds the revoked tokens and subjects of the events to the list.
+// The event is JSON object with jti and sub arrays, and optional exp, e.g.
+// {"jti": ["3f2a"], "exp": 1602547200}. The subscription is restored after
+// the connection to Redis fails, but the events published meanwhile are lost,
+// so the revoked tokens and subjects in Redis are checked as well.
+func (s *RedisStore) SubscribeRevocations(channel string, list *revocation.List, logger *zap.Logger) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	s.pubsub = s.client.Subscribe(context.Background(), channel)
+	s.done = make(chan struct{})
+	go func(ch <-chan *redis.Message, done chan struct{}) {
+		defer close(done)
+		for msg := range ch {
+			entries, err := revocation.ParseEntries([]byte(msg.Payload))
+			if err != nil {
+				logger.Warn("malformed revocation event", zap.String("channel", msg.Channel), zap.Error(err))
+				continue
+			}
+			list.Add(entries)
+			logger.Debug(
+				"received revocation event",
+				zap.String("channel", msg.Channel),
+				zap.Int("jti", len(entries.TokenIDs)),
+				zap.Int("sub", len(entries.Subjects)),
+			)
+		}
+	}(s.pubsub.Channel(), s.done)
+}
+
+// Close closes the subscription and the connections to Redis.
+func (s *RedisStore) Close() error {
+	if s.pubsub != nil {
+		s.pubsub.Close()
+		<-s.done
+		s.pubsub = nil
+	}
+	return s.client.Close()
+}
+
+func (s *RedisStore) exists(key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisCommandTimeout)
+	defer cancel()
+	n, err := s.client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, errors.ErrRedis.WithArgs(err)
+	}
+	return n > 0, nil
+}
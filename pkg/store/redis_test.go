@@ -0,0 +1,318 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
+	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	"github.com/greenpau/caddy-auth-jwt/pkg/revocation"
+)
+
+// fakeRedis is Redis server supporting the commands used by RedisStore.
+type fakeRedis struct {
+	listener net.Listener
+	username string
+	password string
+	mu       sync.Mutex
+	keys     map[string]time.Time
+	commands []string
+	// The connections subscribed to the channels.
+	subscribers map[string][]net.Conn
+}
+
+func newFakeRedis(t *testing.T, username, password string) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRedis{
+		listener: l,
+		username: username,
+		password: password,
+		keys:     make(map[string]time.Time),
+
+		subscribers: make(map[string][]net.Conn),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authenticated := s.password == ""
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToLower(args[0])
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.Join(args, " "))
+		var reply string
+		switch {
+		case cmd == "auth":
+			username, password := "default", args[len(args)-1]
+			if len(args) == 3 {
+				username = args[1]
+			}
+			if s.username != "" && username != s.username || password != s.password {
+				reply = "-WRONGPASS invalid username-password pair\r\n"
+				break
+			}
+			authenticated = true
+			reply = "+OK\r\n"
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case cmd == "select", cmd == "ping":
+			reply = "+OK\r\n"
+		case cmd == "exists":
+			n := 0
+			for _, k := range args[1:] {
+				if t, exists := s.keys[k]; exists && (t.IsZero() || time.Now().Before(t)) {
+					n++
+				}
+			}
+			reply = fmt.Sprintf(":%d\r\n", n)
+		case cmd == "set":
+			var expiresAt time.Time
+			nx := false
+			for i := 3; i < len(args); i++ {
+				switch strings.ToLower(args[i]) {
+				case "nx":
+					nx = true
+				case "px", "ex":
+					n, _ := strconv.Atoi(args[i+1])
+					d := time.Duration(n) * time.Millisecond
+					if strings.ToLower(args[i]) == "ex" {
+						d = time.Duration(n) * time.Second
+					}
+					expiresAt = time.Now().Add(d)
+					i++
+				}
+			}
+			if t, exists := s.keys[args[1]]; nx && exists && (t.IsZero() || time.Now().Before(t)) {
+				reply = "$-1\r\n"
+				break
+			}
+			s.keys[args[1]] = expiresAt
+			reply = "+OK\r\n"
+		case cmd == "subscribe":
+			for i, ch := range args[1:] {
+				s.subscribers[ch] = append(s.subscribers[ch], conn)
+				reply += fmt.Sprintf("*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:%d\r\n", len(ch), ch, i+1)
+			}
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		// The replies are written with the lock held, so that they are not
+		// interleaved with the published messages.
+		_, err = io.WriteString(conn, reply)
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// publish sends the message to the connections subscribed to the channel, and
+// returns the number of the connections.
+func (s *fakeRedis) publish(channel, message string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.subscribers[channel] {
+		fmt.Fprintf(conn, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(channel), channel, len(message), message)
+	}
+	return len(s.subscribers[channel])
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("malformed command: %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("malformed argument: %q", line)
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func TestRedisStore(t *testing.T) {
+	server := newFakeRedis(t, "caddy", "secret")
+	defer server.listener.Close()
+	server.keys["tenant1:revoked:jti:a1"] = time.Time{}
+	server.keys["tenant1:revoked:sub:jsmith"] = time.Now().Add(time.Hour)
+	server.keys["tenant1:revoked:sub:jdoe"] = time.Now().Add(-time.Hour)
+
+	s, err := NewRedisStore(&jwtconfig.RedisConfig{
+		Address:   server.listener.Addr().String(),
+		Username:  "caddy",
+		Password:  "secret",
+		DB:        2,
+		KeyPrefix: "tenant1:",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, test := range []struct {
+		name    string
+		check   func(string) (bool, error)
+		value   string
+		revoked bool
+	}{
+		{name: "revoked token", check: s.IsTokenRevoked, value: "a1", revoked: true},
+		{name: "valid token", check: s.IsTokenRevoked, value: "a2"},
+		{name: "revoked subject", check: s.IsSubjectRevoked, value: "jsmith", revoked: true},
+		{name: "subject with expired revocation", check: s.IsSubjectRevoked, value: "jdoe"},
+		{name: "token id of revoked subject", check: s.IsTokenRevoked, value: "jsmith"},
+	} {
+		revoked, err := test.check(test.value)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if revoked != test.revoked {
+			t.Fatalf("%s: got: %t expected: %t", test.name, revoked, test.revoked)
+		}
+	}
+
+	expiresAt := time.Now().Add(time.Minute)
+	if added, err := s.Add("iss a1", expiresAt); !added || err != nil {
+		t.Fatalf("unexpected result of first presentation: %t, %v", added, err)
+	}
+	if added, err := s.Add("iss a1", expiresAt); added || err != nil {
+		t.Fatalf("unexpected result of second presentation: %t, %v", added, err)
+	}
+	if added, err := s.Add("iss a2", time.Now().Add(-time.Minute)); !added || err != nil {
+		t.Fatalf("unexpected result of expired token: %t, %v", added, err)
+	}
+
+	server.mu.Lock()
+	commands := strings.Join(server.commands, "\n")
+	server.mu.Unlock()
+	for _, cmd := range []string{"auth caddy secret", "select 2", "exists tenant1:revoked:jti:a1", "set tenant1:replay:iss a1 1"} {
+		if !strings.Contains(commands, cmd) {
+			t.Fatalf("command %q not sent, commands:\n%s", cmd, commands)
+		}
+	}
+
+	// The wrong credentials fail the commands.
+	s, err = NewRedisStore(&jwtconfig.RedisConfig{
+		Address:  server.listener.Addr().String(),
+		Password: "wrong",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.IsTokenRevoked("a1"); !errors.Is(err, jwterrors.ErrRedis) {
+		t.Fatalf("unexpected error: %v, expected: %v", err, jwterrors.ErrRedis)
+	}
+
+	if _, err := NewRedisStore(&jwtconfig.RedisConfig{}); !errors.Is(err, jwterrors.ErrRedisNoAddress) {
+		t.Fatalf("unexpected error: %v, expected: %v", err, jwterrors.ErrRedisNoAddress)
+	}
+	if _, err := NewRedisStore(&jwtconfig.RedisConfig{Address: "localhost:6379", TLSCAFile: "/nonexistent/ca.pem", TLS: true}); !errors.Is(err, jwterrors.ErrRedisCAFile) {
+		t.Fatalf("unexpected error: %v, expected: %v", err, jwterrors.ErrRedisCAFile)
+	}
+}
+
+func TestRedisStoreRevocationEvents(t *testing.T) {
+	server := newFakeRedis(t, "", "")
+	defer server.listener.Close()
+
+	s, err := NewRedisStore(&jwtconfig.RedisConfig{
+		Address: server.listener.Addr().String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := revocation.NewList()
+	s.SubscribeRevocations("revocations", list, nil)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for server.publish("revocations", "not json") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription not established")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	server.publish("revocations", fmt.Sprintf(`{"jti": ["a2"], "exp": %d}`, time.Now().Add(-time.Minute).Unix()))
+	server.publish("revocations", `{"jti": ["a1"], "sub": ["jsmith"]}`)
+
+	for {
+		revoked, _ := list.IsSubjectRevoked("jsmith")
+		if revoked {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("revocation event not received")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if revoked, _ := list.IsTokenRevoked("a1"); !revoked {
+		t.Fatal("token a1 is not revoked")
+	}
+	if revoked, _ := list.IsTokenRevoked("a2"); revoked {
+		t.Fatal("token a2 with expired revocation is revoked")
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- s.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close did not return")
+	}
+}
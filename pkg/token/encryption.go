@@ -0,0 +1,94 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// The supported key management algorithms of the encrypted tokens.
+var encryptionAlgorithms = map[string]bool{
+	"RSA-OAEP":       true,
+	"RSA-OAEP-256":   true,
+	"ECDH-ES":        true,
+	"ECDH-ES+A256KW": true,
+}
+
+// The supported content encryption algorithms of the encrypted tokens.
+var contentEncryptionAlgorithms = map[string]bool{
+	"A256GCM": true,
+}
+
+// IsEncrypted returns true if the token is an encrypted token, i.e. JWE in
+// compact serialization.
+func IsEncrypted(s string) bool {
+	return strings.Count(s, ".") == 4
+}
+
+// Decrypt decrypts the encrypted token with the first of the keys able to
+// decrypt it, and returns the nested signed token. The keys are RSA or ECDSA
+// private keys. The nested token is not verified.
+func Decrypt(s string, keys []interface{}) (string, error) {
+	if len(keys) == 0 {
+		return "", errors.ErrNoDecryptionKeys
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s[:strings.Index(s, ".")])
+	if err != nil {
+		return "", errors.ErrTokenDecryption.WithArgs(err)
+	}
+	header := map[string]interface{}{}
+	if err := json.Unmarshal(b, &header); err != nil {
+		return "", errors.ErrTokenDecryption.WithArgs(err)
+	}
+	if alg, _ := header["alg"].(string); !encryptionAlgorithms[alg] {
+		return "", errors.ErrUnsupportedEncryptionAlg.WithArgs(header["alg"])
+	}
+	if enc, _ := header["enc"].(string); !contentEncryptionAlgorithms[enc] {
+		return "", errors.ErrUnsupportedEncryptionEnc.WithArgs(header["enc"])
+	}
+	// The compressed tokens are rejected, because the size of the
+	// decompressed token is not limited.
+	if _, exists := header["zip"]; exists {
+		return "", errors.ErrTokenDecryption.WithArgs("compressed tokens are not supported")
+	}
+	if err := checkCriticalHeaders(header); err != nil {
+		return "", err
+	}
+	obj, err := jose.ParseEncrypted(s)
+	if err != nil {
+		return "", errors.ErrTokenDecryption.WithArgs(err)
+	}
+	var plaintext []byte
+	for _, key := range keys {
+		if plaintext, err = obj.Decrypt(key); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return "", errors.ErrTokenDecryption.WithArgs(err)
+	}
+	// Per RFC 7519, the nested token is signed. The encrypted claims without
+	// a signature are not accepted.
+	nested := string(plaintext)
+	if strings.Count(nested, ".") != 2 {
+		return "", errors.ErrEncryptedTokenNotNested
+	}
+	return nested, nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+// The headers of PASETO public tokens. Both versions are signed with Ed25519.
+const (
+	PasetoV2Public = "v2.public."
+	PasetoV4Public = "v4.public."
+)
+
+// IsPaseto returns true if the token is PASETO public token, i.e. v2.public
+// or v4.public token.
+func IsPaseto(s string) bool {
+	return strings.HasPrefix(s, PasetoV2Public) || strings.HasPrefix(s, PasetoV4Public)
+}
+
+// ParsePaseto verifies PASETO public token with Ed25519 public key returned by
+// the key function, and the exp and nbf claims of the token. The token passed
+// to the key function has EdDSA alg header, and kid header when the footer of
+// the token is JSON object with kid. The exp, nbf, and iat claims of the token,
+// i.e. RFC 3339 timestamps, are converted to the seconds since the epoch, as
+// in JWTs.
+func ParsePaseto(s string, keyFunc KeyFunc, leeway time.Duration) (*Token, error) {
+	var header string
+	switch {
+	case strings.HasPrefix(s, PasetoV2Public):
+		header = PasetoV2Public
+	case strings.HasPrefix(s, PasetoV4Public):
+		header = PasetoV4Public
+	default:
+		return nil, errors.ErrInvalidPasetoToken
+	}
+	parts := strings.Split(strings.TrimPrefix(s, header), ".")
+	if len(parts) > 2 {
+		return nil, errors.ErrInvalidPasetoToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(b) < ed25519.SignatureSize {
+		return nil, errors.ErrInvalidPasetoToken
+	}
+	message, signature := b[:len(b)-ed25519.SignatureSize], b[len(b)-ed25519.SignatureSize:]
+	var footer []byte
+	if len(parts) == 2 {
+		if footer, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+			return nil, errors.ErrInvalidPasetoToken
+		}
+	}
+
+	token := &Token{
+		Alg:    "EdDSA",
+		Method: EdDSA,
+		Header: map[string]interface{}{"alg": "EdDSA", "typ": strings.TrimSuffix(header, ".")},
+		Claims: map[string]interface{}{},
+	}
+	// The footer is either JSON object, e.g. with kid, or an opaque string.
+	footerClaims := map[string]interface{}{}
+	if json.Unmarshal(footer, &footerClaims) == nil {
+		if kid, ok := footerClaims["kid"].(string); ok {
+			token.Header["kid"] = kid
+		}
+	}
+	key, err := keyFunc(token)
+	if err != nil {
+		return nil, err
+	}
+	pk, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.ErrInvalidKeyType
+	}
+	pieces := [][]byte{[]byte(header), message, footer}
+	if header == PasetoV4Public {
+		// The implicit assertion of v4 tokens is empty.
+		pieces = append(pieces, nil)
+	}
+	if !ed25519.Verify(pk, preAuthEncode(pieces...), signature) {
+		return nil, errors.ErrPasetoSignature
+	}
+
+	if err := json.Unmarshal(message, &token.Claims); err != nil {
+		return nil, errors.ErrInvalidPasetoToken
+	}
+	for _, k := range []string{"exp", "nbf", "iat"} {
+		v, exists := token.Claims[k]
+		if !exists {
+			continue
+		}
+		ts, ok := v.(string)
+		if !ok {
+			return nil, errors.ErrPasetoClaim.WithArgs(k, v)
+		}
+		t, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			return nil, errors.ErrPasetoClaim.WithArgs(k, v)
+		}
+		token.Claims[k] = float64(t.Unix())
+	}
+	now := time.Now()
+	if exp, ok := token.Claims["exp"].(float64); ok && now.Add(-leeway).Unix() > int64(exp) {
+		return nil, errors.ErrExpiredToken
+	}
+	if nbf, ok := token.Claims["nbf"].(float64); ok && now.Add(leeway).Unix() < int64(nbf) {
+		return nil, errors.ErrTokenNotValidYet
+	}
+	return token, nil
+}
+
+// preAuthEncode returns the pre-authentication encoding of the pieces, i.e.
+// the signed message of PASETO tokens.
+func preAuthEncode(pieces ...[]byte) []byte {
+	le64 := func(n int) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, uint64(n)&(1<<63-1))
+		return b
+	}
+	b := le64(len(pieces))
+	for _, piece := range pieces {
+		b = append(b, le64(len(piece))...)
+		b = append(b, piece...)
+	}
+	return b
+}
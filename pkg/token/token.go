@@ -0,0 +1,244 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package token parses, decrypts, verifies, and signs JSON Web Tokens. It is
+// the only package using the JWT and JOSE libraries, so that the token
+// backends, the claims, and the validator do not depend on the libraries.
+package token
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"time"
+
+	jwtlib "github.com/golang-jwt/jwt/v5"
+	"github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+// SigningMethod is the family of the signing algorithm of a token.
+type SigningMethod int
+
+// The families of the signing algorithms.
+const (
+	UnknownMethod SigningMethod = iota
+	// HMAC is HS256, HS384, and HS512.
+	HMAC
+	// RSA is RS256, RS384, and RS512.
+	RSA
+	// RSAPSS is PS256, PS384, and PS512.
+	RSAPSS
+	// ECDSA is ES256, ES384, and ES512.
+	ECDSA
+	// EdDSA is EdDSA with Ed25519 curve.
+	EdDSA
+)
+
+// GetSigningMethod returns the family of the signing algorithm, e.g. RSA
+// for RS256.
+func GetSigningMethod(alg string) SigningMethod {
+	switch alg {
+	case "HS256", "HS384", "HS512":
+		return HMAC
+	case "RS256", "RS384", "RS512":
+		return RSA
+	case "PS256", "PS384", "PS512":
+		return RSAPSS
+	case "ES256", "ES384", "ES512":
+		return ECDSA
+	case "EdDSA":
+		return EdDSA
+	}
+	return UnknownMethod
+}
+
+// Token is a parsed token. The token passed to KeyFunc is not verified yet.
+type Token struct {
+	// The signing algorithm in alg header, e.g. RS256.
+	Alg string
+	// The family of the signing algorithm.
+	Method SigningMethod
+	Header map[string]interface{}
+	Claims map[string]interface{}
+}
+
+// KeyFunc returns the key for verifying the signature of the token.
+type KeyFunc func(*Token) (interface{}, error)
+
+// Parser parses and verifies tokens.
+type Parser interface {
+	// Parse verifies the signature of the token with the key returned by
+	// the key function, and the exp, nbf, and iat claims of the token.
+	Parse(s string, keyFunc KeyFunc) (*Token, error)
+	// ParseUnverified parses the token without verifying it, e.g. to read
+	// the claims selecting the keys.
+	ParseUnverified(s string) (*Token, error)
+}
+
+// ParserOptions are the options of Parser.
+type ParserOptions struct {
+	// The accepted signing algorithms, e.g. RS256. If empty, any supported
+	// algorithm is accepted.
+	ValidMethods []string
+	// The leeway applied to exp, nbf, and iat claims.
+	Leeway time.Duration
+}
+
+type parser struct {
+	parser *jwtlib.Parser
+}
+
+// NewParser returns Parser instance.
+func NewParser(opts *ParserOptions) Parser {
+	parserOpts := []jwtlib.ParserOption{jwtlib.WithIssuedAt()}
+	if opts != nil && len(opts.ValidMethods) > 0 {
+		parserOpts = append(parserOpts, jwtlib.WithValidMethods(opts.ValidMethods))
+	}
+	if opts != nil && opts.Leeway > 0 {
+		parserOpts = append(parserOpts, jwtlib.WithLeeway(opts.Leeway))
+	}
+	return &parser{
+		parser: jwtlib.NewParser(parserOpts...),
+	}
+}
+
+// Parse parses and verifies the token.
+func (p *parser) Parse(s string, keyFunc KeyFunc) (*Token, error) {
+	claims := jwtlib.MapClaims{}
+	var keyErr error
+	t, err := p.parser.ParseWithClaims(s, claims, func(t *jwtlib.Token) (interface{}, error) {
+		if err := checkCriticalHeaders(t.Header); err != nil {
+			keyErr = err
+			return nil, err
+		}
+		key, err := keyFunc(newToken(t, claims))
+		keyErr = err
+		return key, err
+	})
+	if err != nil {
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		return nil, unwrapError(err)
+	}
+	if !t.Valid {
+		return nil, errors.ErrInvalidToken
+	}
+	return newToken(t, claims), nil
+}
+
+// ParseUnverified parses the token without verifying it. The token with
+// unsupported crit header is rejected, because the token verified remotely,
+// e.g. with Kubernetes TokenReview API, is not parsed again.
+func (p *parser) ParseUnverified(s string) (*Token, error) {
+	claims := jwtlib.MapClaims{}
+	t, _, err := p.parser.ParseUnverified(s, claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCriticalHeaders(t.Header); err != nil {
+		return nil, err
+	}
+	return newToken(t, claims), nil
+}
+
+// checkCriticalHeaders checks crit header of the token. Per RFC 7515, the
+// token declaring an extension not understood by the parser is rejected. The
+// parser does not understand any extensions.
+func checkCriticalHeaders(header map[string]interface{}) error {
+	v, exists := header["crit"]
+	if !exists {
+		return nil
+	}
+	names, ok := v.([]interface{})
+	if !ok || len(names) == 0 {
+		return errors.ErrCriticalHeaderMalformed
+	}
+	for _, n := range names {
+		name, ok := n.(string)
+		if !ok || name == "" {
+			return errors.ErrCriticalHeaderMalformed
+		}
+		// The extensions must be present in the header.
+		if _, exists := header[name]; !exists {
+			return errors.ErrCriticalHeaderMalformed
+		}
+	}
+	return errors.ErrCriticalHeaderUnsupported.WithArgs(names[0])
+}
+
+// unwrapError returns the cause of the error returned by the library, e.g.
+// crypto/rsa: verification error, without the generic prefix, e.g. token
+// signature is invalid.
+func unwrapError(err error) error {
+	if e, ok := err.(interface{ Unwrap() []error }); ok {
+		if errs := e.Unwrap(); len(errs) > 1 {
+			return errs[len(errs)-1]
+		}
+	}
+	return err
+}
+
+func newToken(t *jwtlib.Token, claims jwtlib.MapClaims) *Token {
+	alg, _ := t.Header["alg"].(string)
+	return &Token{
+		Alg:    alg,
+		Method: GetSigningMethod(alg),
+		Header: t.Header,
+		Claims: claims,
+	}
+}
+
+// Sign returns the token with the claims signed with the key. The claims are
+// encoded as JSON. The headers, e.g. kid, are added to the header of the token.
+func Sign(alg string, claims interface{}, headers map[string]interface{}, key interface{}) (string, error) {
+	if GetSigningMethod(alg) == UnknownMethod {
+		return "", errors.ErrInvalidSigningMethod
+	}
+	sm := jwtlib.GetSigningMethod(alg)
+	if sm == nil {
+		return "", errors.ErrInvalidSigningMethod
+	}
+	t := jwtlib.NewWithClaims(sm, encodedClaims{claims})
+	for k, v := range headers {
+		t.Header[k] = v
+	}
+	s, err := t.SignedString(key)
+	switch {
+	case stderrors.Is(err, jwtlib.ErrInvalidKeyType):
+		return "", errors.ErrInvalidKeyType
+	case stderrors.Is(err, jwtlib.ErrInvalidKey):
+		return "", errors.ErrInvalidKey
+	case err != nil:
+		return "", err
+	}
+	return s, nil
+}
+
+// encodedClaims are the claims being signed. The library requires the
+// claims to provide the registered claims, but uses them only when
+// verifying tokens.
+type encodedClaims struct {
+	claims interface{}
+}
+
+func (c encodedClaims) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.claims)
+}
+
+func (c encodedClaims) GetExpirationTime() (*jwtlib.NumericDate, error) { return nil, nil }
+func (c encodedClaims) GetIssuedAt() (*jwtlib.NumericDate, error)       { return nil, nil }
+func (c encodedClaims) GetNotBefore() (*jwtlib.NumericDate, error)      { return nil, nil }
+func (c encodedClaims) GetIssuer() (string, error)                      { return "", nil }
+func (c encodedClaims) GetSubject() (string, error)                     { return "", nil }
+func (c encodedClaims) GetAudience() (jwtlib.ClaimStrings, error)       { return nil, nil }
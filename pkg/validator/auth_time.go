@@ -0,0 +1,103 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validator
+
+import (
+	"encoding/json"
+	"time"
+
+	jwtacl "github.com/greenpau/caddy-auth-jwt/pkg/acl"
+	jwtclaims "github.com/greenpau/caddy-auth-jwt/pkg/claims"
+	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
+	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+// checkAuthTime checks that the user of the token authenticated, per auth_time
+// claim, recently enough to access the path. When several requirements apply
+// to the path, the one with the shortest maximum age is enforced. The tokens
+// of stale sessions are rejected even though the tokens did not expire, so
+// that the users are sent to authenticate again.
+func (v *TokenValidator) checkAuthTime(path string, claims *jwtclaims.UserClaims, opts *jwtconfig.TokenValidatorOptions) error {
+	maxAge := v.getAuthTimeMaxAge(path)
+	if maxAge == 0 {
+		return nil
+	}
+	authTime, found := getAuthTime(claims)
+	if !found {
+		return jwterrors.ErrAuthTimeNotFound
+	}
+	var leeway time.Duration
+	if opts != nil && opts.ClockSkew > 0 {
+		leeway = time.Duration(opts.ClockSkew) * time.Second
+	}
+	if age := time.Since(time.Unix(authTime, 0)); age > maxAge+leeway {
+		return jwterrors.ErrAuthTimeExceeded.WithArgs(age.Round(time.Second), maxAge)
+	}
+	return nil
+}
+
+// getAuthTimeMaxAge returns the shortest maximum age of authentication among
+// the requirements applying to the path, or zero when none applies.
+func (v *TokenValidator) getAuthTimeMaxAge(path string) time.Duration {
+	var maxAge time.Duration
+	for _, req := range v.AuthTimeRequirements {
+		if req.MaxAge <= 0 || !isAuthTimeRequired(req, path) {
+			continue
+		}
+		if d := time.Duration(req.MaxAge) * time.Second; maxAge == 0 || d < maxAge {
+			maxAge = d
+		}
+	}
+	return maxAge
+}
+
+func isAuthTimeRequired(req *jwtconfig.AuthTimeRequirement, path string) bool {
+	if len(req.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range req.Paths {
+		if pattern == "any" || jwtacl.MatchPathBasedACL(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// getAuthTime returns the value of auth_time claim, in seconds since epoch.
+func getAuthTime(claims *jwtclaims.UserClaims) (int64, bool) {
+	if claims == nil {
+		return 0, false
+	}
+	v, found := claims.GetClaim("auth_time")
+	if !found {
+		return 0, false
+	}
+	switch v := v.(type) {
+	case float64:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, true
+		}
+		if f, err := v.Float64(); err == nil {
+			return int64(f), true
+		}
+	}
+	return 0, false
+}
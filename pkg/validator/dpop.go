@@ -0,0 +1,205 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validator
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwtbackends "github.com/greenpau/caddy-auth-jwt/pkg/backends"
+	jwtclaims "github.com/greenpau/caddy-auth-jwt/pkg/claims"
+	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
+	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwttoken "github.com/greenpau/caddy-auth-jwt/pkg/token"
+)
+
+// The default maximum age of DPoP proofs in seconds.
+const defaultDPoPMaxAge = 60
+
+// dpopSigningMethods are the signing algorithms accepted of DPoP proofs. Per
+// RFC 9449, the proofs are signed with asymmetric keys.
+var dpopSigningMethods = []string{
+	"RS256", "RS384", "RS512", "PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512", "EdDSA",
+}
+
+// getAuthorizationScheme splits the value of Authorization header into the
+// authentication scheme and the credentials.
+func getAuthorizationScheme(s string) (string, string) {
+	kv := strings.SplitN(strings.TrimSpace(s), " ", 2)
+	if len(kv) != 2 {
+		return "", ""
+	}
+	return kv[0], strings.TrimSpace(kv[1])
+}
+
+// isBearerScheme returns true if the authentication scheme is Bearer, or one
+// of the bearer schemes of the options, e.g. JWT. The schemes are
+// case-insensitive.
+func isBearerScheme(scheme string, opts *jwtconfig.TokenValidatorOptions) bool {
+	if strings.EqualFold(scheme, "Bearer") {
+		return true
+	}
+	if opts == nil {
+		return false
+	}
+	for _, s := range opts.BearerSchemes {
+		if strings.EqualFold(scheme, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDPoPProof checks DPoP proof of the request per RFC 9449. The proof is
+// signed with the key in its jwk header, and the thumbprint of the key is the
+// cnf.jkt claim of the access token. The proof is bound to the method and
+// the URI of the request, and to the access token.
+func (v *TokenValidator) checkDPoPProof(r *http.Request, accessToken string, claims *jwtclaims.UserClaims, opts *jwtconfig.TokenValidatorOptions) error {
+	proofs := r.Header.Values("DPoP")
+	switch {
+	case len(proofs) == 0:
+		return jwterrors.ErrDPoPProofNotFound
+	case len(proofs) > 1:
+		return jwterrors.ErrDPoPProofNotUnique
+	}
+	var leeway time.Duration
+	if opts.ClockSkew > 0 {
+		leeway = time.Duration(opts.ClockSkew) * time.Second
+	}
+	maxAge := time.Duration(defaultDPoPMaxAge) * time.Second
+	if opts.DPoPMaxAge > 0 {
+		maxAge = time.Duration(opts.DPoPMaxAge) * time.Second
+	}
+
+	var thumbprint string
+	parser := jwttoken.NewParser(&jwttoken.ParserOptions{ValidMethods: dpopSigningMethods, Leeway: leeway})
+	proof, err := parser.Parse(proofs[0], func(token *jwttoken.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); !strings.EqualFold(typ, "dpop+jwt") {
+			return nil, jwterrors.ErrTokenTypeMismatch.WithArgs("dpop+jwt", token.Header["typ"])
+		}
+		jwk, ok := token.Header["jwk"].(map[string]interface{})
+		if !ok {
+			return nil, jwterrors.ErrDPoPProof.WithArgs("jwk header not found")
+		}
+		// The proof must not carry the private key.
+		if _, exists := jwk["d"]; exists {
+			return nil, jwterrors.ErrDPoPProof.WithArgs("jwk header has private key")
+		}
+		b, err := json.Marshal(jwk)
+		if err != nil {
+			return nil, err
+		}
+		key := &jwtbackends.JwksKey{}
+		if err := json.Unmarshal(b, key); err != nil {
+			return nil, jwterrors.ErrDPoPProof.WithArgs(err)
+		}
+		if thumbprint, err = key.Thumbprint(); err != nil {
+			return nil, err
+		}
+		return key.PublicKey()
+	})
+	if err != nil {
+		return jwterrors.ErrDPoPProof.WithArgs(err)
+	}
+
+	if htm, _ := proof.Claims["htm"].(string); htm != r.Method {
+		return jwterrors.ErrDPoPProofClaimMismatch.WithArgs("htm", r.Method, proof.Claims["htm"])
+	}
+	htu, _ := proof.Claims["htu"].(string)
+	if requestURI := getRequestURI(r); !matchRequestURI(htu, requestURI) {
+		return jwterrors.ErrDPoPProofClaimMismatch.WithArgs("htu", requestURI, proof.Claims["htu"])
+	}
+	iat, ok := proof.Claims["iat"].(float64)
+	if !ok {
+		return jwterrors.ErrDPoPProofClaimNotFound.WithArgs("iat")
+	}
+	issuedAt := time.Unix(int64(iat), 0)
+	if time.Since(issuedAt) > maxAge+leeway || time.Until(issuedAt) > leeway {
+		return jwterrors.ErrDPoPProofExpired
+	}
+	sum := sha256.Sum256([]byte(accessToken))
+	ath := base64.RawURLEncoding.EncodeToString(sum[:])
+	if proofAth, _ := proof.Claims["ath"].(string); proofAth != ath {
+		return jwterrors.ErrDPoPProofClaimMismatch.WithArgs("ath", ath, proof.Claims["ath"])
+	}
+	if jkt := getConfirmationClaim(claims, "jkt"); jkt != thumbprint {
+		return jwterrors.ErrDPoPKeyMismatch
+	}
+	jti, _ := proof.Claims["jti"].(string)
+	if jti == "" {
+		return jwterrors.ErrDPoPProofClaimNotFound.WithArgs("jti")
+	}
+	added, err := v.dpopProofs.Add(thumbprint+" "+jti, issuedAt.Add(maxAge+leeway))
+	if err != nil {
+		return jwterrors.ErrDPoPProof.WithArgs(err)
+	}
+	if !added {
+		return jwterrors.ErrDPoPProofReplayed
+	}
+	return nil
+}
+
+// getConfirmationClaim returns the member of cnf claim of the token, e.g. jkt
+// of the tokens bound to DPoP key.
+func getConfirmationClaim(claims *jwtclaims.UserClaims, k string) string {
+	if claims == nil {
+		return ""
+	}
+	cnf, _ := claims.RawClaims["cnf"].(map[string]interface{})
+	v, _ := cnf[k].(string)
+	return v
+}
+
+// getRequestURI returns the URI of the request without the query and the
+// fragment.
+func getRequestURI(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.EscapedPath()
+}
+
+// matchRequestURI returns true if htu claim of DPoP proof is the URI of the
+// request. The query and the fragment of the claim are ignored, and the
+// scheme and the host are case-insensitive.
+func matchRequestURI(htu, requestURI string) bool {
+	u, err := url.Parse(htu)
+	if err != nil || htu == "" {
+		return false
+	}
+	expected, err := url.Parse(requestURI)
+	if err != nil {
+		return false
+	}
+	normalizeHost := func(u *url.URL) string {
+		host := strings.ToLower(u.Host)
+		switch {
+		case u.Scheme == "https" && strings.HasSuffix(host, ":443"):
+			return strings.TrimSuffix(host, ":443")
+		case u.Scheme == "http" && strings.HasSuffix(host, ":80"):
+			return strings.TrimSuffix(host, ":80")
+		}
+		return host
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	return u.Scheme == expected.Scheme && normalizeHost(u) == normalizeHost(expected) && u.EscapedPath() == expected.EscapedPath()
+}
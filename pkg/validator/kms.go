@@ -15,16 +15,23 @@
 package validator
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"unicode"
 
-	jwtlib "github.com/dgrijalva/jwt-go"
 	//"go.uber.org/zap"
+	jwtbackends "github.com/greenpau/caddy-auth-jwt/pkg/backends"
 	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
 	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	"go.uber.org/zap"
 )
 
 var defaultKeyID = "0"
@@ -38,6 +45,7 @@ var rsaConfigSource = []string{"env", "config"} // this is how tokenSecret works
 type kmsLoader struct {
 	conf          *jwtconfig.CommonTokenConfig
 	_dir          string
+	_dirs         []string // the directories of non-RSA key types
 	_files, _keys map[string]string
 }
 
@@ -46,6 +54,11 @@ func (l *kmsLoader) config() {
 	if configDir != "" {
 		l._dir = configDir
 	}
+	for _, dir := range []string{l.conf.TokenECDSADir, l.conf.TokenEdDSADir} {
+		if dir != "" {
+			l._dirs = append(l._dirs, dir)
+		}
+	}
 
 	for k, v := range l.conf.TokenRSAFiles {
 		l._files[k] = v
@@ -64,6 +77,34 @@ func (l *kmsLoader) config() {
 			l._keys[defaultKeyID] = l.conf.TokenRSAKey // <- overwrite explict key
 		}
 	}
+
+	l.merge(l.conf.TokenECDSAFiles, l.conf.TokenECDSAKeys, l.conf.TokenECDSAFile, l.conf.TokenECDSAKey)
+	l.merge(l.conf.TokenEdDSAFiles, l.conf.TokenEdDSAKeys, l.conf.TokenEdDSAFile, l.conf.TokenEdDSAKey)
+}
+
+// merge adds the files and keys of non-RSA key types without overwriting
+// the already present key ids.
+func (l *kmsLoader) merge(files, keys map[string]string, file, key string) {
+	for k, v := range files {
+		if _, ok := l._files[k]; !ok {
+			l._files[k] = v
+		}
+	}
+	for k, v := range keys {
+		if _, ok := l._keys[k]; !ok {
+			l._keys[k] = v
+		}
+	}
+	if file != "" {
+		if _, ok := l._files[defaultKeyID]; !ok {
+			l._files[defaultKeyID] = file
+		}
+	}
+	if key != "" {
+		if _, ok := l._keys[defaultKeyID]; !ok {
+			l._keys[defaultKeyID] = key
+		}
+	}
 }
 
 func (l *kmsLoader) env() {
@@ -100,53 +141,64 @@ func (l *kmsLoader) env() {
 }
 
 func (l *kmsLoader) directory() (done bool, err error) {
-	slash := string(filepath.Separator)
+	dirs := l._dirs
 	if len(l._dir) > 0 {
-		err = filepath.Walk(l._dir, func(path string, info os.FileInfo, err error) error {
-			if err != nil || info.IsDir() {
-				return err
-			}
+		dirs = append([]string{l._dir}, dirs...)
+	}
+	for _, dir := range dirs {
+		if err := l.walkDirectory(dir); err != nil {
+			return false, jwterrors.ErrWalkDir.WithArgs(err)
+		}
+		done = true // we have success
+	}
+	return done, nil
+}
 
-			absDir, err := filepath.Abs(l._dir)
-			if err != nil {
-				absDir = l._dir // just fall back to the value we had before
+// walkDirectory loads the keys from the files in the directory and its
+// subdirectories. The kid is the path of the file relative to the directory,
+// without the file extension, and with the path separators replaced with
+// underscores.
+func (l *kmsLoader) walkDirectory(dir string) error {
+	slash := string(filepath.Separator)
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			absDir = dir // just fall back to the value we had before
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			absPath = path
+		}
+		key := strings.TrimPrefix(absPath, absDir)
+		key = strings.TrimSuffix(key, filepath.Ext(key))
+		key = strings.Replace(key, slash, "_", -1)
+		key = strings.Trim(key, "_")
+		for i := 0; i < len(key); i++ {
+			c := key[i]
+			switch {
+			case c == 95, // make sure we only have chars [0-9a-zA-Z_]
+				c >= 48 && c <= 57,
+				c >= 65 && c <= 90,
+				c >= 97 && c <= 122:
+				continue
 			}
-			absPath, err := filepath.Abs(path)
+			return nil
+		}
+
+		if _, ok := l._keys[key]; !ok {
+			b, err := ioutil.ReadFile(path)
 			if err != nil {
-				absPath = path
-			}
-			key := strings.TrimPrefix(absPath, absDir)
-			key = strings.TrimSuffix(key, ".key")
-			key = strings.Replace(key, slash, "_", -1)
-			key = strings.Trim(key, "_")
-			for i := 0; i < len(key); i++ {
-				c := key[i]
-				switch {
-				case c == 95, // make sure we only have chars [0-9a-zA-Z_]
-					c >= 48 && c <= 57,
-					c >= 65 && c <= 90,
-					c >= 97 && c <= 122:
-					continue
-				}
-				return nil
+				return jwterrors.ErrReadPEMFile.WithArgs("dir", err)
 			}
 
-			if _, ok := l._keys[key]; !ok {
-				b, err := ioutil.ReadFile(path)
-				if err != nil {
-					return jwterrors.ErrReadPEMFile.WithArgs("dir", err)
-				}
-
-				l._keys[key] = string(b)
-			}
-			return nil
-		})
-		if err != nil {
-			return false, jwterrors.ErrWalkDir.WithArgs(err)
+			l._keys[key] = string(b)
 		}
-		done = true // we have success
-	}
-	return done, err
+		return nil
+	})
 }
 
 func (l *kmsLoader) file() (done bool, err error) {
@@ -173,9 +225,9 @@ func (l *kmsLoader) key() (done bool, err error) {
 	return done, err
 }
 
-// LoadEncryptionKeys loads keys for the RSA encryption based on the order determined
-// by rsaSource and rsaConfigSource
-func LoadEncryptionKeys(config *jwtconfig.CommonTokenConfig) error {
+// newKmsLoader returns the loader with the key sources of the configuration
+// based on the order determined by rsaConfigSource.
+func newKmsLoader(config *jwtconfig.CommonTokenConfig) (*kmsLoader, error) {
 	loader := &kmsLoader{
 		conf: config,
 		// log:    logger,
@@ -189,58 +241,317 @@ func LoadEncryptionKeys(config *jwtconfig.CommonTokenConfig) error {
 		"env":    loader.env,
 	}
 
-	// ss is the sourceSource
-	ss := map[string]func() (bool, error){
-		"dir":  loader.directory,
-		"file": loader.file,
-		"key":  loader.key,
-	}
-
 	for _, configSrc := range rsaConfigSource {
 		fn, exists := cs[configSrc]
 		if !exists {
-			return jwterrors.ErrUnknownConfigSource
+			return nil, jwterrors.ErrUnknownConfigSource
 		}
 		fn()
 	}
+	return loader, nil
+}
+
+// paths returns the key files and directories of the loader.
+func (l *kmsLoader) paths() []string {
+	var paths []string
+	if l._dir != "" {
+		paths = append(paths, l._dir)
+	}
+	paths = append(paths, l._dirs...)
+	for _, fp := range l._files {
+		paths = append(paths, fp)
+	}
+	return paths
+}
+
+// LoadEncryptionKeys loads keys for the RSA encryption based on the order determined
+// by rsaSource and rsaConfigSource
+func LoadEncryptionKeys(config *jwtconfig.CommonTokenConfig) error {
+	keys, err := loadEncryptionKeys(config)
+	for k, pk := range keys {
+		config.AddTokenKey(k, pk)
+	}
+	return err
+}
+
+// loadEncryptionKeys returns the keys of the configuration. Along with the
+// error, it returns the keys parsed successfully.
+func loadEncryptionKeys(config *jwtconfig.CommonTokenConfig) (map[string]interface{}, error) {
+	loader, err := newKmsLoader(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// ss is the sourceSource
+	ss := map[string]func() (bool, error){
+		"dir":  loader.directory,
+		"file": loader.file,
+		"key":  loader.key,
+	}
 
 	for _, src := range rsaSource {
 		fn, exists := ss[src]
 		if !exists {
-			return jwterrors.ErrUnknownConfigSource
+			return nil, jwterrors.ErrUnknownConfigSource
 		}
 		done, err := fn()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if done {
 			break
 		}
 	}
 
+	keys := make(map[string]interface{})
 	var rtnErr error
 	for k, v := range loader._keys {
 		//loader.log.Info("RSA key processing...", zap.String("name", k))
 
 		switch {
 		case strings.Contains(v, "BEGIN RSA PRIVATE"):
-			pk, err := jwtlib.ParseRSAPrivateKeyFromPEM([]byte(v))
+			pk, err := parseRSAPrivateKeyFromPEM(k, []byte(v))
 			if err != nil {
 				rtnErr = fmt.Errorf("%v %w", rtnErr, err) // wraps error
 				continue
 			}
-			config.AddTokenKey(k, pk)
+			keys[k] = pk
 			//loader.log.Info("RSA private key added", zap.String("name", k))
+		case strings.Contains(v, "BEGIN EC PRIVATE"):
+			pk, err := parseECPrivateKeyFromPEM(k, []byte(v))
+			if err != nil {
+				rtnErr = fmt.Errorf("%v %w", rtnErr, err) // wraps error
+				continue
+			}
+			keys[k] = pk
+		case strings.Contains(v, "BEGIN PRIVATE KEY"):
+			pk, err := parsePrivateKeyFromPEM(k, []byte(v))
+			if err != nil {
+				rtnErr = fmt.Errorf("%v %w", rtnErr, err) // wraps error
+				continue
+			}
+			keys[k] = pk
 		case strings.Contains(v, "BEGIN PUBLIC KEY"):
-			pk, err := jwtlib.ParseRSAPublicKeyFromPEM([]byte(v))
+			pk, err := parsePublicKeyFromPEM(k, []byte(v))
 			if err != nil {
 				rtnErr = fmt.Errorf("%v %w", rtnErr, err) // wraps error
 				continue
 			}
-			config.AddTokenKey(k, pk)
+			keys[k] = pk
 			//loader.log.Info("RS public key added", zap.String("name", k))
 		}
 	}
 
-	return rtnErr
+	return keys, rtnErr
+}
+
+// LoadSecret reads the shared secret of the configuration from
+// token_secret_file. The secret in token_secret takes precedence.
+func LoadSecret(config *jwtconfig.CommonTokenConfig) error {
+	if config.TokenSecret != "" || config.TokenSecretFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(config.TokenSecretFile)
+	if err != nil {
+		return jwterrors.ErrReadSecretFile.WithArgs(config.TokenSecretFile, err)
+	}
+	secret := strings.TrimRightFunc(string(b), unicode.IsSpace)
+	if secret == "" {
+		return jwterrors.ErrEmptySecretFile.WithArgs(config.TokenSecretFile)
+	}
+	config.TokenSecret = secret
+	return nil
+}
+
+// loadDecryptionKey returns the private key decrypting the encrypted tokens,
+// i.e. PEM encoded RSA or ECDSA private key in the configuration or in the
+// key file.
+func loadDecryptionKey(config *jwtconfig.CommonTokenConfig) (interface{}, error) {
+	kid := "token_decryption_key"
+	b := []byte(config.TokenDecryptionKey)
+	if config.TokenDecryptionKey == "" {
+		kid = config.TokenDecryptionKeyFile
+		var err error
+		b, err = ioutil.ReadFile(config.TokenDecryptionKeyFile)
+		if err != nil {
+			return nil, jwterrors.ErrInvalidDecryptionKey.WithArgs(kid, err)
+		}
+	}
+	if pk, err := parseRSAPrivateKeyFromPEM(kid, b); err == nil {
+		return pk, nil
+	}
+	pk, err := parseECPrivateKeyFromPEM(kid, b)
+	if err != nil {
+		return nil, jwterrors.ErrInvalidDecryptionKey.WithArgs(kid, err)
+	}
+	return pk, nil
+}
+
+// LoadKeySets loads the keys from JSON Web Key Set file and from JSON Web
+// Key Set embedded in the configuration.
+func LoadKeySets(config *jwtconfig.CommonTokenConfig, logger *zap.Logger) error {
+	keys, err := loadKeySets(config, logger)
+	if err != nil {
+		return err
+	}
+	for k, pk := range keys {
+		config.AddTokenKey(k, pk)
+	}
+	return nil
+}
+
+// loadKeySets returns the keys of JSON Web Key Set file and JSON Web Key Set
+// embedded in the configuration.
+func loadKeySets(config *jwtconfig.CommonTokenConfig, logger *zap.Logger) (map[string]interface{}, error) {
+	if config.TokenJwksFile == "" && config.TokenJwks == "" {
+		return nil, nil
+	}
+	keySetOptions, err := getKeySetOptions(config, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	if config.TokenJwksFile != "" {
+		fileKeys, err := jwtbackends.ParseKeySetFile(config.TokenJwksFile, keySetOptions)
+		if err != nil {
+			return nil, err
+		}
+		for k, pk := range fileKeys {
+			keys[k] = pk
+		}
+	}
+
+	if config.TokenJwks != "" {
+		inlineKeys, err := jwtbackends.ParseInlineKeySet(config.TokenJwks, keySetOptions)
+		if err != nil {
+			return nil, err
+		}
+		for k, pk := range inlineKeys {
+			keys[k] = pk
+		}
+	}
+	return keys, nil
+}
+
+// loadVerificationKeys reads the keys of the configuration from the key
+// files, directories, and key sets again. Unlike LoadEncryptionKeys, it
+// fails on any malformed key and leaves the configuration unchanged.
+func loadVerificationKeys(config *jwtconfig.CommonTokenConfig, logger *zap.Logger) (map[string]interface{}, error) {
+	keys, err := loadEncryptionKeys(config)
+	if err != nil {
+		return nil, err
+	}
+	keySetKeys, err := loadKeySets(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	for k, pk := range keySetKeys {
+		keys[k] = pk
+	}
+	return keys, nil
+}
+
+// keyFilePaths returns the key files and directories of the configuration.
+func keyFilePaths(config *jwtconfig.CommonTokenConfig) ([]string, error) {
+	loader, err := newKmsLoader(config)
+	if err != nil {
+		return nil, err
+	}
+	paths := loader.paths()
+	if config.TokenJwksFile != "" {
+		paths = append(paths, config.TokenJwksFile)
+	}
+	return paths, nil
+}
+
+// getKeySetOptions returns the options of JSON Web Key Set parsing.
+func getKeySetOptions(config *jwtconfig.CommonTokenConfig, logger *zap.Logger) (*jwtbackends.KeySetOptions, error) {
+	opts := &jwtbackends.KeySetOptions{
+		Logger: logger,
+	}
+	if config.TokenJwksX5cCAFile != "" {
+		pool, err := jwtbackends.LoadCertPool(config.TokenJwksX5cCAFile)
+		if err != nil {
+			return nil, err
+		}
+		opts.X5cRoots = pool
+	}
+	return opts, nil
+}
+
+// parsePublicKeyFromPEM parses PEM encoded PKIX public key. The supported
+// key types are RSA, ECDSA, and Ed25519.
+func parsePublicKeyFromPEM(kid string, b []byte) (interface{}, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, jwterrors.ErrKeyMustBePEMEncoded
+	}
+	pk, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch pk.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return pk, nil
+	}
+	return nil, jwterrors.ErrUnsupportedKeyType.WithArgs(pk, kid)
+}
+
+// parsePrivateKeyFromPEM parses PEM encoded PKCS #8 private key. The supported
+// key types are RSA, ECDSA, and Ed25519.
+func parsePrivateKeyFromPEM(kid string, b []byte) (interface{}, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, jwterrors.ErrKeyMustBePEMEncoded
+	}
+	pk, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch pk.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return pk, nil
+	}
+	return nil, jwterrors.ErrUnsupportedKeyType.WithArgs(pk, kid)
+}
+
+// parseRSAPrivateKeyFromPEM parses PEM encoded PKCS #1 or PKCS #8 RSA private key.
+func parseRSAPrivateKeyFromPEM(kid string, b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, jwterrors.ErrKeyMustBePEMEncoded
+	}
+	if pk, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return pk, nil
+	}
+	pk, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := pk.(*rsa.PrivateKey)
+	if !ok {
+		return nil, jwterrors.ErrUnsupportedKeyType.WithArgs(pk, kid)
+	}
+	return rsaKey, nil
+}
+
+// parseECPrivateKeyFromPEM parses PEM encoded SEC 1 or PKCS #8 ECDSA private key.
+func parseECPrivateKeyFromPEM(kid string, b []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, jwterrors.ErrKeyMustBePEMEncoded
+	}
+	if pk, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return pk, nil
+	}
+	pk, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := pk.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, jwterrors.ErrUnsupportedKeyType.WithArgs(pk, kid)
+	}
+	return ecKey, nil
 }
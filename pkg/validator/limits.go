@@ -0,0 +1,98 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+
+	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
+	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwttoken "github.com/greenpau/caddy-auth-jwt/pkg/token"
+)
+
+// checkTokenLimits checks the size of the token, and the number of the claims
+// and the nesting depth of the header and the claims of JWT, before the token
+// is decrypted or parsed. The limits protect the parsing from oversized or
+// pathological tokens. The malformed tokens are left to the parser.
+func checkTokenLimits(s string, opts *jwtconfig.TokenValidatorOptions) error {
+	if opts == nil {
+		return nil
+	}
+	if opts.MaxTokenSize > 0 && len(s) > opts.MaxTokenSize {
+		return jwterrors.ErrTokenTooLarge.WithArgs(opts.MaxTokenSize)
+	}
+	if opts.MaxTokenClaims <= 0 && opts.MaxTokenDepth <= 0 {
+		return nil
+	}
+	if jwttoken.IsEncrypted(s) || isOpaqueToken(s) || jwttoken.IsPaseto(s) {
+		return nil
+	}
+	parts := strings.Split(s, ".")
+	for i, part := range parts[:2] {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+		if err != nil {
+			return nil
+		}
+		if opts.MaxTokenDepth > 0 {
+			if depth, err := getJSONDepth(b, opts.MaxTokenDepth); err != nil {
+				return nil
+			} else if depth > opts.MaxTokenDepth {
+				return jwterrors.ErrTokenTooDeep.WithArgs(opts.MaxTokenDepth)
+			}
+		}
+		if i == 1 && opts.MaxTokenClaims > 0 {
+			claims := make(map[string]json.RawMessage)
+			if err := json.Unmarshal(b, &claims); err != nil {
+				return nil
+			}
+			if len(claims) > opts.MaxTokenClaims {
+				return jwterrors.ErrTokenTooManyClaims.WithArgs(opts.MaxTokenClaims)
+			}
+		}
+	}
+	return nil
+}
+
+// getJSONDepth returns the nesting depth of the objects and the arrays in
+// JSON document. It stops when the depth exceeds the limit.
+func getJSONDepth(b []byte, limit int) (int, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	var depth, maxDepth int
+	for {
+		t, err := dec.Token()
+		if err == io.EOF {
+			return maxDepth, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			if depth > maxDepth {
+				maxDepth = depth
+			}
+			if maxDepth > limit {
+				return maxDepth, nil
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+}
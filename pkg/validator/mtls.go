@@ -0,0 +1,69 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validator
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+
+	jwtacl "github.com/greenpau/caddy-auth-jwt/pkg/acl"
+	jwtclaims "github.com/greenpau/caddy-auth-jwt/pkg/claims"
+	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
+	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+// The path of CertificateBoundPaths matching any path.
+const certificateBoundPathAny = "any"
+
+// checkCertificateBinding checks that the token bound to a client certificate
+// per RFC 8705, i.e. with cnf.x5t#S256 claim, is presented over the mutual TLS
+// connection authenticated with the certificate. The tokens are checked when
+// the certificate binding is enabled, or when the path of the request requires
+// the binding. The tokens presented for these paths must be bound.
+func (v *TokenValidator) checkCertificateBinding(r *http.Request, claims *jwtclaims.UserClaims, opts *jwtconfig.TokenValidatorOptions) error {
+	required := v.isCertificateBindingRequired(r.URL.Path)
+	if !required && (opts == nil || !opts.ValidateCertificateBinding) {
+		return nil
+	}
+	thumbprint := getConfirmationClaim(claims, "x5t#S256")
+	if thumbprint == "" {
+		if required {
+			return jwterrors.ErrCertificateBindingRequired
+		}
+		return nil
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return jwterrors.ErrClientCertificateNotFound
+	}
+	sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(thumbprint), []byte(expected)) != 1 {
+		return jwterrors.ErrCertificateBindingMismatch
+	}
+	return nil
+}
+
+// isCertificateBindingRequired returns true if the tokens presented for the
+// path must be bound to client certificates.
+func (v *TokenValidator) isCertificateBindingRequired(path string) bool {
+	for _, pattern := range v.CertificateBoundPaths {
+		if pattern == certificateBoundPathAny || jwtacl.MatchPathBasedACL(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validator
+
+import (
+	"time"
+
+	jwtacl "github.com/greenpau/caddy-auth-jwt/pkg/acl"
+	jwtclaims "github.com/greenpau/caddy-auth-jwt/pkg/claims"
+	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
+	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+)
+
+// checkReplay rejects the second presentation of the token for the single-use
+// paths, e.g. the one-shot tokens of webhook callbacks. The tokens must have
+// jti claim identifying them, and exp claim limiting the time the jti is
+// recorded. The check runs after the other checks, so that the rejected
+// requests do not use up the tokens.
+func (v *TokenValidator) checkReplay(path string, claims *jwtclaims.UserClaims, opts *jwtconfig.TokenValidatorOptions) error {
+	if !v.isSingleUsePath(path) {
+		return nil
+	}
+	if claims.ID == "" {
+		return jwterrors.ErrSingleUseTokenNoJTI
+	}
+	if claims.ExpiresAt == 0 {
+		return jwterrors.ErrSingleUseTokenNoExp
+	}
+	var leeway time.Duration
+	if opts != nil && opts.ClockSkew > 0 {
+		leeway = time.Duration(opts.ClockSkew) * time.Second
+	}
+	// The jti claims are unique per issuer.
+	added, err := v.ReplayStore.Add(claims.Issuer+" "+claims.ID, time.Unix(claims.ExpiresAt, 0).Add(leeway))
+	if err != nil {
+		return jwterrors.ErrReplayStore.WithArgs(err)
+	}
+	if !added {
+		return jwterrors.ErrTokenReplayed
+	}
+	return nil
+}
+
+// isSingleUsePath returns true if the tokens presented for the path are
+// accepted only once.
+func (v *TokenValidator) isSingleUsePath(path string) bool {
+	for _, pattern := range v.SingleUsePaths {
+		if pattern == "any" || jwtacl.MatchPathBasedACL(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validator
+
+import (
+	"time"
+
+	jwtbackends "github.com/greenpau/caddy-auth-jwt/pkg/backends"
+	jwtclaims "github.com/greenpau/caddy-auth-jwt/pkg/claims"
+	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwtrevocation "github.com/greenpau/caddy-auth-jwt/pkg/revocation"
+)
+
+// loadRevocationFile loads the revocation file and starts watching it. When
+// the file changes, the entries are replaced with the content of the file.
+// If the reload fails, the current entries are kept.
+func (v *TokenValidator) loadRevocationFile() error {
+	v.revocations = nil
+	if v.RevocationFile == "" {
+		return nil
+	}
+	entries, err := jwtrevocation.LoadFile(v.RevocationFile)
+	if err != nil {
+		return err
+	}
+	revocations := jwtrevocation.NewList()
+	revocations.Replace(entries)
+	v.revocations = revocations
+	v.revocationWatcher = jwtbackends.NewKeyFileWatcher([]string{v.RevocationFile}, func() error {
+		entries, err := jwtrevocation.LoadFile(v.RevocationFile)
+		if err != nil {
+			return err
+		}
+		revocations.Replace(entries)
+		return nil
+	}, &jwtbackends.KeyWatchOptions{
+		Debounce:     keyWatchDebounce,
+		PollInterval: time.Duration(defaultKeyWatchPollInterval) * time.Second,
+		Logger:       v.logger,
+	})
+	return nil
+}
+
+// checkRevocation rejects the revoked tokens, and the tokens of the revoked
+// subjects. When the revocation store fails, the tokens are rejected.
+func (v *TokenValidator) checkRevocation(claims *jwtclaims.UserClaims) error {
+	var stores []jwtrevocation.Store
+	if v.revocations != nil {
+		stores = append(stores, v.revocations)
+	}
+	if v.RevocationStore != nil {
+		stores = append(stores, v.RevocationStore)
+	}
+	// The events received on the revocation channel are checked before the
+	// revoked tokens in Redis, which also cover the events published while
+	// the instance is disconnected from Redis.
+	if v.revocationEvents != nil {
+		stores = append(stores, v.revocationEvents)
+	}
+	if v.redisStore != nil {
+		stores = append(stores, v.redisStore)
+	}
+	for _, store := range stores {
+		if claims.ID != "" {
+			revoked, err := store.IsTokenRevoked(claims.ID)
+			if err != nil {
+				return jwterrors.ErrRevocationCheck.WithArgs(err)
+			}
+			if revoked {
+				return jwterrors.ErrTokenRevoked
+			}
+		}
+		if claims.Subject != "" {
+			revoked, err := store.IsSubjectRevoked(claims.Subject)
+			if err != nil {
+				return jwterrors.ErrRevocationCheck.WithArgs(err)
+			}
+			if revoked {
+				return jwterrors.ErrSubjectRevoked.WithArgs(claims.Subject)
+			}
+		}
+	}
+	return nil
+}
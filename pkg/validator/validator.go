@@ -15,56 +15,266 @@
 package validator
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
-	jwtlib "github.com/dgrijalva/jwt-go"
 	jwtacl "github.com/greenpau/caddy-auth-jwt/pkg/acl"
 	jwtbackends "github.com/greenpau/caddy-auth-jwt/pkg/backends"
 	jwtcache "github.com/greenpau/caddy-auth-jwt/pkg/cache"
 	jwtclaims "github.com/greenpau/caddy-auth-jwt/pkg/claims"
 	jwtconfig "github.com/greenpau/caddy-auth-jwt/pkg/config"
 	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
+	jwtrevocation "github.com/greenpau/caddy-auth-jwt/pkg/revocation"
+	jwtstore "github.com/greenpau/caddy-auth-jwt/pkg/store"
+	jwttoken "github.com/greenpau/caddy-auth-jwt/pkg/token"
+	"go.uber.org/zap"
 )
 
 const (
 	tokenSourceHeader = "header"
 	tokenSourceCookie = "cookie"
 	tokenSourceQuery  = "query"
+	// tokenSourceWebSocket is the subprotocols of WebSocket upgrade
+	// requests, i.e. Sec-WebSocket-Protocol header. The browsers do not set
+	// Authorization header on the upgrade requests.
+	tokenSourceWebSocket = "websocket"
+	// tokenSourceForm is the fields of application/x-www-form-urlencoded
+	// bodies of POST requests, e.g. OIDC form_post response mode callbacks.
+	tokenSourceForm = "form"
+	// webSocketBearerProtocol is the subprotocol preceding the token in
+	// Sec-WebSocket-Protocol header, e.g. bearer, <token>.
+	webSocketBearerProtocol = "bearer"
 )
 
 // TokenSources is the map containing token source priorities.
 var TokenSources = map[string]byte{
-	tokenSourceHeader: 0, // the value is the order they are in...
-	tokenSourceCookie: 1,
-	tokenSourceQuery:  2,
+	tokenSourceHeader:    0, // the value is the order they are in...
+	tokenSourceCookie:    1,
+	tokenSourceQuery:     2,
+	tokenSourceWebSocket: 3,
+	tokenSourceForm:      4,
 }
 
-// AllTokenSources is the list of token sources.
+// optionalTokenSources are the token sources searched only when listed in the
+// token sources.
+var optionalTokenSources = map[string]bool{
+	tokenSourceWebSocket: true,
+	tokenSourceForm:      true,
+}
+
+// AllTokenSources is the list of the default token sources. The WebSocket
+// subprotocols and the form bodies are searched only when listed in the
+// token sources.
 var AllTokenSources []string
 
 func init() { // set the default token_sources up
-	AllTokenSources = make([]string, len(TokenSources))
+	sources := make([]string, len(TokenSources))
 	for k, v := range TokenSources {
-		AllTokenSources[int(v)] = k
+		sources[int(v)] = k
+	}
+	for _, source := range sources {
+		if !optionalTokenSources[source] {
+			AllTokenSources = append(AllTokenSources, source)
+		}
 	}
 }
 
+const (
+	// IssuerRoutingPrefer tries the token backends of the trusted tokens
+	// with the issuer of the token first, and then the other backends.
+	IssuerRoutingPrefer = "prefer"
+	// IssuerRoutingStrict tries only the token backends of the trusted
+	// tokens with the issuer of the token. The tokens of other issuers
+	// are tried with the backends of the trusted tokens without issuer.
+	IssuerRoutingStrict = "strict"
+)
+
+const (
+	// TokenFormatJWT is the format of the trusted tokens verifying JWTs.
+	TokenFormatJWT = "jwt"
+	// TokenFormatPaseto is the format of the trusted tokens verifying PASETO
+	// v2.public and v4.public tokens.
+	TokenFormatPaseto = "paseto"
+)
+
+const (
+	// KeyOutageDeny rejects the tokens, while the keys of the trusted
+	// tokens are unavailable, as any other invalid token.
+	KeyOutageDeny = "deny"
+	// KeyOutageUnavailable responds with 503 Service Unavailable, while the
+	// keys of the trusted tokens are unavailable.
+	KeyOutageUnavailable = "unavailable"
+	// KeyOutageAllow passes the requests without verifying the tokens and
+	// without the identity of the users, while the keys of the trusted
+	// tokens are unavailable.
+	KeyOutageAllow = "allow"
+)
+
 var defaultTokenNames = []string{"access_token", "jwt_access_token"}
 
+// defaultFormMaxBodySize is the size of the largest form body searched for
+// the tokens, in bytes.
+const defaultFormMaxBodySize = 1 << 20
+
+const (
+	defaultJwksRefreshInterval    = 3600
+	defaultJwksMinRefreshInterval = 60
+	defaultJwksFetchTimeout       = 10
+	defaultJwksFetchRetries       = 2
+	defaultJwksRetryBackoff       = 1
+)
+
+const (
+	defaultKeyWatchPollInterval     = 60
+	defaultAWSRefreshInterval       = 3600
+	defaultAzureRefreshInterval     = 3600
+	defaultGCPRefreshInterval       = 3600
+	defaultBackendRefreshInterval   = 3600
+	defaultIntrospectionCacheTTL    = 60
+	defaultKubernetesReviewCacheTTL = 60
+)
+
+// keySourceFetchTimeout is the timeout of fetching key material from
+// a key source, e.g. AWS Secrets Manager.
+var keySourceFetchTimeout = 30 * time.Second
+
+// newAWSKeySource returns the key source for AWS Secrets Manager and AWS KMS.
+var newAWSKeySource = jwtbackends.NewAWSKeySource
+
+// newAzureKeySource returns the key source for Azure Key Vault.
+var newAzureKeySource = jwtbackends.NewAzureKeySource
+
+// newGCPKeySource returns the key source for GCP Secret Manager.
+var newGCPKeySource = jwtbackends.NewGCPKeySource
+
+// keyWatchDebounce is the delay between a change of the watched key files
+// and the reload of the keys.
+var keyWatchDebounce = 500 * time.Millisecond
+
 // TokenValidator validates tokens in http requests.
 type TokenValidator struct {
 	TokenConfigs         []*jwtconfig.CommonTokenConfig
 	AuthorizationHeaders map[string]struct{}
 	Cookies              map[string]struct{}
 	QueryParameters      map[string]struct{}
-	Cache                *jwtcache.TokenCache
+	Cache                *jwtcache.ValidationCache
 	AccessList           []*jwtacl.AccessListEntry
 	TokenBackends        []jwtbackends.TokenBackend
 	TokenSources         []string
+	// HeaderNames are the names of the headers holding the tokens, e.g.
+	// X-Id-Token, in the order they are searched. When set, Authorization
+	// header is searched only when listed.
+	HeaderNames []string
+	// CookieNames are the names of the cookies holding the tokens, in the
+	// order they are searched. When set, they replace the token names.
+	CookieNames []string
+	// QueryNames are the names of the query parameters holding the tokens,
+	// e.g. jwt, in the order they are searched. When set, they replace the
+	// token names.
+	QueryNames []string
+	// FormNames are the names of the form fields holding the tokens, e.g.
+	// id_token, in the order they are searched. When empty, the token names
+	// are searched.
+	FormNames []string
+	// FormMaxBodySize is the size of the largest form body searched for the
+	// tokens, in bytes. The larger bodies are not searched. When zero, the
+	// limit is 1 MiB.
+	FormMaxBodySize int64
+	// IssuerRouting is the mode of selecting the token backends by
+	// the issuer of the token, i.e. IssuerRoutingPrefer or IssuerRoutingStrict.
+	// When empty, all backends are tried in order.
+	IssuerRouting string
+	// RequiredClaims are the claims the tokens must have, regardless of
+	// the access list.
+	RequiredClaims []*jwtconfig.RequiredClaim
+	// Provider is the identity provider, e.g. jwtclaims.ProviderKeycloak,
+	// whose preset normalizes the claims of the tokens before the claims
+	// are renamed.
+	Provider string
+	// TokenUse is the value of token_use claim of the tokens issued by AWS
+	// Cognito, i.e. id or access. When empty, both id and access tokens are
+	// accepted. It applies to jwtclaims.ProviderCognito preset.
+	TokenUse string
+	// ClaimNamespaces are the prefixes of the namespaced claims, e.g.
+	// https://example.com/ required by Auth0 for custom claims. The prefixes
+	// are stripped before the claims are normalized by the preset.
+	ClaimNamespaces []string
+	// ClaimMap renames the claims of the tokens, e.g. groups to roles, before
+	// the claims are evaluated.
+	ClaimMap []*jwtconfig.ClaimMapping
+	// ClaimTransforms transform the values of the claims of the tokens, after
+	// the claims are renamed.
+	ClaimTransforms []*jwtconfig.ClaimTransform
+	// CertificateBoundPaths are the paths, e.g. /admin/**, requiring the
+	// tokens bound to client certificates. The any path matches all paths.
+	CertificateBoundPaths []string
+	// AuthTimeRequirements limit the time since the user of the token
+	// authenticated, per auth_time claim, when accessing the paths.
+	AuthTimeRequirements []*jwtconfig.AuthTimeRequirement
+	// SingleUsePaths are the paths, e.g. /webhooks/**, accepting each token
+	// only once. The any path matches all paths.
+	SingleUsePaths []string
+	// ReplayStore records the jti claims of the tokens presented for the
+	// single-use paths. When nil, the claims are recorded in memory.
+	ReplayStore jwtcache.ReplayStore
+	// RevocationFile is the path to JSON or CSV file with the revoked jti
+	// claims and subjects. The file is reloaded when it changes.
+	RevocationFile string
+	// RevocationStore holds the revoked jti claims and subjects, in addition
+	// to the ones in RevocationFile.
+	RevocationStore jwtrevocation.Store
+	// Redis is the server shared by Caddy instances. The revoked tokens and
+	// subjects are looked up, and the single-use tokens are recorded, in it.
+	Redis *jwtconfig.RedisConfig
+	// CacheSize is the maximum number of the validated tokens cached,
+	// so that the tokens presented repeatedly are not verified again until
+	// they expire. Zero or negative value disables the cache.
+	CacheSize int
+	// InvalidTokenCacheTTL is the number of seconds the tokens failing
+	// the verification are cached for, so that the tokens presented
+	// repeatedly are rejected without being verified again. The tokens
+	// are cached only when the cache is enabled. Zero disables caching
+	// of the failures.
+	InvalidTokenCacheTTL int
+	// DecisionCacheSize is the maximum number of the results of remote
+	// token validation, e.g. with token introspection, cached by the token
+	// backends. Zero means 10000.
+	DecisionCacheSize int
+
+	// backendConfigs holds the trusted token configuration for each of
+	// the token backends, in the same order as TokenBackends.
+	backendConfigs []*jwtconfig.CommonTokenConfig
+	// decryptionKeys decrypt the encrypted tokens before the nested tokens
+	// are verified by the token backends.
+	decryptionKeys []interface{}
+	// dpopProofs records the used DPoP proofs.
+	dpopProofs jwtcache.MemoryReplayStore
+	// revocations holds the entries of the revocation file, and
+	// revocationWatcher reloads them when the file changes.
+	revocations       *jwtrevocation.List
+	revocationWatcher *jwtbackends.KeyFileWatcher
+	redisStore        *jwtstore.RedisStore
+	// revocationEvents holds the entries received on the revocation channel
+	// of Redis.
+	revocationEvents *jwtrevocation.List
+	// decisions holds the results of remote token validation, shared by
+	// the token backends.
+	decisions *jwtbackends.DecisionCache
+
+	logger *zap.Logger
 }
 
 // NewTokenValidator returns an instance of TokenValidator
@@ -82,7 +292,7 @@ func NewTokenValidator() *TokenValidator {
 		v.QueryParameters[name] = struct{}{}
 	}
 
-	v.Cache = jwtcache.NewTokenCache()
+	v.Cache = jwtcache.NewValidationCache(0)
 	v.TokenSources = AllTokenSources
 	return v
 }
@@ -102,27 +312,293 @@ func (v *TokenValidator) SetTokenName(name string) {
 	v.QueryParameters[name] = struct{}{}
 }
 
+// SetLogger sets the logger passed to the token backends.
+func (v *TokenValidator) SetLogger(logger *zap.Logger) {
+	v.logger = logger
+}
+
 // ConfigureTokenBackends configures available TokenBackend.
 func (v *TokenValidator) ConfigureTokenBackends() error {
+	v.Stop()
+	switch v.IssuerRouting {
+	case "", IssuerRoutingPrefer, IssuerRoutingStrict:
+	default:
+		return jwterrors.ErrUnsupportedIssuerRouting.WithArgs(v.IssuerRouting)
+	}
+	switch v.Provider {
+	case "", jwtclaims.ProviderKeycloak, jwtclaims.ProviderAzure, jwtclaims.ProviderCognito,
+		jwtclaims.ProviderGitHub, jwtclaims.ProviderFirebase:
+	default:
+		return jwterrors.ErrUnsupportedProvider.WithArgs(v.Provider)
+	}
+	switch v.TokenUse {
+	case "", "id", "access":
+	default:
+		return jwterrors.ErrUnsupportedTokenUse.WithArgs(v.TokenUse)
+	}
+	for _, t := range v.ClaimTransforms {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+	}
+	v.Cache = jwtcache.NewValidationCache(v.CacheSize)
+	v.decisions = jwtbackends.NewDecisionCache(v.DecisionCacheSize)
+	if v.Redis != nil {
+		store, err := jwtstore.NewRedisStore(v.Redis)
+		if err != nil {
+			return err
+		}
+		v.redisStore = store
+		if v.Redis.RevocationChannel != "" {
+			v.revocationEvents = jwtrevocation.NewList()
+			store.SubscribeRevocations(v.Redis.RevocationChannel, v.revocationEvents, v.logger)
+		}
+	}
+	if len(v.SingleUsePaths) > 0 && v.ReplayStore == nil {
+		if v.redisStore != nil {
+			v.ReplayStore = v.redisStore
+		} else {
+			v.ReplayStore = jwtcache.NewMemoryReplayStore(0)
+		}
+	}
+	if err := v.loadRevocationFile(); err != nil {
+		return err
+	}
 	v.TokenBackends = []jwtbackends.TokenBackend{}
+	v.backendConfigs = []*jwtconfig.CommonTokenConfig{}
+	v.decryptionKeys = nil
 
 	for _, c := range v.TokenConfigs {
+		switch c.TokenFormat {
+		case "", TokenFormatJWT, TokenFormatPaseto:
+		default:
+			return jwterrors.ErrUnsupportedTokenFormat.WithArgs(c.TokenFormat)
+		}
+		switch c.TokenKeyOutagePolicy {
+		case "", KeyOutageDeny, KeyOutageUnavailable, KeyOutageAllow:
+		default:
+			return jwterrors.ErrUnsupportedKeyOutagePolicy.WithArgs(c.TokenKeyOutagePolicy)
+		}
+		for _, alg := range c.AllowedAlgorithms {
+			if _, exists := jwtconfig.SigningMethods[alg]; !exists {
+				return jwterrors.ErrUnsupportedAllowedAlgorithm.WithArgs(alg)
+			}
+		}
+		if err := LoadSecret(c); err != nil {
+			return err
+		}
+		if c.HasDecryptionKey() {
+			key, err := loadDecryptionKey(c)
+			if err != nil {
+				return err
+			}
+			v.decryptionKeys = append(v.decryptionKeys, key)
+		}
+		c.ApplyFirebaseProject()
 		if c.TokenSecret != "" {
 			backend, err := jwtbackends.NewSecretKeyTokenBackend(c.TokenSecret)
 			if err != nil {
 				return jwterrors.ErrInvalidSecret.WithArgs(err)
 			}
-			v.TokenBackends = append(v.TokenBackends, backend)
+			v.addTokenBackend(backend, c)
 			continue
 		}
+		if c.HasJwksKeys() {
+			client, err := jwtbackends.NewHTTPClientWithOptions(&jwtbackends.HTTPClientOptions{
+				TLSOptions: jwtbackends.TLSOptions{
+					CAFile:             c.TokenJwksCAFile,
+					ClientCertFile:     c.TokenJwksClientCert,
+					ClientKeyFile:      c.TokenJwksClientKey,
+					InsecureSkipVerify: c.TokenJwksInsecureSkipVerify,
+				},
+				Timeout:   time.Duration(c.TokenJwksHTTPTimeout) * time.Second,
+				ProxyURL:  c.TokenJwksProxyURL,
+				Headers:   getJwksHeaders(c),
+				UserAgent: c.TokenJwksUserAgent,
+
+				BearerToken:     c.TokenJwksBearerToken,
+				BearerTokenFile: c.TokenJwksBearerTokenFile,
+				Username:        c.TokenJwksBasicAuthUsername,
+				Password:        c.TokenJwksBasicAuthPassword,
+			})
+			if err != nil {
+				return err
+			}
+			opts, err := v.getJwksOptions(c, client)
+			if err != nil {
+				return err
+			}
+			var backend *jwtbackends.JwksURIBackend
+			if c.TokenJwksURI != "" {
+				backend, err = jwtbackends.NewJwksURIBackend(c.TokenJwksURI, opts)
+			} else {
+				backend, err = jwtbackends.NewOIDCIssuerBackend(c.TokenOIDCIssuer, opts)
+			}
+			if err != nil {
+				return err
+			}
+			v.addTokenBackend(backend, c)
+		}
+		if c.HasKubernetesKeys() {
+			reviewCacheTTL := c.TokenKubernetesReviewCacheTTL
+			if reviewCacheTTL == 0 {
+				reviewCacheTTL = defaultKubernetesReviewCacheTTL
+			}
+			kubernetesOpts := &jwtbackends.KubernetesOptions{
+				APIServer: c.TokenKubernetesAPIServer,
+				CAFile:    c.TokenKubernetesCAFile,
+				TokenFile: c.TokenKubernetesTokenFile,
+				Audiences: c.TokenAudience,
+
+				ReviewCacheTTL: time.Duration(reviewCacheTTL) * time.Second,
+				Cache:          v.decisions,
+			}
+			client, err := jwtbackends.NewKubernetesHTTPClient(kubernetesOpts)
+			if err != nil {
+				return err
+			}
+			switch c.TokenKubernetesMode {
+			case "", "jwks":
+				opts, err := v.getJwksOptions(c, client)
+				if err != nil {
+					return err
+				}
+				backend, err := jwtbackends.NewJwksURIBackend(jwtbackends.GetKubernetesJwksURI(kubernetesOpts), opts)
+				if err != nil {
+					return err
+				}
+				v.addTokenBackend(backend, c)
+			case "token_review":
+				v.addTokenBackend(jwtbackends.NewKubernetesTokenReviewBackend(kubernetesOpts, client), c)
+			default:
+				return jwterrors.ErrKubernetesMode.WithArgs(c.TokenKubernetesMode)
+			}
+		}
+		if c.HasIntrospection() {
+			cacheTTL := c.TokenIntrospectionCacheTTL
+			if cacheTTL == 0 {
+				cacheTTL = defaultIntrospectionCacheTTL
+			}
+			v.addTokenBackend(jwtbackends.NewIntrospectionBackend(&jwtbackends.IntrospectionOptions{
+				Endpoint:     c.TokenIntrospectionEndpoint,
+				ClientID:     c.TokenIntrospectionClientID,
+				ClientSecret: c.TokenIntrospectionClientSecret,
+				CacheTTL:     time.Duration(cacheTTL) * time.Second,
+				Cache:        v.decisions,
+			}), c)
+		}
+		if c.HasAWSKeys() {
+			source, err := newAWSKeySource(&jwtbackends.AWSOptions{
+				SecretID: c.TokenAWSSecretID,
+				KMSKeyID: c.TokenAWSKMSKeyID,
+				Region:   c.TokenAWSRegion,
+			})
+			if err != nil {
+				return err
+			}
+			refreshInterval := c.TokenAWSRefreshInterval
+			if refreshInterval == 0 {
+				refreshInterval = defaultAWSRefreshInterval
+			}
+			backend, err := v.newKeySourceBackend(c, source, refreshInterval)
+			if err != nil {
+				return err
+			}
+			v.addTokenBackend(backend, c)
+		}
+		if c.HasAzureKeys() {
+			source, err := newAzureKeySource(&jwtbackends.AzureOptions{
+				VaultURL:     c.TokenAzureVaultURL,
+				SecretName:   c.TokenAzureSecretName,
+				KeyName:      c.TokenAzureKeyName,
+				TenantID:     c.TokenAzureTenantID,
+				ClientID:     c.TokenAzureClientID,
+				ClientSecret: c.TokenAzureClientSecret,
+			})
+			if err != nil {
+				return err
+			}
+			refreshInterval := c.TokenAzureRefreshInterval
+			if refreshInterval == 0 {
+				refreshInterval = defaultAzureRefreshInterval
+			}
+			backend, err := v.newKeySourceBackend(c, source, refreshInterval)
+			if err != nil {
+				return err
+			}
+			v.addTokenBackend(backend, c)
+		}
+		if c.HasGCPKeys() {
+			source, err := newGCPKeySource(&jwtbackends.GCPOptions{
+				Secret:          c.TokenGCPSecret,
+				CredentialsFile: c.TokenGCPCredentialsFile,
+			})
+			if err != nil {
+				return err
+			}
+			refreshInterval := c.TokenGCPRefreshInterval
+			if refreshInterval == 0 {
+				refreshInterval = defaultGCPRefreshInterval
+			}
+			backend, err := v.newKeySourceBackend(c, source, refreshInterval)
+			if err != nil {
+				return err
+			}
+			v.addTokenBackend(backend, c)
+		}
+		if c.TokenBackend != nil {
+			var backend jwtbackends.TokenBackend
+			switch module := c.TokenBackend.(type) {
+			case jwtbackends.TokenBackend:
+				backend = module
+			case jwtbackends.KeySource:
+				refreshInterval := c.TokenBackendRefreshInterval
+				if refreshInterval == 0 {
+					refreshInterval = defaultBackendRefreshInterval
+				}
+				var err error
+				backend, err = v.newKeySourceBackend(c, module, refreshInterval)
+				if err != nil {
+					return err
+				}
+			default:
+				return jwterrors.ErrBackendModuleType.WithArgs(module)
+			}
+			v.addTokenBackend(backend, c)
+		}
 		if err := LoadEncryptionKeys(c); err != nil {
 			return err
 		}
+		if err := LoadKeySets(c, v.logger); err != nil {
+			return err
+		}
 
-		tokenKeys := c.GetTokenKeys()
-		if tokenKeys != nil {
-			backend := jwtbackends.NewRSAKeyTokenBackend(tokenKeys)
-			v.TokenBackends = append(v.TokenBackends, backend)
+		backends, err := newKeyTokenBackends(c, c.GetTokenKeys())
+		if err != nil {
+			return err
+		}
+		if c.TokenKeyWatch && len(backends) > 0 {
+			paths, err := keyFilePaths(c)
+			if err != nil {
+				return err
+			}
+			if len(paths) > 0 {
+				pollInterval := c.TokenKeyWatchPollInterval
+				if pollInterval == 0 {
+					pollInterval = defaultKeyWatchPollInterval
+				}
+				backend := jwtbackends.NewReloadableTokenBackend(backends)
+				backend.Watch(paths, v.newKeyReloader(c), &jwtbackends.KeyWatchOptions{
+					Debounce:     keyWatchDebounce,
+					PollInterval: time.Duration(pollInterval) * time.Second,
+					Logger:       v.logger,
+				})
+				v.addTokenBackend(backend, c)
+				continue
+			}
+		}
+		for _, backend := range backends {
+			v.addTokenBackend(backend, c)
 		}
 	}
 	if len(v.TokenBackends) == 0 {
@@ -131,6 +607,482 @@ func (v *TokenValidator) ConfigureTokenBackends() error {
 	return nil
 }
 
+// newKeyTokenBackends returns the token backends for the RSA, ECDSA,
+// and Ed25519 keys.
+func newKeyTokenBackends(c *jwtconfig.CommonTokenConfig, tokenKeys map[string]interface{}) ([]jwtbackends.TokenBackend, error) {
+	backends := []jwtbackends.TokenBackend{}
+	rsaKeys := make(map[string]interface{})
+	ecdsaKeys := make(map[string]interface{})
+	eddsaKeys := make(map[string]interface{})
+	for kid, k := range tokenKeys {
+		switch k.(type) {
+		case *rsa.PrivateKey, *rsa.PublicKey:
+			rsaKeys[kid] = k
+		case *ecdsa.PrivateKey, *ecdsa.PublicKey:
+			ecdsaKeys[kid] = k
+		case ed25519.PrivateKey, ed25519.PublicKey:
+			eddsaKeys[kid] = k
+		}
+	}
+	if len(rsaKeys) > 0 {
+		backend := jwtbackends.NewRSAKeyTokenBackend(rsaKeys)
+		if len(c.TokenRSAPSSMethods) > 0 {
+			if err := backend.SetPSSMethods(c.TokenRSAPSSMethods); err != nil {
+				return nil, err
+			}
+		}
+		backends = append(backends, backend)
+	}
+	if len(ecdsaKeys) > 0 {
+		backends = append(backends, jwtbackends.NewECDSAKeyTokenBackend(ecdsaKeys))
+	}
+	if len(eddsaKeys) > 0 {
+		backends = append(backends, jwtbackends.NewEdDSAKeyTokenBackend(eddsaKeys))
+	}
+	return backends, nil
+}
+
+// newKeyReloader returns the function reading the keys of the trusted token
+// configuration again and returning the new token backends.
+func (v *TokenValidator) newKeyReloader(c *jwtconfig.CommonTokenConfig) func() ([]jwtbackends.TokenBackend, error) {
+	return func() ([]jwtbackends.TokenBackend, error) {
+		keys, err := loadVerificationKeys(c, v.logger)
+		if err != nil {
+			return nil, err
+		}
+		return newKeyTokenBackends(c, keys)
+	}
+}
+
+// newKeySourceBackend returns the token backend with the key material
+// of the key source. The key material is refreshed every refresh
+// interval in seconds, unless the interval is negative. When the key
+// outage policy is set and the keys are refreshed, the backend is created
+// without keys if the first fetch fails.
+func (v *TokenValidator) newKeySourceBackend(c *jwtconfig.CommonTokenConfig, source jwtbackends.KeySource, refreshInterval int) (*jwtbackends.ReloadableTokenBackend, error) {
+	reload := func() ([]jwtbackends.TokenBackend, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), keySourceFetchTimeout)
+		defer cancel()
+		km, err := source.FetchKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		backends := []jwtbackends.TokenBackend{}
+		if km.Secret != "" {
+			backend, err := jwtbackends.NewSecretKeyTokenBackend(km.Secret)
+			if err != nil {
+				return nil, jwterrors.ErrInvalidSecret.WithArgs(err)
+			}
+			backends = append(backends, backend)
+		}
+		keyBackends, err := newKeyTokenBackends(c, km.Keys)
+		if err != nil {
+			return nil, err
+		}
+		return append(backends, keyBackends...), nil
+	}
+	backends, err := reload()
+	if err != nil && (c.TokenKeyOutagePolicy == "" || refreshInterval <= 0) {
+		return nil, err
+	}
+	backend := jwtbackends.NewReloadableTokenBackend(backends)
+	if err != nil {
+		if v.logger != nil {
+			v.logger.Warn("failed fetching keys", zap.String("policy", c.TokenKeyOutagePolicy), zap.Error(err))
+		}
+		backend.SetLastError(err)
+	}
+	if refreshInterval > 0 {
+		backend.Refresh(time.Duration(refreshInterval)*time.Second, reload, v.logger)
+	}
+	return backend, nil
+}
+
+// Stop stops background activities, e.g. periodic key refresh,
+// of the token backends.
+func (v *TokenValidator) Stop() {
+	for _, backend := range v.TokenBackends {
+		if b, ok := backend.(interface{ Stop() }); ok {
+			b.Stop()
+		}
+	}
+	if v.revocationWatcher != nil {
+		v.revocationWatcher.Stop()
+		v.revocationWatcher = nil
+	}
+	if v.redisStore != nil {
+		// The replay store is replaced with the new Redis store, when the
+		// validator is configured again.
+		if v.ReplayStore == jwtcache.ReplayStore(v.redisStore) {
+			v.ReplayStore = nil
+		}
+		v.redisStore.Close()
+		v.redisStore = nil
+	}
+	v.revocationEvents = nil
+}
+
+// addTokenBackend adds a token backend along with the trusted token
+// configuration it was created from.
+func (v *TokenValidator) addTokenBackend(backend jwtbackends.TokenBackend, c *jwtconfig.CommonTokenConfig) {
+	v.TokenBackends = append(v.TokenBackends, backend)
+	v.backendConfigs = append(v.backendConfigs, c)
+}
+
+// getBackendConfig returns the trusted token configuration associated with
+// the token backend. It returns nil when the backend was added directly.
+func (v *TokenValidator) getBackendConfig(i int) *jwtconfig.CommonTokenConfig {
+	if i < len(v.backendConfigs) {
+		return v.backendConfigs[i]
+	}
+	return nil
+}
+
+// stricterKeyOutagePolicy returns the stricter of the key outage policies,
+// i.e. the policy of the trusted token configuration unless the current
+// policy is stricter. The backends added directly deny the tokens.
+func stricterKeyOutagePolicy(policy string, c *jwtconfig.CommonTokenConfig) string {
+	other := KeyOutageDeny
+	if c != nil && c.TokenKeyOutagePolicy != "" {
+		other = c.TokenKeyOutagePolicy
+	}
+	rank := map[string]int{"": 0, KeyOutageAllow: 1, KeyOutageUnavailable: 2, KeyOutageDeny: 3}
+	if rank[other] > rank[policy] {
+		return other
+	}
+	return policy
+}
+
+// Ready returns an error when the keys of any token backend are unavailable,
+// e.g. the keys could not be fetched from JWKS endpoint at startup. The
+// backends loading the keys lazily are ready until a fetch fails.
+func (v *TokenValidator) Ready() error {
+	for _, backend := range v.TokenBackends {
+		checker, ok := backend.(jwtbackends.KeyAvailabilityChecker)
+		if !ok {
+			continue
+		}
+		if err := checker.CheckKeysAvailable(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InspectKeys returns the description of the keys of the token backends.
+func (v *TokenValidator) InspectKeys() []*jwtbackends.KeyStoreInfo {
+	infos := []*jwtbackends.KeyStoreInfo{}
+	for _, backend := range v.TokenBackends {
+		infos = append(infos, jwtbackends.InspectKeys(backend)...)
+	}
+	return infos
+}
+
+// RefreshKeys fetches the keys of the token backends fetching their keys,
+// e.g. from JWKS endpoints, immediately.
+func (v *TokenValidator) RefreshKeys() error {
+	errorMessages := []string{}
+	for _, backend := range v.TokenBackends {
+		if _, err := jwtbackends.RefreshKeys(backend); err != nil {
+			errorMessages = append(errorMessages, err.Error())
+		}
+	}
+	if len(errorMessages) > 0 {
+		return jwterrors.ErrKeyRefresh.WithArgs(errorMessages)
+	}
+	return nil
+}
+
+// hasTokenIntrospector returns true if any token backend validates opaque
+// tokens with token introspection.
+func (v *TokenValidator) hasTokenIntrospector() bool {
+	for _, backend := range v.TokenBackends {
+		if _, ok := backend.(jwtbackends.TokenIntrospector); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isOpaqueToken returns true if the token is neither a JWT nor a PASETO token,
+// e.g. a reference token validated with token introspection.
+func isOpaqueToken(s string) bool {
+	return strings.Count(s, ".") != 2 && !jwttoken.IsPaseto(s)
+}
+
+// The limits of the size of the encoded header and claims of the token in
+// strict mode.
+const (
+	strictMaxHeaderSize = 8192
+	strictMaxClaimsSize = 65536
+)
+
+// checkStrictToken checks the token before the signature is verified, when
+// strict mode is enabled. It returns true if the token identifies its key.
+func checkStrictToken(s string) (bool, error) {
+	parts := strings.Split(s, ".")
+	if len(parts[0]) > strictMaxHeaderSize {
+		return false, jwterrors.ErrStrictHeaderTooLarge.WithArgs(strictMaxHeaderSize)
+	}
+	if len(parts) > 1 && len(parts[1]) > strictMaxClaimsSize {
+		return false, jwterrors.ErrStrictClaimsTooLarge.WithArgs(strictMaxClaimsSize)
+	}
+	token, err := jwttoken.NewParser(nil).ParseUnverified(s)
+	if err != nil {
+		return false, err
+	}
+	if strings.EqualFold(token.Alg, "none") {
+		return false, jwterrors.ErrStrictAlgNone
+	}
+	// The keys embedded in or referenced by the token are never trusted.
+	for _, k := range []string{"jwk", "jku", "x5u"} {
+		if _, exists := token.Header[k]; exists {
+			return false, jwterrors.ErrStrictHeaderNotAllowed.WithArgs(k)
+		}
+	}
+	if _, exists := token.Claims["exp"]; !exists {
+		return false, jwterrors.ErrStrictNoExpiration
+	}
+	_, hasKeyID := jwtbackends.GetKeyID(token)
+	return hasKeyID, nil
+}
+
+// checkTokenType checks that the typ header of the token is the required
+// type. Per RFC 7515, the types are case-insensitive and application/ prefix
+// is optional.
+func checkTokenType(token *jwttoken.Token, requiredType string) error {
+	typ := token.Header["typ"]
+	if typ == nil {
+		return jwterrors.ErrTokenTypeNotFound.WithArgs(requiredType)
+	}
+	normalize := func(s string) string {
+		return strings.TrimPrefix(strings.ToLower(s), "application/")
+	}
+	if s, ok := typ.(string); !ok || normalize(s) != normalize(requiredType) {
+		return jwterrors.ErrTokenTypeMismatch.WithArgs(requiredType, typ)
+	}
+	return nil
+}
+
+// getJwksOptions returns the options of the backend fetching the keys from
+// JSON Web Key Set URL with the client.
+func (v *TokenValidator) getJwksOptions(c *jwtconfig.CommonTokenConfig, client *http.Client) (*jwtbackends.JwksOptions, error) {
+	refreshInterval := c.TokenJwksRefreshInterval
+	if refreshInterval == 0 {
+		refreshInterval = defaultJwksRefreshInterval
+	}
+	minRefreshInterval := c.TokenJwksMinRefreshInterval
+	if minRefreshInterval == 0 {
+		minRefreshInterval = defaultJwksMinRefreshInterval
+	}
+	fetchTimeout := c.TokenJwksFetchTimeout
+	if fetchTimeout == 0 {
+		fetchTimeout = defaultJwksFetchTimeout
+	}
+	fetchRetries := c.TokenJwksFetchRetries
+	if fetchRetries == 0 {
+		fetchRetries = defaultJwksFetchRetries
+	}
+	retryBackoff := c.TokenJwksRetryBackoff
+	if retryBackoff == 0 {
+		retryBackoff = defaultJwksRetryBackoff
+	}
+	keySetOptions, err := getKeySetOptions(c, v.logger)
+	if err != nil {
+		return nil, err
+	}
+	return &jwtbackends.JwksOptions{
+		RefreshInterval:     time.Duration(refreshInterval) * time.Second,
+		MinRefreshInterval:  time.Duration(minRefreshInterval) * time.Second,
+		HTTPClient:          client,
+		FetchTimeout:        time.Duration(fetchTimeout) * time.Second,
+		FetchRetries:        fetchRetries,
+		RetryBackoff:        time.Duration(retryBackoff) * time.Second,
+		TolerateFetchErrors: c.TokenJwksTolerateFetchErrors || c.TokenKeyOutagePolicy != "",
+		WarmUpTimeout:       time.Duration(c.TokenJwksWarmUpTimeout) * time.Second,
+		LazyLoad:            c.TokenJwksLazyLoad,
+		KeySetOptions:       keySetOptions,
+		Logger:              v.logger,
+	}, nil
+}
+
+// getJwksHeaders returns the headers of the requests for the keys, including
+// the API key.
+func getJwksHeaders(c *jwtconfig.CommonTokenConfig) map[string]string {
+	if c.TokenJwksAPIKey == "" {
+		return c.TokenJwksHeaders
+	}
+	headers := make(map[string]string)
+	for k, v := range c.TokenJwksHeaders {
+		headers[k] = v
+	}
+	name := c.TokenJwksAPIKeyHeader
+	if name == "" {
+		name = "X-Api-Key"
+	}
+	headers[name] = c.TokenJwksAPIKey
+	return headers
+}
+
+// checkProviderClaims checks the claims of the token against the
+// requirements of the identity provider preset.
+func (v *TokenValidator) checkProviderClaims(claims map[string]interface{}) error {
+	switch v.Provider {
+	case jwtclaims.ProviderCognito:
+		return checkTokenUse(claims, v.TokenUse)
+	case jwtclaims.ProviderGitHub:
+		iss, _ := claims["iss"].(string)
+		if iss != jwtclaims.GitHubActionsIssuer && !strings.HasPrefix(iss, jwtclaims.GitHubActionsIssuer+"/") {
+			return jwterrors.ErrIssuerNotAllowed.WithArgs(iss)
+		}
+	}
+	return nil
+}
+
+// checkTokenUse checks that token_use claim of AWS Cognito token is the
+// required use, or either id or access when the use is empty.
+func checkTokenUse(claims map[string]interface{}, tokenUse string) error {
+	s, ok := claims["token_use"].(string)
+	if !ok {
+		return jwterrors.ErrTokenUseNotFound
+	}
+	switch {
+	case tokenUse != "" && s != tokenUse:
+		return jwterrors.ErrTokenUseMismatch.WithArgs(tokenUse, s)
+	case tokenUse == "" && s != "id" && s != "access":
+		return jwterrors.ErrTokenUseMismatch.WithArgs("id or access", s)
+	}
+	return nil
+}
+
+// checkTokenClaims checks the claims of the token against the requirements of
+// the trusted token configuration the token was verified with.
+func checkTokenClaims(c *jwtconfig.CommonTokenConfig, claims *jwtclaims.UserClaims) error {
+	if len(c.TokenIssuers) > 0 && !containsAny(c.GetIssuers(), []string{claims.Issuer}) {
+		return jwterrors.ErrIssuerNotAllowed.WithArgs(claims.Issuer)
+	}
+	if len(c.TokenAudience) > 0 {
+		if len(claims.Audience) == 0 {
+			return jwterrors.ErrAudienceNotFound.WithArgs(c.TokenAudience)
+		}
+		if !containsAny(c.TokenAudience, claims.Audience) {
+			return jwterrors.ErrAudienceNotAllowed.WithArgs(claims.Audience, c.TokenAudience)
+		}
+	}
+	if len(c.TokenHostedDomains) > 0 {
+		hd, _ := claims.GetClaim("hd")
+		domain, ok := hd.(string)
+		if !ok || domain == "" {
+			return jwterrors.ErrHostedDomainNotFound.WithArgs(c.TokenHostedDomains)
+		}
+		// The domain names are case-insensitive.
+		allowed := false
+		for _, d := range c.TokenHostedDomains {
+			if strings.EqualFold(d, domain) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return jwterrors.ErrHostedDomainNotAllowed.WithArgs(domain, c.TokenHostedDomains)
+		}
+	}
+	return nil
+}
+
+// checkRequiredClaims checks that the claims of the token include the required
+// claims. The values of the claims are compared as strings, e.g. true for
+// boolean claims.
+func checkRequiredClaims(requiredClaims []*jwtconfig.RequiredClaim, m map[string]interface{}) error {
+	for _, rc := range requiredClaims {
+		value, exists := m[rc.Name]
+		if !exists || value == nil {
+			return jwterrors.ErrRequiredClaimNotFound.WithArgs(rc.Name)
+		}
+		if len(rc.Values) == 0 {
+			continue
+		}
+		var values []string
+		switch v := value.(type) {
+		case []interface{}:
+			for _, entry := range v {
+				values = append(values, fmt.Sprint(entry))
+			}
+		default:
+			values = append(values, fmt.Sprint(v))
+		}
+		if !containsAny(rc.Values, values) {
+			return jwterrors.ErrRequiredClaimMismatch.WithArgs(rc.Name, value, rc.Values)
+		}
+	}
+	return nil
+}
+
+// containsAny returns true if the values include any of the entries.
+func containsAny(values, entries []string) bool {
+	for _, entry := range entries {
+		for _, value := range values {
+			if entry == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getBackendOrder returns the indexes of the token backends to try for
+// the token, according to the issuer routing mode.
+func (v *TokenValidator) getBackendOrder(s string) ([]int, string) {
+	order := make([]int, 0, len(v.TokenBackends))
+	if v.IssuerRouting == "" {
+		for i := range v.TokenBackends {
+			order = append(order, i)
+		}
+		return order, ""
+	}
+	// The issuer is read before the signature is verified, only to select the
+	// backends. A forged issuer selects the keys the token is not signed with.
+	var issuer string
+	if token, err := jwttoken.NewParser(nil).ParseUnverified(s); err == nil {
+		issuer, _ = token.Claims["iss"].(string)
+	}
+	var matched, unbound []int
+	for i := range v.TokenBackends {
+		c := v.getBackendConfig(i)
+		switch {
+		case c == nil || len(c.GetIssuers()) == 0:
+			unbound = append(unbound, i)
+		case containsAny(c.GetIssuers(), []string{issuer}):
+			matched = append(matched, i)
+		}
+	}
+	if v.IssuerRouting == IssuerRoutingStrict {
+		if len(matched) > 0 {
+			return matched, issuer
+		}
+		return unbound, issuer
+	}
+	order = append(order, matched...)
+	for i := range v.TokenBackends {
+		c := v.getBackendConfig(i)
+		if c == nil || !containsAny(c.GetIssuers(), []string{issuer}) {
+			order = append(order, i)
+		}
+	}
+	return order, issuer
+}
+
+// getParser returns token parser for the token backend. The parser
+// restricts the accepted signing methods when the trusted token
+// configuration has an allowlist of signing algorithms.
+func (v *TokenValidator) getParser(i int, leeway time.Duration) jwttoken.Parser {
+	opts := &jwttoken.ParserOptions{Leeway: leeway}
+	if c := v.getBackendConfig(i); c != nil && len(c.AllowedAlgorithms) > 0 {
+		opts.ValidMethods = c.AllowedAlgorithms
+	}
+	return jwttoken.NewParser(opts)
+}
+
 // ClearAuthorizationHeaders clears source HTTP Authorization header.
 func (v *TokenValidator) ClearAuthorizationHeaders() {
 	v.AuthorizationHeaders = make(map[string]struct{})
@@ -153,42 +1105,173 @@ func (v *TokenValidator) ClearAllSources() {
 	v.ClearQueryParameters()
 }
 
-// Authorize authorizes HTTP requests based on the presence and the
-// content of the tokens in the request.
-func (v *TokenValidator) Authorize(r *http.Request, opts *jwtconfig.TokenValidatorOptions) (claims *jwtclaims.UserClaims, valid bool, err error) {
+// Authorize authorizes HTTP requests based on the presence and the
+// content of the tokens in the request.
+func (v *TokenValidator) Authorize(r *http.Request, opts *jwtconfig.TokenValidatorOptions) (*jwtclaims.UserClaims, bool, error) {
+	claims, valid, err := v.authorizeTokenSources(r, opts)
+	if valid {
+		if err := v.checkCertificateBinding(r, claims, opts); err != nil {
+			return nil, false, err
+		}
+		if err := v.checkAuthTime(r.URL.Path, claims, opts); err != nil {
+			return nil, false, err
+		}
+		if err := v.checkReplay(r.URL.Path, claims, opts); err != nil {
+			return nil, false, err
+		}
+	}
+	return claims, valid, err
+}
+
+// authorizeTokenSources authorizes HTTP requests with the tokens found in the
+// token sources.
+func (v *TokenValidator) authorizeTokenSources(r *http.Request, opts *jwtconfig.TokenValidatorOptions) (claims *jwtclaims.UserClaims, valid bool, err error) {
+	// When the first valid token is authorized, the error of the first
+	// invalid token is returned if no token is valid.
+	var firstErr error
+	var invalidSources []string
 	for _, sourceName := range v.TokenSources { // check the source in the order of the slice
-		switch sourceName {
-		case tokenSourceHeader:
-			if claims, valid, err = v.AuthorizeAuthorizationHeader(r, opts); valid || (err != nil && !errors.Is(err, jwterrors.ErrNoTokenFound)) {
-				return claims, valid, err
-			}
-		case tokenSourceCookie:
-			if claims, valid, err = v.AuthorizeCookies(r, opts); valid || (err != nil && !errors.Is(err, jwterrors.ErrNoTokenFound)) {
-				return claims, valid, err
-			}
-		case tokenSourceQuery:
-			if claims, valid, err = v.AuthorizeQueryParameters(r, opts); valid || (err != nil && !errors.Is(err, jwterrors.ErrNoTokenFound)) {
-				return claims, valid, err
+		claims, valid, err = v.authorizeTokenSource(r, sourceName, opts)
+		if valid {
+			if len(invalidSources) > 0 && opts.Logger != nil {
+				opts.Logger.Debug(
+					"authorized first valid token",
+					zap.String("token_source", sourceName),
+					zap.Strings("invalid_token_sources", invalidSources),
+				)
 			}
+			return claims, valid, err
 		}
+		if err == nil || errors.Is(err, jwterrors.ErrNoTokenFound) {
+			continue
+		}
+		if opts == nil || !opts.ValidateFirstValidToken {
+			return claims, valid, err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		invalidSources = append(invalidSources, sourceName)
+	}
+	if firstErr != nil {
+		return nil, false, firstErr
 	}
 
 	return claims, valid, err
 }
 
+// authorizeTokenSource authorizes HTTP requests with the token found in the
+// token source.
+func (v *TokenValidator) authorizeTokenSource(r *http.Request, sourceName string, opts *jwtconfig.TokenValidatorOptions) (*jwtclaims.UserClaims, bool, error) {
+	switch sourceName {
+	case tokenSourceHeader:
+		return v.AuthorizeAuthorizationHeader(r, opts)
+	case tokenSourceCookie:
+		return v.AuthorizeCookies(r, opts)
+	case tokenSourceQuery:
+		return v.AuthorizeQueryParameters(r, opts)
+	case tokenSourceWebSocket:
+		return v.AuthorizeWebSocketProtocol(r, opts)
+	case tokenSourceForm:
+		return v.AuthorizeFormBody(r, opts)
+	}
+	return nil, false, nil
+}
+
 // AuthorizeAuthorizationHeader authorizes HTTP requests based on the presence and the
 // content of the tokens in HTTP Authorization header.
 func (v *TokenValidator) AuthorizeAuthorizationHeader(r *http.Request, opts *jwtconfig.TokenValidatorOptions) (u *jwtclaims.UserClaims, ok bool, err error) {
+	if len(v.HeaderNames) > 0 {
+		return v.authorizeHeaders(r, opts)
+	}
+	return v.authorizeAuthorizationHeader(r, opts)
+}
+
+// authorizeHeaders authorizes HTTP requests with the token in the first of
+// the headers present, in the order of the header names. The headers other
+// than Authorization hold the token, optionally with Bearer scheme, e.g.
+// Cf-Access-Jwt-Assertion header set by Cloudflare Access.
+func (v *TokenValidator) authorizeHeaders(r *http.Request, opts *jwtconfig.TokenValidatorOptions) (*jwtclaims.UserClaims, bool, error) {
+	for _, name := range v.HeaderNames {
+		if strings.EqualFold(name, "Authorization") {
+			u, ok, err := v.authorizeAuthorizationHeader(r, opts)
+			if ok || (err != nil && !errors.Is(err, jwterrors.ErrNoTokenFound)) {
+				return u, ok, err
+			}
+			continue
+		}
+		token := strings.TrimSpace(r.Header.Get(name))
+		if scheme, s := getAuthorizationScheme(token); isBearerScheme(scheme, opts) {
+			token = s
+		}
+		if token != "" {
+			return v.validateBearerToken(token, opts)
+		}
+	}
+	return nil, false, jwterrors.ErrNoTokenFound
+}
+
+func (v *TokenValidator) authorizeAuthorizationHeader(r *http.Request, opts *jwtconfig.TokenValidatorOptions) (u *jwtclaims.UserClaims, ok bool, err error) {
 	authzHeaderStr := r.Header.Get("Authorization")
+	if opts != nil && opts.ValidateDPoP {
+		if scheme, token := getAuthorizationScheme(authzHeaderStr); strings.EqualFold(scheme, "DPoP") && token != "" {
+			if u, ok, err = v.ValidateToken(token, opts); !ok {
+				return u, ok, err
+			}
+			if err := v.checkDPoPProof(r, token, u, opts); err != nil {
+				return nil, false, err
+			}
+			return u, ok, nil
+		}
+	}
+	if opts != nil && opts.ValidateFirstValidToken {
+		return v.authorizeAuthorizationHeaderValues(r, opts)
+	}
 	if authzHeaderStr != "" && len(v.AuthorizationHeaders) > 0 {
 		if token, found := v.SearchAuthorizationHeader(authzHeaderStr, opts); found {
-			return v.ValidateToken(token, opts)
+			return v.validateBearerToken(token, opts)
 		}
 		err = jwterrors.ErrNoTokenFound
 	}
 	return u, ok, err
 }
 
+// authorizeAuthorizationHeaderValues authorizes HTTP requests with the first
+// valid token in the values of Authorization header. When no token is valid,
+// the error of the first token is returned.
+func (v *TokenValidator) authorizeAuthorizationHeaderValues(r *http.Request, opts *jwtconfig.TokenValidatorOptions) (*jwtclaims.UserClaims, bool, error) {
+	values := r.Header.Values("Authorization")
+	if len(values) == 0 || len(v.AuthorizationHeaders) == 0 {
+		return nil, false, nil
+	}
+	var firstErr error
+	for _, value := range values {
+		for _, token := range v.searchAuthorizationHeader(value, opts, 0) {
+			u, ok, err := v.validateBearerToken(token, opts)
+			if ok {
+				return u, ok, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr == nil {
+		firstErr = jwterrors.ErrNoTokenFound
+	}
+	return nil, false, firstErr
+}
+
+// validateBearerToken validates the token presented without DPoP proof. When
+// DPoP is enabled, the tokens bound to DPoP keys are rejected.
+func (v *TokenValidator) validateBearerToken(token string, opts *jwtconfig.TokenValidatorOptions) (*jwtclaims.UserClaims, bool, error) {
+	u, ok, err := v.ValidateToken(token, opts)
+	if ok && opts != nil && opts.ValidateDPoP && getConfirmationClaim(u, "jkt") != "" {
+		return nil, false, jwterrors.ErrDPoPBoundToken
+	}
+	return u, ok, err
+}
+
 // AuthorizeCookies authorizes HTTP requests based on the presence and the
 // content of the tokens in HTTP cookies.
 func (v *TokenValidator) AuthorizeCookies(r *http.Request, opts *jwtconfig.TokenValidatorOptions) (u *jwtclaims.UserClaims, ok bool, err error) {
@@ -196,7 +1279,7 @@ func (v *TokenValidator) AuthorizeCookies(r *http.Request, opts *jwtconfig.Token
 	cookies := r.Cookies()
 	if len(cookies) > 0 && len(v.Cookies) > 0 {
 		if token, found := v.SearchCookies(cookies); found {
-			return v.ValidateToken(token, opts)
+			return v.validateBearerToken(token, opts)
 		}
 		err = jwterrors.ErrNoTokenFound
 	}
@@ -207,40 +1290,190 @@ func (v *TokenValidator) AuthorizeCookies(r *http.Request, opts *jwtconfig.Token
 // content of the tokens in HTTP query parameters.
 func (v *TokenValidator) AuthorizeQueryParameters(r *http.Request, opts *jwtconfig.TokenValidatorOptions) (u *jwtclaims.UserClaims, ok bool, err error) {
 	queryValues := r.URL.Query()
-	if len(queryValues) > 0 && len(v.QueryParameters) > 0 {
+	if len(queryValues) > 0 && (len(v.QueryParameters) > 0 || len(v.QueryNames) > 0) {
 		if token, found := v.SearchQueryValues(queryValues); found {
-			return v.ValidateToken(token, opts)
+			return v.validateBearerToken(token, opts)
+		}
+		err = jwterrors.ErrNoTokenFound
+	}
+	return u, ok, err
+}
+
+// AuthorizeWebSocketProtocol authorizes WebSocket upgrade requests based on
+// the presence and the content of the token in Sec-WebSocket-Protocol header,
+// i.e. the subprotocol following bearer subprotocol.
+func (v *TokenValidator) AuthorizeWebSocketProtocol(r *http.Request, opts *jwtconfig.TokenValidatorOptions) (u *jwtclaims.UserClaims, ok bool, err error) {
+	if protocols := getWebSocketProtocols(r); len(protocols) > 0 {
+		if token, found := searchWebSocketProtocols(protocols); found {
+			return v.validateBearerToken(token, opts)
 		}
 		err = jwterrors.ErrNoTokenFound
 	}
 	return u, ok, err
 }
 
+// AuthorizeFormBody authorizes HTTP POST requests based on the presence and
+// the content of the token in the fields of application/x-www-form-urlencoded
+// body. The body is buffered and passed to the upstreams unchanged.
+func (v *TokenValidator) AuthorizeFormBody(r *http.Request, opts *jwtconfig.TokenValidatorOptions) (u *jwtclaims.UserClaims, ok bool, err error) {
+	if r.Method != http.MethodPost || r.Body == nil || r.Body == http.NoBody {
+		return u, ok, err
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/x-www-form-urlencoded" {
+		return u, ok, err
+	}
+	maxBodySize := v.FormMaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultFormMaxBodySize
+	}
+	if r.ContentLength > maxBodySize {
+		return nil, false, jwterrors.ErrNoTokenFound
+	}
+	b, readErr := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	r.Body = &bufferedBody{Reader: io.MultiReader(bytes.NewReader(b), r.Body), Closer: r.Body}
+	if readErr != nil {
+		return nil, false, jwterrors.ErrFormBodyRead.WithArgs(readErr)
+	}
+	if int64(len(b)) > maxBodySize {
+		return nil, false, jwterrors.ErrNoTokenFound
+	}
+	values, parseErr := url.ParseQuery(string(b))
+	if parseErr != nil {
+		return nil, false, jwterrors.ErrFormBodyRead.WithArgs(parseErr)
+	}
+	if token, found := v.SearchFormValues(values); found {
+		return v.validateBearerToken(token, opts)
+	}
+	return nil, false, jwterrors.ErrNoTokenFound
+}
+
+// bufferedBody is the body of HTTP request with the buffered part read again
+// before the rest of the body.
+type bufferedBody struct {
+	io.Reader
+	io.Closer
+}
+
 // ValidateToken parses a token and returns claims, if valid.
 func (v *TokenValidator) ValidateToken(s string, opts *jwtconfig.TokenValidatorOptions) (*jwtclaims.UserClaims, bool, error) {
 	valid := false
+	presented := s
+	var leeway time.Duration
+	if opts != nil && opts.ClockSkew > 0 {
+		leeway = time.Duration(opts.ClockSkew) * time.Second
+	}
+	// The grace period extends the leeway of exp claim only. The nbf and iat
+	// claims are checked with the leeway after the token is parsed.
+	var grace time.Duration
+	if opts != nil && opts.ExpiryGracePeriod > 0 {
+		grace = time.Duration(opts.ExpiryGracePeriod) * time.Second
+	}
+	if err := checkTokenLimits(s, opts); err != nil {
+		return nil, false, err
+	}
+	if jwttoken.IsEncrypted(s) {
+		nested, err := jwttoken.Decrypt(s, v.decryptionKeys)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := checkTokenLimits(nested, opts); err != nil {
+			return nil, false, err
+		}
+		s = nested
+	}
+	var keyIDRequired bool
+	// The opaque tokens are validated with token introspection, and the
+	// checks of the encoded header and claims do not apply.
+	introspected := isOpaqueToken(s) && v.hasTokenIntrospector()
+	if opts != nil && opts.ValidateStrict && !introspected && !jwttoken.IsPaseto(s) {
+		hasKeyID, err := checkStrictToken(s)
+		if err != nil {
+			return nil, false, err
+		}
+		keyIDRequired = !hasKeyID
+	}
 	// First, check cached entries
-	claims := v.Cache.Get(s)
+	claims, tokenClaims, err := v.Cache.Get(s)
+	if err != nil {
+		return nil, false, err
+	}
 	if claims != nil {
-		if claims.ExpiresAt < time.Now().Unix() {
-			v.Cache.Delete(s)
-			return nil, false, jwterrors.ErrExpiredToken
-		}
+		claims.Token = presented
 		valid = true
 	}
 
 	errorMessages := []string{}
+	// The key outage policy of the token backends without keys, because
+	// their key sources are unavailable.
+	var outagePolicy string
+	// The token verifiers failed the token. Their decisions are cached by
+	// the verifiers, and the errors of the verifiers, e.g. the TokenReview
+	// API is unavailable, are not cached at all.
+	var verifierFailed bool
 	// If not valid, parse claims from a string.
 	if !valid {
-		for _, backend := range v.TokenBackends {
-			token, err := jwtlib.Parse(s, backend.ProvideKey)
+		order, issuer := v.getBackendOrder(s)
+		if len(order) == 0 {
+			errorMessages = append(errorMessages, jwterrors.ErrNoIssuerBackends.WithArgs(issuer).Error())
+		}
+		for _, i := range order {
+			backend := v.TokenBackends[i]
+			if keyIDRequired && jwtbackends.KeyCount(backend) > 1 {
+				errorMessages = append(errorMessages, jwterrors.ErrStrictKeyIDRequired.Error())
+				continue
+			}
+			var token *jwttoken.Token
+			var err error
+			verifier, isVerifier := backend.(jwtbackends.TokenVerifier)
+			introspector, isIntrospector := backend.(jwtbackends.TokenIntrospector)
+			if isIntrospector && !introspected {
+				continue
+			}
+			if isIntrospector {
+				var introspectedClaims map[string]interface{}
+				if introspectedClaims, err = introspector.IntrospectToken(s); err == nil {
+					token = &jwttoken.Token{Header: map[string]interface{}{}, Claims: introspectedClaims}
+				}
+			} else if c := v.getBackendConfig(i); c != nil && c.TokenFormat == TokenFormatPaseto {
+				token, err = jwttoken.ParsePaseto(s, backend.ProvideKey, leeway+grace)
+			} else if isVerifier {
+				if token, err = v.getParser(i, leeway+grace).ParseUnverified(s); err == nil {
+					err = verifier.VerifyToken(s)
+					verifierFailed = verifierFailed || err != nil
+				}
+			} else {
+				token, err = v.getParser(i, leeway+grace).Parse(s, backend.ProvideKey)
+			}
 			if err != nil {
 				errorMessages = append(errorMessages, err.Error())
+				if checker, ok := backend.(jwtbackends.KeyAvailabilityChecker); ok {
+					if unavailableErr := checker.CheckKeysAvailable(); unavailableErr != nil {
+						errorMessages = append(errorMessages, unavailableErr.Error())
+						outagePolicy = stricterKeyOutagePolicy(outagePolicy, v.getBackendConfig(i))
+					}
+				}
 				continue
 			}
-			if !token.Valid {
+			if c := v.getBackendConfig(i); c != nil && c.TokenRequiredType != "" {
+				if err := checkTokenType(token, c.TokenRequiredType); err != nil {
+					errorMessages = append(errorMessages, err.Error())
+					continue
+				}
+			}
+			if len(v.ClaimNamespaces) > 0 {
+				token.Claims = jwtclaims.StripClaimNamespaces(token.Claims, v.ClaimNamespaces)
+			}
+			if err := v.checkProviderClaims(token.Claims); err != nil {
+				errorMessages = append(errorMessages, err.Error())
 				continue
 			}
+			token.Claims = jwtclaims.NormalizeClaims(v.Provider, token.Claims)
+			if len(v.ClaimMap) > 0 {
+				token.Claims = jwtclaims.MapClaims(token.Claims, v.ClaimMap)
+			}
+			if len(v.ClaimTransforms) > 0 {
+				token.Claims = jwtclaims.TransformClaims(token.Claims, v.ClaimTransforms)
+			}
 			claims, err = jwtclaims.ParseClaims(token)
 			if err != nil {
 				errorMessages = append(errorMessages, err.Error())
@@ -250,22 +1483,78 @@ func (v *TokenValidator) ValidateToken(s string, opts *jwtconfig.TokenValidatorO
 				errorMessages = append(errorMessages, "claims is nil")
 				continue
 			}
+			if c := v.getBackendConfig(i); c != nil {
+				if err := checkTokenClaims(c, claims); err != nil {
+					errorMessages = append(errorMessages, err.Error())
+					continue
+				}
+			}
+			if grace > 0 {
+				if err := checkNotBefore(claims, leeway, time.Now()); err != nil {
+					errorMessages = append(errorMessages, err.Error())
+					continue
+				}
+			}
+			tokenClaims = token.Claims
+			claims.Token = presented
 			valid = true
+			// The tokens without expiration time are not cached. The results
+			// of token introspection and token reviews are cached by the
+			// backends, for the configured time to live.
+			if claims.ExpiresAt > 0 && !introspected && !isVerifier {
+				v.Cache.Add(s, claims, tokenClaims, time.Unix(claims.ExpiresAt, 0).Add(leeway+grace))
+			}
 			break
 		}
 	}
 
 	if valid {
+		if err := v.checkRevocation(claims); err != nil {
+			return nil, false, err
+		}
+		if len(v.RequiredClaims) > 0 {
+			if tokenClaims == nil {
+				tokenClaims = claims.AsMap()
+			}
+			if err := checkRequiredClaims(v.RequiredClaims, tokenClaims); err != nil {
+				return nil, false, err
+			}
+		}
+		if opts != nil && opts.ValidateRequireExpiration && claims.ExpiresAt == 0 {
+			return nil, false, jwterrors.ErrExpirationNotFound
+		}
+		if opts != nil && opts.MaxTokenLifetime > 0 {
+			if claims.IssuedAt == 0 {
+				return nil, false, jwterrors.ErrIssuedAtNotFound
+			}
+			maxLifetime := time.Duration(opts.MaxTokenLifetime) * time.Second
+			if age := time.Since(time.Unix(claims.IssuedAt, 0)); age > maxLifetime+leeway {
+				return nil, false, jwterrors.ErrTokenLifetimeExceeded.WithArgs(age.Round(time.Second), maxLifetime)
+			}
+		}
 		if len(v.AccessList) == 0 {
 			return nil, false, jwterrors.ErrNoAccessList
 		}
 		aclAllowed := false
+		// The entry allowing the claims, but requiring stronger authentication
+		// context than the one of the token.
+		var stepUpEntry *jwtacl.AccessListEntry
+		// The claims are denied by an entry, rather than not allowed by any.
+		var denied bool
 		for _, entry := range v.AccessList {
 			claimAllowed, abortProcessing := entry.IsClaimAllowed(claims, opts)
 			if abortProcessing {
 				aclAllowed = claimAllowed
+				stepUpEntry = nil
+				denied = true
 				break
 			}
+			if claimAllowed && !entry.IsAuthContextSatisfied(claims) {
+				if stepUpEntry == nil {
+					stepUpEntry = entry
+				}
+				claimAllowed = false
+			}
 			if claimAllowed {
 				aclAllowed = true
 			} else if entry.Action == "allow" && opts.ValidateAllowMatchAll {
@@ -274,6 +1563,12 @@ func (v *TokenValidator) ValidateToken(s string, opts *jwtconfig.TokenValidatorO
 			}
 		}
 		if !aclAllowed {
+			if stepUpEntry != nil {
+				return nil, false, jwterrors.ErrStepUpRequired.WithArgs(stepUpEntry.RequiredACR, stepUpEntry.RequiredAMR)
+			}
+			if scopes := getRequiredScopes(v.AccessList, claims, opts); len(scopes) > 0 && !denied {
+				return nil, false, jwterrors.InsufficientScopeError{Scopes: scopes}
+			}
 			return nil, false, jwterrors.ErrAccessNotAllowed
 		}
 
@@ -313,28 +1608,105 @@ func (v *TokenValidator) ValidateToken(s string, opts *jwtconfig.TokenValidatorO
 	}
 
 	if !valid {
-		return nil, false, jwterrors.ErrInvalid.WithArgs(errorMessages)
+		switch outagePolicy {
+		case KeyOutageUnavailable:
+			return nil, false, jwterrors.ErrKeysUnavailable.WithArgs(errorMessages)
+		case KeyOutageAllow:
+			return nil, false, jwterrors.ErrKeysUnavailableAllowed.WithArgs(errorMessages)
+		}
+		err := jwterrors.ErrInvalid.WithArgs(errorMessages)
+		// The opaque tokens are cached by the token introspection, and the
+		// tokens failed by the token verifiers by the verifiers. The tokens
+		// failing while the keys are unavailable are not cached.
+		if v.InvalidTokenCacheTTL > 0 && !introspected && outagePolicy == "" && !verifierFailed {
+			v.Cache.AddFailure(s, err, time.Now().Add(time.Duration(v.InvalidTokenCacheTTL)*time.Second))
+		}
+		return nil, false, err
 	}
 
 	return claims, true, nil
 }
 
+// getRequiredScopes returns the scopes allowed by the access list entries
+// matching the request, i.e. one of the scopes the token requires to be
+// allowed. It returns nil if the token has one of the scopes, because the
+// token is not allowed for other reasons.
+func getRequiredScopes(entries []*jwtacl.AccessListEntry, claims *jwtclaims.UserClaims, opts *jwtconfig.TokenValidatorOptions) []string {
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Action != "allow" || entry.Claim != "scopes" || !entry.MatchesRequest(opts) {
+			continue
+		}
+		for _, value := range entry.Values {
+			if value == "*" || value == "any" {
+				continue
+			}
+			if containsAny(claims.Scopes, []string{value}) {
+				return nil
+			}
+			if !seen[value] {
+				seen[value] = true
+				scopes = append(scopes, value)
+			}
+		}
+	}
+	return scopes
+}
+
+// checkNotBefore checks that nbf and iat claims of the token are not in the
+// future, beyond the leeway.
+func checkNotBefore(claims *jwtclaims.UserClaims, leeway time.Duration, now time.Time) error {
+	limit := now.Add(leeway).Unix()
+	if claims.NotBefore > limit {
+		return jwterrors.ErrTokenNotValidYet
+	}
+	if claims.IssuedAt > limit {
+		return jwterrors.ErrTokenNotValidYet
+	}
+	return nil
+}
+
+// IsStaleToken returns true if the token expired, but is still accepted
+// during the grace period of the expired tokens.
+func IsStaleToken(claims *jwtclaims.UserClaims, opts *jwtconfig.TokenValidatorOptions, now time.Time) bool {
+	if opts == nil || opts.ExpiryGracePeriod <= 0 || claims.ExpiresAt <= 0 {
+		return false
+	}
+	leeway := time.Duration(opts.ClockSkew) * time.Second
+	return now.After(time.Unix(claims.ExpiresAt, 0).Add(leeway))
+}
+
 // SearchAuthorizationHeader searches for tokens in the authorization header of
 // HTTP requests.
 func (v *TokenValidator) SearchAuthorizationHeader(s string, opts *jwtconfig.TokenValidatorOptions) (string, bool) {
+	if tokens := v.searchAuthorizationHeader(s, opts, 1); len(tokens) > 0 {
+		return tokens[0], true
+	}
+	return "", false
+}
+
+// searchAuthorizationHeader returns up to the limit of the tokens in the
+// value of the authorization header, in order. Zero limit returns all tokens.
+func (v *TokenValidator) searchAuthorizationHeader(s string, opts *jwtconfig.TokenValidatorOptions, limit int) []string {
 	if len(v.AuthorizationHeaders) == 0 || s == "" {
-		return "", false
+		return nil
 	}
+	var tokens []string
 	header := strings.Split(s, ",")
 	for _, entry := range header {
-		if opts != nil && opts.ValidateBearerHeader && strings.HasPrefix(entry, "Bearer") {
+		if limit > 0 && len(tokens) == limit {
+			break
+		}
+		if opts != nil && opts.ValidateBearerHeader {
 			// If JWT token as being passed as a bearer token
 			// then, the token will not be a key-value pair.
-			kv := strings.SplitN(entry, " ", 2)
-			if len(kv) != 2 {
+			if scheme, token := getAuthorizationScheme(entry); isBearerScheme(scheme, opts) {
+				if token != "" {
+					tokens = append(tokens, token)
+				}
 				continue
 			}
-			return strings.TrimSpace(kv[1]), true
 		}
 		kv := strings.SplitN(entry, "=", 2)
 		if len(kv) != 2 {
@@ -342,15 +1714,23 @@ func (v *TokenValidator) SearchAuthorizationHeader(s string, opts *jwtconfig.Tok
 		}
 		k := strings.TrimSpace(kv[0])
 		if _, exists := v.AuthorizationHeaders[k]; exists {
-			return strings.TrimSpace(kv[1]), true
+			tokens = append(tokens, strings.TrimSpace(kv[1]))
 		}
 	}
-	return "", false
+	return tokens
 }
 
 // SearchCookies searches for tokens in the cookies of HTTP requests.
 func (v *TokenValidator) SearchCookies(cookies []*http.Cookie) (string, bool) {
-	if len(cookies) == 0 || len(v.Cookies) == 0 {
+	if len(cookies) == 0 || (len(v.Cookies) == 0 && len(v.CookieNames) == 0) {
+		return "", false
+	}
+	if len(v.CookieNames) > 0 {
+		for _, name := range v.CookieNames {
+			if token, found := searchCookie(cookies, name); found {
+				return token, true
+			}
+		}
 		return "", false
 	}
 	for _, cookie := range cookies {
@@ -358,21 +1738,327 @@ func (v *TokenValidator) SearchCookies(cookies []*http.Cookie) (string, bool) {
 			continue
 		}
 		if _, exists := v.Cookies[cookie.Name]; exists {
-			if len(cookie.Value) > 32 {
-				token := strings.TrimSpace(cookie.Value)
-				arr := strings.Split(token, " ")
-				token = arr[0]
+			if token, found := getCookieToken(cookie.Value); found {
 				return token, true
 			}
 		}
 	}
+	for name := range v.Cookies {
+		if token, found := getCookieToken(joinCookieChunks(cookies, name)); found {
+			return token, true
+		}
+	}
+	return "", false
+}
+
+// IsTokenCookie returns true if the cookie holds the token, or a chunk of
+// the token, e.g. access_token.0.
+func (v *TokenValidator) IsTokenCookie(name string) bool {
+	if i := strings.LastIndex(name, "."); i > 0 {
+		if _, err := strconv.Atoi(name[i+1:]); err == nil && v.IsTokenCookie(name[:i]) {
+			return true
+		}
+	}
+	if len(v.CookieNames) > 0 {
+		for _, cookieName := range v.CookieNames {
+			if cookieName == name {
+				return true
+			}
+		}
+		return false
+	}
+	_, exists := v.Cookies[name]
+	return exists
+}
+
+// SearchFormValues searches for tokens in the values of the form fields.
+func (v *TokenValidator) SearchFormValues(values url.Values) (string, bool) {
+	if len(v.FormNames) > 0 {
+		for _, k := range v.FormNames {
+			if value := strings.TrimSpace(values.Get(k)); value != "" {
+				return value, true
+			}
+		}
+		return "", false
+	}
+	for k := range v.QueryParameters {
+		if value := strings.TrimSpace(values.Get(k)); value != "" {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// IsTokenQueryParameter returns true if the query parameter holds the token.
+func (v *TokenValidator) IsTokenQueryParameter(name string) bool {
+	if len(v.QueryNames) > 0 {
+		for _, queryName := range v.QueryNames {
+			if queryName == name {
+				return true
+			}
+		}
+		return false
+	}
+	_, exists := v.QueryParameters[name]
+	return exists
+}
+
+// RemoveQueryParameters removes the query parameters holding the tokens from
+// the request, so that the tokens are not passed to the upstreams and do not
+// appear in the URI of the request, e.g. in the logs and in the redirects.
+// The order of the other query parameters is preserved. The query parameters
+// are kept when the query is not the token source.
+func (v *TokenValidator) RemoveQueryParameters(r *http.Request) bool {
+	if r.URL == nil || r.URL.RawQuery == "" {
+		return false
+	}
+	var querySource bool
+	for _, sourceName := range v.TokenSources {
+		if sourceName == tokenSourceQuery {
+			querySource = true
+		}
+	}
+	if !querySource {
+		return false
+	}
+	var removed bool
+	var params []string
+	for _, param := range strings.Split(r.URL.RawQuery, "&") {
+		name := strings.SplitN(param, "=", 2)[0]
+		if s, err := url.QueryUnescape(name); err == nil {
+			name = s
+		}
+		if v.IsTokenQueryParameter(name) {
+			removed = true
+			continue
+		}
+		params = append(params, param)
+	}
+	if !removed {
+		return false
+	}
+	r.URL.RawQuery = strings.Join(params, "&")
+	r.RequestURI = r.URL.RequestURI()
+	// The form holding the query parameters is parsed again when needed.
+	r.Form = nil
+	return true
+}
+
+// RemoveTokens removes the tokens found in the token sources from the
+// request, i.e. Authorization header and the headers holding the tokens, the
+// cookies holding the tokens, and the query parameters holding the tokens, so
+// that the upstreams do not receive the tokens. Authorization header is kept
+// when it holds the credentials other than the tokens, e.g. Basic.
+func (v *TokenValidator) RemoveTokens(r *http.Request, opts *jwtconfig.TokenValidatorOptions) {
+	for _, sourceName := range v.TokenSources {
+		switch sourceName {
+		case tokenSourceHeader:
+			v.removeHeaders(r, opts)
+		case tokenSourceCookie:
+			v.removeCookies(r)
+		case tokenSourceQuery:
+			v.RemoveQueryParameters(r)
+		}
+	}
+}
+
+// removeHeaders removes the headers holding the tokens.
+func (v *TokenValidator) removeHeaders(r *http.Request, opts *jwtconfig.TokenValidatorOptions) {
+	authorization := len(v.HeaderNames) == 0
+	for _, name := range v.HeaderNames {
+		if strings.EqualFold(name, "Authorization") {
+			authorization = true
+			continue
+		}
+		r.Header.Del(name)
+	}
+	if !authorization {
+		return
+	}
+	for _, value := range r.Header.Values("Authorization") {
+		scheme, _ := getAuthorizationScheme(value)
+		if strings.EqualFold(scheme, "DPoP") || len(v.searchAuthorizationHeader(value, opts, 1)) > 0 {
+			r.Header.Del("Authorization")
+			r.Header.Del("DPoP")
+			return
+		}
+	}
+}
+
+// IsHeaderToken returns true if the token was found in Authorization header,
+// with Bearer scheme, or in the headers holding the tokens, rather than in
+// the cookies or the other token sources.
+func (v *TokenValidator) IsHeaderToken(r *http.Request, s string, opts *jwtconfig.TokenValidatorOptions) bool {
+	if s == "" {
+		return false
+	}
+	names := v.HeaderNames
+	if len(names) == 0 {
+		names = []string{"Authorization"}
+	}
+	for _, name := range names {
+		if strings.EqualFold(name, "Authorization") {
+			for _, value := range r.Header.Values("Authorization") {
+				for _, token := range v.searchAuthorizationHeader(value, opts, 0) {
+					if token == s {
+						return true
+					}
+				}
+			}
+			continue
+		}
+		token := strings.TrimSpace(r.Header.Get(name))
+		if scheme, t := getAuthorizationScheme(token); isBearerScheme(scheme, opts) {
+			token = t
+		}
+		if token == s {
+			return true
+		}
+	}
+	return false
+}
+
+// removeCookies removes the cookies holding the tokens, including the chunks
+// of the tokens.
+func (v *TokenValidator) removeCookies(r *http.Request) {
+	cookies := r.Cookies()
+	var removed bool
+	var kept []*http.Cookie
+	for _, cookie := range cookies {
+		if v.IsTokenCookie(cookie.Name) {
+			removed = true
+			continue
+		}
+		kept = append(kept, cookie)
+	}
+	if !removed {
+		return
+	}
+	r.Header.Del("Cookie")
+	for _, cookie := range kept {
+		r.AddCookie(cookie)
+	}
+}
+
+// RemoveWebSocketProtocol removes bearer subprotocol and the token from
+// Sec-WebSocket-Protocol header of the request, so that the token is not
+// passed to the upstreams. The upstreams select one of the other subprotocols.
+// When no other subprotocols are requested, it returns bearer subprotocol,
+// which the response selects for the browsers to accept the upgrade.
+func (v *TokenValidator) RemoveWebSocketProtocol(r *http.Request) string {
+	var webSocketSource bool
+	for _, sourceName := range v.TokenSources {
+		if sourceName == tokenSourceWebSocket {
+			webSocketSource = true
+		}
+	}
+	if !webSocketSource {
+		return ""
+	}
+	protocols := getWebSocketProtocols(r)
+	for i, protocol := range protocols {
+		if !strings.EqualFold(protocol, webSocketBearerProtocol) || i+1 == len(protocols) {
+			continue
+		}
+		protocols = append(protocols[:i:i], protocols[i+2:]...)
+		if len(protocols) == 0 {
+			r.Header.Del("Sec-WebSocket-Protocol")
+			return protocol
+		}
+		r.Header.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+		return ""
+	}
+	return ""
+}
+
+// getWebSocketProtocols returns the subprotocols requested by WebSocket
+// upgrade request.
+func getWebSocketProtocols(r *http.Request) []string {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return nil
+	}
+	var protocols []string
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(value, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	return protocols
+}
+
+// searchWebSocketProtocols returns the subprotocol following bearer
+// subprotocol.
+func searchWebSocketProtocols(protocols []string) (string, bool) {
+	for i, protocol := range protocols {
+		if strings.EqualFold(protocol, webSocketBearerProtocol) && i+1 < len(protocols) {
+			return protocols[i+1], true
+		}
+	}
 	return "", false
 }
 
+// searchCookie returns the token in the cookie with the name, or in its
+// chunks, e.g. access_token.0 and access_token.1.
+func searchCookie(cookies []*http.Cookie, name string) (string, bool) {
+	for _, cookie := range cookies {
+		if cookie != nil && cookie.Name == name {
+			if token, found := getCookieToken(cookie.Value); found {
+				return token, true
+			}
+		}
+	}
+	return getCookieToken(joinCookieChunks(cookies, name))
+}
+
+// joinCookieChunks returns the value split into the cookies with the name
+// and the chunk number suffix, starting at zero, e.g. the large tokens of
+// Azure AD split into access_token.0 and access_token.1.
+func joinCookieChunks(cookies []*http.Cookie, name string) string {
+	chunks := make(map[string]string)
+	for _, cookie := range cookies {
+		if cookie != nil && strings.HasPrefix(cookie.Name, name+".") {
+			if _, exists := chunks[cookie.Name]; !exists {
+				chunks[cookie.Name] = cookie.Value
+			}
+		}
+	}
+	var sb strings.Builder
+	for i := 0; ; i++ {
+		chunk, exists := chunks[name+"."+strconv.Itoa(i)]
+		if !exists {
+			break
+		}
+		sb.WriteString(chunk)
+	}
+	return sb.String()
+}
+
+// getCookieToken returns the token in the value of the cookie.
+func getCookieToken(value string) (string, bool) {
+	if len(value) <= 32 {
+		return "", false
+	}
+	token := strings.TrimSpace(value)
+	arr := strings.Split(token, " ")
+	return arr[0], true
+}
+
 // SearchQueryValues searches for tokens in the values of query parameters of
 // HTTP requests.
 func (v *TokenValidator) SearchQueryValues(params url.Values) (string, bool) {
-	if len(v.QueryParameters) == 0 || len(params) == 0 {
+	if (len(v.QueryParameters) == 0 && len(v.QueryNames) == 0) || len(params) == 0 {
+		return "", false
+	}
+
+	if len(v.QueryNames) > 0 {
+		for _, k := range v.QueryNames {
+			value := params.Get(k)
+			if len(value) > 32 {
+				return strings.TrimSpace(value), true
+			}
+		}
 		return "", false
 	}
 
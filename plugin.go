@@ -15,10 +15,12 @@
 package jwt
 
 import (
+	"errors"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
 	jwtauth "github.com/greenpau/caddy-auth-jwt/pkg/auth"
+	jwterrors "github.com/greenpau/caddy-auth-jwt/pkg/errors"
 	"github.com/satori/go.uuid"
 	"net/http"
 )
@@ -31,6 +33,11 @@ func init() {
 // the presense and content of JWT token.
 type AuthMiddleware struct {
 	Authorizer *jwtauth.Authorizer `json:"authorizer,omitempty"`
+	// The requests matching any of the matcher sets pass without a token,
+	// as the requests for the exempt paths of the authorizer.
+	ExemptMatcherSetsRaw caddyhttp.RawMatcherSets `json:"exempt_match,omitempty" caddy:"namespace=http.matchers"`
+
+	exemptMatcherSets caddyhttp.MatcherSets
 }
 
 // CaddyModule returns the Caddy module information.
@@ -43,6 +50,31 @@ func (AuthMiddleware) CaddyModule() caddy.ModuleInfo {
 
 // Provision provisions JWT authorization provider
 func (m *AuthMiddleware) Provision(ctx caddy.Context) error {
+	repl := caddy.NewReplacer()
+	for _, c := range m.Authorizer.TrustedTokens {
+		if c == nil {
+			continue
+		}
+		c.ReplacePlaceholders(func(s string) string {
+			return repl.ReplaceKnown(s, "")
+		})
+		if c.TokenBackendRaw != nil {
+			mod, err := ctx.LoadModule(c, "TokenBackendRaw")
+			if err != nil {
+				return jwterrors.ErrBackendModuleLoad.WithArgs(err)
+			}
+			c.TokenBackend = mod
+		}
+	}
+	if m.ExemptMatcherSetsRaw != nil {
+		mods, err := ctx.LoadModule(m, "ExemptMatcherSetsRaw")
+		if err != nil {
+			return jwterrors.ErrExemptMatcherLoad.WithArgs(err)
+		}
+		if err := m.exemptMatcherSets.FromInterface(mods); err != nil {
+			return jwterrors.ErrExemptMatcherLoad.WithArgs(err)
+		}
+	}
 	opts := make(map[string]interface{})
 	opts["logger"] = ctx.Logger(m)
 	return m.Authorizer.Provision(opts)
@@ -53,12 +85,24 @@ func (m *AuthMiddleware) Validate() error {
 	return nil
 }
 
+// Cleanup implements caddy.CleanerUpper.
+func (m *AuthMiddleware) Cleanup() error {
+	return m.Authorizer.Cleanup()
+}
+
 // Authenticate authorizes access based on the presense and content of JWT token.
 func (m AuthMiddleware) Authenticate(w http.ResponseWriter, r *http.Request) (caddyauth.User, bool, error) {
 	reqID := GetRequestID(r)
 	opts := make(map[string]interface{})
 	opts["request_id"] = reqID
+	if m.exemptMatcherSets.AnyMatch(r) {
+		opts["exempt"] = true
+	}
 	user, authOK, err := m.Authorizer.Authenticate(w, r, opts)
+	var denial jwterrors.DenialError
+	if errors.As(err, &denial) {
+		return caddyauth.User{}, false, handOffDenial(r, denial)
+	}
 	if user == nil {
 		return caddyauth.User{}, authOK, err
 	}
@@ -82,9 +126,21 @@ func (m AuthMiddleware) Authenticate(w http.ResponseWriter, r *http.Request) (ca
 var (
 	_ caddy.Provisioner       = (*AuthMiddleware)(nil)
 	_ caddy.Validator         = (*AuthMiddleware)(nil)
+	_ caddy.CleanerUpper      = (*AuthMiddleware)(nil)
 	_ caddyauth.Authenticator = (*AuthMiddleware)(nil)
 )
 
+// handOffDenial returns the denial as caddyhttp.HandlerError and sets
+// http.auth.jwt.deny_status and http.auth.jwt.deny_reason placeholders, so
+// that the error routes of the server respond to the denial.
+func handOffDenial(r *http.Request, denial jwterrors.DenialError) error {
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		repl.Set("http.auth.jwt.deny_status", denial.Status)
+		repl.Set("http.auth.jwt.deny_reason", denial.Reason)
+	}
+	return caddyhttp.Error(denial.Status, denial)
+}
+
 // GetRequestID returns request ID.
 func GetRequestID(r *http.Request) string {
 	rawRequestID := caddyhttp.GetVar(r.Context(), "request_id")